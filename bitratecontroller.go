@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/webrtc/v3"
 )
@@ -15,6 +16,8 @@ import (
 var (
 	ErrAlreadyClaimed          = errors.New("bwcontroller: already claimed")
 	ErrorInsufficientBandwidth = errors.New("bwcontroller: bandwidth is insufficient")
+	ErrBitrateControllerClosed = errors.New("bwcontroller: bitrate controller is closed")
+	ErrTooManyClaims           = errors.New("bwcontroller: too many claims")
 )
 
 const (
@@ -23,8 +26,127 @@ const (
 	decreaseBitrate = -1
 )
 
+const (
+	// the adjustment delay used when the RTT is not measured yet
+	defaultAdjustmentDelay = 2 * time.Second
+	// the adjustment delay is this many times of the RTT
+	adjustmentDelayRTTMultiplier = 20
+	// a decrease within the increase window means the bitrate was increased too fast
+	increaseWindowMultiplier = 5
+	// the fraction of the bitrate step to the next quality that is reserved while probing
+	probeBitrateFraction = 0.5
+	// the number of bandwidth estimation updates the probe must fit before the next quality is committed
+	probeTicksToCommit = 2
+	// the shortest interval to check the stalled claims
+	minStallCheckInterval = 50 * time.Millisecond
+	// the RED audio is forwarded with the redundant encodings when the fraction lost is above this threshold,
+	// and only with the primary encoding again when the fraction lost is below the recover threshold
+	audioRedundancyLossThreshold    = 0.05
+	audioRedundancyRecoverThreshold = 0.01
+	// the audio payload bitrate below this is the Opus DTX silence, the lowest Opus speech bitrate is 6 kbps
+	audioSilenceBitrate = 4_000
+	// the audio must be silent this long before its reserved bitrate is given to the video
+	audioSilenceWindow = 2 * time.Second
+	// the bitrate reserved for the silent audio, enough for the DTX packets sent every 400ms
+	audioSilentReservedBitrate = 4_000
+)
+
+// DefaultTrackStallTimeout is the default time without any packet from the publisher before a subscribed track is stalled
+const DefaultTrackStallTimeout = 3 * time.Second
+
+// defaultAdjustmentInterval is the interval of the bitrate adjustment when the bandwidth estimation is not used
+const defaultAdjustmentInterval = 3 * time.Second
+
+// DefaultSimulcastProbeTimeout is the suggested time to wait for the simulcast layers of a publisher when a track is subscribed,
+// the probe is disabled unless RoomOptions.SimulcastProbeTimeout is set
+const DefaultSimulcastProbeTimeout = 1 * time.Second
+
 type bitrateAdjustment int
 
+// DefaultPriority is the weight of a client track when the bandwidth is distributed between tracks
+const DefaultPriority = 1
+
+// validPriority make sure the priority weight is at least the default priority
+func validPriority(weight int) int {
+	if weight < DefaultPriority {
+		return DefaultPriority
+	}
+
+	return weight
+}
+
+// screenWeightMultiplier give the screen share tracks a bigger share of the bandwidth than the camera tracks
+// with the same priority, the screen content need more bits to keep the text readable
+const screenWeightMultiplier = 2
+
+// DegradationPolicy decide which video source keep its quality longer when the bandwidth is not enough
+type DegradationPolicy int
+
+const (
+	// DegradationScreenPriority reduce the camera tracks before the screen share tracks, this is the default
+	DegradationScreenPriority DegradationPolicy = iota
+	// DegradationCameraPriority reduce the screen share tracks before the camera tracks
+	DegradationCameraPriority
+	// DegradationEqual reduce the tracks only by their priority and bitrate regardless of the source
+	DegradationEqual
+)
+
+// isProtected return true if the track is reduced last and increased first by the policy
+func (p DegradationPolicy) isProtected(track iClientTrack) bool {
+	switch p {
+	case DegradationScreenPriority:
+		return track.IsScreen()
+	case DegradationCameraPriority:
+		return !track.IsScreen()
+	default:
+		return false
+	}
+}
+
+// qualityCounts is the number of video claims on each quality level
+type qualityCounts [QualityVeryHigh + 1]int
+
+// add count the quality of the claim, the audio claims are skipped because the audio levels are not video levels
+func (c *qualityCounts) add(claim *bitrateClaim, quality QualityLevel) {
+	if claim.track.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	if quality <= QualityVeryHigh {
+		c[quality]++
+	}
+}
+
+// above return the number of claims with a higher quality than the quality
+func (c *qualityCounts) above(quality QualityLevel) int {
+	count := 0
+	for q := int(quality) + 1; q < len(c); q++ {
+		count += c[q]
+	}
+
+	return count
+}
+
+// below return the number of claims with a lower quality than the quality
+func (c *qualityCounts) below(quality QualityLevel) int {
+	count := 0
+	for q := 0; q < int(quality) && q < len(c); q++ {
+		count += c[q]
+	}
+
+	return count
+}
+
+// distributionWeight return the weight of the track when the initial bandwidth is distributed between the video tracks
+func distributionWeight(track iClientTrack) int {
+	weight := validPriority(track.Priority())
+	if track.IsScreen() {
+		weight *= screenWeightMultiplier
+	}
+
+	return weight
+}
+
 type bitrateClaim struct {
 	mu               sync.RWMutex
 	track            iClientTrack
@@ -34,6 +156,35 @@ type bitrateClaim struct {
 	delayCounter     int
 	lastIncreaseTime time.Time
 	lastDecreaseTime time.Time
+	adjustmentDelay  time.Duration
+	increaseWindow   time.Duration
+	// the quality that is probed before committed, QualityNone if the claim is not probing
+	probeQuality   QualityLevel
+	probeTicks     int
+	probeStartTime time.Time
+	// the number of consecutive reports with the fraction lost above the decrease threshold
+	highLossReports int
+	// the packets received and lost of the last counted receiver report, a new report change it
+	lastLossReport uint64
+	// the paused claim keep its quality but is not forwarded and not counted in the total bitrates
+	paused bool
+	// the stalled claim doesn't receive any packet from the publisher and not counted in the total bitrates
+	stalled   bool
+	addedTime time.Time
+	// the silent audio claim only reserve the DTX bitrate, the audio bytes sent are sampled to detect the silence
+	silent            bool
+	silentSince       time.Time
+	lastPayloadSent   uint64
+	lastPayloadSentTS time.Time
+	// the claim is pinned to the quality closest to the target bitrate without exceeding it, not pinned if zero
+	targetBitrate uint32
+	// stop the goroutine that remove the claim when the track is ended
+	stopWatch context.CancelFunc
+	// the max quality capped by the simulcast layers probe and the max quality before the cap, QualityNone if not capped
+	layerProbeCap      QualityLevel
+	layerProbeUncapped QualityLevel
+	// the earliest time of the current headroom to increase the claim, zero if there is no headroom
+	headroomSince time.Time
 }
 
 func (c *bitrateClaim) Quality() QualityLevel {
@@ -43,6 +194,14 @@ func (c *bitrateClaim) Quality() QualityLevel {
 	return c.quality
 }
 
+// isSimulcast return false if the simulcast track only has a single layer, the claim is not adapted between layers
+func (c *bitrateClaim) isSimulcast() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.simulcast
+}
+
 func (c *bitrateClaim) Bitrate() uint32 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -50,12 +209,105 @@ func (c *bitrateClaim) Bitrate() uint32 {
 	return c.bitrate
 }
 
-func (c *bitrateClaim) isAllowToIncrease() bool {
+// adjustmentTimes return the last time the claim quality is increased and decreased
+func (c *bitrateClaim) adjustmentTimes() (lastIncreaseTime, lastDecreaseTime time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lastIncreaseTime, c.lastDecreaseTime
+}
+
+// isPaused return true if the track of the claim is paused, see iClientTrack.Pause
+func (c *bitrateClaim) isPaused() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.paused
+}
+
+// isStalled return true if the publisher stop sending the packets of the claim track, see bitrateController.checkStalledClaims
+func (c *bitrateClaim) isStalled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stalled
+}
+
+// isSuspended return true if the claim is not forwarded because it is paused or stalled
+func (c *bitrateClaim) isSuspended() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.paused || c.stalled
+}
+
+// highestQuality return the highest quality the claim can be increased to from the SFU highest quality.
+// The simulcast track only has three layers, so it never go above the high quality.
+func (c *bitrateClaim) highestQuality(highestQuality QualityLevel) QualityLevel {
+	if c.track.IsSimulcast() && highestQuality > QualityHigh {
+		return QualityHigh
+	}
+
+	return highestQuality
+}
+
+// isActive return false if the claim is paused or stalled, or if it is a simulcast claim
+// and the claimed layer is not received from the publisher
+func (c *bitrateClaim) isActive() bool {
+	if c.isSuspended() {
+		return false
+	}
+
+	if track, ok := c.track.(*simulcastClientTrack); ok {
+		return track.remoteTrack.isTrackActive(c.Quality())
+	}
+
+	return true
+}
+
+// sentBitrate is the bitrate of the claim counted in the total bitrates, zero if the claim is paused or stalled
+func (c *bitrateClaim) sentBitrate() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.currentSentBitrate()
+}
+
+// currentSentBitrate is the bitrate that is sent for the claim, must be called with the mutex held
+func (c *bitrateClaim) currentSentBitrate() uint32 {
+	if c.paused || c.stalled {
+		return 0
+	}
+
+	if c.silent {
+		return min(c.bitrate, audioSilentReservedBitrate)
+	}
+
+	return c.bitrate
+}
+
+// isSilent return true if the audio claim is in the DTX silence
+func (c *bitrateClaim) isSilent() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.silent
+}
+
+// isProbing return true if the claim reserve a part of the bitrate to the next quality but not yet switched to it
+func (c *bitrateClaim) isProbing() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.probeQuality != QualityNone
+}
+
+func (c *bitrateClaim) isAllowToIncrease(now time.Time) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if c.delayCounter > 0 && time.Since(c.lastIncreaseTime) < time.Duration(c.delayCounter)*10*time.Second {
-		glog.Info("clienttrack: delay increase,  delay counter ", c.delayCounter)
+	if c.delayCounter > 0 && now.Sub(c.lastIncreaseTime) < time.Duration(c.delayCounter)*c.increaseWindow {
+		logger().Info("clienttrack: delay increase,  delay counter ", c.delayCounter)
 
 		return false
 	}
@@ -63,8 +315,87 @@ func (c *bitrateClaim) isAllowToIncrease() bool {
 	return true
 }
 
+// AdjustmentDelay is the minimum time between two bitrate adjustments of the claim
+func (c *bitrateClaim) AdjustmentDelay() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.adjustmentDelay
+}
+
+// IncreaseWindow is the time after an increase where a decrease means the bitrate was increased too fast
+func (c *bitrateClaim) IncreaseWindow() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.increaseWindow
+}
+
+// updateDelays scale the adjustment delay and the increase window with the RTT. A high RTT link need
+// more time before the effect of an adjustment is visible in the stats, a low RTT link can adjust faster.
+func (c *bitrateClaim) updateDelays(rtt, minDelay, maxDelay time.Duration) {
+	delay := defaultAdjustmentDelay
+	if rtt > 0 {
+		delay = rtt * adjustmentDelayRTTMultiplier
+	}
+
+	if minDelay > 0 && delay < minDelay {
+		delay = minDelay
+	}
+
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.adjustmentDelay = delay
+	c.increaseWindow = delay * increaseWindowMultiplier
+}
+
+// priority return the weight of the claim when the bandwidth is distributed between claims
+func (c *bitrateClaim) priority() int {
+	return validPriority(c.track.Priority())
+}
+
+// IsAdjustable return false if the track only has a single layer or the claim is pinned to a target bitrate
 func (c *bitrateClaim) IsAdjustable() bool {
-	return c.track.IsSimulcast() || c.track.IsScaleable()
+	return (c.track.IsSimulcast() || c.track.IsScaleable()) && c.TargetBitrate() == 0
+}
+
+func (c *bitrateClaim) TargetBitrate() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.targetBitrate
+}
+
+// minQuality return the quality floor of the claim, the floor will never exceed the track max quality
+func (c *bitrateClaim) minQuality() QualityLevel {
+	return min(c.track.MinQuality(), c.track.MaxQuality())
+}
+
+// countHighLossReport return the number of consecutive high loss reports, the count is reset by a report below the threshold.
+// The report is identified by the packets received and lost that it cover, the same report is counted once however many
+// adjustments read it.
+func (c *bitrateClaim) countHighLossReport(report uint64, isHighLoss bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if report == c.lastLossReport {
+		return c.highLossReports
+	}
+
+	c.lastLossReport = report
+
+	if isHighLoss {
+		c.highLossReports++
+	} else {
+		c.highLossReports = 0
+	}
+
+	return c.highLossReports
 }
 
 func (c *bitrateClaim) pushbackDelayCounter() {
@@ -77,32 +408,168 @@ func (c *bitrateClaim) pushbackDelayCounter() {
 	}
 
 	c.delayCounter = int(math.Ceil(float64(c.delayCounter) * 1.5))
-	glog.Info("clienttrack: pushback delay counter to ", c.delayCounter)
+	logger().Info("clienttrack: pushback delay counter to ", c.delayCounter)
+}
+
+// ClaimSnapshot is the quality and bitrate claimed by a client track
+type ClaimSnapshot struct {
+	TrackID string       `json:"track_id"`
+	Quality QualityLevel `json:"quality"`
+	Bitrate uint32       `json:"bitrate"`
+}
+
+// BandwidthSnapshot is a read-only view of what the bitrate controller knows about the client bandwidth
+type BandwidthSnapshot struct {
+	EstimatedBandwidth    uint32          `json:"estimated_bandwidth"`
+	TotalAllocatedBitrate uint32          `json:"total_allocated_bitrate"`
+	TotalSentBitrate      uint32          `json:"total_sent_bitrate"`
+	Claims                []ClaimSnapshot `json:"claims"`
 }
 
 type bitrateController struct {
 	mu                      sync.RWMutex
+	context                 context.Context
+	cancel                  context.CancelFunc
+	wg                      sync.WaitGroup
 	lastBitrateAdjustmentTS time.Time
 	client                  *Client
 	claims                  map[string]*bitrateClaim
 	useBandwidthEstimation  bool
+	strategy                BitrateStrategy
+	lossIncreaseThreshold   float64
+	lossDecreaseThreshold   float64
+	lossDecreaseReports     int
+	decisionLog             atomic.Pointer[decisionLog]
+	// the interval of the adjustment when the bandwidth estimation is not used
+	interval time.Duration
+	// the estimated bandwidth is capped to this bandwidth, unlimited if zero
+	maxBandwidth uint32
+	// the clock of the adjustment timings, replaced in the tests to control the delays
+	clock Clock
+	// the earliest time of the current bandwidth headroom to fit the claims to the bandwidth, zero if there is no headroom
+	headroomSince time.Time
+}
+
+// Clock tell the current time to the bitrate controller, the adjustment delays and the increase window are measured with it
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default clock that use the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// BCOption configure the bitrate controller when it is created with newBitrateController
+type BCOption func(*bitrateController)
+
+// WithInterval set the interval of the bitrate adjustment when the bandwidth estimation is not used
+func WithInterval(interval time.Duration) BCOption {
+	return func(bc *bitrateController) {
+		if interval > 0 {
+			bc.interval = interval
+		}
+	}
+}
+
+// WithStrategy set the strategy that used to adjust the claims bitrate, nil use the default strategy
+func WithStrategy(strategy BitrateStrategy) BCOption {
+	return func(bc *bitrateController) {
+		bc.strategy = strategy
+	}
+}
+
+// WithMaxBandwidth cap the estimated bandwidth that is allocated to the claims, unlimited if zero
+func WithMaxBandwidth(bandwidth uint32) BCOption {
+	return func(bc *bitrateController) {
+		bc.maxBandwidth = bandwidth
+	}
+}
+
+// WithLossThresholds set the fraction lost thresholds of the loss based strategy, the invalid thresholds are ignored
+func WithLossThresholds(increase, decrease float64) BCOption {
+	return func(bc *bitrateController) {
+		if err := bc.SetLossThresholds(increase, decrease); err != nil {
+			logger().Warn("bitratecontroller: loss thresholds are ignored, ", err)
+		}
+	}
+}
+
+// WithClock replace the clock that is used to time the bitrate adjustments, the tests use it to control the delays
+func WithClock(clock Clock) BCOption {
+	return func(bc *bitrateController) {
+		if clock != nil {
+			bc.clock = clock
+		}
+	}
+}
+
+// WithBandwidthEstimation adjust the bitrate when the bandwidth estimation is changed instead of on every interval
+func WithBandwidthEstimation(enabled bool) BCOption {
+	return func(bc *bitrateController) {
+		bc.useBandwidthEstimation = enabled
+	}
 }
 
+// newbitrateController is kept for the existing callers, the intervalMonitor is ignored as it has always been
 func newbitrateController(client *Client, intervalMonitor time.Duration, useBandwidthEstimation bool) *bitrateController {
+	return newBitrateController(client, WithBandwidthEstimation(useBandwidthEstimation))
+}
+
+func newBitrateController(client *Client, opts ...BCOption) *bitrateController {
+	ctx, cancel := context.WithCancel(client.context)
+
 	bc := &bitrateController{
-		mu:                     sync.RWMutex{},
-		client:                 client,
-		claims:                 make(map[string]*bitrateClaim, 0),
-		useBandwidthEstimation: useBandwidthEstimation,
+		mu:                    sync.RWMutex{},
+		context:               ctx,
+		cancel:                cancel,
+		client:                client,
+		claims:                make(map[string]*bitrateClaim, 0),
+		lossIncreaseThreshold: DefaultLossIncreaseThreshold,
+		lossDecreaseThreshold: DefaultLossDecreaseThreshold,
+		lossDecreaseReports:   DefaultLossDecreaseReports,
+		interval:              defaultAdjustmentInterval,
+		clock:                 realClock{},
+	}
+
+	for _, opt := range opts {
+		opt(bc)
+	}
+
+	if bc.strategy == nil {
+		bc.strategy = bc.defaultStrategy()
 	}
 
-	if !useBandwidthEstimation {
+	if !bc.useBandwidthEstimation {
 		bc.start()
 	}
 
+	bc.monitorStalledClaims()
+	bc.monitorProbePadding()
+
 	return bc
 }
 
+// Close stop the adjustment goroutine and the claim watchers, and wait until all of them are returned.
+// No more bitrate adjustment is made after Close is returned. It is safe to call Close more than once.
+func (bc *bitrateController) Close() {
+	bc.mu.Lock()
+	bc.cancel()
+	bc.mu.Unlock()
+
+	bc.wg.Wait()
+}
+
+func (bc *bitrateController) now() time.Time {
+	return bc.clock.Now()
+}
+
+func (bc *bitrateController) isClosed() bool {
+	return bc.context.Err() != nil
+}
+
 func (bc *bitrateController) Claims() map[string]*bitrateClaim {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
@@ -115,6 +582,41 @@ func (bc *bitrateController) Claims() map[string]*bitrateClaim {
 	return claims
 }
 
+// ClaimInfo is a snapshot of a bitrate claim, see bitrateController.ListClaims
+type ClaimInfo struct {
+	TrackID    string
+	Kind       webrtc.RTPCodecType
+	Quality    QualityLevel
+	Bitrate    uint32
+	Simulcast  bool
+	IsScreen   bool
+	MaxQuality QualityLevel
+}
+
+// ListClaims return the snapshot of the claims sorted by the track ID, the snapshot is not updated when the claims change
+func (bc *bitrateController) ListClaims() []ClaimInfo {
+	claims := bc.Claims()
+
+	infos := make([]ClaimInfo, 0, len(claims))
+	for _, claim := range claims {
+		infos = append(infos, ClaimInfo{
+			TrackID:    claim.track.ID(),
+			Kind:       claim.track.Kind(),
+			Quality:    claim.Quality(),
+			Bitrate:    claim.Bitrate(),
+			Simulcast:  claim.isSimulcast(),
+			IsScreen:   claim.track.IsScreen(),
+			MaxQuality: claim.track.MaxQuality(),
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].TrackID < infos[j].TrackID
+	})
+
+	return infos
+}
+
 func (bc *bitrateController) Exist(id string) bool {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
@@ -137,15 +639,63 @@ func (bc *bitrateController) GetClaim(id string) *bitrateClaim {
 	return nil
 }
 
-// if all is false, only active claims will be counted
+// Snapshot return the estimated bandwidth and the current claims. All claims are read in a single pass
+// under the controller lock, so the totals are always match with the claims in the snapshot.
+func (bc *bitrateController) Snapshot() BandwidthSnapshot {
+	snapshot := BandwidthSnapshot{
+		EstimatedBandwidth: bc.estimatedBandwidth(),
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	snapshot.Claims = make([]ClaimSnapshot, 0, len(bc.claims))
+
+	for id, claim := range bc.claims {
+		claim.mu.RLock()
+		claimSnapshot := ClaimSnapshot{
+			TrackID: id,
+			Quality: claim.quality,
+			Bitrate: claim.bitrate,
+		}
+		sentBitrate := claim.currentSentBitrate()
+		claim.mu.RUnlock()
+
+		snapshot.TotalAllocatedBitrate += claimSnapshot.Bitrate
+		// the paused, stalled, and silent claims send less than their allocated bitrate
+		snapshot.TotalSentBitrate += sentBitrate
+		snapshot.Claims = append(snapshot.Claims, claimSnapshot)
+	}
+
+	return snapshot
+}
+
+// TotalBitrates return the total bitrate of the claims, the paused and stalled claims are not counted
 func (bc *bitrateController) TotalBitrates() uint32 {
 	return bc.totalBitrates()
 }
 
+// TotalActiveBitrates return the total bitrate of the claims that their track is sending the claimed quality.
+// The simulcast claim is not counted if the publisher doesn't send the claimed layer.
+func (bc *bitrateController) TotalActiveBitrates() uint32 {
+	return bc.totalActiveBitrates()
+}
+
+func (bc *bitrateController) totalActiveBitrates() uint32 {
+	total := uint32(0)
+	for _, claim := range bc.Claims() {
+		if claim.isActive() {
+			total += claim.sentBitrate()
+		}
+	}
+
+	return total
+}
+
 func (bc *bitrateController) totalBitrates() uint32 {
 	total := uint32(0)
 	for _, claim := range bc.Claims() {
-		total += claim.bitrate
+		total += claim.sentBitrate()
 	}
 
 	return total
@@ -159,69 +709,206 @@ func (bc *bitrateController) setQuality(clientTrackID string, quality QualityLev
 		claim.mu.Lock()
 
 		if claim.quality < quality {
-			claim.lastIncreaseTime = time.Now()
+			claim.lastIncreaseTime = bc.now()
+			// the increased bitrate take the headroom, the next increase wait for a new sustained headroom
+			claim.headroomSince = time.Time{}
+			bc.headroomSince = time.Time{}
 		}
 
-		bitrate := bc.client.sfu.QualityLevelToBitrate(quality)
+		bitrate := bc.qualityBitrate(claim.track, quality)
 		claim.quality = quality
 		claim.bitrate = bitrate
+		claim.probeQuality = QualityNone
+		claim.probeTicks = 0
 		claim.mu.Unlock()
 
 		bc.claims[clientTrackID] = claim
 	}
 }
 
-func (bc *bitrateController) setSimulcastClaim(clientTrackID string, simulcast bool) {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+// setPaused exclude or include back the claim in the total bitrates, the freed bandwidth is given to the other claims
+// on the next adjustment
+func (bc *bitrateController) setPaused(clientTrackID string, paused bool) {
+	bc.mu.RLock()
+	claim, ok := bc.claims[clientTrackID]
+	bc.mu.RUnlock()
 
-	if claim, ok := bc.claims[clientTrackID]; ok {
-		claim.simulcast = simulcast
-		bc.claims[clientTrackID] = claim
+	if !ok {
+		return
 	}
+
+	claim.mu.Lock()
+	claim.paused = paused
+	claim.mu.Unlock()
+
+	// cancel the probe and restore the committed bitrate of the claim
+	bc.setQuality(clientTrackID, claim.Quality())
 }
 
-// this handle some simulcast failed to send mid and low track, only high track available
-// by default we just send the high track that is only available
-func (bc *bitrateController) checkAllTrackActive(claim *bitrateClaim) (bool, QualityLevel) {
-	trackCount := 0
-	quality := QualityNone
-	track, ok := claim.track.(*simulcastClientTrack)
+// refreshClaimBitrates update the claims bitrate after the quality bitrates mapping is changed
+func (bc *bitrateController) refreshClaimBitrates() {
+	highestQuality := bc.client.sfu.HighestQuality()
 
-	if ok {
-		if track.remoteTrack.remoteTrackHigh != nil {
-			trackCount++
-			quality = QualityHigh
+	for id, claim := range bc.Claims() {
+		quality := claim.Quality()
+		// the very high tier could be disabled after the claim is upgraded to it
+		if quality == QualityVeryHigh && highestQuality < QualityVeryHigh {
+			quality = highestQuality
 		}
 
-		if track.remoteTrack.remoteTrackMid != nil {
-			trackCount++
-			quality = QualityMid
+		// the pinned claim is mapped again to the new quality bitrates
+		if target := claim.TargetBitrate(); target > 0 {
+			quality = bc.targetQuality(claim, target)
 		}
 
-		if track.remoteTrack.remoteTrackLow != nil {
-			trackCount++
-			quality = QualityLow
-		}
+		bc.setQuality(id, quality)
+	}
+}
 
-		if trackCount == 1 {
-			qualityLvl := Uint32ToQualityLevel(uint32(quality))
-			if claim.quality != qualityLvl {
-				bc.setQuality(claim.track.ID(), qualityLvl)
-			}
+// SetTargetBitrate pin the claim of the track to the highest quality that the bitrate doesn't exceed the target bitrate,
+// the lowest quality is used if the target is below it. The pinned claim is not adjusted by the bitrate controller,
+// set 0 to unpin the claim and let the bitrate controller adjust it again.
+func (bc *bitrateController) SetTargetBitrate(trackID string, bps uint32) error {
+	claim := bc.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackNotFound
+	}
 
-			// this will force the current track identified as non simulcast track
-			if claim.simulcast {
-				bc.setSimulcastClaim(claim.track.ID(), false)
+	if !claim.track.IsSimulcast() && !claim.track.IsScaleable() {
+		return ErrTrackNotAdjustable
+	}
+
+	claim.mu.Lock()
+	claim.targetBitrate = bps
+	claim.mu.Unlock()
+
+	if bps == 0 {
+		return nil
+	}
+
+	quality := bc.targetQuality(claim, bps)
+	if quality != claim.Quality() {
+		if remoteTrack, ok := simulcastRemoteTrack(claim.track); ok {
+			remoteTrack.sendPLI(quality)
+		} else if quality < claim.Quality() {
+			// scaleable track will wait for the upswitch point or request PLI by itself when scaling up
+			claim.track.RequestPLI()
+		}
+
+		logger().Info("bitratecontroller: track ", trackID, " is pinned to quality ", quality, " for target bitrate ", ThousandSeparator(int(bps)))
+		bc.setQuality(trackID, quality)
+	}
+
+	return nil
+}
+
+// targetQuality return the highest quality of the claim that the bitrate doesn't exceed the target bitrate
+func (bc *bitrateController) targetQuality(claim *bitrateClaim, target uint32) QualityLevel {
+	quality := QualityLevel(QualityLow)
+
+	for q := QualityLevel(QualityMid); q <= bc.highestQuality(claim); q = higherQuality(q) {
+		if bc.qualityBitrate(claim.track, q) > target {
+			break
+		}
+
+		quality = q
+	}
+
+	return quality
+}
+
+func (bc *bitrateController) setSimulcastClaim(clientTrackID string, simulcast bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if claim, ok := bc.claims[clientTrackID]; ok {
+		claim.mu.Lock()
+		claim.simulcast = simulcast
+		claim.mu.Unlock()
+
+		bc.claims[clientTrackID] = claim
+	}
+}
+
+// this handle some simulcast failed to send mid and low track, only high track available
+// by default we just send the high track that is only available
+// when the publisher enable the simulcast later and the other layers appear, the claim is promoted back to simulcast
+func (bc *bitrateController) checkAllTrackActive(claim *bitrateClaim) (bool, QualityLevel) {
+	trackCount := 0
+	quality := QualityNone
+	track, ok := claim.track.(*simulcastClientTrack)
+
+	if ok {
+		bc.liftLayerProbeCap(claim, track)
+
+		if track.remoteTrack.getRemoteTrack(QualityHigh) != nil {
+			trackCount++
+			quality = QualityHigh
+		}
+
+		if track.remoteTrack.getRemoteTrack(QualityMid) != nil {
+			trackCount++
+			quality = QualityMid
+		}
+
+		if track.remoteTrack.getRemoteTrack(QualityLow) != nil {
+			trackCount++
+			quality = QualityLow
+		}
+
+		if trackCount == 1 {
+			qualityLvl := Uint32ToQualityLevel(uint32(quality))
+
+			// the single layer can't be adapted, the track is not forwarded until the subscriber can afford the layer
+			if !bc.isSingleLayerAffordable(claim, qualityLvl) {
+				if claim.Quality() != QualityNone {
+					logger().Warn("bitrate: track ", claim.track.ID(), " only has a single layer ", qualityLvl, " that is over the budget, the track is not forwarded")
+					bc.setQuality(claim.track.ID(), QualityNone)
+					bc.client.onTrackLayerUnaffordable(claim.track.ID(), qualityLvl)
+				}
+
+				qualityLvl = QualityNone
+			} else if claim.Quality() != qualityLvl {
+				bc.setQuality(claim.track.ID(), qualityLvl)
+			}
+
+			// this will force the current track identified as non simulcast track
+			if claim.isSimulcast() {
+				bc.setSimulcastClaim(claim.track.ID(), false)
 			}
 
 			return true, qualityLvl
 		}
 
-		return true, claim.quality
+		// the publisher upgraded to simulcast, re-enable the multi layer adaptation
+		if trackCount > 1 && !claim.isSimulcast() {
+			logger().Info("bitrate: track ", claim.track.ID(), " has ", trackCount, " layers, enable simulcast adaptation")
+			bc.setSimulcastClaim(claim.track.ID(), true)
+		}
+
+		return true, claim.Quality()
+	}
+
+	return false, claim.Quality()
+}
+
+// isSingleLayerAffordable return true if the layer is not above the track max quality and the bandwidth left by
+// the other active claims is enough to forward the layer
+func (bc *bitrateController) isSingleLayerAffordable(claim *bitrateClaim, quality QualityLevel) bool {
+	if quality > claim.track.MaxQuality() {
+		return false
 	}
 
-	return false, claim.quality
+	used := uint32(0)
+	for id, other := range bc.Claims() {
+		if id != claim.track.ID() && other.isActive() {
+			used += other.sentBitrate()
+		}
+	}
+
+	bandwidth := bc.estimatedBandwidth()
+
+	return used <= bandwidth && bc.qualityBitrate(claim.track, quality) <= bandwidth-used
 }
 
 func (bc *bitrateController) addAudioClaims(clientTracks []iClientTrack) (leftTracks []iClientTrack, err error) {
@@ -256,25 +943,142 @@ func (bc *bitrateController) addAudioClaims(clientTracks []iClientTrack) (leftTr
 	return leftTracks, nil
 }
 
+// redundancySwitcher is implemented by the audio tracks that can forward the RED packets with or without the redundant encodings
+type redundancySwitcher interface {
+	setRedundancy(enabled bool)
+}
+
+// updateAudioRedundancy forward the redundant audio encodings only while the receiver report packet loss,
+// the redundancy almost double the audio bitrate and is not useful on a clean network
+func (bc *bitrateController) updateAudioRedundancy(clientTrackID string) {
+	claim := bc.GetClaim(clientTrackID)
+	if claim == nil || claim.track.LocalTrack().Codec().MimeType != "audio/red" {
+		return
+	}
+
+	switcher, ok := claim.track.(redundancySwitcher)
+	if !ok {
+		return
+	}
+
+	sender, err := bc.client.stats.GetSender(clientTrackID)
+	if err != nil {
+		return
+	}
+
+	fractionLost := sender.RemoteInboundRTPStreamStats.FractionLost
+
+	switch quality := claim.Quality(); {
+	case quality == QualityAudio && fractionLost > audioRedundancyLossThreshold:
+		logger().Info("bitratecontroller: forward audio redundancy for track ", clientTrackID, " lost ratio ", fractionLost)
+		switcher.setRedundancy(true)
+		bc.setQuality(clientTrackID, QualityAudioRed)
+	case quality == QualityAudioRed && fractionLost < audioRedundancyRecoverThreshold:
+		logger().Info("bitratecontroller: stop audio redundancy for track ", clientTrackID, " lost ratio ", fractionLost)
+		switcher.setRedundancy(false)
+		bc.setQuality(clientTrackID, QualityAudio)
+	}
+}
+
+// updateAudioSilence sample the audio payload bytes sent to detect the Opus DTX silence. The silent audio only
+// reserve the DTX bitrate so the video can use the rest, the full bitrate is reserved again on the first sample
+// with speech.
+func (bc *bitrateController) updateAudioSilence(clientTrackID string, now time.Time) {
+	claim := bc.GetClaim(clientTrackID)
+	if claim == nil || claim.track.Kind() != webrtc.RTPCodecTypeAudio {
+		return
+	}
+
+	sender, err := bc.client.stats.GetSender(clientTrackID)
+	if err != nil {
+		return
+	}
+
+	outbound := sender.OutboundRTPStreamStats
+	payloadSent := outbound.BytesSent - outbound.HeaderBytesSent
+
+	claim.mu.Lock()
+	defer claim.mu.Unlock()
+
+	if !claim.lastPayloadSentTS.IsZero() && payloadSent >= claim.lastPayloadSent && now.After(claim.lastPayloadSentTS) {
+		bitrate := float64(payloadSent-claim.lastPayloadSent) * 8 / now.Sub(claim.lastPayloadSentTS).Seconds()
+
+		if bitrate < audioSilenceBitrate {
+			if claim.silentSince.IsZero() {
+				claim.silentSince = claim.lastPayloadSentTS
+			}
+
+			if !claim.silent && now.Sub(claim.silentSince) >= audioSilenceWindow {
+				logger().Info("bitratecontroller: audio track ", clientTrackID, " is silent, release the reserved bitrate")
+				claim.silent = true
+			}
+		} else {
+			claim.silentSince = time.Time{}
+			claim.silent = false
+		}
+	}
+
+	claim.lastPayloadSent = payloadSent
+	claim.lastPayloadSentTS = now
+}
+
+// qualityBitrate return the bitrate of the quality level, the screen share track use the screen bitrates
+func (bc *bitrateController) qualityBitrate(track iClientTrack, quality QualityLevel) uint32 {
+	return bc.client.SFU().TrackQualityLevelToBitrate(quality, track.IsScreen())
+}
+
 // this should never return QualityNone becaus it will delay onTrack event
-func (bc *bitrateController) getDistributedQuality(totalTracks int) QualityLevel {
-	if totalTracks == 0 {
+// the available bandwidth is distributed proportionally to the track weight from the total weight of the tracks,
+// then the share is compared with the quality bitrates of the track, the screen bitrates if isScreen is true
+func (bc *bitrateController) getDistributedQuality(weight, totalWeight int, isScreen bool) QualityLevel {
+	return bc.distributedQuality(bc.availableBandwidth(), weight, totalWeight, isScreen)
+}
+
+// availableBandwidth return the estimated bandwidth that is not used by the active claims,
+// the inactive claims are not sending anything so their bitrate is available for the new claims
+func (bc *bitrateController) availableBandwidth() uint32 {
+	bw := bc.estimatedBandwidth()
+	active := bc.totalActiveBitrates()
+	if active >= bw {
 		return 0
 	}
 
-	availableBandwidth := bc.client.GetEstimatedBandwidth() - bc.totalBitrates()
+	return bw - active
+}
+
+// distributedQuality return the quality that fit the weighted share of the available bandwidth
+func (bc *bitrateController) distributedQuality(availableBandwidth uint32, weight, totalWeight int, isScreen bool) QualityLevel {
+	if totalWeight == 0 {
+		return 0
+	}
 
-	distributedBandwidth := availableBandwidth / uint32(totalTracks)
+	distributedBandwidth := uint32(uint64(availableBandwidth) * uint64(weight) / uint64(totalWeight))
 
-	bitrateConfig := bc.client.SFU().bitrateConfigs
+	sfu := bc.client.SFU()
 
-	if distributedBandwidth < bitrateConfig.VideoMid {
+	if distributedBandwidth < sfu.TrackQualityLevelToBitrate(QualityMid, isScreen) {
 		return QualityLow
-	} else if distributedBandwidth < bitrateConfig.VideoHigh {
+	} else if distributedBandwidth < sfu.TrackQualityLevelToBitrate(QualityHigh, isScreen) {
 		return QualityMid
+	} else if sfu.HighestQuality() < QualityVeryHigh || distributedBandwidth < sfu.TrackQualityLevelToBitrate(QualityVeryHigh, isScreen) {
+		return QualityHigh
+	}
+
+	return QualityVeryHigh
+}
+
+// highestQuality return the highest quality that the claim can be increased to, never above the client quality ceiling
+func (bc *bitrateController) highestQuality(claim *bitrateClaim) QualityLevel {
+	return min(claim.highestQuality(bc.client.SFU().HighestQuality()), bc.client.QualityCeiling())
+}
+
+func (bc *bitrateController) startupQuality() QualityLevel {
+	quality := bc.client.SFU().BitrateConfigs().StartupQuality
+	if quality == QualityNone {
+		return QualityLow
 	}
 
-	return QualityHigh
+	return quality
 }
 
 func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
@@ -284,35 +1088,37 @@ func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
 	}
 
 	errors := make([]error, 0)
-	claimed := 0
+
+	totalWeight := 0
+	for _, clientTrack := range leftTracks {
+		totalWeight += distributionWeight(clientTrack)
+	}
+
+	// split the bandwidth left after the audio claims once, so every track get its fair share regardless of
+	// how much the tracks claimed before it are already sending
+	availableBandwidth := bc.availableBandwidth()
 
 	for _, clientTrack := range leftTracks {
 		if clientTrack.Kind() == webrtc.RTPCodecTypeVideo {
-			trackQuality := bc.getDistributedQuality(len(leftTracks) - claimed)
-			bc.mu.RLock()
-			if _, ok := bc.claims[clientTrack.ID()]; ok {
-				errors = append(errors, ErrAlreadyClaimed)
-				bc.mu.RUnlock()
-				continue
+			trackQuality := bc.distributedQuality(availableBandwidth, distributionWeight(clientTrack), totalWeight, clientTrack.IsScreen())
+
+			// the bandwidth estimation is not reliable yet when the claim is added, start conservatively and ramp up later
+			if startupQuality := bc.startupQuality(); trackQuality > startupQuality {
+				trackQuality = startupQuality
 			}
-			bc.mu.RUnlock()
 
 			if !clientTrack.IsSimulcast() && !clientTrack.IsScaleable() {
 				trackQuality = QualityHigh
 			}
 
-			// set last quality that use for requesting PLI after claim added
-			if clientTrack.IsSimulcast() {
-				clientTrack.(*simulcastClientTrack).lastQuality.Store(uint32(trackQuality))
-			} else if clientTrack.IsScaleable() {
-				clientTrack.(*scaleableClientTrack).lastQuality = trackQuality
-			}
-
-			_, err := bc.addClaim(clientTrack, trackQuality, true)
-			if err != nil {
+			// the existing claim is checked by addClaim, the track of an existing claim is left untouched
+			if _, err := bc.addClaim(clientTrack, trackQuality, true); err != nil {
 				errors = append(errors, err)
+				continue
 			}
-			claimed++
+
+			// set last quality that use for requesting PLI after claim added
+			clientTrack.SetLastQuality(trackQuality)
 		}
 	}
 
@@ -323,27 +1129,65 @@ func (bc *bitrateController) addClaims(clientTracks []iClientTrack) error {
 	return nil
 }
 
+// addClaim check and insert the claim under the same write lock, so the same track is never claimed twice.
+// It returns ErrAlreadyClaimed if the track already has a claim.
 func (bc *bitrateController) addClaim(clientTrack iClientTrack, quality QualityLevel, locked bool) (*bitrateClaim, error) {
-	bitrate := bc.client.sfu.QualityLevelToBitrate(quality)
+	bitrate := bc.qualityBitrate(clientTrack, quality)
 
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
+	if bc.isClosed() {
+		return nil, ErrBitrateControllerClosed
+	}
+
+	if _, ok := bc.claims[clientTrack.ID()]; ok {
+		return nil, ErrAlreadyClaimed
+	}
+
+	if maxClaims := bc.client.options.MaxClaims; maxClaims > 0 && len(bc.claims) >= maxClaims {
+		logger().Warn("bitrate: client ", bc.client.ID(), " reach the maximum ", maxClaims, " claims, track ", clientTrack.ID(), " is not forwarded")
+		return nil, ErrTooManyClaims
+	}
+
+	watchCtx, stopWatch := context.WithCancel(bc.context)
+
 	bc.claims[clientTrack.ID()] = &bitrateClaim{
-		mu:        sync.RWMutex{},
-		track:     clientTrack,
-		quality:   quality,
-		simulcast: clientTrack.IsSimulcast(),
-		bitrate:   bitrate,
+		mu:              sync.RWMutex{},
+		track:           clientTrack,
+		quality:         quality,
+		simulcast:       clientTrack.IsSimulcast(),
+		bitrate:         bitrate,
+		adjustmentDelay: defaultAdjustmentDelay,
+		increaseWindow:  defaultAdjustmentDelay * increaseWindowMultiplier,
+		addedTime:       bc.now(),
+		stopWatch:       stopWatch,
 	}
 
+	if track, ok := clientTrack.(*simulcastClientTrack); ok {
+		if timeout := bc.client.SFU().SimulcastProbeTimeout(); timeout > 0 {
+			bc.wg.Add(1)
+			go bc.probeSimulcastLayers(track, timeout)
+		}
+	}
+
+	bc.wg.Add(1)
+
 	go func() {
-		ctx, cancel := context.WithCancel(clientTrack.Context())
-		defer cancel()
-		<-ctx.Done()
+		defer bc.wg.Done()
+
+		defer stopWatch()
+
+		select {
+		case <-watchCtx.Done():
+			// the controller is closed or the claim is removed or released
+			return
+		case <-clientTrack.Context().Done():
+		}
+
 		bc.removeClaim(clientTrack.ID())
 		if bc.client.IsDebugEnabled() {
-			glog.Info("clienttrack: track ", clientTrack.ID(), " claim removed")
+			logger().Debug("clienttrack: track ", clientTrack.ID(), " claim removed")
 		}
 		clientTrack.Client().stats.removeSenderStats(clientTrack.ID())
 	}()
@@ -351,221 +1195,747 @@ func (bc *bitrateController) addClaim(clientTrack iClientTrack, quality QualityL
 	return bc.claims[clientTrack.ID()], nil
 }
 
-func (bc *bitrateController) removeClaim(id string) {
+// probeSimulcastLayers discover the layers that the publisher is sending and cap the max quality of the track
+// to the highest layer, so the claim is never increased to a layer that doesn't exist
+func (bc *bitrateController) probeSimulcastLayers(track *simulcastClientTrack, timeout time.Duration) {
+	defer bc.wg.Done()
+
+	ctx, cancel := context.WithCancel(track.Context())
+	defer cancel()
+
+	stop := context.AfterFunc(bc.context, cancel)
+	defer stop()
+
+	quality := track.remoteTrack.probeLayers(ctx, timeout)
+	if quality == QualityNone {
+		logger().Warn("bitratecontroller: no simulcast layer received for track ", track.ID(), " after ", timeout)
+		return
+	}
+
+	if quality < track.MaxQuality() {
+		bc.mu.RLock()
+		claim, ok := bc.claims[track.ID()]
+		bc.mu.RUnlock()
+
+		if !ok {
+			return
+		}
+
+		logger().Info("bitratecontroller: simulcast track ", track.ID(), " highest layer is ", quality, ", max quality is capped")
+
+		claim.mu.Lock()
+		claim.layerProbeCap = quality
+		claim.layerProbeUncapped = track.MaxQuality()
+		claim.mu.Unlock()
+
+		track.SetMaxQuality(quality)
+	}
+}
+
+// liftLayerProbeCap restore the max quality capped by the simulcast layers probe once the publisher send a higher layer,
+// the layer may be enabled later by the publisher or just not received within the probe timeout
+func (bc *bitrateController) liftLayerProbeCap(claim *bitrateClaim, track *simulcastClientTrack) {
+	claim.mu.Lock()
+	defer claim.mu.Unlock()
+
+	if claim.layerProbeCap == QualityNone {
+		return
+	}
+
+	// the max quality is changed after the probe, the cap is not owned by the probe anymore
+	if track.MaxQuality() != claim.layerProbeCap {
+		claim.layerProbeCap = QualityNone
+		return
+	}
+
+	since := time.Now().Add(-simulcastLayerActiveThreshold).UnixNano()
+	if track.remoteTrack.highestLayerSince(since) <= claim.layerProbeCap {
+		return
+	}
+
+	logger().Info("bitratecontroller: simulcast track ", track.ID(), " higher layer is received, max quality cap is lifted")
+	track.SetMaxQuality(claim.layerProbeUncapped)
+	claim.layerProbeCap = QualityNone
+}
+
+func (bc *bitrateController) removeClaim(id string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	claim, ok := bc.claims[id]
+	if !ok {
+		logger().Error("bitrate: track ", id, " is not exists")
+		return
+	}
+
+	claim.stopWatch()
+	delete(bc.claims, id)
+}
+
+// releaseClaims remove all claims without waiting for their tracks to end, the claims can be added back later
+// with addClaims without leaving the previous watcher goroutines running.
+func (bc *bitrateController) releaseClaims() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for id, claim := range bc.claims {
+		claim.stopWatch()
+		delete(bc.claims, id)
+	}
+}
+
+func (bc *bitrateController) exists(id string) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if _, ok := bc.claims[id]; ok {
+		return true
+	}
+
+	return false
+}
+
+// isProtectedNeedIncrease return true if a track that protected by the degradation policy can still be increased,
+// the protected tracks are increased first
+func (bc *bitrateController) isProtectedNeedIncrease() bool {
+	policy := bc.client.SFU().DegradationPolicy()
+
+	for _, claim := range bc.Claims() {
+		if policy.isProtected(claim.track) && claim.IsAdjustable() && !claim.isSuspended() &&
+			claim.Quality() < claim.track.MaxQuality() && claim.Quality() < bc.highestQuality(claim) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isThereUnprotectedCanDecrease return true if a track that not protected by the degradation policy can be reduced first
+func (bc *bitrateController) isThereUnprotectedCanDecrease(lowestQuality QualityLevel) bool {
+	policy := bc.client.SFU().DegradationPolicy()
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	for _, claim := range bc.claims {
+		if !policy.isProtected(claim.track) && (claim.track.IsScaleable() || claim.track.IsSimulcast()) && claim.Quality() > lowestQuality {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bc *bitrateController) isThereLowerPriorityCanDecrease(claim *bitrateClaim) bool {
+	for _, c := range bc.Claims() {
+		if c != claim && c.IsAdjustable() && c.priority() < claim.priority() && c.Quality() > QualityLow && c.Quality() > c.minQuality() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bc *bitrateController) isThereHigherPriorityNeedIncrease(claim *bitrateClaim) bool {
+	for _, c := range bc.Claims() {
+		if c != claim && c.IsAdjustable() && c.priority() > claim.priority() && c.Quality() <= claim.Quality() && c.Quality() < c.track.MaxQuality() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bc *bitrateController) getQuality(t *simulcastClientTrack) QualityLevel {
+	claim := bc.GetClaim(t.ID())
+	if claim == nil {
+		// this must be never reached
+		panic("bitrate: claim is not exists")
+	}
+
+	return bc.claimQuality(t, claim)
+}
+
+// claimQuality return the layer of the simulcast track that is forwarded for the claim,
+// the quality floor never override the ceiling
+func (bc *bitrateController) claimQuality(t *simulcastClientTrack, claim *bitrateClaim) QualityLevel {
+	ceiling := min(t.MaxQuality(), t.client.QualityCeiling())
+
+	quality := min(claim.Quality(), ceiling)
+	quality = max(quality, min(claim.minQuality(), ceiling))
+
+	if quality != QualityNone && !t.remoteTrack.isTrackActive(quality) {
+		if nearest := bc.nearestActiveLayer(t, quality, ceiling); nearest != QualityNone {
+			return nearest
+		}
+	}
+
+	return quality
+}
+
+// nearestActiveLayer return the active simulcast layer with the bitrate closest to the quality bitrate,
+// the lower layer is preferred on a tie because it is safer for the bandwidth. The layers above the ceiling are
+// never selected. The screen bitrates are compared for a screen track. It returns QualityNone if no layer is active.
+func (bc *bitrateController) nearestActiveLayer(t *simulcastClientTrack, quality, ceiling QualityLevel) QualityLevel {
+	track := t.remoteTrack
+	target := int64(bc.qualityBitrate(t, quality))
+	nearest := QualityLevel(QualityNone)
+	nearestDistance := int64(0)
+
+	for _, layer := range []QualityLevel{QualityLow, QualityMid, QualityHigh} {
+		if layer == quality || layer > ceiling || !track.isTrackActive(layer) {
+			continue
+		}
+
+		distance := int64(bc.qualityBitrate(t, layer)) - target
+		if distance < 0 {
+			distance = -distance
+		}
+
+		if nearest == QualityNone || distance < nearestDistance {
+			nearest = layer
+			nearestDistance = distance
+		}
+	}
+
+	return nearest
+}
+
+func (bc *bitrateController) totalSentBitrates() uint32 {
+	total := uint32(0)
+
+	for _, claim := range bc.Claims() {
+		total += claim.sentBitrate()
+	}
+
+	return total
+}
+
+// totalSentVideoBitrates is the total bitrate of the claims excluding the audio claims
+func (bc *bitrateController) totalSentVideoBitrates() uint32 {
+	total := uint32(0)
+
+	for _, claim := range bc.Claims() {
+		if claim.track.Kind() == webrtc.RTPCodecTypeAudio {
+			continue
+		}
+
+		total += claim.sentBitrate()
+	}
+
+	return total
+}
+
+// totalSentAudioBitrates is the total bitrate of the audio claims, counted in one pass over the claims
+func (bc *bitrateController) totalSentAudioBitrates() uint32 {
+	total := uint32(0)
+
+	for _, claim := range bc.Claims() {
+		if claim.track.Kind() == webrtc.RTPCodecTypeAudio {
+			total += claim.sentBitrate()
+		}
+	}
+
+	return total
+}
+
+// estimatedBandwidth return the estimated bandwidth of the client capped to the max bandwidth of the controller
+func (bc *bitrateController) estimatedBandwidth() uint32 {
+	return bc.capBandwidth(bc.client.GetEstimatedBandwidth())
+}
+
+func (bc *bitrateController) capBandwidth(bw uint32) uint32 {
+	if bc.maxBandwidth > 0 && bw > bc.maxBandwidth {
+		return bc.maxBandwidth
+	}
+
+	return bw
+}
+
+// payloadBandwidth return the bandwidth left for the payload after the packet headers overhead, the claim bitrates
+// are payload bitrates while the estimated bandwidth include the headers
+func (bc *bitrateController) payloadBandwidth(bw uint32) uint32 {
+	overhead := bc.client.SFU().BitrateConfigs().PacketOverhead
+	if overhead <= 0 {
+		return bw
+	}
+
+	return uint32(float64(bw) / (1 + overhead))
+}
+
+// videoBandwidth return the bandwidth left for the video after the audio claims and the audio headroom are reserved
+func (bc *bitrateController) videoBandwidth(bw uint32) uint32 {
+	reserved := bc.totalSentAudioBitrates()
+	if reserved > 0 {
+		reserved += bc.client.SFU().BitrateConfigs().AudioHeadroom
+	}
+
+	if reserved >= bw {
+		return 0
+	}
+
+	return bw - reserved
+}
+
+func (bc *bitrateController) start() {
+	bc.wg.Add(1)
+
+	go func() {
+		defer bc.wg.Done()
+
+		ticker := time.NewTicker(bc.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bc.context.Done():
+				return
+			case <-ticker.C:
+				bc.checkAndAdjustBitrates()
+			}
+		}
+	}()
+}
+
+// monitorStalledClaims check the stalled claims several times within the stall timeout
+func (bc *bitrateController) monitorStalledClaims() {
+	interval := bc.client.SFU().TrackStallTimeout() / 4
+	if interval < minStallCheckInterval {
+		interval = minStallCheckInterval
+	}
+
+	bc.wg.Add(1)
+
+	go func() {
+		defer bc.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bc.context.Done():
+				return
+			case <-ticker.C:
+				bc.checkStalledClaims(bc.now())
+			}
+		}
+	}()
+}
+
+// checkStalledClaims mark the claim as stalled when its track doesn't receive any packet within the stall timeout,
+// the stalled claim is excluded from the total bitrates so the bandwidth is given to the other claims.
+// When the packets are received again, the claim is counted back and a keyframe is requested.
+func (bc *bitrateController) checkStalledClaims(now time.Time) {
+	timeout := bc.client.SFU().TrackStallTimeout()
+
+	for id, claim := range bc.Claims() {
+		lastPacketTime := claim.track.lastPacketTime()
+		if lastPacketTime.Before(claim.addedTime) {
+			lastPacketTime = claim.addedTime
+		}
+
+		stalled := now.Sub(lastPacketTime) > timeout
+
+		claim.mu.Lock()
+		changed := claim.stalled != stalled
+		claim.stalled = stalled
+		claim.mu.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		if stalled {
+			logger().Warn("bitratecontroller: track ", id, " is stalled, no packet received since ", now.Sub(lastPacketTime))
+		} else {
+			logger().Info("bitratecontroller: track ", id, " is recovered from stall")
+
+			if claim.track.Kind() == webrtc.RTPCodecTypeVideo {
+				claim.track.RequestPLI()
+			}
+		}
+
+		// cancel the probe and restore the committed bitrate of the claim
+		bc.setQuality(id, claim.Quality())
+
+		bc.client.onTrackStalled(id, stalled)
+	}
+}
+
+func (bc *bitrateController) canDecreaseBitrate() bool {
+	claims := bc.Claims()
+
+	for _, claim := range claims {
+		if claim.IsAdjustable() &&
+			claim.Quality() > QualityLow {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bc *bitrateController) needIncreaseBitrate(availableBw uint32) bool {
+	claims := bc.Claims()
+
+	for _, claim := range claims {
+		if claim.isProbing() {
+			return true
+		}
+
+		if claim.IsAdjustable() &&
+			claim.Quality() < claim.track.MaxQuality() &&
+			bc.isEnoughBandwidthToIncrase(availableBw, claim) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bc *bitrateController) MonitorBandwidth(estimator cc.BandwidthEstimator) {
+	estimator.OnTargetBitrateChange(func(bw int) {
+		bc.client.onEstimatedBandwidthChange(uint32(bw))
+
+		// the REMB feedback drive the adjustment when the transport-cc feedback is not negotiated
+		if bc.client.isREMBActive() {
+			return
+		}
+
+		bc.onBandwidthChanged(uint32(bw))
+	})
+}
+
+// onREMB use the REMB feedback from the receiver as the estimated bandwidth.
+// This is only called when the transport-cc feedback is not negotiated, so the congestion controller can't estimate the bandwidth.
+func (bc *bitrateController) onREMB(bitrate uint32) {
+	bc.client.rembBandwidth.Store(bitrate)
+
+	bc.client.onEstimatedBandwidthChange(bitrate)
+
+	if !bc.useBandwidthEstimation {
+		return
+	}
+
+	bc.onBandwidthChanged(bitrate)
+}
+
+func (bc *bitrateController) onBandwidthChanged(bw uint32) {
+	if bc.isClosed() {
+		return
+	}
+
+	bw = bc.capBandwidth(bw)
+
+	var needAdjustment bool
+
+	totalSendBitrates := bc.totalSentVideoBitrates()
+
+	// the claim bitrates are payload bitrates, the estimated bandwidth include the packet headers
+	videoBw := bc.videoBandwidth(bc.payloadBandwidth(bw))
+
+	availableBw := videoBw - totalSendBitrates
+
+	if totalSendBitrates < videoBw {
+		bitrateConfigs := bc.client.sfu.BitrateConfigs()
+		if bw < bitrateConfigs.VideoMid-bitrateConfigs.VideoLow {
+			return
+		}
+
+		needAdjustment = bc.isHeadroomSustained(bc.needIncreaseBitrate(availableBw))
+	} else {
+		bc.isHeadroomSustained(false)
+		needAdjustment = bc.canDecreaseBitrate()
+	}
+
+	if !needAdjustment {
+		return
+	}
+
+	logger().Info("bitratecontroller: available bandwidth ", ThousandSeparator(int(bw)), " total bitrate ", ThousandSeparator(int(totalSendBitrates)))
+
+	bc.fitBitratesToBandwidth(bw)
+
+	bc.mu.Lock()
+	bc.lastBitrateAdjustmentTS = bc.now()
+	bc.mu.Unlock()
+}
+
+// isHeadroomSustained record if the bandwidth has the headroom to increase the bitrate now, and return true if the
+// headroom has been there for the sustained headroom duration. The headroom is reset as soon as it is gone,
+// so a transient spike of the bandwidth estimation never increase the bitrate.
+func (bc *bitrateController) isHeadroomSustained(hasHeadroom bool) bool {
+	now := bc.now()
+	sustainedHeadroom := bc.client.SFU().BitrateConfigs().SustainedHeadroom
+
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	if _, ok := bc.claims[id]; !ok {
-		glog.Error("bitrate: track ", id, " is not exists")
-		return
-	}
+	return isSustained(&bc.headroomSince, hasHeadroom, now, sustainedHeadroom)
+}
 
-	delete(bc.claims, id)
+// isHeadroomSustained is the same with bitrateController.isHeadroomSustained for the headroom to increase the claim,
+// each claim wait for its own sustained headroom
+func (c *bitrateClaim) isHeadroomSustained(hasHeadroom bool, now time.Time, sustainedHeadroom time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return isSustained(&c.headroomSince, hasHeadroom, now, sustainedHeadroom)
 }
 
-func (bc *bitrateController) exists(id string) bool {
-	bc.mu.RLock()
-	defer bc.mu.RUnlock()
+func isSustained(since *time.Time, hasHeadroom bool, now time.Time, sustainedHeadroom time.Duration) bool {
+	if !hasHeadroom {
+		*since = time.Time{}
+		return false
+	}
 
-	if _, ok := bc.claims[id]; ok {
-		return true
+	if since.IsZero() {
+		*since = now
 	}
 
-	return false
+	return now.Sub(*since) >= sustainedHeadroom
 }
 
-func (bc *bitrateController) isScreenNeedIncrease(highestQuality QualityLevel) bool {
-	bc.mu.RLock()
-	defer bc.mu.RUnlock()
+// keyframeRequests collect the keyframe requests of a bitrate adjustment and send them once on flush. Only the last
+// requested layer of a simulcast track is requested, because the track only switch to the last quality of the claim.
+type keyframeRequests struct {
+	requests map[string]keyframeRequest
+}
 
-	for _, claim := range bc.claims {
-		if claim.track.IsScreen() && claim.quality <= highestQuality {
-			return true
-		}
-	}
+type keyframeRequest struct {
+	track   iClientTrack
+	quality QualityLevel
+}
 
-	return false
+func newKeyframeRequests() *keyframeRequests {
+	return &keyframeRequests{
+		requests: make(map[string]keyframeRequest),
+	}
 }
 
-func (bc *bitrateController) isThereNonScreenCanDecrease(lowestQuality QualityLevel) bool {
-	bc.mu.RLock()
-	defer bc.mu.RUnlock()
+// request queue a keyframe request for the quality layer of the track, the quality is only used by the simulcast track
+func (r *keyframeRequests) request(track iClientTrack, quality QualityLevel) {
+	r.requests[track.ID()] = keyframeRequest{track: track, quality: quality}
+}
 
-	for _, claim := range bc.claims {
-		if !claim.track.IsScreen() && (claim.track.IsScaleable() || claim.track.IsSimulcast()) && claim.quality > lowestQuality {
-			return true
+func (r *keyframeRequests) flush() {
+	for id, req := range r.requests {
+		if track, ok := req.track.(*simulcastClientTrack); ok {
+			track.remoteTrack.sendPLI(req.quality)
+		} else {
+			req.track.RequestPLI()
 		}
-	}
 
-	return false
+		delete(r.requests, id)
+	}
 }
 
-func (bc *bitrateController) getQuality(t *simulcastClientTrack) QualityLevel {
-	track := t.remoteTrack
+// fitBitratesToBandwidth reduce or increase the claims one step at a time until the claims fit the bandwidth.
+// The claims are compared by the bitrate per priority weight, so the bandwidth is distributed proportionally to the weight.
+// When the bandwidth estimation is used, an increase is probed first with a fraction of the bitrate step
+// and only committed after the probe fit the bandwidth for a couple of estimation updates.
+func (bc *bitrateController) fitBitratesToBandwidth(bw uint32) {
+	claims := bc.Claims()
 
-	claim := bc.GetClaim(t.ID())
-	if claim == nil {
-		// this must be never reached
-		panic("bitrate: claim is not exists")
+	keyframes := newKeyframeRequests()
+	defer keyframes.flush()
+
+	// the audio is reserved off the top, so the video can never starve the audio
+	videoBw := bc.videoBandwidth(bc.payloadBandwidth(bw))
+
+	totalSentBitrates := bc.totalSentVideoBitrates()
+	if totalSentBitrates > videoBw {
+		// the probing claim is the first to give back the bandwidth
+		bc.cancelProbes(claims)
+
+		totalSentBitrates = bc.totalSentVideoBitrates()
 	}
 
-	quality := min(claim.quality, t.MaxQuality(), Uint32ToQualityLevel(t.client.quality.Load()))
+	if totalSentBitrates > videoBw {
+		// reduce bitrates
+		for totalSentBitrates > videoBw {
+			claim := nextClaimToReduce(claims, bc.client.SFU().DegradationPolicy())
+			if claim == nil {
+				return
+			}
+
+			keyframes.request(claim.track, lowerQuality(claim.Quality()))
+			logger().Info("bitratecontroller: reduce bitrate for track ", claim.track.ID(), " from ", claim.Quality(), " to ", lowerQuality(claim.Quality()))
+			bc.setQuality(claim.track.ID(), lowerQuality(claim.Quality()))
 
-	if quality != QualityNone && !track.isTrackActive(quality) {
-		if quality != QualityLow && track.isTrackActive(QualityLow) {
-			return QualityLow
+			totalSentBitrates = bc.totalSentVideoBitrates()
 		}
 
-		if quality != QualityMid && track.isTrackActive(QualityMid) {
-			return QualityMid
+		logger().Info("bitratecontroller: total sent bitrates ", ThousandSeparator(int(totalSentBitrates)), " available video bandwidth ", ThousandSeparator(int(videoBw)))
+	} else {
+		if bc.useBandwidthEstimation && bc.advanceProbes(claims, videoBw, keyframes) {
+			// only one claim is probed at a time
+			return
 		}
 
-		if quality != QualityHigh && track.isTrackActive(QualityHigh) {
-			return QualityHigh
+		// increase bitrates
+		for {
+			claim := bc.nextClaimToIncrease(claims)
+			if claim == nil {
+				return
+			}
+
+			if bc.useBandwidthEstimation {
+				bc.startProbe(claim, videoBw)
+				return
+			}
+
+			oldBitrate := claim.Bitrate()
+			newBitrate := bc.qualityBitrate(claim.track, higherQuality(claim.Quality()))
+			bitrateIncrease := newBitrate - oldBitrate
+
+			// check if the bitrate increase will more than the available bandwidth
+			if totalSentBitrates+bitrateIncrease >= videoBw {
+				return
+			}
+
+			// scaleable track will wait for the upswitch point or request PLI by itself when scaling up
+			if !claim.track.IsScaleable() {
+				keyframes.request(claim.track, higherQuality(claim.Quality()))
+			}
+
+			logger().Info("bitratecontroller: increase bitrate for track ", claim.track.ID(), " from ", claim.Quality(), " to ", higherQuality(claim.Quality()))
+			bc.setQuality(claim.track.ID(), higherQuality(claim.Quality()))
+			// update current total bitrates
+			totalSentBitrates = bc.totalSentVideoBitrates()
 		}
 	}
-
-	return quality
 }
 
-func (bc *bitrateController) totalSentBitrates() uint32 {
-	total := uint32(0)
+// startProbe reserve a fraction of the bitrate step to the next quality of the claim if it fit the video bandwidth
+func (bc *bitrateController) startProbe(claim *bitrateClaim, videoBw uint32) {
+	targetQuality := higherQuality(claim.Quality())
+	committedBitrate := bc.qualityBitrate(claim.track, claim.Quality())
+	targetBitrate := bc.qualityBitrate(claim.track, targetQuality)
+	probeBitrate := committedBitrate + uint32(float64(targetBitrate-committedBitrate)*probeBitrateFraction)
 
-	for _, claim := range bc.Claims() {
-		bc.mu.RLock()
-		total += claim.bitrate
-		bc.mu.RUnlock()
+	if bc.totalSentVideoBitrates()-committedBitrate+probeBitrate >= videoBw {
+		return
 	}
 
-	return total
+	logger().Info("bitratecontroller: probe bitrate for track ", claim.track.ID(), " to quality ", targetQuality, " with bitrate ", ThousandSeparator(int(probeBitrate)))
+
+	claim.mu.Lock()
+	claim.probeQuality = targetQuality
+	claim.probeTicks = 0
+	claim.probeStartTime = bc.now()
+	claim.bitrate = probeBitrate
+	claim.mu.Unlock()
 }
 
-func (bc *bitrateController) start() {
-	go func() {
-		context, cancel := context.WithCancel(bc.client.context)
-		defer cancel()
+// advanceProbes count the estimation updates where the probing claims fit the bandwidth and commit the probed quality
+// when the probe is stable long enough and the full bitrate of the probed quality fit the bandwidth.
+// It returns true if there is a claim that still probing.
+func (bc *bitrateController) advanceProbes(claims map[string]*bitrateClaim, videoBw uint32, keyframes *keyframeRequests) bool {
+	isProbing := false
 
-		ticker := time.NewTicker(3 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-context.Done():
-				return
-			case <-ticker.C:
-				bc.checkAndAdjustBitrates()
-			}
+	for _, claim := range claims {
+		if !claim.isProbing() {
+			continue
 		}
-	}()
-}
 
-func (bc *bitrateController) canDecreaseBitrate() bool {
-	claims := bc.Claims()
+		claim.mu.Lock()
+		claim.probeTicks++
+		probeTicks := claim.probeTicks
+		probeQuality := claim.probeQuality
+		probeBitrate := claim.bitrate
+		claim.mu.Unlock()
 
-	for _, claim := range claims {
-		if claim.IsAdjustable() &&
-			claim.Quality() > QualityLow {
-			return true
+		targetBitrate := bc.qualityBitrate(claim.track, probeQuality)
+
+		if probeTicks < probeTicksToCommit || bc.totalSentVideoBitrates()-probeBitrate+targetBitrate >= videoBw {
+			isProbing = true
+			continue
+		}
+
+		// scaleable track will wait for the upswitch point or request PLI by itself when scaling up
+		if !claim.track.IsScaleable() {
+			keyframes.request(claim.track, probeQuality)
 		}
+
+		logger().Info("bitratecontroller: increase bitrate for track ", claim.track.ID(), " from ", claim.Quality(), " to ", probeQuality)
+		bc.setQuality(claim.track.ID(), probeQuality)
 	}
 
-	return false
+	return isProbing
 }
 
-func (bc *bitrateController) needIncreaseBitrate(availableBw uint32) bool {
-	claims := bc.Claims()
-
+// cancelProbes give back the reserved probe bitrate of the claims
+func (bc *bitrateController) cancelProbes(claims map[string]*bitrateClaim) {
 	for _, claim := range claims {
-		if claim.IsAdjustable() &&
-			claim.Quality() < claim.track.MaxQuality() &&
-			bc.isEnoughBandwidthToIncrase(availableBw, claim) {
-			return true
+		if claim.isProbing() {
+			logger().Info("bitratecontroller: cancel probe for track ", claim.track.ID())
+			bc.setQuality(claim.track.ID(), claim.Quality())
 		}
 	}
-
-	return false
 }
 
-func (bc *bitrateController) MonitorBandwidth(estimator cc.BandwidthEstimator) {
-	estimator.OnTargetBitrateChange(func(bw int) {
-		var needAdjustment bool
+// nextClaimToReduce return the claim with the highest bitrate per priority weight that still can be reduced,
+// on the same ratio the lower priority claim is reduced first. The claims protected by the degradation policy
+// are only reduced when there is no other claim can be reduced.
+func nextClaimToReduce(claims map[string]*bitrateClaim, policy DegradationPolicy) *bitrateClaim {
+	var selected *bitrateClaim
 
-		totalSendBitrates := bc.totalSentBitrates()
+	for _, claim := range claims {
+		if !claim.IsAdjustable() || claim.isSuspended() || claim.Quality() <= QualityLow || claim.Quality() <= claim.minQuality() {
+			continue
+		}
 
-		availableBw := uint32(bw) - totalSendBitrates
+		if selected == nil {
+			selected = claim
+			continue
+		}
 
-		if totalSendBitrates < uint32(bw) {
-			if bw < int(bc.client.sfu.bitrateConfigs.VideoMid-bc.client.sfu.bitrateConfigs.VideoLow) {
-				return
+		if isProtected, isSelectedProtected := policy.isProtected(claim.track), policy.isProtected(selected.track); isProtected != isSelectedProtected {
+			if isSelectedProtected {
+				selected = claim
 			}
 
-			needAdjustment = bc.needIncreaseBitrate(availableBw)
-		} else {
-			needAdjustment = bc.canDecreaseBitrate()
-		}
-
-		if !needAdjustment {
-			return
+			continue
 		}
 
-		glog.Info("bitratecontroller: available bandwidth ", ThousandSeparator(int(bw)), " total bitrate ", ThousandSeparator(int(totalSendBitrates)))
+		ratio := uint64(claim.Bitrate()) * uint64(selected.priority())
+		selectedRatio := uint64(selected.Bitrate()) * uint64(claim.priority())
 
-		bc.fitBitratesToBandwidth(uint32(bw))
-
-		bc.mu.Lock()
-		bc.lastBitrateAdjustmentTS = time.Now()
-		bc.mu.Unlock()
+		if ratio > selectedRatio || (ratio == selectedRatio && claim.priority() < selected.priority()) {
+			selected = claim
+		}
+	}
 
-	})
+	return selected
 }
 
-func (bc *bitrateController) fitBitratesToBandwidth(bw uint32) {
-	totalSentBitrates := bc.totalSentBitrates()
+// nextClaimToIncrease return the claim with the lowest bitrate per priority weight that still can be increased,
+// on the same ratio the higher priority claim is increased first. The claim is never increased above the quality that
+// can be forwarded, so no bandwidth is reserved for a quality above the track max quality or the client ceiling.
+func (bc *bitrateController) nextClaimToIncrease(claims map[string]*bitrateClaim) *bitrateClaim {
+	var selected *bitrateClaim
 
-	claims := bc.Claims()
-	if totalSentBitrates > bw {
-		// reduce bitrates
-		for i := QualityHigh; i > QualityLow; i-- {
-			for _, claim := range claims {
-				if claim.IsAdjustable() &&
-					claim.Quality() == QualityLevel(i) {
-					claim.track.RequestPLI()
-					glog.Info("bitratecontroller: reduce bitrate for track ", claim.track.ID(), " from ", claim.Quality(), " to ", claim.Quality()-1)
-					bc.setQuality(claim.track.ID(), claim.Quality()-1)
-
-					totalSentBitrates = bc.totalSentBitrates()
-
-					// check if the reduced bitrate is fit to the available bandwidth
-					if totalSentBitrates <= bw {
-						glog.Info("bitratecontroller: total sent bitrates ", ThousandSeparator(int(totalSentBitrates)), " available bandwidth ", ThousandSeparator(int(bw)))
-						return
-					}
-				}
-			}
+	for _, claim := range claims {
+		if !claim.IsAdjustable() || claim.isSuspended() || claim.Quality() < QualityLow ||
+			claim.Quality() >= bc.highestQuality(claim) || claim.Quality() >= claim.track.MaxQuality() {
+			continue
 		}
-	} else {
-		// increase bitrates
-		for i := QualityLow; i < QualityHigh; i++ {
-			for _, claim := range claims {
-				if claim.IsAdjustable() &&
-					claim.Quality() == QualityLevel(i) {
-					oldBitrate := claim.Bitrate()
-					newBitrate := bc.client.SFU().QualityLevelToBitrate(claim.Quality() + 1)
-					bitrateIncrease := newBitrate - oldBitrate
-
-					// check if the bitrate increase will more than the available bandwidth
-					if totalSentBitrates+bitrateIncrease >= bw {
-						return
-					}
 
-					claim.track.RequestPLI()
-					glog.Info("bitratecontroller: increase bitrate for track ", claim.track.ID(), " from ", claim.Quality(), " to ", claim.Quality()+1)
-					bc.setQuality(claim.track.ID(), claim.Quality()+1)
-					// update current total bitrates
-					totalSentBitrates = bc.totalSentBitrates()
-				}
-			}
+		if selected == nil {
+			selected = claim
+			continue
+		}
+
+		ratio := uint64(claim.Bitrate()) * uint64(selected.priority())
+		selectedRatio := uint64(selected.Bitrate()) * uint64(claim.priority())
+
+		if ratio < selectedRatio || (ratio == selectedRatio && claim.priority() > selected.priority()) {
+			selected = claim
 		}
 	}
+
+	return selected
 }
 
 // checkAndAdjustBitrates will check if the available bandwidth is enough to send the current bitrate
@@ -573,72 +1943,86 @@ func (bc *bitrateController) fitBitratesToBandwidth(bw uint32) {
 // if the bandwidth is enough to send the current bitrate, then it will try to increase the bitrate
 // each time adjustment needed, it will only increase or decrese single track.
 func (bc *bitrateController) checkAndAdjustBitrates() {
-	currentLowestQuality := QualityLevel(QualityHigh)
-	currentHighestQuality := QualityLevel(QualityNone)
+	if bc.isClosed() {
+		return
+	}
 
-	noneCount := 0
-	lowCount := 0
-	midCount := 0
-	highCount := 0
-	screenCount := 0
+	currentLowestQuality := bc.client.SFU().HighestQuality()
+	policy := bc.client.SFU().DegradationPolicy()
+
+	// the claims are only compared with the claims on the same degradation order, so the protected claims like
+	// multiple screen shares are distributed fairly between them instead of being blocked by the other claims
+	counts := map[bool]*qualityCounts{true: {}, false: {}}
 
 	claims := bc.Claims()
 
+	// the keyframes are requested once at the end of the adjustment, so a track layer is never requested twice
+	keyframes := newKeyframeRequests()
+	defer keyframes.flush()
+
 	for _, claim := range claims {
 		allActive, quality := bc.checkAllTrackActive(claim)
 		if !allActive {
 			bc.setQuality(claim.track.ID(), quality)
 		}
 
-		if claim.quality < currentLowestQuality {
-			currentLowestQuality = claim.quality
+		claimQuality := claim.Quality()
+		if claimQuality < currentLowestQuality {
+			currentLowestQuality = claimQuality
 		}
 
-		if claim.quality > currentHighestQuality {
-			currentHighestQuality = claim.quality
-		}
+		counts[policy.isProtected(claim.track)].add(claim, claimQuality)
+	}
 
-		if claim.track.IsScreen() {
-			screenCount++
-		}
+	// stepping down one claim per adjustment take too many adjustments to recover from a bandwidth collapse
+	if bc.collapseOnSevereCongestion(claims, keyframes) {
+		return
+	}
 
-		switch claim.quality {
-		case QualityNone:
-			noneCount++
-		case QualityLow:
-			lowCount++
-		case QualityMid:
-			midCount++
-		case QualityHigh:
-			highCount++
+	for _, claim := range claims {
+		// the paused or stalled claim is not sent, there is nothing to adjust
+		if claim.isSuspended() {
+			continue
 		}
 
-	}
+		// the single layer simulcast claim is pinned by checkAllTrackActive
+		if claim.track.IsSimulcast() && !claim.isSimulcast() {
+			continue
+		}
 
-	for _, claim := range claims {
 		if claim.IsAdjustable() {
 			maxQuality := claim.track.MaxQuality()
-			if claim.quality > claim.track.MaxQuality() {
+			if claim.Quality() > claim.track.MaxQuality() {
 				bc.setQuality(claim.track.ID(), maxQuality)
 			}
 
+			// make sure the claim is not below the track quality floor
+			if minQuality := claim.minQuality(); claim.Quality() < minQuality {
+				bc.setQuality(claim.track.ID(), minQuality)
+			}
+
 			bitrateAdjustment := bc.getBitrateAdjustment(claim)
 
 			if bitrateAdjustment == keepBitrate {
-				if (claim.track.IsSimulcast() && claim.track.(*simulcastClientTrack).remoteTrack.isTrackActive(claim.quality)) || claim.track.IsScaleable() {
+				if claim.track.IsScaleable() {
+					continue
+				}
+
+				if remoteTrack, ok := simulcastRemoteTrack(claim.track); ok && remoteTrack.isTrackActive(claim.Quality()) {
 					continue
 				}
 
 				bitrateAdjustment = decreaseBitrate
 			}
 
+			quality := claim.Quality()
+
 			if bitrateAdjustment == decreaseBitrate {
-				if (claim.track.IsSimulcast() || claim.track.IsScaleable()) && claim.quality > QualityLow {
-					reducedQuality := claim.quality - 1
+				if (claim.track.IsSimulcast() || claim.track.IsScaleable()) && quality > QualityLow {
+					reducedQuality := lowerQuality(quality)
 
-					if claim.quality == QualityLow && midCount+highCount > 0 {
-						continue
-					} else if claim.quality == QualityMid && highCount > 0 {
+					// reduce the claim with the highest quality first
+					if counts[policy.isProtected(claim.track)].above(quality) > 0 {
 						continue
 					}
 
@@ -646,45 +2030,40 @@ func (bc *bitrateController) checkAndAdjustBitrates() {
 						// never reduce track to none
 						// this could make the ontrack never triggered on the receiver
 						continue
-					} else if claim.track.IsScreen() && bc.isThereNonScreenCanDecrease(currentLowestQuality) {
-						// skip if there is a non screen track can be reduced
+					} else if reducedQuality < claim.minQuality() {
+						// never reduce track below the quality floor
+						continue
+					} else if policy.isProtected(claim.track) && bc.isThereUnprotectedCanDecrease(currentLowestQuality) {
+						// skip if there is a track that not protected by the degradation policy can be reduced
+						continue
+					} else if bc.isThereLowerPriorityCanDecrease(claim) {
+						// skip if there is a lower priority track can be reduced
 						continue
 					}
 
-					if claim.track.IsSimulcast() {
-						claim.track.(*simulcastClientTrack).remoteTrack.sendPLI(reducedQuality)
-					} else {
-						claim.track.RequestPLI()
-					}
+					keyframes.request(claim.track, reducedQuality)
 
-					glog.Info("clienttrack: send pli for track ", claim.track.ID(), " quality ", reducedQuality, " changed from ", claim.quality)
+					logger().Info("clienttrack: send pli for track ", claim.track.ID(), " quality ", reducedQuality, " changed from ", quality)
 					bc.setQuality(claim.track.ID(), reducedQuality)
 
 					return
 				}
 
 			} else if bitrateAdjustment == increaseBitrate {
-				if claim.IsAdjustable() && claim.quality < claim.track.MaxQuality() {
-					increasedQuality := claim.quality + 1
+				if claim.IsAdjustable() && quality < claim.track.MaxQuality() && quality < bc.highestQuality(claim) {
+					increasedQuality := higherQuality(quality)
 
-					if claim.quality == QualityMid && noneCount+lowCount > 0 {
-						continue
-					} else if claim.quality == QualityLow && noneCount > 0 {
+					// increase the claim with the lowest quality first
+					if counts[policy.isProtected(claim.track)].below(quality) > 0 {
 						continue
 					}
 
-					if !claim.track.IsScreen() && bc.isScreenNeedIncrease(currentHighestQuality) {
+					if !policy.isProtected(claim.track) && bc.isProtectedNeedIncrease() {
 						continue
 					}
 
-					if claim.track.IsSimulcast() {
-						claim.track.(*simulcastClientTrack).remoteTrack.sendPLI(increasedQuality)
-					} else {
-						claim.track.RequestPLI()
-					}
-
-					if bc.client.IsDebugEnabled() {
-						glog.Info("clienttrack: send pli for track ", claim.track.ID(), " quality ", increasedQuality, " changed from ", claim.quality)
+					if bc.isThereHigherPriorityNeedIncrease(claim) {
+						continue
 					}
 
 					// don't increase if the quality is higher than allowed max quality
@@ -692,6 +2071,15 @@ func (bc *bitrateController) checkAndAdjustBitrates() {
 						continue
 					}
 
+					// scaleable track will wait for the upswitch point or request PLI by itself when scaling up
+					if claim.track.IsSimulcast() {
+						keyframes.request(claim.track, increasedQuality)
+					}
+
+					if bc.client.IsDebugEnabled() {
+						logger().Debug("clienttrack: send pli for track ", claim.track.ID(), " quality ", increasedQuality, " changed from ", quality)
+					}
+
 					bc.setQuality(claim.track.ID(), increasedQuality)
 
 					return
@@ -702,31 +2090,119 @@ func (bc *bitrateController) checkAndAdjustBitrates() {
 	}
 }
 
+// collapseOnSevereCongestion reduce all the adjustable claims to the highest quality that fit the video bandwidth
+// when the sent video bitrate overshoot the bandwidth by the severe congestion ratio. It return true if any claim is reduced.
+func (bc *bitrateController) collapseOnSevereCongestion(claims map[string]*bitrateClaim, keyframes *keyframeRequests) bool {
+	ratio := bc.client.SFU().BitrateConfigs().SevereCongestionRatio
+	if ratio <= 0 {
+		return false
+	}
+
+	bw := bc.videoBandwidth(bc.payloadBandwidth(bc.estimatedBandwidth()))
+	if float64(bc.totalSentVideoBitrates()) <= float64(bw)*ratio {
+		return false
+	}
+
+	fixed := uint32(0)
+	adjustable := make([]*bitrateClaim, 0, len(claims))
+
+	for _, claim := range claims {
+		if claim.track.Kind() == webrtc.RTPCodecTypeAudio || claim.isSuspended() {
+			continue
+		}
+
+		isLayered := (claim.track.IsSimulcast() && claim.isSimulcast()) || claim.track.IsScaleable()
+		if !claim.IsAdjustable() || !isLayered {
+			fixed += claim.sentBitrate()
+			continue
+		}
+
+		adjustable = append(adjustable, claim)
+	}
+
+	// find the highest quality cap that fit all the adjustable claims in the bandwidth
+	capQuality := QualityLevel(QualityLow)
+
+	for quality := bc.client.SFU().HighestQuality(); quality > QualityLow; quality = lowerQuality(quality) {
+		total := fixed
+
+		for _, claim := range adjustable {
+			total += bc.qualityBitrate(claim.track, bc.cappedQuality(claim, quality))
+		}
+
+		if total <= bw {
+			capQuality = quality
+			break
+		}
+	}
+
+	reduced := false
+
+	for _, claim := range adjustable {
+		reducedQuality := bc.cappedQuality(claim, capQuality)
+		if reducedQuality >= claim.Quality() {
+			continue
+		}
+
+		keyframes.request(claim.track, reducedQuality)
+
+		logger().Info("clienttrack: severe congestion, send pli for track ", claim.track.ID(), " quality ", reducedQuality, " changed from ", claim.Quality())
+		bc.setQuality(claim.track.ID(), reducedQuality)
+
+		reduced = true
+	}
+
+	return reduced
+}
+
+// cappedQuality return the claim quality capped to the quality without going below the claim quality floor
+func (bc *bitrateController) cappedQuality(claim *bitrateClaim, quality QualityLevel) QualityLevel {
+	capped := claim.Quality()
+	if capped > quality {
+		capped = quality
+	}
+
+	if minQuality := claim.minQuality(); capped < minQuality {
+		capped = minQuality
+	}
+
+	if capped < QualityLow {
+		capped = QualityLow
+	}
+
+	return capped
+}
+
 func (bc *bitrateController) onRemoteViewedSizeChanged(videoSize videoSize) {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
 	claim, ok := bc.claims[videoSize.TrackID]
 	if !ok {
-		glog.Error("bitrate: track ", videoSize.TrackID, " is not exists")
+		logger().Error("bitrate: track ", videoSize.TrackID, " is not exists")
 		return
 	}
 
 	if claim.track.Kind() != webrtc.RTPCodecTypeVideo {
-		glog.Error("bitrate: track ", videoSize.TrackID, " is not video track")
+		logger().Error("bitrate: track ", videoSize.TrackID, " is not video track")
 		return
 	}
 
 	if videoSize.Width == 0 || videoSize.Height == 0 {
 		claim.track.SetMaxQuality(QualityNone)
+		return
 	}
 
-	if videoSize.Width*videoSize.Height <= bc.client.sfu.bitrateConfigs.VideoLowPixels {
+	bitrateConfigs := bc.client.sfu.BitrateConfigs()
+
+	if videoSize.Width*videoSize.Height <= bitrateConfigs.VideoLowPixels {
 		claim.track.SetMaxQuality(QualityLow)
-	} else if videoSize.Width*videoSize.Height <= bc.client.sfu.bitrateConfigs.VideoMidPixels {
+	} else if videoSize.Width*videoSize.Height <= bitrateConfigs.VideoMidPixels {
 		claim.track.SetMaxQuality(QualityMid)
-	} else {
+	} else if videoSize.Width*videoSize.Height <= bitrateConfigs.VideoHighPixels {
 		claim.track.SetMaxQuality(QualityHigh)
+	} else {
+		claim.track.SetMaxQuality(bc.highestQuality(claim))
 	}
 }
 
@@ -748,35 +2224,75 @@ func (bc *bitrateController) onRemoteViewedSizeChanged(videoSize videoSize) {
 // - if the counter is 0 then increase the bitrate
 // - if the bitrate back to decrease then the delay counter will add 1.5x of the previous delay counter
 func (bc *bitrateController) getBitrateAdjustment(claim *bitrateClaim) bitrateAdjustment {
+	adjustment := bc.getClaimBitrateAdjustment(claim)
+
+	// never decrease the claim below the track quality floor
+	if adjustment == decreaseBitrate && claim.Quality() <= claim.minQuality() {
+		adjustment = keepBitrate
+	}
+
+	bc.recordDecision(claim, adjustment)
+
+	return adjustment
+}
+
+// updateClaimDelays recompute the claim delays from the RTT reported by the receiver
+func (bc *bitrateController) updateClaimDelays(claim *bitrateClaim) {
+	var rtt time.Duration
+
+	sender, err := bc.client.stats.GetSender(claim.track.ID())
+	if err == nil {
+		rtt = sender.RemoteInboundRTPStreamStats.RoundTripTime
+	}
+
+	bitrateConfigs := bc.client.SFU().BitrateConfigs()
+	claim.updateDelays(rtt, bitrateConfigs.AdjustmentDelayMin, bitrateConfigs.AdjustmentDelayMax)
+}
+
+func (bc *bitrateController) getClaimBitrateAdjustment(claim *bitrateClaim) bitrateAdjustment {
+	bc.updateClaimDelays(claim)
+
 	// don't adjust bitrates too fast
-	if time.Since(claim.lastDecreaseTime) < 2*time.Second || time.Since(claim.lastIncreaseTime) < 2*time.Second {
+	adjustmentDelay := claim.AdjustmentDelay()
+	now := bc.now()
+	lastIncreaseTime, lastDecreaseTime := claim.adjustmentTimes()
+	if now.Sub(lastDecreaseTime) < adjustmentDelay || now.Sub(lastIncreaseTime) < adjustmentDelay {
 		return keepBitrate
 	}
 
-	if claim.track.IsSimulcast() {
-		track := claim.track.(*simulcastClientTrack)
-		switch claim.quality {
+	if remoteTrack, ok := simulcastRemoteTrack(claim.track); ok {
+		switch claim.Quality() {
 		case QualityHigh:
-			if track.remoteTrack.remoteTrackHigh == nil {
+			if remoteTrack.remoteTrackHigh == nil {
 				return decreaseBitrate
 			}
 		case QualityMid:
-			if track.remoteTrack.remoteTrackMid == nil {
+			if remoteTrack.remoteTrackMid == nil {
 				return decreaseBitrate
 			}
 		case QualityLow:
-			if track.remoteTrack.remoteTrackLow == nil {
+			if remoteTrack.remoteTrackLow == nil {
 				return increaseBitrate
 			}
 		}
 	}
 
-	if bc.useBandwidthEstimation {
-		availableBandwidth := bc.client.GetEstimatedBandwidth()
-		return bc.getBitrateBasedAdjustment(availableBandwidth, claim)
-	}
+	return bc.Strategy().Adjust(claim, bc.strategyContext(claim))
+}
 
-	return bc.getLossBasedAdjustment(claim)
+// strategyContext collect the state that the strategy need to decide the adjustment of the claim
+func (bc *bitrateController) strategyContext(claim *bitrateClaim) StrategyContext {
+	lastIncreaseTime, lastDecreaseTime := claim.adjustmentTimes()
+
+	return StrategyContext{
+		EstimatedBandwidth: bc.estimatedBandwidth(),
+		TotalBitrates:      bc.totalBitrates(),
+		TotalSentBitrates:  bc.totalSentBitrates(),
+		AdjustmentDelay:    claim.AdjustmentDelay(),
+		IncreaseWindow:     claim.IncreaseWindow(),
+		LastIncreaseTime:   lastIncreaseTime,
+		LastDecreaseTime:   lastDecreaseTime,
+	}
 }
 
 func (bc *bitrateController) getBitrateBasedAdjustment(bandwidth uint32, claim *bitrateClaim) bitrateAdjustment {
@@ -784,39 +2300,55 @@ func (bc *bitrateController) getBitrateBasedAdjustment(bandwidth uint32, claim *
 		return keepBitrate
 	}
 
+	bandwidth = bc.payloadBandwidth(bandwidth)
+
 	totalBitrates := bc.totalSentBitrates()
-	if totalBitrates > bandwidth && claim.quality != QualityNone {
+	sustainedHeadroom := bc.client.SFU().BitrateConfigs().SustainedHeadroom
+
+	if totalBitrates > bandwidth {
+		claim.isHeadroomSustained(false, bc.now(), sustainedHeadroom)
+	}
+
+	if totalBitrates > bandwidth && claim.Quality() != QualityNone {
 		// if we got decrease after we increase within short time, then we need to delay the next increase
-		if time.Since(claim.lastIncreaseTime) < 10*time.Second {
+		if lastIncreaseTime, _ := claim.adjustmentTimes(); bc.now().Sub(lastIncreaseTime) < claim.IncreaseWindow() {
 			if bc.client.IsDebugEnabled() {
-				glog.Info("bitrate: track ", claim.track.ID(), " decrease bitrate too fast, delay increase bitrate")
+				logger().Debug("bitrate: track ", claim.track.ID(), " decrease bitrate too fast, delay increase bitrate")
 			}
 			claim.pushbackDelayCounter()
 		}
 
 		if bc.client.IsDebugEnabled() {
-			glog.Info("bitrate: track ", claim.track.ID(), " decrease bitrate. Availabel bandwidth ", ThousandSeparator(int(bandwidth)), " total bitrate ", ThousandSeparator(int(totalBitrates)))
+			logger().Debug("bitrate: track ", claim.track.ID(), " decrease bitrate. Availabel bandwidth ", ThousandSeparator(int(bandwidth)), " total bitrate ", ThousandSeparator(int(totalBitrates)))
 		}
 
 		return decreaseBitrate
-	} else if totalBitrates < bandwidth && claim.quality != QualityHigh {
-		if !bc.useBandwidthEstimation && !claim.isAllowToIncrease() {
+	} else if totalBitrates < bandwidth && claim.Quality() < bc.highestQuality(claim) {
+		if !bc.useBandwidthEstimation && !claim.isAllowToIncrease(bc.now()) {
 			if bc.client.IsDebugEnabled() {
-				glog.Info("bitrate: track ", claim.track.ID(), " increase bitrate too fast, delay increase bitrate")
+				logger().Debug("bitrate: track ", claim.track.ID(), " increase bitrate too fast, delay increase bitrate")
 			}
 			return keepBitrate
 		}
 
 		if !bc.isEnoughBandwidthToIncrase(bandwidth, claim) {
 			if bc.client.IsDebugEnabled() {
-				glog.Info("bitrate: track ", claim.track.ID(), " not enough bandwidth to increase bitrate")
+				logger().Debug("bitrate: track ", claim.track.ID(), " not enough bandwidth to increase bitrate")
+			}
+
+			return keepBitrate
+		}
+
+		if !claim.isHeadroomSustained(true, bc.now(), sustainedHeadroom) {
+			if bc.client.IsDebugEnabled() {
+				logger().Debug("bitrate: track ", claim.track.ID(), " headroom is not sustained yet, delay increase bitrate")
 			}
 
 			return keepBitrate
 		}
 
 		if bc.client.IsDebugEnabled() {
-			glog.Info("bitrate: track ", claim.track.ID(), " increase bitrate. Availabel bandwidth ", ThousandSeparator(int(bandwidth)), " total bitrate ", ThousandSeparator(int(totalBitrates)))
+			logger().Debug("bitrate: track ", claim.track.ID(), " increase bitrate. Availabel bandwidth ", ThousandSeparator(int(bandwidth)), " total bitrate ", ThousandSeparator(int(totalBitrates)))
 		}
 
 		return increaseBitrate
@@ -826,14 +2358,14 @@ func (bc *bitrateController) getBitrateBasedAdjustment(bandwidth uint32, claim *
 }
 
 func (bc *bitrateController) isEnoughBandwidthToIncrase(bandwidthLeft uint32, claim *bitrateClaim) bool {
-	nextQuality := claim.Quality() + 1
+	nextQuality := higherQuality(claim.Quality())
 
-	if nextQuality > QualityHigh {
+	if nextQuality > bc.highestQuality(claim) {
 		return false
 	}
 
-	nextBitrate := bc.client.sfu.QualityLevelToBitrate(nextQuality)
-	currentBitrate := bc.client.sfu.QualityLevelToBitrate(claim.Quality())
+	nextBitrate := bc.qualityBitrate(claim.track, nextQuality)
+	currentBitrate := bc.qualityBitrate(claim.track, claim.Quality())
 
 	bandwidthGap := nextBitrate - currentBitrate
 
@@ -843,38 +2375,52 @@ func (bc *bitrateController) isEnoughBandwidthToIncrase(bandwidthLeft uint32, cl
 func (bc *bitrateController) getLossBasedAdjustment(claim *bitrateClaim) bitrateAdjustment {
 	sender, err := bc.client.stats.GetSender(claim.track.ID())
 	if err != nil {
-		glog.Error("bitrate: track ", claim.track.ID(), " is not exists")
+		logger().Error("bitrate: track ", claim.track.ID(), " is not exists")
 		return keepBitrate
 	}
 
 	lostSentRatio := sender.RemoteInboundRTPStreamStats.FractionLost
+	increaseThreshold, decreaseThreshold := bc.LossThresholds()
+
+	report := sender.RemoteInboundRTPStreamStats.PacketsReceived + uint64(sender.RemoteInboundRTPStreamStats.PacketsLost)
+	highLossReports := claim.countHighLossReport(report, lostSentRatio > decreaseThreshold)
 
-	if lostSentRatio < 0.02 && claim.quality != QualityHigh {
+	if lostSentRatio < increaseThreshold && claim.Quality() < bc.highestQuality(claim) {
 		if bc.client.IsDebugEnabled() {
-			glog.Info("bitrate: track ", claim.track.ID(), " lost ratio ", lostSentRatio, " can increase bitrate")
+			logger().Debug("bitrate: track ", claim.track.ID(), " lost ratio ", lostSentRatio, " can increase bitrate")
 		}
 
-		if !bc.useBandwidthEstimation && !claim.isAllowToIncrease() {
+		if !bc.useBandwidthEstimation && !claim.isAllowToIncrease(bc.now()) {
 			if bc.client.IsDebugEnabled() {
-				glog.Info("bitrate: track ", claim.track.ID(), " increase bitrate too fast, delay increase bitrate")
+				logger().Debug("bitrate: track ", claim.track.ID(), " increase bitrate too fast, delay increase bitrate")
 			}
 			return keepBitrate
 		}
 
 		return increaseBitrate
-	} else if lostSentRatio > 0.1 && claim.quality != QualityNone {
+	} else if lostSentRatio > decreaseThreshold && claim.Quality() != QualityNone {
+		// a single spike is ignored, the loss must be sustained before the bitrate is decreased
+		if highLossReports < bc.LossDecreaseReports() {
+			if bc.client.IsDebugEnabled() {
+				logger().Debug("bitrate: track ", claim.track.ID(), " lost ratio ", lostSentRatio, " wait for consecutive high loss reports")
+			}
+
+			return keepBitrate
+		}
+
 		if bc.client.IsDebugEnabled() {
-			glog.Info("bitrate: track ", claim.track.ID(), " lost ratio ", lostSentRatio, " need to decrease bitrate")
+			logger().Debug("bitrate: track ", claim.track.ID(), " lost ratio ", lostSentRatio, " need to decrease bitrate")
 		}
 
 		if bc.client.IsDebugEnabled() {
-			glog.Info("last increase time ", time.Since(claim.lastIncreaseTime).Milliseconds(), " ms")
+			lastIncreaseTime, _ := claim.adjustmentTimes()
+			logger().Debug("last increase time ", bc.now().Sub(lastIncreaseTime).Milliseconds(), " ms")
 		}
 
 		// if we got decrease after we increase within short time, then we need to delay the next increase
-		if time.Since(claim.lastIncreaseTime) < 10*time.Second {
+		if lastIncreaseTime, _ := claim.adjustmentTimes(); bc.now().Sub(lastIncreaseTime) < claim.IncreaseWindow() {
 			if bc.client.IsDebugEnabled() {
-				glog.Info("bitrate: track ", claim.track.ID(), " decrease bitrate too fast, delay increase bitrate")
+				logger().Debug("bitrate: track ", claim.track.ID(), " decrease bitrate too fast, delay increase bitrate")
 			}
 			claim.pushbackDelayCounter()
 		}
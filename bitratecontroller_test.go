@@ -0,0 +1,2203 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// testClientTrack is a minimal iClientTrack that is not connected to any peer connection,
+// it is used to test the bitrate controller allocation logic
+type testClientTrack struct {
+	mu         sync.Mutex
+	id         string
+	context    context.Context
+	cancel     context.CancelFunc
+	client     *Client
+	kind       webrtc.RTPCodecType
+	localTrack *webrtc.TrackLocalStaticRTP
+	simulcast  bool
+	scaleable  bool
+	isScreen   bool
+	maxQuality QualityLevel
+	minQuality QualityLevel
+	priority   int
+	pliCount   *atomic.Int32
+	paused     atomic.Bool
+	redundancy atomic.Bool
+	pushCount  atomic.Int32
+}
+
+func newTestClientTrack(t *testing.T, c *Client, id string, kind webrtc.RTPCodecType, scaleable bool) *testClientTrack {
+	ctx, cancel := context.WithCancel(c.context)
+	t.Cleanup(cancel)
+
+	mimeType := webrtc.MimeTypeVP9
+	if kind == webrtc.RTPCodecTypeAudio {
+		mimeType = webrtc.MimeTypeOpus
+	}
+
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mimeType}, id, "stream-"+id)
+	require.NoError(t, err)
+
+	return &testClientTrack{
+		id:         id,
+		context:    ctx,
+		cancel:     cancel,
+		client:     c,
+		kind:       kind,
+		localTrack: localTrack,
+		scaleable:  scaleable,
+		maxQuality: QualityHigh,
+		minQuality: QualityNone,
+		priority:   DefaultPriority,
+		pliCount:   &atomic.Int32{},
+	}
+}
+
+func (t *testClientTrack) push(_ rtp.Packet, _ QualityLevel) {
+	t.pushCount.Add(1)
+}
+
+func (t *testClientTrack) AvailableQualities() []QualityLevel {
+	return []QualityLevel{QualityLow, QualityMid, QualityHigh}
+}
+
+func (t *testClientTrack) setRedundancy(enabled bool) {
+	t.redundancy.Store(enabled)
+}
+
+func (t *testClientTrack) Pause() {
+	t.paused.Store(true)
+	t.client.bitrateController.setPaused(t.ID(), true)
+}
+
+func (t *testClientTrack) Resume() {
+	if !t.paused.Swap(false) {
+		return
+	}
+
+	t.client.bitrateController.setPaused(t.ID(), false)
+
+	// the client need a keyframe to decode the track after the gap
+	t.RequestPLI()
+}
+
+func (t *testClientTrack) IsPaused() bool {
+	return t.paused.Load()
+}
+
+func (t *testClientTrack) lastPacketTime() time.Time {
+	return time.Now()
+}
+
+func (t *testClientTrack) ID() string {
+	return t.id
+}
+
+func (t *testClientTrack) Context() context.Context {
+	return t.context
+}
+
+func (t *testClientTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+func (t *testClientTrack) LocalTrack() *webrtc.TrackLocalStaticRTP {
+	return t.localTrack
+}
+
+func (t *testClientTrack) IsScreen() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.isScreen
+}
+
+func (t *testClientTrack) IsSimulcast() bool {
+	return t.simulcast
+}
+
+func (t *testClientTrack) IsScaleable() bool {
+	return t.scaleable
+}
+
+func (t *testClientTrack) SetSourceType(sourceType TrackType) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.isScreen = sourceType == TrackTypeScreen
+}
+
+func (t *testClientTrack) Client() *Client {
+	return t.client
+}
+
+func (t *testClientTrack) RequestPLI() {
+	t.pliCount.Add(1)
+}
+
+func (t *testClientTrack) SetMaxQuality(quality QualityLevel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.maxQuality = quality
+}
+
+func (t *testClientTrack) MaxQuality() QualityLevel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.maxQuality
+}
+
+func (t *testClientTrack) SetMinQuality(quality QualityLevel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.minQuality = quality
+}
+
+func (t *testClientTrack) OnQualityChange(_ func(old, new QualityLevel)) {}
+
+func (t *testClientTrack) SetLastQuality(_ QualityLevel) {}
+
+func (t *testClientTrack) SetPriority(weight int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.priority = weight
+}
+
+func (t *testClientTrack) Priority() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.priority
+}
+
+func (t *testClientTrack) MinQuality() QualityLevel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.minQuality
+}
+
+// newTestClient create a client without peer connection that use the initial bandwidth as the estimated bandwidth
+func newTestClient(t *testing.T, bandwidth uint32) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bitrates := DefaultBitrates()
+	bitrates.InitialBandwidth = bandwidth
+	// the tests compute the bandwidth from the payload bitrates, the overhead is tested separately
+	bitrates.PacketOverhead = 0
+
+	s := New(ctx, sfuOptions{
+		Bitrates:      bitrates,
+		QualityPreset: DefaultQualityPreset(),
+		Codecs:        []string{webrtc.MimeTypeVP9, webrtc.MimeTypeOpus},
+	})
+
+	quality := &atomic.Uint32{}
+	quality.Store(QualityVeryHigh)
+
+	c := &Client{
+		id:                 GenerateID(),
+		context:            s.context,
+		sfu:                s,
+		quality:            quality,
+		receivingBandwidth: &atomic.Uint32{},
+		egressBandwidth:    &atomic.Uint32{},
+		ingressBandwidth:   &atomic.Uint32{},
+		rembBandwidth:      &atomic.Uint32{},
+		maxDecodeQuality:   &atomic.Uint32{},
+	}
+
+	c.maxDecodeQuality.Store(QualityVeryHigh)
+
+	c.stats = newClientStats(c)
+	c.bitrateController = newbitrateController(c, 0, true)
+
+	return c
+}
+
+func TestMinQualityFloor(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	// only enough bandwidth for a mid and a low track
+	client := newTestClient(t, bitrates.VideoMid+bitrates.VideoLow+10_000)
+	bc := client.bitrateController
+
+	speaker := newTestClientTrack(t, client, "speaker", webrtc.RTPCodecTypeVideo, true)
+	speaker.SetMinQuality(QualityMid)
+	other := newTestClientTrack(t, client, "other", webrtc.RTPCodecTypeVideo, true)
+
+	_, err := bc.addClaim(speaker, QualityHigh, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(other, QualityHigh, true)
+	require.NoError(t, err)
+
+	bc.fitBitratesToBandwidth(client.GetEstimatedBandwidth())
+
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(speaker.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(other.ID()).Quality())
+
+	// the floor track is never reduced even when the bandwidth is not enough
+	bc.fitBitratesToBandwidth(bitrates.VideoLow)
+
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(speaker.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(other.ID()).Quality())
+}
+
+func TestIncreaseWindowFollowRTT(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// without RTT measurement use the default window
+	bc.updateClaimDelays(claim)
+	require.Equal(t, defaultAdjustmentDelay, claim.AdjustmentDelay())
+	require.Equal(t, defaultAdjustmentDelay*increaseWindowMultiplier, claim.IncreaseWindow())
+
+	setRTT := func(rtt time.Duration) {
+		s := stats.Stats{}
+		s.RemoteInboundRTPStreamStats.RoundTripTime = rtt
+		client.stats.SetSender(track.ID(), s)
+		bc.updateClaimDelays(claim)
+	}
+
+	// high RTT expand the window
+	setRTT(200 * time.Millisecond)
+	require.Equal(t, 4*time.Second, claim.AdjustmentDelay())
+	require.Equal(t, 20*time.Second, claim.IncreaseWindow())
+
+	// clamped to the configured max
+	setRTT(time.Second)
+	require.Equal(t, DefaultBitrates().AdjustmentDelayMax, claim.AdjustmentDelay())
+
+	// low RTT shrink the window but not below the configured min
+	setRTT(10 * time.Millisecond)
+	require.Equal(t, DefaultBitrates().AdjustmentDelayMin, claim.AdjustmentDelay())
+	require.Less(t, claim.IncreaseWindow(), defaultAdjustmentDelay*increaseWindowMultiplier)
+}
+
+func TestBandwidthSnapshot(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	audio := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+	video := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+
+	_, err := bc.addClaim(audio, QualityAudio, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(video, QualityMid, true)
+	require.NoError(t, err)
+
+	// the paused claim keep its allocated bitrate but send nothing
+	paused := newTestClientTrack(t, client, "paused", webrtc.RTPCodecTypeVideo, true)
+	_, err = bc.addClaim(paused, QualityLow, true)
+	require.NoError(t, err)
+	bc.setPaused(paused.ID(), true)
+
+	snapshot := client.BandwidthSnapshot()
+
+	require.Equal(t, client.GetEstimatedBandwidth(), snapshot.EstimatedBandwidth)
+	require.Equal(t, bc.totalSentBitrates(), snapshot.TotalSentBitrate)
+	require.Equal(t, snapshot.TotalAllocatedBitrate-bc.GetClaim(paused.ID()).Bitrate(), snapshot.TotalSentBitrate)
+	require.Less(t, snapshot.TotalSentBitrate, snapshot.TotalAllocatedBitrate)
+	require.Len(t, snapshot.Claims, 3)
+
+	total := uint32(0)
+	for _, claim := range snapshot.Claims {
+		total += claim.Bitrate
+		require.Equal(t, bc.GetClaim(claim.TrackID).Quality(), claim.Quality)
+	}
+
+	require.Equal(t, snapshot.TotalAllocatedBitrate, total)
+}
+
+func TestPriorityWeight(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	// only enough bandwidth for a mid and a low track
+	client := newTestClient(t, bitrates.VideoMid+bitrates.VideoLow+10_000)
+	bc := client.bitrateController
+
+	speaker := newTestClientTrack(t, client, "speaker", webrtc.RTPCodecTypeVideo, true)
+	speaker.SetPriority(3)
+	other := newTestClientTrack(t, client, "other", webrtc.RTPCodecTypeVideo, true)
+
+	_, err := bc.addClaim(speaker, QualityHigh, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(other, QualityHigh, true)
+	require.NoError(t, err)
+
+	bc.fitBitratesToBandwidth(client.GetEstimatedBandwidth())
+
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(speaker.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(other.ID()).Quality())
+
+	// the lower priority track is already at the lowest quality, so the speaker can be reduced in the adjustment loop
+	require.False(t, bc.isThereLowerPriorityCanDecrease(bc.GetClaim(speaker.ID())))
+
+	// the higher priority track get the high quality when the bandwidth is not enough for both,
+	// each increase is probed before committed so fit a few times until it is stable
+	for i := 0; i < 2*(probeTicksToCommit+1); i++ {
+		bc.fitBitratesToBandwidth(bitrates.VideoHigh + bitrates.VideoMid + 10_000)
+	}
+
+	require.Equal(t, QualityLevel(QualityHigh), bc.GetClaim(speaker.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(other.ID()).Quality())
+}
+
+func TestDistributedQualityByPriority(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 1_000_000)
+	bc := client.bitrateController
+
+	// let the distribution decide the quality
+	require.NoError(t, client.SFU().SetStartupQuality(QualityHigh))
+
+	other := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	other.id = "other"
+	speaker := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	speaker.id = "speaker"
+	speaker.SetPriority(3)
+
+	require.NoError(t, bc.addClaims([]iClientTrack{other, speaker}))
+
+	// 1/4 of the bandwidth for the other track and the rest for the speaker
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(other.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(speaker.ID()).Quality())
+}
+
+func TestStartupQuality(t *testing.T) {
+	t.Parallel()
+
+	// the cold estimation is enough for a high quality
+	client := newTestClient(t, DefaultBitrates().VideoHigh*4)
+	bc := client.bitrateController
+
+	first := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	first.id = "first"
+
+	require.NoError(t, bc.addClaims([]iClientTrack{first}))
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(first.ID()).Quality())
+
+	require.ErrorIs(t, client.SFU().SetStartupQuality(QualityNone), ErrInvalidStartupQuality)
+	require.NoError(t, client.SFU().SetStartupQuality(QualityMid))
+
+	second := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	second.id = "second"
+
+	require.NoError(t, bc.addClaims([]iClientTrack{second}))
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(second.ID()).Quality())
+
+	// the existing claim is not changed
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(first.ID()).Quality())
+}
+
+func TestAddClaimsErrors(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.options.MaxClaims = 1
+	bc := client.bitrateController
+
+	first := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	first.id = "first"
+	require.NoError(t, bc.addClaims([]iClientTrack{first}))
+
+	second := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	second.id = "second"
+
+	err := bc.addClaims([]iClientTrack{first, second})
+	require.ErrorIs(t, err, ErrAlreadyClaimed)
+	require.ErrorIs(t, err, ErrTooManyClaims)
+	require.NotErrorIs(t, err, ErrorInsufficientBandwidth)
+
+	var multiErr *MultiError
+	require.ErrorAs(t, err, &multiErr)
+	require.Equal(t, []error{ErrAlreadyClaimed, ErrTooManyClaims}, multiErr.Errors())
+
+	require.NoError(t, FlattenErrors(nil))
+}
+
+func TestProbeBeforeIncrease(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.VideoLow+10_000)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// tight bandwidth that fit the probe but not the full step to the mid quality
+	step := bitrates.VideoMid - bitrates.VideoLow
+	tightBandwidth := bitrates.VideoLow + step*3/4
+
+	bc.fitBitratesToBandwidth(tightBandwidth)
+	require.True(t, claim.isProbing())
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	require.Equal(t, bitrates.VideoLow+uint32(float64(step)*probeBitrateFraction), claim.Bitrate())
+
+	// never committed while the full step does not fit
+	for i := 0; i < probeTicksToCommit*2; i++ {
+		bc.fitBitratesToBandwidth(tightBandwidth)
+	}
+
+	require.True(t, claim.isProbing())
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+
+	// the probe is cancelled when the bandwidth drop
+	bc.fitBitratesToBandwidth(bitrates.VideoLow + 10_000)
+	require.False(t, claim.isProbing())
+	require.Equal(t, bitrates.VideoLow, claim.Bitrate())
+
+	// with enough bandwidth the increase is committed after the probe ticks
+	bandwidth := bitrates.VideoMid + 10_000
+	bc.fitBitratesToBandwidth(bandwidth)
+	require.True(t, claim.isProbing())
+
+	for i := 0; i < probeTicksToCommit-1; i++ {
+		bc.fitBitratesToBandwidth(bandwidth)
+		require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	}
+
+	bc.fitBitratesToBandwidth(bandwidth)
+	require.False(t, claim.isProbing())
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+	require.Equal(t, bitrates.VideoMid, claim.Bitrate())
+}
+
+func TestSingleStepIncreaseWithoutBandwidthEstimation(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.VideoLow+10_000)
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	bc.fitBitratesToBandwidth(bitrates.VideoMid + 10_000)
+	require.False(t, claim.isProbing())
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+}
+
+func TestREMBFallback(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+	// the REMB reports are sent back to back on the real clock
+	client.sfu.bitrateConfigs.SustainedHeadroom = 0
+
+	first := newTestClientTrack(t, client, "first", webrtc.RTPCodecTypeVideo, true)
+	second := newTestClientTrack(t, client, "second", webrtc.RTPCodecTypeVideo, true)
+
+	_, err := bc.addClaim(first, QualityHigh, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(second, QualityHigh, true)
+	require.NoError(t, err)
+
+	require.False(t, client.isREMBActive())
+
+	// the receiver report a low bandwidth through REMB
+	remb := bitrates.VideoLow*2 + 10_000
+	bc.onREMB(remb)
+
+	require.True(t, client.isREMBActive())
+	require.Equal(t, remb, client.GetEstimatedBandwidth())
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(first.ID()).Quality())
+	require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(second.ID()).Quality())
+
+	// the bandwidth recover, the increase is probed and committed on the next REMB reports
+	remb = bitrates.VideoMid + bitrates.VideoLow + 10_000
+	for i := 0; i <= probeTicksToCommit; i++ {
+		bc.onREMB(remb)
+	}
+
+	require.Equal(t, bitrates.VideoMid+bitrates.VideoLow, bc.totalSentBitrates())
+}
+
+func TestIsTransportCCNegotiated(t *testing.T) {
+	t.Parallel()
+
+	params := webrtc.RTPSendParameters{}
+	params.Codecs = []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, RTCPFeedback: []webrtc.RTCPFeedback{{Type: webrtc.TypeRTCPFBGoogREMB}}}},
+	}
+
+	require.False(t, isTransportCCNegotiated(params))
+
+	params.Codecs[0].RTCPFeedback = append(params.Codecs[0].RTCPFeedback, webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC})
+
+	require.True(t, isTransportCCNegotiated(params))
+}
+
+func TestSetQualityBitrates(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	require.NoError(t, client.sfu.clients.Add(client))
+
+	bc := client.bitrateController
+	s := client.SFU()
+
+	existing := newTestClientTrack(t, client, "existing", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(existing, QualityMid, true)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, s.SetQualityBitrates(500_000, 150_000, 2_500_000), ErrInvalidQualityBitrates)
+	require.ErrorIs(t, s.SetQualityBitrates(150_000, 500_000, 500_000), ErrInvalidQualityBitrates)
+	require.ErrorIs(t, s.SetQualityBitrates(0, 500_000, 2_500_000), ErrInvalidQualityBitrates)
+
+	require.NoError(t, s.SetQualityBitrates(150_000, 500_000, 2_500_000))
+
+	require.Equal(t, uint32(150_000), s.QualityLevelToBitrate(QualityLow))
+	require.Equal(t, uint32(500_000), s.QualityLevelToBitrate(QualityMid))
+	require.Equal(t, uint32(2_500_000), s.QualityLevelToBitrate(QualityHigh))
+	require.Equal(t, uint32(500_000), s.BitrateConfigs().VideoMid)
+
+	// the existing claim is updated to the new mapping
+	require.Equal(t, uint32(500_000), claim.Bitrate())
+
+	// new claims use the new bitrates
+	track := newTestClientTrack(t, client, "new", webrtc.RTPCodecTypeVideo, true)
+	newClaim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2_500_000), newClaim.Bitrate())
+}
+
+func TestAudioReservedBeforeVideo(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+
+	audioTracks := make([]*testClientTrack, 0)
+	for _, id := range []string{"audio-1", "audio-2"} {
+		track := newTestClientTrack(t, client, id, webrtc.RTPCodecTypeAudio, false)
+		_, err := bc.addClaim(track, QualityAudio, true)
+		require.NoError(t, err)
+
+		audioTracks = append(audioTracks, track)
+	}
+
+	videoTracks := make([]*testClientTrack, 0)
+	for _, id := range []string{"video-1", "video-2", "video-3", "video-4"} {
+		track := newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true)
+		_, err := bc.addClaim(track, QualityHigh, true)
+		require.NoError(t, err)
+
+		videoTracks = append(videoTracks, track)
+	}
+
+	audioReserved := 2*bitrates.Audio + bitrates.AudioHeadroom
+
+	// the bandwidth is only enough for the audio and the low quality videos
+	bandwidth := audioReserved + 4*bitrates.VideoLow + 10_000
+	require.Equal(t, 4*bitrates.VideoLow+10_000, bc.videoBandwidth(bandwidth))
+
+	bc.fitBitratesToBandwidth(bandwidth)
+
+	for _, track := range audioTracks {
+		claim := bc.GetClaim(track.ID())
+		require.Equal(t, QualityLevel(QualityAudio), claim.Quality())
+		require.Equal(t, bitrates.Audio, claim.Bitrate())
+		require.Equal(t, int32(0), track.pliCount.Load())
+	}
+
+	for _, track := range videoTracks {
+		require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(track.ID()).Quality())
+	}
+
+	require.LessOrEqual(t, bc.totalSentBitrates(), bandwidth)
+
+	// the video is never increased into the audio headroom
+	for i := 0; i <= probeTicksToCommit; i++ {
+		bc.fitBitratesToBandwidth(audioReserved + 3*bitrates.VideoLow + bitrates.VideoMid - 10_000)
+	}
+
+	for _, track := range videoTracks {
+		require.Equal(t, QualityLevel(QualityLow), bc.GetClaim(track.ID()).Quality())
+	}
+}
+
+type alwaysIncreaseStrategy struct {
+	contexts chan StrategyContext
+}
+
+func (s alwaysIncreaseStrategy) Adjust(_ *BitrateClaim, ctx StrategyContext) BitrateAdjustment {
+	select {
+	case s.contexts <- ctx:
+	default:
+	}
+
+	return IncreaseBitrate
+}
+
+func TestCustomBitrateStrategy(t *testing.T) {
+	t.Parallel()
+
+	// the bandwidth is not enough to increase with the default strategy
+	client := newTestClient(t, DefaultBitrates().VideoLow)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Equal(t, KeepBitrate, bc.getBitrateAdjustment(claim))
+
+	strategy := alwaysIncreaseStrategy{contexts: make(chan StrategyContext, 1)}
+	client.SetBitrateStrategy(strategy)
+
+	require.Equal(t, IncreaseBitrate, bc.getBitrateAdjustment(claim))
+
+	ctx := <-strategy.contexts
+	require.Equal(t, client.GetEstimatedBandwidth(), ctx.EstimatedBandwidth)
+	require.Equal(t, bc.totalSentBitrates(), ctx.TotalSentBitrates)
+	require.Equal(t, claim.AdjustmentDelay(), ctx.AdjustmentDelay)
+
+	bc.checkAndAdjustBitrates()
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+
+	// reset to the default strategy
+	client.SetBitrateStrategy(nil)
+	require.IsType(t, bandwidthBasedStrategy{}, bc.Strategy())
+}
+
+func TestLossThresholds(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	setFractionLost := func(fractionLost float64) {
+		s := newReceiverReport(fractionLost)
+		client.stats.SetSender(track.ID(), s)
+	}
+
+	// decrease on the first high loss report to test the thresholds alone
+	require.NoError(t, client.SetLossDecreaseReports(1))
+
+	// the default thresholds
+	setFractionLost(0.03)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+	setFractionLost(0.15)
+	require.Equal(t, DecreaseBitrate, bc.getLossBasedAdjustment(claim))
+
+	require.ErrorIs(t, client.SetLossThresholds(0.1, 0.1), ErrInvalidLossThresholds)
+	require.ErrorIs(t, client.SetLossThresholds(-0.1, 0.1), ErrInvalidLossThresholds)
+	require.ErrorIs(t, client.SetLossThresholds(0.1, 1.1), ErrInvalidLossThresholds)
+
+	// a more tolerant network
+	require.NoError(t, client.SetLossThresholds(0.05, 0.2))
+
+	setFractionLost(0.03)
+	require.Equal(t, IncreaseBitrate, bc.getLossBasedAdjustment(claim))
+	setFractionLost(0.15)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+	setFractionLost(0.25)
+	require.Equal(t, DecreaseBitrate, bc.getLossBasedAdjustment(claim))
+}
+
+func TestLossDecreaseReports(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	setFractionLost := func(fractionLost float64) {
+		s := newReceiverReport(fractionLost)
+		client.stats.SetSender(track.ID(), s)
+	}
+
+	require.Equal(t, DefaultLossDecreaseReports, bc.LossDecreaseReports())
+	require.ErrorIs(t, client.SetLossDecreaseReports(0), ErrInvalidLossDecreaseReports)
+
+	// a single spike followed by normal reports doesn't decrease the bitrate
+	setFractionLost(0.3)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+	setFractionLost(0.05)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+	setFractionLost(0.3)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+
+	// the increase is not delayed
+	setFractionLost(0)
+	require.Equal(t, IncreaseBitrate, bc.getLossBasedAdjustment(claim))
+
+	// the same report read by the next adjustments is counted once
+	setFractionLost(0.3)
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+	require.Equal(t, KeepBitrate, bc.getLossBasedAdjustment(claim))
+
+	// the sustained loss decrease the bitrate
+	setFractionLost(0.3)
+	require.Equal(t, DecreaseBitrate, bc.getLossBasedAdjustment(claim))
+	require.Equal(t, DecreaseBitrate, bc.getLossBasedAdjustment(claim))
+}
+
+func TestBitrateControllerClose(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.VideoHigh*2)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	bc.Close()
+
+	// the bandwidth drop is ignored after closed
+	bc.onBandwidthChanged(bitrates.VideoLow)
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+
+	bc.checkAndAdjustBitrates()
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+
+	// the claim watcher is stopped, so the claim is not removed when the track is ended
+	track.cancel()
+	require.True(t, bc.exists(track.ID()))
+
+	_, err = bc.addClaim(newTestClientTrack(t, client, "video2", webrtc.RTPCodecTypeVideo, true), QualityHigh, true)
+	require.ErrorIs(t, err, ErrBitrateControllerClosed)
+
+	// double close is safe
+	bc.Close()
+}
+
+func TestScreenBitrateProfile(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.InitialBandwidth)
+	bc := client.bitrateController
+
+	camera := newTestClientTrack(t, client, "camera", webrtc.RTPCodecTypeVideo, true)
+	screen := newTestClientTrack(t, client, "screen", webrtc.RTPCodecTypeVideo, true)
+	screen.isScreen = true
+
+	cameraClaim, err := bc.addClaim(camera, QualityMid, true)
+	require.NoError(t, err)
+
+	screenClaim, err := bc.addClaim(screen, QualityMid, true)
+	require.NoError(t, err)
+
+	require.Equal(t, bitrates.VideoMid, cameraClaim.Bitrate())
+	require.Equal(t, bitrates.ScreenMid, screenClaim.Bitrate())
+	require.NotEqual(t, cameraClaim.Bitrate(), screenClaim.Bitrate())
+
+	// the screen bitrate is used on the quality change as well
+	bc.setQuality(screen.ID(), QualityHigh)
+	require.Equal(t, bitrates.ScreenHigh, screenClaim.Bitrate())
+
+	// enough bandwidth for a camera high quality is only enough for a screen mid quality
+	other := newTestClient(t, bitrates.VideoHigh+100_000).bitrateController
+	require.Equal(t, QualityLevel(QualityHigh), other.getDistributedQuality(1, 1, false))
+	require.Equal(t, QualityLevel(QualityMid), other.getDistributedQuality(1, 1, true))
+}
+
+func TestMaxDecodeQualityWithViewport(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.InitialBandwidth)
+	bc := client.bitrateController
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	_, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the viewport only need a mid quality
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 360, Height: 180})
+	require.Equal(t, QualityLevel(QualityMid), track.MaxQuality())
+
+	// the device can only decode a low quality
+	client.SetMaxDecodeQuality(QualityLow)
+	require.Equal(t, QualityLevel(QualityLow), track.MaxQuality())
+
+	// the viewport is bigger but the decoder cap still win
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 1280, Height: 720})
+	require.Equal(t, QualityLevel(QualityLow), track.MaxQuality())
+
+	// the decoder cap is lifted, the viewport cap is used again
+	client.SetMaxDecodeQuality(QualityHigh)
+	require.Equal(t, QualityLevel(QualityHigh), track.MaxQuality())
+
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 180, Height: 90})
+	client.SetMaxDecodeQuality(QualityMid)
+	require.Equal(t, QualityLevel(QualityLow), track.MaxQuality())
+}
+
+func TestViewportResizePLI(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	pliCount := &atomic.Int32{}
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {
+		pliCount.Add(1)
+	}})
+	track.lastQuality = QualityLow
+
+	_, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// shrinking or hiding the viewport never need a keyframe
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 360, Height: 180})
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 180, Height: 90})
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 0, Height: 0})
+	require.Equal(t, int32(0), pliCount.Load())
+
+	// the viewport is enlarged above the forwarded quality
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 360, Height: 180})
+	require.Equal(t, int32(1), pliCount.Load())
+
+	// the same size is reported again
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 360, Height: 180})
+	require.Equal(t, int32(1), pliCount.Load())
+
+	client.options.DisableMaxQualityPLI = true
+	bc.onRemoteViewedSizeChanged(videoSize{TrackID: track.ID(), Width: 1280, Height: 720})
+	require.Equal(t, int32(1), pliCount.Load())
+	require.Equal(t, QualityLevel(QualityHigh), track.MaxQuality())
+}
+
+func TestMaxClaims(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.options.MaxClaims = 2
+	bc := client.bitrateController
+
+	for i := 0; i < 2; i++ {
+		track := newTestClientTrack(t, client, fmt.Sprintf("video-%d", i), webrtc.RTPCodecTypeVideo, true)
+		_, err := bc.addClaim(track, QualityLow, true)
+		require.NoError(t, err)
+	}
+
+	rejected := newTestClientTrack(t, client, "video-2", webrtc.RTPCodecTypeVideo, true)
+	_, err := bc.addClaim(rejected, QualityLow, true)
+	require.ErrorIs(t, err, ErrTooManyClaims)
+	require.False(t, bc.exists(rejected.ID()))
+
+	// zero means unlimited
+	client.options.MaxClaims = 0
+	_, err = bc.addClaim(rejected, QualityLow, true)
+	require.NoError(t, err)
+}
+
+func TestScreenDistributedQuality(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 3_000_000)
+	bc := client.bitrateController
+
+	// let the distribution decide the quality
+	require.NoError(t, client.SFU().SetStartupQuality(QualityHigh))
+
+	tracks := make([]iClientTrack, 0)
+	for _, id := range []string{"camera-1", "camera-2", "screen"} {
+		track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+		track.id = id
+		track.isScreen = id == "screen"
+		tracks = append(tracks, track)
+	}
+
+	require.NoError(t, bc.addClaims(tracks))
+
+	// the screen share get the half of the bandwidth, each camera get a quarter
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim("camera-1").Quality())
+	require.Equal(t, QualityLevel(QualityMid), bc.GetClaim("camera-2").Quality())
+	require.Equal(t, QualityLevel(QualityHigh), bc.GetClaim("screen").Quality())
+}
+
+func TestDistributedQualityFairSplit(t *testing.T) {
+	t.Parallel()
+
+	// the fair share of each track is between the mid and the high bitrate
+	bitrates := DefaultBitrates()
+	client := newTestClient(t, 2*bitrates.VideoHigh+2*bitrates.VideoMid)
+	bc := client.bitrateController
+
+	require.NoError(t, client.SFU().SetStartupQuality(QualityHigh))
+
+	tracks := make([]iClientTrack, 0)
+	for i := 0; i < 4; i++ {
+		track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+		track.id = fmt.Sprintf("camera-%d", i)
+		tracks = append(tracks, track)
+	}
+
+	require.NoError(t, bc.addClaims(tracks))
+
+	// the share left unused by the first claims is not given to the last claims
+	for _, track := range tracks {
+		require.Equal(t, QualityLevel(QualityMid), bc.GetClaim(track.ID()).Quality(), track.ID())
+	}
+}
+
+func TestPausedClaimFreeBandwidth(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	// enough for the high quality of a single track, but not together with another track
+	bandwidth := bitrates.VideoHigh + 10_000
+
+	client := newTestClient(t, bandwidth)
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	active := newTestClientTrack(t, client, "active", webrtc.RTPCodecTypeVideo, true)
+	activeClaim, err := bc.addClaim(active, QualityLow, true)
+	require.NoError(t, err)
+
+	paused := newTestClientTrack(t, client, "paused", webrtc.RTPCodecTypeVideo, true)
+	pausedClaim, err := bc.addClaim(paused, QualityLow, true)
+	require.NoError(t, err)
+
+	paused.Pause()
+	require.True(t, paused.IsPaused())
+	require.True(t, pausedClaim.isPaused())
+
+	// the paused claim keep its quality but contribute nothing to the total bitrates
+	require.Equal(t, QualityLevel(QualityLow), pausedClaim.Quality())
+	require.Equal(t, activeClaim.Bitrate(), bc.totalSentBitrates())
+	require.Equal(t, activeClaim.Bitrate(), bc.totalBitrates())
+
+	for i := 0; i < 3; i++ {
+		bc.fitBitratesToBandwidth(bandwidth)
+	}
+
+	// the freed bandwidth is given to the active track, the paused claim is never adjusted
+	require.Equal(t, QualityLevel(QualityHigh), activeClaim.Quality())
+	require.Equal(t, QualityLevel(QualityLow), pausedClaim.Quality())
+	require.Equal(t, bitrates.VideoHigh, bc.totalSentBitrates())
+
+	// resume count the claim back and request a keyframe
+	paused.Resume()
+	require.False(t, pausedClaim.isPaused())
+	require.Equal(t, int32(1), paused.pliCount.Load())
+	require.Equal(t, bitrates.VideoHigh+bitrates.VideoLow, bc.totalSentBitrates())
+
+	// resume is a no-op if the track is not paused
+	paused.Resume()
+	require.Equal(t, int32(1), paused.pliCount.Load())
+}
+
+func TestStalledClaim(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.sfu.trackStallTimeout = 200 * time.Millisecond
+	bc := client.bitrateController
+
+	stalledChan := make(chan bool, 2)
+	client.OnTrackStalled(func(trackID string, stalled bool) {
+		if trackID == "video" {
+			stalledChan <- stalled
+		}
+	})
+
+	pliCount := &atomic.Int32{}
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {
+		pliCount.Add(1)
+	}})
+
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	sequence := uint16(0)
+	feed := func() {
+		sequence++
+		track.push(rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000, Marker: true},
+			Payload: vp9Payload(0, 0, true, false),
+		}, QualityLow)
+	}
+
+	feed()
+	bc.checkStalledClaims(time.Now())
+	require.False(t, claim.isStalled())
+	require.Equal(t, claim.Bitrate(), bc.totalSentBitrates())
+
+	// stop feeding the track, the monitor flag it after the timeout
+	select {
+	case stalled := <-stalledChan:
+		require.True(t, stalled)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for the stalled track")
+	}
+
+	require.True(t, claim.isStalled())
+	require.Equal(t, uint32(0), bc.totalSentBitrates())
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+
+	// the track recover when the packets are received again
+	pliBefore := pliCount.Load()
+	feed()
+	bc.checkStalledClaims(time.Now())
+	require.False(t, claim.isStalled())
+	require.Equal(t, claim.Bitrate(), bc.totalSentBitrates())
+
+	select {
+	case stalled := <-stalledChan:
+		require.False(t, stalled)
+	case <-time.After(time.Second):
+		require.Fail(t, "timeout waiting for the recovered track")
+	}
+
+	require.Greater(t, pliCount.Load(), pliBefore)
+}
+
+func TestStalledClaimClock(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.sfu.trackStallTimeout = time.Minute
+	client.bitrateController.Close()
+
+	// the test clock is ahead of the system time, the claim age is measured with the test clock
+	clock := newTestClock()
+	clock.Set(time.Now().Add(time.Hour))
+
+	bc := newBitrateController(client, WithClock(clock))
+	t.Cleanup(bc.Close)
+
+	client.bitrateController = bc
+
+	// the track never receive any packet, the stall timeout start when the claim is added
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), claim.addedTime)
+
+	bc.checkStalledClaims(clock.Now())
+	require.False(t, claim.isStalled())
+
+	clock.Advance(time.Minute + time.Millisecond)
+	bc.checkStalledClaims(clock.Now())
+	require.True(t, claim.isStalled())
+
+	// the packet time is stamped with the same clock, the claim is resumed once a packet is received
+	track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 1}, Payload: vp9Payload(0, 0, false, false)}, QualityLow)
+	require.True(t, clock.Now().Equal(track.lastPacketTime()))
+
+	bc.checkStalledClaims(clock.Now())
+	require.False(t, claim.isStalled())
+}
+
+func TestVeryHighQualityTier(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+	veryHigh := uint32(3_000_000)
+
+	client := newTestClient(t, 10_000_000)
+	require.NoError(t, client.sfu.clients.Add(client))
+
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+	s := client.SFU()
+
+	// the tier is disabled by default
+	require.Equal(t, QualityLevel(QualityHigh), s.HighestQuality())
+	require.Equal(t, QualityLevel(QualityHigh), bc.getDistributedQuality(1, 1, false))
+
+	require.ErrorIs(t, s.SetVeryHighQualityBitrate(bitrates.VideoHigh), ErrInvalidQualityBitrates)
+	require.NoError(t, s.SetVeryHighQualityBitrate(veryHigh))
+	require.ErrorIs(t, s.SetQualityBitrates(bitrates.VideoLow, bitrates.VideoMid, veryHigh), ErrInvalidQualityBitrates)
+
+	require.Equal(t, QualityLevel(QualityVeryHigh), s.HighestQuality())
+	require.Equal(t, veryHigh, s.QualityLevelToBitrate(QualityVeryHigh))
+	require.Equal(t, veryHigh, s.TrackQualityLevelToBitrate(QualityVeryHigh, true))
+	require.Equal(t, QualityLevel(QualityVeryHigh), Uint32ToQualityLevel(QualityVeryHigh))
+	require.Equal(t, QualityLevel(QualityAudio), Uint32ToQualityLevel(QualityAudio))
+
+	// the audio levels keep their values, the very high level is stepped from and to the high level
+	require.EqualValues(t, 4, QualityAudio)
+	require.EqualValues(t, 5, QualityAudioRed)
+	require.Equal(t, QualityLevel(QualityVeryHigh), higherQuality(QualityHigh))
+	require.Equal(t, QualityLevel(QualityHigh), lowerQuality(QualityVeryHigh))
+	require.Equal(t, QualityLevel(QualityVeryHigh), capVideoQuality(QualityAudioRed))
+
+	// the bandwidth share is allocated across the four tiers
+	require.Equal(t, QualityLevel(QualityVeryHigh), bc.getDistributedQuality(1, 1, false))
+	require.Equal(t, QualityLevel(QualityHigh), bc.getDistributedQuality(1, 4, false))
+	require.Equal(t, QualityLevel(QualityMid), bc.getDistributedQuality(1, 10, false))
+	require.Equal(t, QualityLevel(QualityLow), bc.getDistributedQuality(1, 100, false))
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	track.maxQuality = QualityVeryHigh
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the simulcast track only has three layers, it stay at the high quality
+	simulcast := newTestClientTrack(t, client, "simulcast", webrtc.RTPCodecTypeVideo, true)
+	simulcast.scaleable = false
+	simulcast.simulcast = true
+	simulcastClaim, err := bc.addClaim(simulcast, QualityHigh, true)
+	require.NoError(t, err)
+
+	headroom := bitrates.AudioHeadroom + 10_000
+
+	bc.fitBitratesToBandwidth(veryHigh + bitrates.VideoHigh + headroom)
+	require.Equal(t, QualityLevel(QualityVeryHigh), claim.Quality())
+	require.Equal(t, veryHigh, claim.Bitrate())
+	require.Equal(t, QualityLevel(QualityHigh), simulcastClaim.Quality())
+
+	// never increased above the very high quality
+	bc.fitBitratesToBandwidth(10_000_000)
+	require.Equal(t, QualityLevel(QualityVeryHigh), claim.Quality())
+	require.Equal(t, QualityLevel(QualityHigh), simulcastClaim.Quality())
+
+	// reduced one tier at a time when the bandwidth drop
+	bc.fitBitratesToBandwidth(bitrates.VideoHigh*2 + headroom)
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+	require.Equal(t, QualityLevel(QualityHigh), simulcastClaim.Quality())
+
+	bc.fitBitratesToBandwidth(veryHigh + bitrates.VideoHigh + headroom)
+	require.Equal(t, QualityLevel(QualityVeryHigh), claim.Quality())
+
+	// the claim is moved back to the high quality when the tier is disabled
+	require.NoError(t, s.SetVeryHighQualityBitrate(0))
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+	require.Equal(t, bitrates.VideoHigh, claim.Bitrate())
+}
+
+func TestAudioRedundancyToggle(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "audio/red"}, "audio", "stream-audio")
+	require.NoError(t, err)
+	track.localTrack = localTrack
+
+	_, err = bc.addAudioClaims([]iClientTrack{track})
+	require.NoError(t, err)
+
+	claim := bc.GetClaim(track.ID())
+	require.Equal(t, QualityLevel(QualityAudioRed), claim.Quality())
+
+	setFractionLost := func(fractionLost float64) {
+		s := newReceiverReport(fractionLost)
+		client.stats.SetSender(track.ID(), s)
+		bc.updateAudioRedundancy(track.ID())
+	}
+
+	// no loss, the redundancy is not needed
+	setFractionLost(0)
+	require.Equal(t, QualityLevel(QualityAudio), claim.Quality())
+	require.Equal(t, DefaultBitrates().Audio, claim.Bitrate())
+	require.False(t, track.redundancy.Load())
+
+	// a loss between the thresholds keep the current state
+	setFractionLost(0.03)
+	require.Equal(t, QualityLevel(QualityAudio), claim.Quality())
+
+	setFractionLost(0.1)
+	require.Equal(t, QualityLevel(QualityAudioRed), claim.Quality())
+	require.Equal(t, DefaultBitrates().AudioRed, claim.Bitrate())
+	require.True(t, track.redundancy.Load())
+
+	setFractionLost(0.03)
+	require.Equal(t, QualityLevel(QualityAudioRed), claim.Quality())
+
+	setFractionLost(0.005)
+	require.Equal(t, QualityLevel(QualityAudio), claim.Quality())
+	require.False(t, track.redundancy.Load())
+}
+
+func TestDecisionLog(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().VideoLow)
+	bc := client.bitrateController
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// disabled by default
+	bc.getBitrateAdjustment(claim)
+	require.Nil(t, client.BitrateDecisionLog())
+
+	client.EnableBitrateDecisionLog(3)
+
+	for i := 1; i <= 5; i++ {
+		s := newReceiverReport(float64(i) / 100)
+		client.stats.SetSender(track.ID(), s)
+
+		bc.getBitrateAdjustment(claim)
+	}
+
+	decisions := client.BitrateDecisionLog()
+	require.Len(t, decisions, 3)
+
+	for i, decision := range decisions {
+		require.Equal(t, track.ID(), decision.TrackID)
+		require.Equal(t, QualityLevel(QualityLow), decision.Quality)
+		require.Equal(t, client.GetEstimatedBandwidth(), decision.EstimatedBandwidth)
+		require.Equal(t, KeepBitrate, decision.Adjustment)
+		// the oldest decisions are dropped
+		require.Equal(t, float64(i+3)/100, decision.FractionLost)
+
+		if i > 0 {
+			require.False(t, decision.Time.Before(decisions[i-1].Time))
+		}
+	}
+
+	client.EnableBitrateDecisionLog(0)
+	require.Nil(t, client.BitrateDecisionLog())
+}
+
+func TestDegradationPolicy(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	testCases := map[DegradationPolicy]string{
+		DegradationScreenPriority: "camera",
+		DegradationCameraPriority: "screen",
+		// without a protected source the screen has the higher bitrate on the same priority
+		DegradationEqual: "screen",
+	}
+
+	for policy, reduced := range testCases {
+		client := newTestClient(t, bitrates.InitialBandwidth)
+		client.sfu.degradationPolicy = policy
+		bc := client.bitrateController
+
+		camera := newTestClientTrack(t, client, "camera", webrtc.RTPCodecTypeVideo, true)
+		screen := newTestClientTrack(t, client, "screen", webrtc.RTPCodecTypeVideo, true)
+		screen.isScreen = true
+
+		_, err := bc.addClaim(camera, QualityHigh, true)
+		require.NoError(t, err)
+		_, err = bc.addClaim(screen, QualityHigh, true)
+		require.NoError(t, err)
+
+		// only enough bandwidth to reduce one of the tracks by a single step
+		bc.fitBitratesToBandwidth(bitrates.VideoHigh + bitrates.ScreenHigh - 100_000)
+
+		for _, id := range []string{"camera", "screen"} {
+			expected := QualityLevel(QualityHigh)
+			if id == reduced {
+				expected = QualityMid
+			}
+
+			require.Equal(t, expected, bc.GetClaim(id).Quality(), "policy %d track %s", policy, id)
+		}
+	}
+}
+
+type alwaysDecreaseStrategy struct{}
+
+func (s alwaysDecreaseStrategy) Adjust(_ *BitrateClaim, _ StrategyContext) BitrateAdjustment {
+	return DecreaseBitrate
+}
+
+func TestMultipleScreenFairness(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	// the strategy is forced, the claims must step one quality level at a time regardless of the bandwidth
+	client.sfu.bitrateConfigs.SevereCongestionRatio = 0
+	bc := client.bitrateController
+
+	camera := newTestClientTrack(t, client, "camera", webrtc.RTPCodecTypeVideo, true)
+	screens := make([]*bitrateClaim, 0, 2)
+	for _, id := range []string{"screen-1", "screen-2"} {
+		screen := newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true)
+		screen.isScreen = true
+
+		claim, err := bc.addClaim(screen, QualityHigh, true)
+		require.NoError(t, err)
+
+		screens = append(screens, claim)
+	}
+
+	cameraClaim, err := bc.addClaim(camera, QualityLow, true)
+	require.NoError(t, err)
+
+	// skip the adjustment delay after each increase
+	adjust := func() {
+		bc.checkAndAdjustBitrates()
+
+		for _, claim := range bc.Claims() {
+			claim.mu.Lock()
+			claim.lastIncreaseTime = time.Time{}
+			claim.mu.Unlock()
+		}
+	}
+
+	requireComparable := func() {
+		diff := int(screens[0].Quality()) - int(screens[1].Quality())
+		require.LessOrEqual(t, diff, 1)
+		require.GreaterOrEqual(t, diff, -1)
+	}
+
+	// the camera can't be reduced anymore, the screens are reduced in turn
+	client.SetBitrateStrategy(alwaysDecreaseStrategy{})
+	for i := 0; i < 4; i++ {
+		adjust()
+		requireComparable()
+	}
+
+	require.Equal(t, QualityLevel(QualityLow), screens[0].Quality())
+	require.Equal(t, QualityLevel(QualityLow), screens[1].Quality())
+
+	// one screen recovered first, the other screen catch up before the camera is increased
+	bc.setQuality(screens[0].track.ID(), QualityHigh)
+
+	client.SetBitrateStrategy(alwaysIncreaseStrategy{contexts: make(chan StrategyContext, 1)})
+	for i := 0; i < 2; i++ {
+		adjust()
+		require.Equal(t, QualityLevel(QualityLow), cameraClaim.Quality())
+	}
+
+	require.Equal(t, QualityLevel(QualityHigh), screens[0].Quality())
+	require.Equal(t, QualityLevel(QualityHigh), screens[1].Quality())
+
+	// the camera is increased once the screens can't be increased anymore
+	adjust()
+	require.Equal(t, QualityLevel(QualityMid), cameraClaim.Quality())
+}
+
+func TestAudioSilenceReleaseBitrate(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+
+	audio := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+	audioClaim, err := bc.addClaim(audio, QualityAudio, true)
+	require.NoError(t, err)
+
+	video := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	videoClaim, err := bc.addClaim(video, QualityLow, true)
+	require.NoError(t, err)
+
+	payloadSent := uint64(0)
+	now := time.Now()
+	sample := func(bitrate uint64) {
+		payloadSent += bitrate / 8
+		s := stats.Stats{}
+		s.OutboundRTPStreamStats.HeaderBytesSent = payloadSent / 10
+		s.OutboundRTPStreamStats.BytesSent = payloadSent + s.OutboundRTPStreamStats.HeaderBytesSent
+		client.stats.SetSender(audio.ID(), s)
+
+		bc.updateAudioSilence(audio.ID(), now)
+		now = now.Add(time.Second)
+	}
+
+	// the bandwidth is not enough to increase the video while the audio is reserved
+	bandwidth := bitrates.Audio + bitrates.AudioHeadroom + bitrates.VideoMid - 10_000
+	require.Less(t, bc.videoBandwidth(bandwidth), bitrates.VideoMid)
+
+	sample(32_000)
+	sample(32_000)
+	require.False(t, audioClaim.isSilent())
+
+	// the DTX silence only send a few bytes, it is detected after the silence window
+	sample(800)
+	require.False(t, audioClaim.isSilent())
+	sample(800)
+	sample(800)
+	require.True(t, audioClaim.isSilent())
+	require.Equal(t, uint32(audioSilentReservedBitrate), audioClaim.sentBitrate())
+
+	// the released audio bitrate is used by the video
+	require.GreaterOrEqual(t, bc.videoBandwidth(bandwidth), bitrates.VideoMid)
+	for i := 0; i <= probeTicksToCommit; i++ {
+		bc.fitBitratesToBandwidth(bandwidth)
+	}
+
+	require.Equal(t, QualityLevel(QualityMid), videoClaim.Quality())
+
+	// the audio bitrate is reserved again as soon as the speech resume
+	sample(32_000)
+	require.False(t, audioClaim.isSilent())
+	require.Equal(t, bitrates.Audio, audioClaim.sentBitrate())
+	require.Less(t, bc.videoBandwidth(bandwidth), bitrates.VideoMid)
+}
+
+func TestListClaims(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	require.Empty(t, bc.ListClaims())
+
+	audio := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+	screen := newTestClientTrack(t, client, "screen", webrtc.RTPCodecTypeVideo, true)
+	screen.isScreen = true
+	screen.SetMaxQuality(QualityMid)
+
+	audioClaim, err := bc.addClaim(audio, QualityHigh, false)
+	require.NoError(t, err)
+	screenClaim, err := bc.addClaim(screen, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Equal(t, []ClaimInfo{
+		{
+			TrackID:    "audio",
+			Kind:       webrtc.RTPCodecTypeAudio,
+			Quality:    QualityHigh,
+			Bitrate:    audioClaim.Bitrate(),
+			Simulcast:  false,
+			IsScreen:   false,
+			MaxQuality: audio.MaxQuality(),
+		},
+		{
+			TrackID:    "screen",
+			Kind:       webrtc.RTPCodecTypeVideo,
+			Quality:    QualityLow,
+			Bitrate:    screenClaim.Bitrate(),
+			Simulcast:  screen.IsSimulcast(),
+			IsScreen:   true,
+			MaxQuality: QualityMid,
+		},
+	}, bc.ListClaims())
+
+	bc.removeClaim("audio")
+
+	claims := bc.ListClaims()
+	require.Len(t, claims, 1)
+	require.Equal(t, "screen", claims[0].TrackID)
+}
+
+func TestClientQualityCeiling(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth*10)
+	bc := client.bitrateController
+
+	require.Equal(t, QualityLevel(QualityHigh), client.QualityCeiling())
+
+	claims := make([]*bitrateClaim, 0, 2)
+	for _, id := range []string{"camera", "screen"} {
+		claim, err := bc.addClaim(newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true), QualityHigh, true)
+		require.NoError(t, err)
+
+		claims = append(claims, claim)
+	}
+
+	audioClaim, err := bc.addClaim(newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false), QualityAudio, true)
+	require.NoError(t, err)
+
+	adjust := func() {
+		bc.checkAndAdjustBitrates()
+
+		for _, claim := range bc.Claims() {
+			claim.mu.Lock()
+			claim.lastIncreaseTime = time.Time{}
+			claim.mu.Unlock()
+		}
+	}
+
+	// all video tracks are reduced at once, the audio is not capped
+	client.SetQualityCeiling(QualityLow)
+	require.Equal(t, QualityLevel(QualityLow), client.QualityCeiling())
+
+	for _, claim := range claims {
+		require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	}
+
+	require.Equal(t, QualityLevel(QualityAudio), audioClaim.Quality())
+
+	// the bandwidth is enough, but the tracks are not increased above the ceiling
+	client.SetBitrateStrategy(alwaysIncreaseStrategy{contexts: make(chan StrategyContext, 1)})
+	for i := 0; i < 3; i++ {
+		adjust()
+	}
+
+	for _, claim := range claims {
+		require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	}
+
+	// the tracks can be increased up to the raised ceiling
+	client.SetQualityCeiling(QualityMid)
+	for i := 0; i < 3; i++ {
+		adjust()
+	}
+
+	for _, claim := range claims {
+		require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+	}
+}
+
+func TestPacketOverheadHeadroom(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	// the bandwidth is just enough for the payload of two high tracks, but not with the packet headers
+	bandwidth := 2*bitrates.VideoHigh + 10_000
+
+	client := newTestClient(t, bandwidth)
+	client.sfu.bitrateConfigs.PacketOverhead = DefaultPacketOverhead
+	bc := client.bitrateController
+
+	for _, id := range []string{"first", "second"} {
+		_, err := bc.addClaim(newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true), QualityHigh, true)
+		require.NoError(t, err)
+	}
+
+	bc.fitBitratesToBandwidth(bandwidth)
+
+	// the allocation leave the headroom for the overhead
+	total := bc.totalSentBitrates()
+	require.Less(t, total, 2*bitrates.VideoHigh)
+	require.LessOrEqual(t, float64(total)*(1+DefaultPacketOverhead), float64(bandwidth))
+
+	// a claim is reduced by the adjustment too
+	claim := bc.GetClaim("first")
+	bc.setQuality("first", QualityHigh)
+	bc.setQuality("second", QualityHigh)
+	require.Equal(t, bitrateAdjustment(decreaseBitrate), bc.getBitrateBasedAdjustment(bandwidth, claim))
+
+	// the same allocation fit without the overhead
+	client.sfu.bitrateConfigs.PacketOverhead = 0
+	require.Equal(t, bitrateAdjustment(keepBitrate), bc.getBitrateBasedAdjustment(bandwidth, claim))
+}
+
+func TestPacketOverheadBandwidthEstimation(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	// the estimated bandwidth fit the payload of two high tracks, but not with the packet headers
+	bandwidth := 2*bitrates.VideoHigh + 10_000
+
+	client := newTestClient(t, bandwidth)
+	client.sfu.bitrateConfigs.PacketOverhead = DefaultPacketOverhead
+	bc := client.bitrateController
+
+	for _, id := range []string{"first", "second"} {
+		_, err := bc.addClaim(newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true), QualityHigh, true)
+		require.NoError(t, err)
+	}
+
+	estimator := &testBandwidthEstimator{}
+	bc.MonitorBandwidth(estimator)
+
+	// the estimation from the congestion controller is reduced by the overhead before it is compared to the claims
+	estimator.setBitrate(int(bandwidth))
+
+	total := bc.totalSentBitrates()
+	require.Less(t, total, 2*bitrates.VideoHigh)
+	require.LessOrEqual(t, float64(total)*(1+DefaultPacketOverhead), float64(bandwidth))
+}
+
+func TestSevereCongestionCollapse(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	tracks := make([]*testClientTrack, 0, 3)
+	claims := make([]*bitrateClaim, 0, 3)
+
+	for _, id := range []string{"video1", "video2", "video3"} {
+		track := newTestClientTrack(t, client, id, webrtc.RTPCodecTypeVideo, true)
+		claim, err := bc.addClaim(track, QualityHigh, true)
+		require.NoError(t, err)
+
+		tracks = append(tracks, track)
+		claims = append(claims, claim)
+	}
+
+	require.Equal(t, 3*bitrates.VideoHigh, bc.totalSentBitrates())
+
+	// the bandwidth collapse to near zero, all the claims drop to the low quality in a single adjustment
+	client.rembBandwidth.Store(50_000)
+	bc.checkAndAdjustBitrates()
+
+	for i, claim := range claims {
+		require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+		require.Equal(t, int32(1), tracks[i].pliCount.Load())
+	}
+
+	// nothing left to reduce, the next adjustment doesn't request more keyframes
+	bc.checkAndAdjustBitrates()
+
+	for i, claim := range claims {
+		require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+		require.Equal(t, int32(1), tracks[i].pliCount.Load())
+	}
+
+	// the claims only drop to the highest quality that fit the bandwidth
+	client.rembBandwidth.Store(3 * bitrates.VideoMid)
+
+	for _, claim := range claims {
+		bc.setQuality(claim.track.ID(), QualityHigh)
+	}
+
+	bc.checkAndAdjustBitrates()
+
+	for _, claim := range claims {
+		require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+	}
+}
+
+func TestSevereCongestionDisabled(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	client.sfu.bitrateConfigs.SevereCongestionRatio = 0
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the claim is reduced one quality level at a time
+	client.rembBandwidth.Store(50_000)
+	bc.checkAndAdjustBitrates()
+
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+}
+
+func TestDecreaseWithAudioClaim(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	client.sfu.bitrateConfigs.SevereCongestionRatio = 0
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	audio := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+	_, err := bc.addClaim(audio, QualityAudio, true)
+	require.NoError(t, err)
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the audio quality is not counted as a video quality above the high quality
+	client.rembBandwidth.Store(50_000)
+	bc.checkAndAdjustBitrates()
+
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+}
+
+func TestKeyframeRequestsCoalesced(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	other := newTestClientTrack(t, client, "other", webrtc.RTPCodecTypeVideo, true)
+	otherClaim, err := bc.addClaim(other, QualityLow, true)
+	require.NoError(t, err)
+
+	// the track is reduced twice in the same adjustment, but only one keyframe is requested
+	bc.fitBitratesToBandwidth(2*bitrates.VideoLow + 10_000)
+
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+	require.Equal(t, int32(1), track.pliCount.Load())
+
+	// the track that is not changed doesn't request a keyframe
+	require.Equal(t, QualityLevel(QualityLow), otherClaim.Quality())
+	require.Equal(t, int32(0), other.pliCount.Load())
+
+	// the same layer requested more than once is flushed once
+	keyframes := newKeyframeRequests()
+	keyframes.request(other, QualityMid)
+	keyframes.request(other, QualityMid)
+	keyframes.flush()
+	keyframes.flush()
+
+	require.Equal(t, int32(1), other.pliCount.Load())
+}
+
+func TestBitrateControllerOptions(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	client.bitrateController.Close()
+
+	strategy := alwaysIncreaseStrategy{contexts: make(chan StrategyContext, 1)}
+
+	bc := newBitrateController(client,
+		WithBandwidthEstimation(false),
+		WithInterval(50*time.Millisecond),
+		WithStrategy(strategy),
+		WithMaxBandwidth(500_000),
+		WithLossThresholds(0.01, 0.2),
+	)
+	t.Cleanup(bc.Close)
+
+	client.bitrateController = bc
+
+	require.False(t, bc.useBandwidthEstimation)
+	require.Equal(t, strategy, bc.Strategy())
+
+	increase, decrease := bc.LossThresholds()
+	require.Equal(t, 0.01, increase)
+	require.Equal(t, 0.2, decrease)
+
+	// the estimated bandwidth is capped to the max bandwidth
+	require.Equal(t, uint32(500_000), bc.estimatedBandwidth())
+	require.Equal(t, uint32(500_000), bc.Snapshot().EstimatedBandwidth)
+
+	// the claim is adjusted on the configured interval
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	ctx := <-strategy.contexts
+	require.Equal(t, uint32(500_000), ctx.EstimatedBandwidth)
+
+	require.Eventually(t, func() bool {
+		return claim.Quality() == QualityMid
+	}, time.Second, 10*time.Millisecond)
+
+	// the invalid options are ignored and the default strategy is used without a strategy option
+	other := newBitrateController(client, WithLossThresholds(0.5, 0.1), WithInterval(0))
+	t.Cleanup(other.Close)
+
+	increase, decrease = other.LossThresholds()
+	require.Equal(t, DefaultLossIncreaseThreshold, increase)
+	require.Equal(t, DefaultLossDecreaseThreshold, decrease)
+	require.Equal(t, defaultAdjustmentInterval, other.interval)
+	require.IsType(t, lossBasedStrategy{}, other.Strategy())
+}
+
+func TestLossScenario(t *testing.T) {
+	t.Parallel()
+
+	h := newNetworkHarness(t, false)
+	h.addTrack("video", QualityMid)
+
+	bw := uint32(10_000_000)
+
+	changes := h.run([]networkStep{
+		// a sustained loss decrease the quality on the second report
+		{at: 0, estimatedBandwidth: bw, fractionLost: 0.2},
+		{at: 500 * time.Millisecond, estimatedBandwidth: bw, fractionLost: 0.2},
+		// the low quality is never reduced further
+		{at: 3 * time.Second, estimatedBandwidth: bw, fractionLost: 0.2},
+		// the loss is recovered, the quality is increased one level after each adjustment delay
+		{at: 6 * time.Second, estimatedBandwidth: bw, fractionLost: 0},
+		{at: 6500 * time.Millisecond, estimatedBandwidth: bw, fractionLost: 0},
+		{at: 9 * time.Second, estimatedBandwidth: bw, fractionLost: 0},
+		{at: 20 * time.Second, estimatedBandwidth: bw, fractionLost: 0},
+	})
+
+	require.Equal(t, []qualityChange{
+		{at: 500 * time.Millisecond, trackID: "video", from: QualityMid, to: QualityLow},
+		{at: 6 * time.Second, trackID: "video", from: QualityLow, to: QualityMid},
+		{at: 9 * time.Second, trackID: "video", from: QualityMid, to: QualityHigh},
+	}, changes)
+}
+
+func TestBandwidthScenario(t *testing.T) {
+	t.Parallel()
+
+	h := newNetworkHarness(t, true)
+	// the sustained headroom is tested separately
+	h.client.sfu.bitrateConfigs.SustainedHeadroom = 0
+	h.addTrack("first", QualityHigh)
+	h.addTrack("second", QualityHigh)
+
+	changes := h.run([]networkStep{
+		{at: 0, estimatedBandwidth: 3_000_000},
+		// the bandwidth drop, both tracks are reduced together
+		{at: 1 * time.Second, estimatedBandwidth: 1_000_000},
+		{at: 2 * time.Second, estimatedBandwidth: 400_000},
+		// the bandwidth is recovered, each increase is probed for a couple of estimations before it is committed
+		{at: 3 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 4 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 5 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 6 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 7 * time.Second, estimatedBandwidth: 3_000_000},
+	})
+
+	require.Len(t, changes, 6)
+
+	for _, change := range changes[:4] {
+		require.Equal(t, change.from-1, change.to)
+	}
+
+	require.Equal(t, time.Second, changes[0].at)
+	require.Equal(t, time.Second, changes[1].at)
+	require.Equal(t, 2*time.Second, changes[2].at)
+	require.Equal(t, 2*time.Second, changes[3].at)
+
+	// the tracks are increased one at a time
+	require.Equal(t, 5*time.Second, changes[4].at)
+	require.Equal(t, 7*time.Second, changes[5].at)
+	require.NotEqual(t, changes[4].trackID, changes[5].trackID)
+
+	require.Equal(t, QualityLevel(QualityMid), h.quality("first"))
+	require.Equal(t, QualityLevel(QualityMid), h.quality("second"))
+}
+
+func TestSustainedHeadroom(t *testing.T) {
+	t.Parallel()
+
+	h := newNetworkHarness(t, true)
+	h.addTrack("video", QualityLow)
+
+	sustained := h.client.SFU().BitrateConfigs().SustainedHeadroom
+	require.Equal(t, DefaultSustainedHeadroom, sustained)
+
+	changes := h.run([]networkStep{
+		// a spike of the estimation then drop again never increase the quality
+		{at: 0, estimatedBandwidth: 3_000_000},
+		{at: 1 * time.Second, estimatedBandwidth: 400_000},
+		{at: 2 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 4 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 5 * time.Second, estimatedBandwidth: 400_000},
+		{at: 6 * time.Second, estimatedBandwidth: 3_000_000},
+	})
+
+	require.Empty(t, changes)
+	require.False(t, h.bc.GetClaim("video").isProbing())
+
+	// the headroom from 6s is sustained, the increase is probed and committed
+	changes = h.run([]networkStep{
+		{at: 7 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 8 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 9 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 10 * time.Second, estimatedBandwidth: 3_000_000},
+		{at: 11 * time.Second, estimatedBandwidth: 3_000_000},
+	})
+
+	require.NotEmpty(t, changes)
+	require.Equal(t, qualityChange{at: 11 * time.Second, trackID: "video", from: QualityLow, to: QualityMid}, changes[0])
+
+	// the bandwidth based strategy wait for the sustained headroom too
+	client := newTestClient(t, 3_000_000)
+	client.bitrateController.Close()
+
+	clock := newTestClock()
+	bc := newBitrateController(client, WithBandwidthEstimation(true), WithClock(clock))
+	t.Cleanup(bc.Close)
+
+	client.bitrateController = bc
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, claim))
+
+	clock.Advance(sustained - time.Millisecond)
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, claim))
+
+	clock.Advance(time.Millisecond)
+	require.Equal(t, IncreaseBitrate, bc.getBitrateBasedAdjustment(3_000_000, claim))
+}
+
+func TestSustainedHeadroomPerClaim(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 3_000_000)
+	client.bitrateController.Close()
+
+	clock := newTestClock()
+	bc := newBitrateController(client, WithBandwidthEstimation(true), WithClock(clock))
+	t.Cleanup(bc.Close)
+
+	client.bitrateController = bc
+	sustained := client.SFU().BitrateConfigs().SustainedHeadroom
+
+	first := newTestClientTrack(t, client, "first", webrtc.RTPCodecTypeVideo, true)
+	firstClaim, err := bc.addClaim(first, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, firstClaim))
+
+	clock.Advance(sustained)
+	require.Equal(t, IncreaseBitrate, bc.getBitrateBasedAdjustment(3_000_000, firstClaim))
+
+	// the other claim doesn't share the headroom of the first claim
+	second := newTestClientTrack(t, client, "second", webrtc.RTPCodecTypeVideo, true)
+	secondClaim, err := bc.addClaim(second, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, secondClaim))
+
+	// the increase is committed, the next step wait for a new sustained headroom
+	bc.setQuality(first.ID(), QualityMid)
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, firstClaim))
+
+	clock.Advance(sustained - time.Millisecond)
+	require.Equal(t, KeepBitrate, bc.getBitrateBasedAdjustment(3_000_000, firstClaim))
+
+	clock.Advance(time.Millisecond)
+	require.Equal(t, IncreaseBitrate, bc.getBitrateBasedAdjustment(3_000_000, firstClaim))
+	require.Equal(t, IncreaseBitrate, bc.getBitrateBasedAdjustment(3_000_000, secondClaim))
+}
+
+func TestAdjustmentDelayBoundaries(t *testing.T) {
+	t.Parallel()
+
+	h := newNetworkHarness(t, false)
+	require.NoError(t, h.bc.SetLossDecreaseReports(1))
+
+	h.addTrack("video", QualityLow)
+
+	// adjust the loss based claim after the clock is advanced
+	adjust := func(d time.Duration, fractionLost float64) QualityLevel {
+		h.clock.Advance(d)
+
+		s := newReceiverReport(fractionLost)
+		h.client.stats.SetSender("video", s)
+
+		h.bc.checkAndAdjustBitrates()
+
+		return h.quality("video")
+	}
+
+	// no loss, the quality is increased
+	require.Equal(t, QualityLevel(QualityMid), adjust(0, 0))
+
+	// the 2s adjustment delay is not passed yet
+	require.Equal(t, QualityLevel(QualityMid), adjust(1999*time.Millisecond, 0.2))
+
+	// the adjustment delay is passed, the loss decrease the quality within the 10s increase window
+	require.Equal(t, QualityLevel(QualityLow), adjust(time.Millisecond, 0.2))
+
+	// the decrease within the increase window delay the next increase until 10s after the last increase
+	require.Equal(t, QualityLevel(QualityLow), adjust(5*time.Second, 0))
+	require.Equal(t, QualityLevel(QualityLow), adjust(2999*time.Millisecond, 0))
+	require.Equal(t, QualityLevel(QualityMid), adjust(time.Millisecond, 0))
+	require.Equal(t, 10*time.Second, h.clock.Now().Sub(h.start))
+}
+
+func TestTargetBitrate(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	require.NoError(t, client.sfu.clients.Add(client))
+
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// the target between the mid and high bitrates never overshoot to the high quality
+	require.NoError(t, client.SetTrackTargetBitrate("video", (bitrates.VideoMid+bitrates.VideoHigh)/2))
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+	require.False(t, claim.IsAdjustable())
+
+	// the pinned claim is not increased even there is enough bandwidth
+	bc.fitBitratesToBandwidth(10_000_000)
+	require.Equal(t, QualityLevel(QualityMid), claim.Quality())
+
+	// the claim is mapped again when the quality bitrates are changed
+	require.NoError(t, client.SFU().SetQualityBitrates(bitrates.VideoLow, bitrates.VideoHigh, 2*bitrates.VideoHigh))
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+
+	// the target below the low bitrate use the low quality
+	require.NoError(t, client.SetTrackTargetBitrate("video", 1))
+	require.Equal(t, QualityLevel(QualityLow), claim.Quality())
+
+	// unpin the claim
+	require.NoError(t, client.SetTrackTargetBitrate("video", 0))
+	require.True(t, claim.IsAdjustable())
+
+	bc.fitBitratesToBandwidth(10_000_000)
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+
+	require.ErrorIs(t, client.SetTrackTargetBitrate("unknown", bitrates.VideoMid), ErrTrackNotFound)
+
+	single := newTestClientTrack(t, client, "single", webrtc.RTPCodecTypeVideo, false)
+	_, err = bc.addClaim(single, QualityHigh, true)
+	require.NoError(t, err)
+	require.ErrorIs(t, client.SetTrackTargetBitrate("single", bitrates.VideoMid), ErrTrackNotAdjustable)
+}
+
+func TestSimulcastTrackTypeMismatch(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	require.NoError(t, client.sfu.clients.Add(client))
+
+	bc := client.bitrateController
+	bc.useBandwidthEstimation = false
+
+	// the track report simulcast but is not a simulcastClientTrack
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, false)
+	track.simulcast = true
+
+	require.NotPanics(t, func() {
+		require.NoError(t, bc.addClaims([]iClientTrack{track}))
+	})
+
+	claim := bc.GetClaim("video")
+	require.NotNil(t, claim)
+
+	claim.mu.Lock()
+	claim.lastIncreaseTime = time.Time{}
+	claim.lastDecreaseTime = time.Time{}
+	claim.mu.Unlock()
+
+	require.NotPanics(t, func() {
+		bc.getClaimBitrateAdjustment(claim)
+		bc.checkAndAdjustBitrates()
+		client.requestQualitySwitch(QualityMid)
+		require.NoError(t, bc.SetTargetBitrate("video", DefaultBitrates().VideoLow))
+	})
+}
+
+// run with the race detector to catch the claim fields that are read without the claim lock
+func TestConcurrentAdjustAndTotals(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+
+	for i := 0; i < 4; i++ {
+		track := newTestClientTrack(t, client, fmt.Sprintf("video-%d", i), webrtc.RTPCodecTypeVideo, true)
+		_, err := bc.addClaim(track, QualityLow, true)
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	run := func(fn func(i int)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; ctx.Err() == nil; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	qualities := []QualityLevel{QualityLow, QualityMid, QualityHigh}
+
+	run(func(i int) {
+		bc.setQuality(fmt.Sprintf("video-%d", i%4), qualities[i%len(qualities)])
+	})
+
+	run(func(_ int) {
+		bc.checkAndAdjustBitrates()
+	})
+
+	run(func(_ int) {
+		total := bc.totalBitrates()
+		require.LessOrEqual(t, total, 4*DefaultBitrates().VideoHigh)
+		bc.totalSentBitrates()
+		bc.Snapshot()
+	})
+
+	wg.Wait()
+}
+
+func TestAddClaimConcurrently(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	for i := 0; i < 50; i++ {
+		track := newTestClientTrack(t, client, fmt.Sprintf("video-%d", i), webrtc.RTPCodecTypeVideo, true)
+
+		start := make(chan struct{})
+		errs := make(chan error, 2)
+
+		for j := 0; j < 2; j++ {
+			go func() {
+				<-start
+				errs <- bc.addClaims([]iClientTrack{track})
+			}()
+		}
+
+		close(start)
+
+		succeeded := 0
+		for j := 0; j < 2; j++ {
+			if err := <-errs; err == nil {
+				succeeded++
+			} else {
+				require.ErrorIs(t, err, ErrAlreadyClaimed)
+			}
+		}
+
+		require.Equal(t, 1, succeeded)
+	}
+
+	require.Len(t, bc.Claims(), 50)
+}
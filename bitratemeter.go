@@ -0,0 +1,72 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// the window of the forwarded bitrate measurement
+	bitrateMeterWindow = time.Second
+	// the window is split into buckets, so the old bytes expire gradually instead of all at once
+	bitrateMeterBuckets = 10
+)
+
+// bitrateMeter measure the bitrate of the bytes added within the sliding window
+type bitrateMeter struct {
+	mu          sync.Mutex
+	buckets     [bitrateMeterBuckets]uint64
+	lastBucket  int64
+	hasRecorded bool
+}
+
+func bucketIndex(now time.Time) int64 {
+	return now.UnixNano() / int64(bitrateMeterWindow/bitrateMeterBuckets)
+}
+
+// expire clear the buckets that moved out of the window since the last bucket, must be called with the mutex held
+func (m *bitrateMeter) expire(bucket int64) {
+	if !m.hasRecorded || bucket <= m.lastBucket {
+		return
+	}
+
+	if bucket-m.lastBucket >= bitrateMeterBuckets {
+		m.buckets = [bitrateMeterBuckets]uint64{}
+	} else {
+		for i := m.lastBucket + 1; i <= bucket; i++ {
+			m.buckets[i%bitrateMeterBuckets] = 0
+		}
+	}
+
+	m.lastBucket = bucket
+}
+
+func (m *bitrateMeter) add(bytes int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := bucketIndex(now)
+	m.expire(bucket)
+
+	if !m.hasRecorded {
+		m.hasRecorded = true
+		m.lastBucket = bucket
+	}
+
+	m.buckets[bucket%bitrateMeterBuckets] += uint64(bytes)
+}
+
+// bitrate return the bits per second of the bytes added within the window
+func (m *bitrateMeter) bitrate(now time.Time) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire(bucketIndex(now))
+
+	total := uint64(0)
+	for _, bytes := range m.buckets {
+		total += bytes
+	}
+
+	return uint32(float64(total*8) / bitrateMeterWindow.Seconds())
+}
@@ -0,0 +1,137 @@
+package sfu
+
+import "time"
+
+// BitrateClaim and BitrateAdjustment are exported so a BitrateStrategy can be implemented outside the package
+type (
+	BitrateClaim      = bitrateClaim
+	BitrateAdjustment = bitrateAdjustment
+)
+
+const (
+	KeepBitrate     BitrateAdjustment = keepBitrate
+	IncreaseBitrate BitrateAdjustment = increaseBitrate
+	DecreaseBitrate BitrateAdjustment = decreaseBitrate
+)
+
+// StrategyContext is the state of the bitrate controller when the strategy is asked to adjust a claim
+type StrategyContext struct {
+	// the estimated bandwidth of the client in bps
+	EstimatedBandwidth uint32
+	// the total bitrate of all claims in bps
+	TotalBitrates uint32
+	// the total sent bitrate of all claims in bps
+	TotalSentBitrates uint32
+	// the minimum time between two adjustments of the claim
+	AdjustmentDelay time.Duration
+	// a decrease within this window after an increase means the bitrate was increased too fast
+	IncreaseWindow   time.Duration
+	LastIncreaseTime time.Time
+	LastDecreaseTime time.Time
+}
+
+// BitrateStrategy decide if the bitrate of a claim should be increased, decreased, or kept.
+// The controller only call the strategy after the adjustment delay is passed and the claim is adjustable.
+type BitrateStrategy interface {
+	Adjust(claim *bitrateClaim, ctx StrategyContext) bitrateAdjustment
+}
+
+const (
+	// the fraction lost below this threshold allow the loss based adjuster to increase the bitrate
+	DefaultLossIncreaseThreshold = 0.02
+	// the fraction lost above this threshold make the loss based adjuster decrease the bitrate
+	DefaultLossDecreaseThreshold = 0.1
+	// the number of consecutive reports above the decrease threshold before the loss based adjuster decrease the bitrate
+	DefaultLossDecreaseReports = 2
+)
+
+// lossBasedStrategy adjust the bitrate based on the packet loss reported by the receiver
+type lossBasedStrategy struct {
+	bc *bitrateController
+}
+
+func (s lossBasedStrategy) Adjust(claim *bitrateClaim, _ StrategyContext) bitrateAdjustment {
+	return s.bc.getLossBasedAdjustment(claim)
+}
+
+// bandwidthBasedStrategy adjust the bitrate based on the estimated bandwidth from the congestion controller
+type bandwidthBasedStrategy struct {
+	bc *bitrateController
+}
+
+func (s bandwidthBasedStrategy) Adjust(claim *bitrateClaim, ctx StrategyContext) bitrateAdjustment {
+	return s.bc.getBitrateBasedAdjustment(ctx.EstimatedBandwidth, claim)
+}
+
+func (bc *bitrateController) defaultStrategy() BitrateStrategy {
+	if bc.useBandwidthEstimation {
+		return bandwidthBasedStrategy{bc: bc}
+	}
+
+	return lossBasedStrategy{bc: bc}
+}
+
+// SetStrategy replace the strategy that used to adjust the claims bitrate, set nil to use the default strategy
+func (bc *bitrateController) SetStrategy(strategy BitrateStrategy) {
+	if strategy == nil {
+		strategy = bc.defaultStrategy()
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.strategy = strategy
+}
+
+func (bc *bitrateController) Strategy() BitrateStrategy {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.strategy
+}
+
+// SetLossThresholds set the fraction lost thresholds that used by the loss based strategy. The bitrate is increased
+// when the fraction lost is below the increase threshold and decreased when it is above the decrease threshold.
+func (bc *bitrateController) SetLossThresholds(increase, decrease float64) error {
+	if increase < 0 || increase >= decrease || decrease > 1 {
+		return ErrInvalidLossThresholds
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.lossIncreaseThreshold = increase
+	bc.lossDecreaseThreshold = decrease
+
+	return nil
+}
+
+func (bc *bitrateController) LossThresholds() (increase, decrease float64) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.lossIncreaseThreshold, bc.lossDecreaseThreshold
+}
+
+// SetLossDecreaseReports set how many consecutive reports the fraction lost must be above the decrease threshold
+// before the loss based strategy decrease the bitrate, so a momentary loss spike doesn't drop the quality.
+// Set 1 to decrease on the first report.
+func (bc *bitrateController) SetLossDecreaseReports(reports int) error {
+	if reports < 1 {
+		return ErrInvalidLossDecreaseReports
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.lossDecreaseReports = reports
+
+	return nil
+}
+
+func (bc *bitrateController) LossDecreaseReports() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.lossDecreaseReports
+}
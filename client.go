@@ -11,7 +11,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/inlivedev/sfu/pkg/interceptors/playoutdelay"
 	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
 	"github.com/pion/interceptor"
@@ -35,6 +34,9 @@ const (
 	ClientTypeUpBridge   = "upbridge"
 	ClientTypeDownBridge = "downbridge"
 
+	// QualityVeryHigh is numbered after the audio levels to keep their values, step between the video levels
+	// with higherQuality and lowerQuality instead of adding or subtracting one
+	QualityVeryHigh = 6
 	QualityAudioRed = 5
 	QualityAudio    = 4
 	QualityHigh     = 3
@@ -42,10 +44,12 @@ const (
 	QualityLow      = 1
 	QualityNone     = 0
 
-	messageTypeVideoSize  = "video_size"
-	messageTypeStats      = "stats"
-	messageTypeVADStarted = "vad_started"
-	messageTypeVADEnded   = "vad_ended"
+	messageTypeVideoSize        = "video_size"
+	messageTypeMaxDecodeQuality = "max_decode_quality"
+	messageTypeStats            = "stats"
+	messageTypeVADStarted       = "vad_started"
+	messageTypeVADEnded         = "vad_ended"
+	messageTypeData             = "data"
 )
 
 type QualityLevel uint32
@@ -73,6 +77,18 @@ type ClientOptions struct {
 	// 100/150/200 ms: These could be the max target latency for interactive streaming use cases depending on the actual application (gaming, remoting with audio, interactive scenarios)
 	// 400 ms: Application that want to ensure a network glitch has very little chance of causing a freeze can start with a minimum delay target that is high enough to deal with network issues. Video streaming is one example.
 	MaxPlayoutDelay uint16
+	// Configure the highest video quality that the client device can decode, the video sent to the client never exceed
+	// this quality even the bandwidth and the viewport allow it. Zero means no limit.
+	MaxDecodeQuality QualityLevel
+	// Configure the maximum number of tracks forwarded to the client, the tracks over the limit are not forwarded.
+	// This protect the SFU from a single client subscribing hundreds of tracks in a large room. Zero means unlimited.
+	MaxClaims int
+	// Disable the keyframe request when the max quality of a track is raised, for example when the viewport of the
+	// track is enlarged. The quality is then increased on the next keyframe sent by the publisher.
+	DisableMaxQualityPLI bool
+	// Configure which layer of the VP9 scaleable tracks is dropped first when the quality is reduced, a low-power
+	// device may prefer to keep the resolution and drop the frame rate. Default is LayerReductionBalanced.
+	LayerReductionPreference LayerReductionPreference
 }
 
 type internalDataMessage struct {
@@ -90,6 +106,17 @@ type internalDataStats struct {
 	Data remoteClientStats `json:"data"`
 }
 
+type internalDataMaxDecodeQuality struct {
+	Type string       `json:"type"`
+	Data QualityLevel `json:"data"`
+}
+
+// internalDataData is the typed data message, the SFU set the sender and the sent time of the message received from the client
+type internalDataData struct {
+	Type string `json:"type"`
+	Data Data   `json:"data"`
+}
+
 type internalDataVideoSize struct {
 	Type string    `json:"type"`
 	Data videoSize `json:"data"`
@@ -117,6 +144,7 @@ type Client struct {
 	canAddCandidate       *atomic.Bool
 	clientTracks          map[string]iClientTrack
 	internalDataChannel   *webrtc.DataChannel
+	controlDataChannel    *webrtc.DataChannel
 	dataChannels          *DataChannelList
 	estimator             cc.BandwidthEstimator
 	initialTracksCount    atomic.Uint32
@@ -133,22 +161,27 @@ type Client struct {
 	// the source can be set through client.SetTracksSourceType()
 	pendingPublishedTracks *trackList
 	// published tracks are the remote tracks from other clients that are published to this client
-	publishedTracks                   *trackList
-	pendingRemoteRenegotiation        *atomic.Bool
-	queue                             *queue
-	receiveRED                        bool
-	state                             *atomic.Value
-	sfu                               *SFU
-	onConnectionStateChangedCallbacks []func(webrtc.PeerConnectionState)
-	onJoinedCallbacks                 []func()
-	onLeftCallbacks                   []func()
-	onVoiceDetectedCallbacks          []func(voiceactivedetector.VoiceActivity)
-	onTrackRemovedCallbacks           []func(sourceType string, track *webrtc.TrackLocalStaticRTP)
-	onIceCandidate                    func(context.Context, *webrtc.ICECandidate)
-	onBeforeRenegotiation             func(context.Context) bool
-	onRenegotiation                   func(context.Context, webrtc.SessionDescription) (webrtc.SessionDescription, error)
-	onAllowedRemoteRenegotiation      func()
-	onTracksAvailableCallbacks        []func([]ITrack)
+	publishedTracks                     *trackList
+	pendingRemoteRenegotiation          *atomic.Bool
+	queue                               *queue
+	receiveRED                          bool
+	state                               *atomic.Value
+	sfu                                 *SFU
+	onConnectionStateChangedCallbacks   []func(webrtc.PeerConnectionState)
+	onJoinedCallbacks                   []func()
+	onLeftCallbacks                     []func()
+	onVoiceDetectedCallbacks            []func(voiceactivedetector.VoiceActivity)
+	onTrackRemovedCallbacks             []func(sourceType string, track *webrtc.TrackLocalStaticRTP)
+	onIceCandidate                      func(context.Context, *webrtc.ICECandidate)
+	onBeforeRenegotiation               func(context.Context) bool
+	onRenegotiation                     func(context.Context, webrtc.SessionDescription) (webrtc.SessionDescription, error)
+	onAllowedRemoteRenegotiation        func()
+	onTracksAvailableCallbacks          []func([]ITrack)
+	onEstimatedBandwidthChangeCallbacks []func(bps uint32)
+	onTrackStalledCallbacks             []func(trackID string, stalled bool)
+	onTrackLayerUnaffordableCallbacks   []func(trackID string, quality QualityLevel)
+	onDataCallbacks                     []func(Data)
+	onDataChannelRateLimitedCallbacks   []func(label string, messages int, size int)
 	// onTrack is used by SFU to take action when a new track is added to the client
 	onTrack                        func(ITrack)
 	onTracksAdded                  func([]ITrack)
@@ -160,12 +193,25 @@ type Client struct {
 	pendingRemoteCandidates        []webrtc.ICECandidateInit
 	pendingLocalCandidates         []*webrtc.ICECandidate
 	quality                        *atomic.Uint32
+	maxDecodeQuality               *atomic.Uint32
 	receivingBandwidth             *atomic.Uint32
 	egressBandwidth                *atomic.Uint32
 	ingressBandwidth               *atomic.Uint32
+	rembBandwidth                  *atomic.Uint32
 	ingressQualityLimitationReason *atomic.Value
 	isDebug                        bool
 	vad                            *voiceactivedetector.Interceptor
+	// the claims and the packet caches are released while the connection is failed
+	isReleased atomic.Bool
+	// limit the messages relayed from all data channels of the client
+	dataChannelLimiter *dataChannelRateLimiter
+	// aggregate the messages dropped by the rate limit and by the full send queue of the client
+	rateLimitedDrops *dropReporter
+	sendQueueDrops   *dropReporter
+	// the audio tracks published by the client are not forwarded while muted
+	audioMuted atomic.Bool
+	// the LayerReductionPreference of the scaleable tracks sent to the client
+	layerReductionPreference atomic.Uint32
 }
 
 func DefaultClientOptions() ClientOptions {
@@ -193,6 +239,9 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	}
 
 	RegisterSimulcastHeaderExtensions(m, webrtc.RTPCodecTypeVideo)
+	RegisterAbsCaptureTimeHeaderExtension(m)
+	RegisterLayerDropHeaderExtension(m)
+	RegisterVideoOrientationHeaderExtension(m)
 	if opts.EnableVoiceDetection {
 		voiceactivedetector.RegisterAudioLevelHeaderExtension(m)
 	}
@@ -216,7 +265,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	i.Add(statsInterceptorFactory)
 
 	if opts.EnableVoiceDetection {
-		glog.Info("client: voice detection is enabled")
+		logger().Info("client: voice detection is enabled")
 		vadInterceptorFactory := voiceactivedetector.NewInterceptor(localCtx)
 
 		// enable voice detector
@@ -239,7 +288,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 			// if bw below 100_000, somehow the estimator will struggle to probe the bandwidth and will stuck there. So we set the min to 100_000
 			// TODO: we need to use packet loss based bandwidth adjuster when the bandwidth is below 100_000
 			return gcc.NewSendSideBWE(
-				gcc.SendSideBWEInitialBitrate(int(s.bitrateConfigs.InitialBandwidth)),
+				gcc.SendSideBWEInitialBitrate(int(s.BitrateConfigs().InitialBandwidth)),
 				gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
 			)
 		})
@@ -265,8 +314,18 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		i.Add(playoutDelayInterceptor)
 	}
 
-	// Use the default set of Interceptors
-	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+	// the NACKs to the publisher are sent by the remote tracks, only the responder for the subscriber NACKs is registered
+	if err := configureNACKResponder(m, i); err != nil {
+		panic(err)
+	}
+
+	// the sender reports count the packets written on each sender, which are the packets forwarded to the subscriber
+	// after the quality and the layers are selected, not the packets sent by the publisher
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		panic(err)
+	}
+
+	if err := configureTWCCSender(m, i, s.twccFeedbackInterval); err != nil {
 		panic(err)
 	}
 
@@ -276,7 +335,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		settingEngine.SetICEUDPMux(s.mux.mux)
 	} else {
 		if err := settingEngine.SetEphemeralUDPPortRange(s.portStart, s.portEnd); err != nil {
-			glog.Error("client: error set ephemeral udp port range ", err)
+			logger().Error("client: error set ephemeral udp port range ", err)
 		}
 	}
 
@@ -294,7 +353,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	stateNew.Store(ClientStateNew)
 
 	var quality atomic.Uint32
-	quality.Store(QualityHigh)
+	quality.Store(QualityVeryHigh)
 	client = &Client{
 		id:                             id,
 		name:                           name,
@@ -323,24 +382,37 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		receivingBandwidth:             &atomic.Uint32{},
 		egressBandwidth:                &atomic.Uint32{},
 		ingressBandwidth:               &atomic.Uint32{},
+		rembBandwidth:                  &atomic.Uint32{},
 		ingressQualityLimitationReason: &atomic.Value{},
 		onTracksAvailableCallbacks:     make([]func([]ITrack), 0),
 		vad:                            vad,
+		dataChannelLimiter:             newDataChannelRateLimiter(s.dataChannelRateLimit),
 	}
 
+	client.rateLimitedDrops = newDropReporter(dataChannelDropReportInterval, func(label string, drops droppedMessages) {
+		logger().Warn("client: ", client.ID(), " data channel ", label, " is over the rate limit, drop ", drops.messages, " messages ", drops.bytes, " bytes")
+		client.onDataChannelRateLimited(label, drops.messages, drops.bytes)
+	})
+
+	client.sendQueueDrops = newDropReporter(dataChannelDropReportInterval, func(label string, drops droppedMessages) {
+		logger().Warn("client: ", client.ID(), " data channel ", label, " send queue is full, drop ", drops.messages, " messages ", drops.bytes, " bytes")
+	})
+
 	// setup internal data channel
 	if opts.EnableVoiceDetection {
 		client.enableSendVADToInternalDataChannel()
 		client.enableVADStatUpdate()
 	}
 
-	client.quality.Store(QualityHigh)
+	client.maxDecodeQuality = &atomic.Uint32{}
+	client.SetMaxDecodeQuality(opts.MaxDecodeQuality)
+	client.SetLayerReductionPreference(opts.LayerReductionPreference)
 
 	client.ingressQualityLimitationReason.Store("none")
 
 	client.stats = newClientStats(client)
 
-	client.bitrateController = newbitrateController(client, s.pliInterval, s.enableBandwidthEstimator)
+	client.bitrateController = newBitrateController(client, WithBandwidthEstimation(s.enableBandwidthEstimator))
 
 	if s.enableBandwidthEstimator {
 		go func() {
@@ -356,7 +428,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 	peerConnection.OnConnectionStateChange(func(connectionState webrtc.PeerConnectionState) {
 		if client.isDebug {
-			glog.Info("client: connection state changed ", connectionState.String())
+			logger().Debug("client: connection state changed ", connectionState.String())
 		}
 		go client.onConnectionStateChanged(connectionState)
 	})
@@ -366,7 +438,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		var track ITrack
 
-		defer glog.Info("client: new track ", remoteTrack.ID(), " Kind:", remoteTrack.Kind(), " Codec: ", remoteTrack.Codec().MimeType, " RID: ", remoteTrack.RID())
+		defer logger().Info("client: new track ", remoteTrack.ID(), " Kind:", remoteTrack.Kind(), " Codec: ", remoteTrack.Codec().MimeType, " RID: ", remoteTrack.RID())
 
 		onPLI := func() {
 			if client.peerConnection == nil || client.peerConnection.PC() == nil || client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
@@ -376,7 +448,24 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 			if err := client.peerConnection.PC().WriteRTCP([]rtcp.Packet{
 				&rtcp.PictureLossIndication{MediaSSRC: uint32(remoteTrack.SSRC())},
 			}); err != nil {
-				glog.Error("client: error write pli ", err)
+				logger().Error("client: error write pli ", err)
+			}
+		}
+
+		nack := nackOptions{}
+		if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+			nack = nackOptions{
+				retransmitLimit: s.nackRetransmitLimit,
+				backoff:         s.nackBackoff,
+				onNACK: func(nack *rtcp.TransportLayerNack) {
+					if client.peerConnection == nil || client.peerConnection.PC() == nil || client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
+						return
+					}
+
+					if err := client.peerConnection.PC().WriteRTCP([]rtcp.Packet{nack}); err != nil {
+						logger().Error("client: error write nack ", err)
+					}
+				},
 			}
 		}
 
@@ -390,7 +479,10 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 		if remoteTrack.RID() == "" {
 			// not simulcast
 
-			track = newTrack(client.context, client.id, remoteTrack, s.pliInterval, onPLI, client.statsGetter, onStatsUpdated)
+			track = newTrack(client.context, client.id, remoteTrack, receiver.GetParameters().HeaderExtensions, s.pliInterval, s.pliDebounceWindow, onPLI, nack, client.statsGetter, onStatsUpdated)
+			if track.Kind() == webrtc.RTPCodecTypeAudio {
+				track.(*Track).muted.Store(client.audioMuted.Load())
+			}
 
 			go func() {
 				ctx, cancel := context.WithCancel(track.Context())
@@ -399,9 +491,7 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 				client.stats.removeReceiverStats(remoteTrack.ID())
 			}()
 
-			if err := client.tracks.Add(track); err != nil {
-				glog.Error("client: error add track ", err)
-			}
+			client.addTrack(track)
 
 			client.onTrack(track)
 			track.SetAsProcessed()
@@ -416,10 +506,8 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 
 			if err != nil {
 				// if track not found, add it
-				track = newSimulcastTrack(client.context, client.id, remoteTrack, s.pliInterval, onPLI, client.statsGetter, onStatsUpdated)
-				if err := client.tracks.Add(track); err != nil {
-					glog.Error("client: error add track ", err)
-				}
+				track = newSimulcastTrack(client.context, client.id, remoteTrack, receiver.GetParameters().HeaderExtensions, s.pliInterval, s.pliDebounceWindow, onPLI, nack, client.statsGetter, onStatsUpdated, s.RIDToQuality)
+				client.addTrack(track)
 
 				go func() {
 					ctx, cancel := context.WithCancel(track.Context())
@@ -429,11 +517,11 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 				}()
 
 				if simulcast, ok = track.(*SimulcastTrack); !ok {
-					glog.Error("client: error track is not simulcast track")
+					logger().Error("client: error track is not simulcast track")
 				}
 
 			} else if simulcast, ok = track.(*SimulcastTrack); ok {
-				simulcast.AddRemoteTrack(simulcast.context, remoteTrack, client.statsGetter, onStatsUpdated)
+				simulcast.AddRemoteTrack(simulcast.context, remoteTrack, onPLI, client.statsGetter, onStatsUpdated)
 			}
 
 			// only process track when the lowest quality is available
@@ -468,6 +556,22 @@ func NewClient(s *SFU, id string, name string, peerConnectionConfig webrtc.Confi
 	return client
 }
 
+// addTrack add the track published by the client and inform the SFU, the SFU is informed again when the track is ended
+func (c *Client) addTrack(track ITrack) {
+	if err := c.tracks.Add(track); err != nil {
+		logger().Error("client: error add track ", err)
+		return
+	}
+
+	c.sfu.onTrackPublished(c.id, track.ID(), track.Kind())
+
+	go func() {
+		<-track.Context().Done()
+
+		c.sfu.onTrackUnpublished(c.id, track.ID(), track.Kind())
+	}()
+}
+
 func (c *Client) ID() string {
 	return c.id
 }
@@ -534,8 +638,8 @@ func (c *Client) IsAllowNegotiation() bool {
 
 // SDP negotiation from remote client
 func (c *Client) Negotiate(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	glog.Info("client: negotiation started ", c.ID)
-	defer glog.Info("client: negotiation done ", c.ID)
+	logger().Info("client: negotiation started ", c.ID)
+	defer logger().Info("client: negotiation done ", c.ID)
 
 	answerChan := make(chan webrtc.SessionDescription)
 	errorChan := make(chan error)
@@ -562,7 +666,7 @@ func (c *Client) negotiateQueuOp(offer webrtc.SessionDescription) (*webrtc.Sessi
 	if !c.receiveRED {
 		match, err := regexp.MatchString(`a=rtpmap:63`, offer.SDP)
 		if err != nil {
-			glog.Error("client: error on check RED support in SDP ", err)
+			logger().Error("client: error on check RED support in SDP ", err)
 		} else {
 			c.receiveRED = match
 		}
@@ -642,7 +746,7 @@ func (c *Client) OnRenegotiation(callback func(context.Context, webrtc.SessionDe
 func (c *Client) renegotiateQueuOp() {
 	c.mu.Lock()
 	if c.onRenegotiation == nil {
-		glog.Error("client: onRenegotiation is not set, can't do renegotiation")
+		logger().Error("client: onRenegotiation is not set, can't do renegotiation")
 		c.mu.Unlock()
 
 		return
@@ -651,7 +755,7 @@ func (c *Client) renegotiateQueuOp() {
 	c.mu.Unlock()
 
 	if c.isInRemoteNegotiation.Load() {
-		glog.Info("sfu: renegotiation is delayed because the remote client is doing negotiation ", c.ID)
+		logger().Info("sfu: renegotiation is delayed because the remote client is doing negotiation ", c.ID)
 
 		return
 	}
@@ -694,14 +798,14 @@ func (c *Client) renegotiateQueuOp() {
 
 					offer, err := c.peerConnection.PC().CreateOffer(nil)
 					if err != nil {
-						glog.Error("sfu: error create offer on renegotiation ", err)
+						logger().Error("sfu: error create offer on renegotiation ", err)
 						return
 					}
 
 					// Sets the LocalDescription, and starts our UDP listeners
 					err = c.peerConnection.PC().SetLocalDescription(offer)
 					if err != nil {
-						glog.Error("sfu: error set local description on renegotiation ", err)
+						logger().Error("sfu: error set local description on renegotiation ", err)
 						return
 					}
 
@@ -709,12 +813,12 @@ func (c *Client) renegotiateQueuOp() {
 					answer, err := c.onRenegotiation(c.context, *c.peerConnection.PC().LocalDescription())
 					if err != nil {
 						//TODO: when this happen, we need to close the client and ask the remote client to reconnect
-						glog.Error("sfu: error on renegotiation ", err)
+						logger().Error("sfu: error on renegotiation ", err)
 						return
 					}
 
 					if answer.Type != webrtc.SDPTypeAnswer {
-						glog.Error("sfu: error on renegotiation, the answer is not an answer type")
+						logger().Error("sfu: error on renegotiation, the answer is not an answer type")
 						return
 					}
 
@@ -774,7 +878,7 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 
 	transc, err := c.peerConnection.PC().AddTransceiverFromTrack(localTrack, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
 	if err != nil {
-		glog.Error("client: error on adding track ", err)
+		logger().Error("client: error on adding track ", err)
 		return nil
 	}
 
@@ -803,7 +907,7 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 		_ = sender.Stop()
 
 		if err := c.peerConnection.PC().RemoveTrack(sender); err != nil {
-			glog.Error("client: error remove track ", err)
+			logger().Error("client: error remove track ", err)
 			return
 		}
 
@@ -812,6 +916,13 @@ func (c *Client) setClientTrack(t ITrack) iClientTrack {
 		c.renegotiate()
 	}()
 
+	switch track := outputTrack.(type) {
+	case *scaleableClientTrack:
+		track.setSubscriberHeaderExtensions(transc.Sender().GetParameters().HeaderExtensions)
+	case *simulcastClientTrack:
+		track.setSubscriberHeaderExtensions(transc.Sender().GetParameters().HeaderExtensions)
+	}
+
 	// enable RTCP report and stats
 	c.enableReportAndStats(transc.Sender(), outputTrack)
 
@@ -851,11 +962,16 @@ func (c *Client) enableReportAndStats(rtpSender *webrtc.RTPSender, track iClient
 				}
 
 				for _, p := range rtcpPackets {
-					switch p.(type) {
+					switch packet := p.(type) {
 					case *rtcp.PictureLossIndication:
 						track.RequestPLI()
 					case *rtcp.FullIntraRequest:
 						track.RequestPLI()
+					case *rtcp.ReceiverEstimatedMaximumBitrate:
+						// fallback to REMB when the receiver doesn't support transport-cc
+						if !isTransportCCNegotiated(rtpSender.GetParameters()) {
+							c.bitrateController.onREMB(uint32(packet.Bitrate))
+						}
 					}
 				}
 			}
@@ -874,6 +990,11 @@ func (c *Client) enableReportAndStats(rtpSender *webrtc.RTPSender, track iClient
 				return
 			case <-tick.C:
 				c.updateSenderStats(rtpSender)
+
+				if track.Kind() == webrtc.RTPCodecTypeAudio {
+					c.bitrateController.updateAudioRedundancy(track.ID())
+					c.bitrateController.updateAudioSilence(track.ID(), time.Now())
+				}
 			}
 		}
 	}()
@@ -883,7 +1004,7 @@ func (c *Client) processPendingTracks() (isNeedNegotiation bool) {
 	if len(c.pendingReceivedTracks) > 0 {
 		err := c.SubscribeTracks(c.pendingReceivedTracks)
 		if err != nil {
-			glog.Error("client: error subscribe tracks ", err)
+			logger().Error("client: error subscribe tracks ", err)
 			return false
 		}
 
@@ -905,6 +1026,8 @@ func (c *Client) afterClosed() {
 
 	c.cancel()
 
+	c.bitrateController.Close()
+
 	c.sfu.onAfterClientStopped(c)
 }
 
@@ -925,7 +1048,7 @@ func (c *Client) AddICECandidate(candidate webrtc.ICECandidateInit) error {
 		c.pendingRemoteCandidates = append(c.pendingRemoteCandidates, candidate)
 	} else {
 		if err := c.peerConnection.PC().AddICECandidate(candidate); err != nil {
-			glog.Error("client: error add ice candidate ", err)
+			logger().Error("client: error add ice candidate ", err)
 			return err
 		}
 	}
@@ -942,7 +1065,7 @@ func (c *Client) OnIceCandidate(callback func(context.Context, *webrtc.ICECandid
 
 func (c *Client) onIceCandidateCallback(candidate *webrtc.ICECandidate) {
 	if c.onIceCandidate == nil {
-		glog.Info("client: on ice candidate callback is not set")
+		logger().Info("client: on ice candidate callback is not set")
 		return
 	}
 
@@ -961,7 +1084,8 @@ func (c *Client) sendPendingLocalCandidates() {
 }
 
 // OnConnectionStateChanged event is called when the SFU connection state is changed.
-// The callback will receive the connection state as the new state.
+// The callback will receive the connection state as the new state, it can be registered before the negotiation.
+// When the connection is failed, the tracks sent to the client are released until the connection is connected again.
 func (c *Client) OnConnectionStateChanged(callback func(webrtc.PeerConnectionState)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -969,6 +1093,46 @@ func (c *Client) OnConnectionStateChanged(callback func(webrtc.PeerConnectionSta
 	c.onConnectionStateChangedCallbacks = append(c.onConnectionStateChangedCallbacks, callback)
 }
 
+// packetCacheHolder is a client track that cache the sent packets to handle the late packets
+type packetCacheHolder interface {
+	resetPacketCaches()
+}
+
+// releaseResources remove the claims and the packet caches of the tracks sent to the client when the connection failed,
+// nothing can be sent until the connection is recovered or the client is closed after the idle timeout.
+func (c *Client) releaseResources() {
+	if !c.isReleased.CompareAndSwap(false, true) {
+		return
+	}
+
+	logger().Info("client: ", c.ID(), " connection failed, release the claims and the packet caches")
+
+	c.bitrateController.releaseClaims()
+
+	for _, track := range c.ClientTracks() {
+		if holder, ok := track.(packetCacheHolder); ok {
+			holder.resetPacketCaches()
+		}
+	}
+}
+
+// restoreResources add back the claims that released when the connection failed, the tracks are sent again
+// with the qualities distributed from the current bandwidth
+func (c *Client) restoreResources() {
+	if !c.isReleased.CompareAndSwap(true, false) {
+		return
+	}
+
+	clientTracks := make([]iClientTrack, 0)
+	for _, track := range c.ClientTracks() {
+		clientTracks = append(clientTracks, track)
+	}
+
+	if err := c.bitrateController.addClaims(clientTracks); err != nil {
+		logger().Error("client: error restore claims ", err)
+	}
+}
+
 func (c *Client) onConnectionStateChanged(state webrtc.PeerConnectionState) {
 	c.mu.RLock()
 	callbacks := c.onConnectionStateChangedCallbacks
@@ -987,6 +1151,65 @@ func (c *Client) onJoined() {
 	}
 }
 
+// OnEstimatedBandwidthChange event is called on every bandwidth estimator update.
+// The callback will receive the raw estimated bandwidth in bits per second before it is allocated to the tracks.
+// The callbacks are called in the order of the updates before the bandwidth is allocated, so they should not block.
+func (c *Client) OnEstimatedBandwidthChange(callback func(bps uint32)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onEstimatedBandwidthChangeCallbacks = append(c.onEstimatedBandwidthChangeCallbacks, callback)
+}
+
+func (c *Client) onEstimatedBandwidthChange(bps uint32) {
+	c.mu.RLock()
+	callbacks := c.onEstimatedBandwidthChangeCallbacks
+	c.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(bps)
+	}
+}
+
+// OnTrackStalled event is called when a subscribed track doesn't receive any packet from the publisher within the
+// track stall timeout, and called again with stalled false when the packets are received again.
+// The stalled track is excluded from the bandwidth allocation until it is recovered.
+func (c *Client) OnTrackStalled(callback func(trackID string, stalled bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onTrackStalledCallbacks = append(c.onTrackStalledCallbacks, callback)
+}
+
+func (c *Client) onTrackStalled(trackID string, stalled bool) {
+	c.mu.RLock()
+	callbacks := c.onTrackStalledCallbacks
+	c.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		go callback(trackID, stalled)
+	}
+}
+
+// OnTrackLayerUnaffordable event is called when a subscribed simulcast track only has a single layer and the layer
+// is above the track max quality or the subscriber bandwidth. The track is not forwarded until the layer is affordable.
+func (c *Client) OnTrackLayerUnaffordable(callback func(trackID string, quality QualityLevel)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onTrackLayerUnaffordableCallbacks = append(c.onTrackLayerUnaffordableCallbacks, callback)
+}
+
+func (c *Client) onTrackLayerUnaffordable(trackID string, quality QualityLevel) {
+	c.mu.RLock()
+	callbacks := c.onTrackLayerUnaffordableCallbacks
+	c.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		go callback(trackID, quality)
+	}
+}
+
 // OnJoined event is called when the client is joined to the room.
 // This doesn't mean that the client's tracks are already published to the room.
 // This event can be use to track number of clients in the room.
@@ -1030,7 +1253,7 @@ func (c *Client) startIdleTimeout() {
 
 	go func() {
 		<-c.idleTimeoutContext.Done()
-		glog.Info("client: idle timeout reached ", c.ID)
+		logger().Info("client: idle timeout reached ", c.ID)
 		c.afterClosed()
 	}()
 }
@@ -1071,6 +1294,11 @@ func (c *Client) updateSenderStats(sender *webrtc.RTPSender) {
 		return
 	}
 
+	// the track is unsubscribed
+	if !c.bitrateController.exists(sender.Track().ID()) {
+		return
+	}
+
 	ssrc := sender.GetParameters().Encodings[0].SSRC
 
 	stats := c.statsGetter.Get(uint32(ssrc))
@@ -1161,7 +1389,7 @@ func (c *Client) SubscribeTracks(req []SubscribeTrackRequest) error {
 
 		// claim bitrates
 		if err := c.bitrateController.addClaims(clientTracks); err != nil {
-			glog.Error("sfu: failed to add claims ", err)
+			logger().Error("sfu: failed to add claims ", err)
 		}
 
 		// request keyframe
@@ -1183,30 +1411,277 @@ func (c *Client) SubscribeAllTracks() {
 	}
 }
 
+// SubscribeTrack resume forwarding a track that previously unsubscribed with `client.UnsubscribeTrack()`.
+// If the track is never subscribed before, it will be subscribed the same way as `client.SubscribeTracks()`.
+// A keyframe is requested from the publisher so the client can decode the track right away.
+func (c *Client) SubscribeTrack(trackID string) error {
+	c.mu.RLock()
+	clientTrack, ok := c.clientTracks[trackID]
+	c.mu.RUnlock()
+
+	if !ok {
+		for _, track := range c.sfu.AvailableTracks() {
+			if track.ID() == trackID {
+				return c.SubscribeTracks([]SubscribeTrackRequest{{ClientID: track.ClientID(), TrackID: trackID}})
+			}
+		}
+
+		return ErrTrackNotFound
+	}
+
+	if c.bitrateController.exists(trackID) {
+		return nil
+	}
+
+	if err := c.bitrateController.addClaims([]iClientTrack{clientTrack}); err != nil {
+		return err
+	}
+
+	clientTrack.RequestPLI()
+
+	return nil
+}
+
+// UnsubscribeTrack stop forwarding the track to the client without renegotiation.
+// The bitrate claim of the track is removed so the bandwidth can be used by the other tracks,
+// and the packet caches and sender stats of the track are released.
+// Use `client.SubscribeTrack()` to resume the track.
+func (c *Client) UnsubscribeTrack(trackID string) error {
+	c.mu.RLock()
+	clientTrack, ok := c.clientTracks[trackID]
+	c.mu.RUnlock()
+
+	if !ok {
+		return ErrTrackNotFound
+	}
+
+	if c.bitrateController.exists(trackID) {
+		c.bitrateController.removeClaim(trackID)
+	}
+
+	if scaleableTrack, ok := clientTrack.(*scaleableClientTrack); ok {
+		scaleableTrack.packetCaches.Reset()
+	}
+
+	c.stats.removeSenderStats(trackID)
+
+	return nil
+}
+
 // SetQuality method is to set the maximum quality of the video that will be sent to the client.
 // This is for bandwidth efficiency purpose and use when the video is rendered in smaller size than the original size.
+//
+// Deprecated: use SetQualityCeiling.
 func (c *Client) SetQuality(quality QualityLevel) {
+	c.SetQualityCeiling(quality)
+}
+
+// SetQualityCeiling cap the quality of all video tracks sent to the client at once, for example when the client app
+// is in a background tab. The tracks above the ceiling are reduced immediately and their bandwidth is given back,
+// the tracks can be increased up to the ceiling on the next bitrate adjustment. QualityNone stop all video tracks.
+func (c *Client) SetQualityCeiling(quality QualityLevel) {
+	quality = capVideoQuality(quality)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.quality.Load() == uint32(quality) {
+	if c.quality.Swap(uint32(quality)) == uint32(quality) {
 		return
 	}
 
-	glog.Infof("client: %s switch quality to %s", c.ID, quality)
-	c.quality.Store(uint32(quality))
+	logger().Info("client: ", c.ID(), " switch quality to ", quality)
+
+	for _, claim := range c.bitrateController.Claims() {
+		if claim.IsAdjustable() && claim.Quality() > quality {
+			c.bitrateController.setQuality(claim.track.ID(), quality)
+		}
+	}
+
+	c.requestQualitySwitch(quality)
+}
+
+// QualityCeiling return the quality cap of all video tracks sent to the client, see SetQualityCeiling
+// The default ceiling is the SFU highest quality, it follows the very high quality when the tier is enabled or disabled.
+func (c *Client) QualityCeiling() QualityLevel {
+	return min(Uint32ToQualityLevel(c.quality.Load()), c.SFU().HighestQuality())
+}
+
+// requestQualitySwitch request keyframes for the tracks so they can switch to the new quality
+func (c *Client) requestQualitySwitch(quality QualityLevel) {
 	for _, claim := range c.bitrateController.Claims() {
-		if claim.track.IsSimulcast() {
-			claim.track.(*simulcastClientTrack).remoteTrack.sendPLI(quality)
+		if remoteTrack, ok := simulcastRemoteTrack(claim.track); ok {
+			remoteTrack.sendPLI(quality)
 		} else if claim.track.IsScaleable() {
 			claim.track.RequestPLI()
 		}
 	}
 }
 
+// RequestKeyFrames request a keyframe for every video track sent to the client, for example after the client UI is reset.
+// The PLI is sent through each track, so it is debounced like the PLI requested by the client. Paused tracks are skipped.
+func (c *Client) RequestKeyFrames() {
+	for _, claim := range c.bitrateController.Claims() {
+		if claim.track.Kind() != webrtc.RTPCodecTypeVideo || claim.track.IsPaused() {
+			continue
+		}
+
+		claim.track.RequestPLI()
+	}
+}
+
+// SetMaxDecodeQuality set the highest video quality that the client device can decode.
+// It is composed with the viewport quality that set by the client, the lower quality is used as the track max quality.
+func (c *Client) SetMaxDecodeQuality(quality QualityLevel) {
+	if quality == QualityNone {
+		quality = QualityVeryHigh
+	}
+
+	quality = capVideoQuality(quality)
+
+	if c.maxDecodeQuality.Swap(uint32(quality)) == uint32(quality) {
+		return
+	}
+
+	if c.bitrateController != nil {
+		c.requestQualitySwitch(quality)
+	}
+}
+
+// SetLayerReductionPreference set which layer of the VP9 scaleable tracks sent to the client is dropped first when
+// the quality is reduced below the high quality. It is applied on the next packets of the tracks.
+func (c *Client) SetLayerReductionPreference(preference LayerReductionPreference) {
+	c.layerReductionPreference.Store(uint32(preference))
+}
+
+// LayerReductionPreference return the layer reduction preference of the client, see SetLayerReductionPreference
+func (c *Client) LayerReductionPreference() LayerReductionPreference {
+	return LayerReductionPreference(c.layerReductionPreference.Load())
+}
+
+// SetTrackMaxQuality set the highest quality of a video track sent to the client, it replace the quality that set
+// from the viewport size. The track is reduced immediately if it is sent above the quality, and can be increased up to
+// the quality on the next bitrate adjustment.
+func (c *Client) SetTrackMaxQuality(trackID string, quality QualityLevel) error {
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackNotFound
+	}
+
+	quality = capVideoQuality(quality)
+
+	claim.track.SetMaxQuality(quality)
+
+	if claim.IsAdjustable() && claim.Quality() > claim.track.MaxQuality() {
+		c.bitrateController.setQuality(trackID, claim.track.MaxQuality())
+	}
+
+	return nil
+}
+
+// SetTrackTargetBitrate pin a video track sent to the client to the highest quality that doesn't exceed the target bitrate,
+// instead of letting the bitrate controller adjust it with the bandwidth. Set 0 to unpin the track.
+func (c *Client) SetTrackTargetBitrate(trackID string, bps uint32) error {
+	return c.bitrateController.SetTargetBitrate(trackID, bps)
+}
+
+// SetTrackForceKeyframeInterval set how long a scaleable track sent to the client wait for the upswitch point
+// before a keyframe is requested, the default is DefaultForceKeyframeInterval. Set zero to disable it.
+func (c *Client) SetTrackForceKeyframeInterval(trackID string, interval time.Duration) error {
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackNotFound
+	}
+
+	track, ok := claim.track.(*scaleableClientTrack)
+	if !ok {
+		return ErrTrackIsNotScaleable
+	}
+
+	track.SetForceKeyframeInterval(interval)
+
+	return nil
+}
+
+// SetTrackThumbnailInterval keep forwarding a keyframe every interval of a VP9 scaleable track sent to the client
+// while the track quality is QualityNone, so the track is shown instantly when its quality is raised again.
+// The thumbnail mode is disabled by default, set zero to disable it again.
+func (c *Client) SetTrackThumbnailInterval(trackID string, interval time.Duration) error {
+	claim := c.bitrateController.GetClaim(trackID)
+	if claim == nil {
+		return ErrTrackNotFound
+	}
+
+	track, ok := claim.track.(*scaleableClientTrack)
+	if !ok {
+		return ErrTrackIsNotScaleable
+	}
+
+	track.SetThumbnailInterval(interval)
+
+	return nil
+}
+
+// SetAudioMuted stop forwarding the audio tracks published by the client to the other clients until it is unmuted,
+// even the client keep sending the audio. Use it to enforce the mute on the server side.
+func (c *Client) SetAudioMuted(muted bool) {
+	c.audioMuted.Store(muted)
+
+	for _, track := range c.tracks.GetTracks() {
+		if audioTrack, ok := track.(*Track); ok && audioTrack.Kind() == webrtc.RTPCodecTypeAudio {
+			audioTrack.muted.Store(muted)
+		}
+	}
+}
+
+// IsAudioMuted return true if the audio tracks published by the client are muted with SetAudioMuted
+func (c *Client) IsAudioMuted() bool {
+	return c.audioMuted.Load()
+}
+
+// MaxDecodeQuality return the highest video quality that the client device can decode
+func (c *Client) MaxDecodeQuality() QualityLevel {
+	return Uint32ToQualityLevel(c.maxDecodeQuality.Load())
+}
+
+// BandwidthSnapshot returns the estimated bandwidth and the bitrate claimed by each track sent to the client.
+// Poll this to monitor how the bandwidth is allocated between the tracks.
+func (c *Client) BandwidthSnapshot() BandwidthSnapshot {
+	return c.bitrateController.Snapshot()
+}
+
+// SetBitrateStrategy replace the strategy that decide when the bitrate of the tracks sent to the client is adjusted.
+// Set nil to use the default strategy, which is bandwidth estimation based if the bandwidth estimator is enabled,
+// or packet loss based if not.
+func (c *Client) SetBitrateStrategy(strategy BitrateStrategy) {
+	c.bitrateController.SetStrategy(strategy)
+}
+
+// EnableBitrateDecisionLog keep the latest size bitrate adjustment decisions of the tracks sent to the client in memory,
+// use BitrateDecisionLog to dump them for a postmortem. Set 0 to disable the log, it is disabled by default.
+func (c *Client) EnableBitrateDecisionLog(size int) {
+	c.bitrateController.EnableDecisionLog(size)
+}
+
+// BitrateDecisionLog return the logged bitrate decisions from the oldest to the latest
+func (c *Client) BitrateDecisionLog() []Decision {
+	return c.bitrateController.DecisionLog()
+}
+
+// SetLossThresholds set the fraction lost thresholds of the packet loss based strategy, for example a wireless network
+// could tolerate more loss before the bitrate is decreased. The defaults are DefaultLossIncreaseThreshold and DefaultLossDecreaseThreshold.
+func (c *Client) SetLossThresholds(increase, decrease float64) error {
+	return c.bitrateController.SetLossThresholds(increase, decrease)
+}
+
+// SetLossDecreaseReports set how many consecutive reports the packet loss must be above the decrease threshold before
+// the bitrate is decreased, the default is DefaultLossDecreaseReports.
+func (c *Client) SetLossDecreaseReports(reports int) error {
+	return c.bitrateController.SetLossDecreaseReports(reports)
+}
+
 // GetEstimatedBandwidth returns the estimated bandwidth in bits per second based on
-// Google Congestion Controller estimation. If the congestion controller is not enabled,
-// it will return the initial bandwidth. If the receiving bandwidth is not 0, it will return the smallest value between
+// Google Congestion Controller estimation, or the REMB feedback if the receiver doesn't support transport-cc.
+// If the congestion controller is not enabled, it will return the initial bandwidth. If the receiving bandwidth is not 0, it will return the smallest value between
 // the estimated bandwidth and the receiving bandwidth.
 func (c *Client) GetEstimatedBandwidth() uint32 {
 	c.mu.Lock()
@@ -1214,8 +1689,10 @@ func (c *Client) GetEstimatedBandwidth() uint32 {
 
 	estimated := uint32(0)
 
-	if c.estimator == nil {
-		estimated = uint32(c.sfu.bitrateConfigs.InitialBandwidth)
+	if rembBandwidth := c.rembBandwidth.Load(); rembBandwidth != 0 {
+		estimated = rembBandwidth
+	} else if c.estimator == nil {
+		estimated = uint32(c.sfu.BitrateConfigs().InitialBandwidth)
 	} else {
 		estimated = uint32(c.estimator.GetTargetBitrate())
 		c.egressBandwidth.Store(estimated)
@@ -1230,6 +1707,11 @@ func (c *Client) GetEstimatedBandwidth() uint32 {
 	return estimated
 }
 
+// isREMBActive return true if the estimated bandwidth is driven by the REMB feedback from the receiver
+func (c *Client) isREMBActive() bool {
+	return c.rembBandwidth.Load() != 0
+}
+
 // This should get from the publisher client using RTCIceCandidatePairStats.availableOutgoingBitrate
 // from client stats. It should be done through DataChannel so it won't required additional implementation on API endpoints
 // where this SFU is used.
@@ -1251,9 +1733,13 @@ func (c *Client) createDataChannel(label string, initOpts *webrtc.DataChannelIni
 		return err
 	}
 
-	glog.Info("client: data channel created ", label, " ", c.ID())
-	c.sfu.setupMessageForwarder(c.ID(), newDc)
+	logger().Info("client: data channel created ", label, " ", c.ID())
+	c.sfu.setupMessageForwarder(c, newDc)
 	c.dataChannels.Add(newDc)
+	newDc.OnClose(func() {
+		c.dataChannels.Remove(newDc)
+	})
+	c.sfu.replayDataChannelHistory(c.dataChannels, newDc)
 
 	return nil
 }
@@ -1280,7 +1766,7 @@ func (c *Client) onInternalMessage(msg webrtc.DataChannelMessage) {
 	var internalMessage internalDataMessage
 
 	if err := json.Unmarshal(msg.Data, &internalMessage); err != nil {
-		glog.Error("client: error unmarshal internal message ", err)
+		logger().Error("client: error unmarshal internal message ", err)
 		return
 	}
 
@@ -1288,7 +1774,7 @@ func (c *Client) onInternalMessage(msg webrtc.DataChannelMessage) {
 	case messageTypeStats:
 		internalStats := internalDataStats{}
 		if err := json.Unmarshal(msg.Data, &internalStats); err != nil {
-			glog.Error("client: error unmarshal messageTypeStats ", err)
+			logger().Error("client: error unmarshal messageTypeStats ", err)
 			return
 		}
 
@@ -1296,14 +1782,125 @@ func (c *Client) onInternalMessage(msg webrtc.DataChannelMessage) {
 	case messageTypeVideoSize:
 		internalData := internalDataVideoSize{}
 		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
-			glog.Error("client: error unmarshal messageTypeStats ", err)
+			logger().Error("client: error unmarshal messageTypeStats ", err)
 			return
 		}
 
 		c.bitrateController.onRemoteViewedSizeChanged(internalData.Data)
+	case messageTypeMaxDecodeQuality:
+		internalData := internalDataMaxDecodeQuality{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			logger().Error("client: error unmarshal messageTypeMaxDecodeQuality ", err)
+			return
+		}
+
+		c.SetMaxDecodeQuality(internalData.Data)
+	case messageTypeData:
+		// the typed message is relayed like the other data channel messages, so it is limited the same way
+		if !c.dataChannelLimiter.allow(len(msg.Data), time.Now()) {
+			c.rateLimitedDrops.add(InternalDataChannelLabel, len(msg.Data))
+			return
+		}
+
+		internalData := internalDataData{}
+		if err := json.Unmarshal(msg.Data, &internalData); err != nil {
+			logger().Error("client: error unmarshal messageTypeData ", err)
+			return
+		}
+
+		if err := c.SendData(internalData.Data.ToID, internalData.Data.Data); err != nil {
+			logger().Error("client: error send data to ", internalData.Data.ToID, " ", err)
+		}
+	}
+}
+
+// SendData send the typed message from the client to the client with the toID, or to all other clients if toID is empty.
+// The payload is wrapped in Data with the client as the sender, it is received by the OnData callbacks of the target
+// clients and sent to the target peers over the internal data channel as {"type":"data","data":Data}.
+// Use the data channels created with SFU.CreateDataChannel to pass through the raw messages instead.
+func (c *Client) SendData(toID string, payload interface{}) error {
+	return c.sfu.sendData(Data{
+		FromID: c.ID(),
+		ToID:   toID,
+		SentAt: time.Now(),
+		Data:   payload,
+	})
+}
+
+// OnDataChannelRateLimited event is called when the messages sent by the client on a data channel are dropped because
+// the client is over the DataChannelRateLimit of the room. The drops are aggregated, the event is called at most once
+// per second for each data channel with the number of the dropped messages and their total size in bytes.
+func (c *Client) OnDataChannelRateLimited(callback func(label string, messages int, size int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onDataChannelRateLimitedCallbacks = append(c.onDataChannelRateLimitedCallbacks, callback)
+}
+
+func (c *Client) onDataChannelRateLimited(label string, messages int, size int) {
+	c.mu.RLock()
+	callbacks := c.onDataChannelRateLimitedCallbacks
+	c.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(label, messages, size)
 	}
 }
 
+// OnData event is called when the client receive a typed message sent with SendData from the other client.
+func (c *Client) OnData(callback func(Data)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onDataCallbacks = append(c.onDataCallbacks, callback)
+}
+
+// receiveData call the OnData callbacks and forward the message to the peer over the internal data channel
+func (c *Client) receiveData(data Data) error {
+	c.mu.RLock()
+	callbacks := c.onDataCallbacks
+	c.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		go callback(data)
+	}
+
+	encoded, err := json.Marshal(internalDataData{
+		Type: messageTypeData,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	internalDataChannel := c.getInternalDataChannel()
+	if internalDataChannel == nil {
+		return nil
+	}
+
+	if internalDataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		c.dataChannels.sendWhenOpen(internalDataChannel, encoded, DefaultDataChannelMessageTTL)
+		return nil
+	}
+
+	return internalDataChannel.SendText(string(encoded))
+}
+
+// getInternalDataChannel return the internal data channel, nil if it is not created yet
+func (c *Client) getInternalDataChannel() *webrtc.DataChannel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.internalDataChannel
+}
+
+func (c *Client) setInternalDataChannel(dc *webrtc.DataChannel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.internalDataChannel = dc
+}
+
 func (c *Client) onStatsMessage(stats remoteClientStats) {
 	c.ingressQualityLimitationReason.Store(stats.QualityLimitationReason)
 
@@ -1443,11 +2040,12 @@ func (c *Client) IsVADEnabled() bool {
 
 func (c *Client) enableSendVADToInternalDataChannel() {
 	c.OnVoiceDetected(func(activity voiceactivedetector.VoiceActivity) {
-		if c.internalDataChannel == nil {
+		internalDataChannel := c.getInternalDataChannel()
+		if internalDataChannel == nil {
 			return
 		}
 
-		if c.internalDataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		if internalDataChannel.ReadyState() != webrtc.DataChannelStateOpen {
 			return
 		}
 
@@ -1465,12 +2063,12 @@ func (c *Client) enableSendVADToInternalDataChannel() {
 
 		data, err := json.Marshal(dataMessage)
 		if err != nil {
-			glog.Error("client: error marshal vad data ", err)
+			logger().Error("client: error marshal vad data ", err)
 			return
 		}
 
-		if err := c.internalDataChannel.SendText(string(data)); err != nil {
-			glog.Error("client: error send vad data ", err)
+		if err := internalDataChannel.SendText(string(data)); err != nil {
+			logger().Error("client: error send vad data ", err)
 			return
 		}
 	})
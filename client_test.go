@@ -2,11 +2,18 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 )
@@ -248,7 +255,8 @@ func TestClientDataChannel(t *testing.T) {
 
 	pc1, _, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer1")
 
-	dcChan := make(chan *webrtc.DataChannel)
+	// the control data channel is created after the internal data channel
+	dcChan := make(chan *webrtc.DataChannel, 2)
 	pc1.OnDataChannel(func(c *webrtc.DataChannel) {
 		dcChan <- c
 	})
@@ -264,3 +272,545 @@ func TestClientDataChannel(t *testing.T) {
 		require.Equal(t, "internal", dc.Label())
 	}
 }
+
+func TestClientControlDataChannel(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	roomName := "test-room"
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = []string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomID, roomName, RoomTypeLocal, roomOpts)
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	require.ErrorIs(t, testRoom.CreateDataChannel(ControlDataChannelLabel, DefaultDataChannelOptions()), ErrDataChannelReserved)
+
+	pc1, client, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer1")
+
+	track := newTestClientTrack(t, client, "video", webrtc.RTPCodecTypeVideo, true)
+	claim, err := client.bitrateController.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	dcChan := make(chan *webrtc.DataChannel, 2)
+	pc1.OnDataChannel(func(c *webrtc.DataChannel) {
+		if c.Label() == ControlDataChannelLabel {
+			c.OnOpen(func() {
+				dcChan <- c
+			})
+		}
+	})
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	var dc *webrtc.DataChannel
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for control data channel")
+	case dc = <-dcChan:
+	}
+
+	// the malformed commands are ignored
+	require.NoError(t, dc.SendText(`{"setQuality":`))
+	require.NoError(t, dc.SendText(`{"setQuality":{"trackID":"video","quality":"ultra"}}`))
+	require.NoError(t, dc.SendText(`{"setQuality":{"trackID":"unknown","quality":"low"}}`))
+
+	require.NoError(t, dc.SendText(`{"setQuality":{"trackID":"video","quality":"mid"}}`))
+
+	require.Eventually(t, func() bool {
+		return claim.Quality() == QualityMid
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, QualityLevel(QualityMid), track.MaxQuality())
+}
+
+func TestClientTypedData(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	testRoom, err := roomManager.NewRoom(roomID, "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	internalChannel := func(pc *webrtc.PeerConnection) (chan *webrtc.DataChannel, chan []byte) {
+		dcChan := make(chan *webrtc.DataChannel, 1)
+		msgChan := make(chan []byte, 1)
+
+		pc.OnDataChannel(func(c *webrtc.DataChannel) {
+			if c.Label() != InternalDataChannelLabel {
+				return
+			}
+
+			c.OnOpen(func() {
+				dcChan <- c
+			})
+
+			c.OnMessage(func(msg webrtc.DataChannelMessage) {
+				// skip the other internal messages like the voice activity
+				if strings.Contains(string(msg.Data), `"type":"data"`) {
+					msgChan <- msg.Data
+				}
+			})
+		})
+
+		return dcChan, msgChan
+	}
+
+	pc1, client1, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer1")
+	dcChan1, _ := internalChannel(pc1)
+
+	pc2, client2, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer2")
+	_, msgChan2 := internalChannel(pc2)
+
+	received := make(chan Data, 1)
+	client2.OnData(func(data Data) {
+		received <- data
+	})
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	var dc *webrtc.DataChannel
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for internal data channel")
+	case dc = <-dcChan1:
+	}
+
+	// the peer send a typed message to the other client through the SFU
+	require.NoError(t, dc.SendText(`{"type":"data","data":{"to_id":"`+client2.ID()+`","data":{"text":"hello"}}}`))
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for typed data")
+	case data := <-received:
+		require.Equal(t, client1.ID(), data.FromID)
+		require.Equal(t, client2.ID(), data.ToID)
+		require.False(t, data.SentAt.IsZero())
+		require.Equal(t, map[string]interface{}{"text": "hello"}, data.Data)
+	}
+
+	select {
+	case <-timeout.Done():
+		t.Fatal("timeout waiting for typed data on the peer")
+	case encoded := <-msgChan2:
+		message := internalDataData{}
+		require.NoError(t, json.Unmarshal(encoded, &message))
+		require.Equal(t, client1.ID(), message.Data.FromID)
+		require.Equal(t, map[string]interface{}{"text": "hello"}, message.Data.Data)
+	}
+
+	require.ErrorIs(t, client1.SendData("unknown", "hello"), ErrClientNotFound)
+}
+
+func TestClientSendDataRateLimit(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.dataChannelLimiter = newDataChannelRateLimiter(DataChannelRateLimit{MessagesPerSecond: 2})
+
+	drops := make(chan droppedMessages, 1)
+	client.rateLimitedDrops = newDropReporter(10*time.Millisecond, func(label string, dropped droppedMessages) {
+		require.Equal(t, InternalDataChannelLabel, label)
+		drops <- dropped
+	})
+
+	// the typed messages on the internal data channel are limited like the other data channel messages
+	message := []byte(`{"type":"data","data":{"data":"hello"}}`)
+	for i := 0; i < 5; i++ {
+		client.onInternalMessage(webrtc.DataChannelMessage{IsString: true, Data: message})
+	}
+
+	select {
+	case dropped := <-drops:
+		require.Equal(t, 3, dropped.messages)
+		require.Equal(t, 3*len(message), dropped.bytes)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the rate limited typed messages")
+	}
+}
+
+func TestClientConnectionFailed(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	testRoom, err := roomManager.NewRoom(roomID, "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err)
+
+	client, err := testRoom.AddClient("client", "client", DefaultClientOptions())
+	require.NoError(t, err)
+
+	// skip the join process, the client is already connected
+	client.state.Store(ClientStateActive)
+
+	states := make(chan webrtc.PeerConnectionState, 2)
+	client.OnConnectionStateChanged(func(state webrtc.PeerConnectionState) {
+		states <- state
+	})
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	client.mu.Lock()
+	client.clientTracks[track.ID()] = track
+	client.mu.Unlock()
+
+	require.NoError(t, client.bitrateController.addClaims([]iClientTrack{track}))
+
+	for i := uint16(1); i <= 5; i++ {
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: uint32(i) * 3000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+	}
+
+	require.Equal(t, 5, track.packetCaches.Len())
+
+	// count the stopped watchers of the released claims
+	stoppedWatchers := &atomic.Int32{}
+	claim := client.bitrateController.GetClaim(track.ID())
+	require.NotNil(t, claim)
+	stopWatch := claim.stopWatch
+	claim.stopWatch = func() {
+		stoppedWatchers.Add(1)
+		stopWatch()
+	}
+
+	client.onConnectionStateChanged(webrtc.PeerConnectionStateFailed)
+	require.Equal(t, webrtc.PeerConnectionStateFailed, <-states)
+
+	require.Eventually(t, func() bool {
+		return len(client.bitrateController.Claims()) == 0 && track.packetCaches.Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the claims are added back when the connection is recovered
+	client.onConnectionStateChanged(webrtc.PeerConnectionStateConnected)
+	require.Equal(t, webrtc.PeerConnectionStateConnected, <-states)
+
+	require.Eventually(t, func() bool {
+		return client.bitrateController.exists(track.ID())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the watcher of the released claim is stopped, only the restored claim watch the track
+	require.Equal(t, int32(1), stoppedWatchers.Load())
+	require.NotSame(t, claim, client.bitrateController.GetClaim(track.ID()))
+
+	// the restored claim is removed once the track is ended
+	track.cancel()
+
+	require.Eventually(t, func() bool {
+		return !client.bitrateController.exists(track.ID())
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestUnsubscribeTrack(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	pliCount := &atomic.Int32{}
+	rt := &remoteTrack{
+		onPLI: func() {
+			pliCount.Add(1)
+		},
+	}
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, rt)
+	client.clientTracks = map[string]iClientTrack{track.ID(): track}
+
+	require.NoError(t, client.bitrateController.addClaims([]iClientTrack{track}))
+
+	sequence := uint16(0)
+	pushPackets := func(count int) {
+		for i := 0; i < count; i++ {
+			sequence++
+			track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+		}
+	}
+
+	pushPackets(5)
+	require.Equal(t, 5, track.packetCaches.caches.Len())
+
+	client.stats.SetSender(track.ID(), stats.Stats{})
+
+	require.NoError(t, client.UnsubscribeTrack(track.ID()))
+	require.False(t, client.bitrateController.exists(track.ID()))
+	require.Equal(t, 0, track.packetCaches.caches.Len())
+
+	_, err := client.stats.GetSender(track.ID())
+	require.Error(t, err)
+
+	// no packet is forwarded after unsubscribe
+	pushPackets(10)
+	require.Equal(t, 0, track.packetCaches.caches.Len())
+
+	// re-subscribe claim the bitrate again and request a fresh keyframe
+	pliBefore := pliCount.Load()
+	require.NoError(t, client.SubscribeTrack(track.ID()))
+	require.True(t, client.bitrateController.exists(track.ID()))
+	require.Equal(t, pliBefore+1, pliCount.Load())
+
+	pushPackets(5)
+	require.Equal(t, 5, track.packetCaches.caches.Len())
+
+	require.ErrorIs(t, client.UnsubscribeTrack("unknown"), ErrTrackNotFound)
+	require.ErrorIs(t, client.SubscribeTrack("unknown"), ErrTrackNotFound)
+}
+
+// testBandwidthEstimator is a cc.BandwidthEstimator that let the test drive the target bitrate
+type testBandwidthEstimator struct {
+	bitrate  int
+	onChange func(bitrate int)
+}
+
+func (e *testBandwidthEstimator) AddStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return writer
+}
+
+func (e *testBandwidthEstimator) WriteRTCP(_ []rtcp.Packet, _ interceptor.Attributes) error {
+	return nil
+}
+
+func (e *testBandwidthEstimator) GetTargetBitrate() int {
+	return e.bitrate
+}
+
+func (e *testBandwidthEstimator) OnTargetBitrateChange(f func(bitrate int)) {
+	e.onChange = f
+}
+
+func (e *testBandwidthEstimator) GetStats() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (e *testBandwidthEstimator) Close() error {
+	return nil
+}
+
+func (e *testBandwidthEstimator) setBitrate(bitrate int) {
+	e.bitrate = bitrate
+	e.onChange(bitrate)
+}
+
+func TestOnEstimatedBandwidthChange(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	estimator := &testBandwidthEstimator{}
+	client.bitrateController.MonitorBandwidth(estimator)
+
+	bitrates := []int{800_000, 300_000, 500_000, 200_000, 900_000}
+
+	estimatedChan := make(chan uint32, len(bitrates))
+	client.OnEstimatedBandwidthChange(func(bps uint32) {
+		estimatedChan <- bps
+	})
+
+	for _, bitrate := range bitrates {
+		estimator.setBitrate(bitrate)
+	}
+
+	// the updates are received in order
+	for _, bitrate := range bitrates {
+		select {
+		case bps := <-estimatedChan:
+			require.Equal(t, uint32(bitrate), bps)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for estimated bandwidth change")
+		}
+	}
+}
+
+func TestRequestKeyFrames(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	bc := client.bitrateController
+
+	camera := newTestClientTrack(t, client, "camera", webrtc.RTPCodecTypeVideo, true)
+	screen := newTestClientTrack(t, client, "screen", webrtc.RTPCodecTypeVideo, true)
+	audio := newTestClientTrack(t, client, "audio", webrtc.RTPCodecTypeAudio, false)
+
+	_, err := bc.addClaim(camera, QualityHigh, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(screen, QualityMid, true)
+	require.NoError(t, err)
+	_, err = bc.addClaim(audio, QualityAudio, true)
+	require.NoError(t, err)
+
+	client.RequestKeyFrames()
+
+	require.Equal(t, int32(1), camera.pliCount.Load())
+	require.Equal(t, int32(1), screen.pliCount.Load())
+	require.Equal(t, int32(0), audio.pliCount.Load())
+}
+
+func TestForwardedTrackSSRC(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	testRoom, err := roomManager.NewRoom(roomID, "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	publisherPC, _, _, publisherConnected := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "publisher", true)
+	_, subscriber, trackChan, _ := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "subscriber", true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 60*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case state := <-publisherConnected:
+		require.True(t, state, "publisher not connected")
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for connection")
+		return
+	}
+
+	var track *webrtc.TrackRemote
+	select {
+	case track = <-trackChan:
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for track")
+		return
+	}
+
+	publisherSSRC := publisherPC.GetSenders()[0].GetParameters().Encodings[0].SSRC
+
+	// the SFU forward the track with the SSRC of its own sender, the same SSRC is used on the sender reports
+	var senderSSRC webrtc.SSRC
+	for _, sender := range subscriber.peerConnection.PC().GetSenders() {
+		if sender.Track() != nil && sender.Track().ID() == track.ID() {
+			senderSSRC = sender.GetParameters().Encodings[0].SSRC
+		}
+	}
+
+	require.Equal(t, senderSSRC, track.SSRC())
+	require.NotEqual(t, publisherSSRC, track.SSRC())
+
+	for i := 0; i < 10; i++ {
+		p, _, err := track.ReadRTP()
+		require.NoError(t, err)
+		require.Equal(t, uint32(track.SSRC()), p.SSRC)
+	}
+
+	// the receiver reports of the subscriber use the sender SSRC, they are terminated at the SFU and matched to the
+	// forwarded track. The publisher receives the reports of the SFU receiver with its own SSRC, nothing is translated.
+	require.Eventually(t, func() bool {
+		senderStats, err := subscriber.Stats().GetSender(track.ID())
+		return err == nil && senderStats.RemoteInboundRTPStreamStats.PacketsReceived > 0
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestForwardedTrackSenderReport(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	testRoom, err := roomManager.NewRoom(roomID, "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	_, _, _, publisherConnected := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "publisher", true)
+	subscriberPC, _, trackChan, _ := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "subscriber", true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 60*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case state := <-publisherConnected:
+		require.True(t, state, "publisher not connected")
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for connection")
+		return
+	}
+
+	var track *webrtc.TrackRemote
+	select {
+	case track = <-trackChan:
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for track")
+		return
+	}
+
+	var receiver *webrtc.RTPReceiver
+	for _, r := range subscriberPC.GetReceivers() {
+		if r.Track() != nil && r.Track().ID() == track.ID() {
+			receiver = r
+		}
+	}
+
+	require.NotNil(t, receiver)
+	require.NoError(t, receiver.SetReadDeadline(time.Now().Add(30*time.Second)))
+
+	receivedPackets := &atomic.Uint32{}
+	lastTimestamp := &atomic.Uint32{}
+
+	go func() {
+		for {
+			p, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+
+			lastTimestamp.Store(p.Timestamp)
+			receivedPackets.Add(1)
+		}
+	}()
+
+	// the sender reports are sent by the SFU sender with the packets it forwarded to the subscriber
+	var sr *rtcp.SenderReport
+	for sr == nil {
+		packets, _, err := receiver.ReadRTCP()
+		require.NoError(t, err, "error waiting for sender report")
+
+		for _, packet := range packets {
+			if report, ok := packet.(*rtcp.SenderReport); ok && report.SSRC == uint32(track.SSRC()) && report.PacketCount > 0 {
+				sr = report
+			}
+		}
+	}
+
+	require.Greater(t, sr.OctetCount, uint32(0))
+
+	require.Eventually(t, func() bool {
+		return receivedPackets.Load() >= sr.PacketCount
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the NTP time is the wall clock and the RTP time is mapped from the forwarded timestamps with the clock rate
+	ntpSeconds := int64(sr.NTPTime>>32) - 2208988800
+	require.InDelta(t, time.Now().Unix(), ntpSeconds, 5)
+	require.InDelta(t, 0, int32(lastTimestamp.Load()-sr.RTPTime), float64(5*track.Codec().ClockRate))
+}
+
+func TestClientAudioMuted(t *testing.T) {
+	t.Parallel()
+
+	publisher := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	publisher.tracks = newTrackList()
+	subscriber := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	relay := NewTrackRelay("audio", "stream", "", webrtc.RTPCodecTypeAudio, 1, webrtc.MimeTypeOpus, make(chan *rtp.Packet))
+	track := newTrack(publisher.context, publisher.ID(), relay, nil, 0, 0, func() {}, nackOptions{}, nil, nil).(*Track)
+	require.NoError(t, publisher.tracks.Add(track))
+
+	subscribed := newTestClientTrack(t, subscriber, "audio", webrtc.RTPCodecTypeAudio, false)
+	track.base.clientTracks.Add(subscribed)
+
+	sequence := uint16(0)
+	read := func() {
+		sequence++
+		track.remoteTrack.onRead(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 960}, Payload: []byte{0x01}})
+	}
+
+	read()
+	require.Equal(t, int32(1), subscribed.pushCount.Load())
+
+	// the publisher keep sending while muted
+	publisher.SetAudioMuted(true)
+	require.True(t, publisher.IsAudioMuted())
+
+	read()
+	read()
+	require.Equal(t, int32(1), subscribed.pushCount.Load())
+
+	publisher.SetAudioMuted(false)
+	read()
+	require.Equal(t, int32(2), subscribed.pushCount.Load())
+}
@@ -3,8 +3,9 @@ package sfu
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -23,9 +24,28 @@ type iClientTrack interface {
 	RequestPLI()
 	SetMaxQuality(quality QualityLevel)
 	MaxQuality() QualityLevel
+	SetMinQuality(quality QualityLevel)
+	MinQuality() QualityLevel
+	OnQualityChange(callback func(old, new QualityLevel))
+	// SetLastQuality store the quality delivered to the client, the tracks with a single quality ignore it
+	SetLastQuality(quality QualityLevel)
+	SetPriority(weight int)
+	Priority() int
+	// Pause stop forwarding the track without removing the subscription, the bandwidth of the track
+	// is given to the other tracks until the track is resumed
+	Pause()
+	// Resume continue forwarding the paused track and request a keyframe
+	Resume()
+	IsPaused() bool
+	// AvailableQualities return the qualities that the publisher currently offer for the track from the lowest,
+	// use it to build the quality menu of the client. Empty if the layers are not known yet.
+	AvailableQualities() []QualityLevel
+	// lastPacketTime is the time the last packet from the publisher reached the track, zero if no packet yet
+	lastPacketTime() time.Time
 }
 
 type clientTrack struct {
+	packetTime
 	id          string
 	context     context.Context
 	cancel      context.CancelFunc
@@ -36,6 +56,26 @@ type clientTrack struct {
 	localTrack  *webrtc.TrackLocalStaticRTP
 	remoteTrack *remoteTrack
 	isScreen    bool
+	paused      atomic.Bool
+}
+
+// packetTime keep the time the last packet from the publisher reached the client track, it is embedded by the client
+// tracks to implement lastPacketTime
+type packetTime struct {
+	ts atomic.Int64
+}
+
+func (p *packetTime) markPacket(now time.Time) {
+	p.ts.Store(now.UnixNano())
+}
+
+func (p *packetTime) lastPacketTime() time.Time {
+	ts := p.ts.Load()
+	if ts == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ts)
 }
 
 func newClientTrack(c *Client, t *Track, isScreen bool) *clientTrack {
@@ -73,19 +113,52 @@ func (t *clientTrack) Kind() webrtc.RTPCodecType {
 }
 
 func (t *clientTrack) push(rtp rtp.Packet, _ QualityLevel) {
+	t.markPacket(t.client.bitrateController.now())
+
 	if t.client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
 		return
 	}
 
+	if !t.client.bitrateController.exists(t.ID()) {
+		// do nothing if the track is unsubscribed
+		return
+	}
+
+	if t.paused.Load() {
+		return
+	}
+
 	if t.Kind() == webrtc.RTPCodecTypeAudio {
 		// do something here with audio level
 	}
 
 	if err := t.localTrack.WriteRTP(&rtp); err != nil {
-		glog.Error("clienttrack: error on write rtp", err)
+		logger().Error("clienttrack: error on write rtp", err)
+	}
+}
+
+func (t *clientTrack) Pause() {
+	t.paused.Store(true)
+	t.client.bitrateController.setPaused(t.ID(), true)
+}
+
+func (t *clientTrack) Resume() {
+	if !t.paused.Swap(false) {
+		return
+	}
+
+	t.client.bitrateController.setPaused(t.ID(), false)
+
+	// the client need a keyframe to decode the track after the gap
+	if t.Kind() == webrtc.RTPCodecTypeVideo {
+		t.RequestPLI()
 	}
 }
 
+func (t *clientTrack) IsPaused() bool {
+	return t.paused.Load()
+}
+
 func (t *clientTrack) LocalTrack() *webrtc.TrackLocalStaticRTP {
 	return t.localTrack
 }
@@ -113,6 +186,14 @@ func (t *clientTrack) RequestPLI() {
 	t.remoteTrack.sendPLI()
 }
 
+func (t *clientTrack) AvailableQualities() []QualityLevel {
+	if t.Kind() == webrtc.RTPCodecTypeAudio {
+		return []QualityLevel{QualityAudio}
+	}
+
+	return []QualityLevel{QualityHigh}
+}
+
 func (t *clientTrack) SetMaxQuality(_ QualityLevel) {
 	// do nothing
 }
@@ -120,3 +201,37 @@ func (t *clientTrack) SetMaxQuality(_ QualityLevel) {
 func (t *clientTrack) MaxQuality() QualityLevel {
 	return QualityHigh
 }
+
+func (t *clientTrack) SetMinQuality(_ QualityLevel) {
+	// do nothing
+}
+
+func (t *clientTrack) SetPriority(_ int) {
+	// do nothing, the track bitrate is not adjustable
+}
+
+func (t *clientTrack) Priority() int {
+	return DefaultPriority
+}
+
+func (t *clientTrack) OnQualityChange(_ func(old, new QualityLevel)) {
+	// do nothing, the track only has one quality
+}
+
+func (t *clientTrack) SetLastQuality(_ QualityLevel) {
+	// do nothing, the track only has one quality
+}
+
+func (t *clientTrack) MinQuality() QualityLevel {
+	return QualityNone
+}
+
+// maxQualityNeedKeyframe return true if raising the max quality of a track let it forward a higher quality than the
+// last forwarded quality. Lowering the max quality never need a keyframe because the lower layers are already decodable.
+func maxQualityNeedKeyframe(client *Client, previous, next, lastQuality QualityLevel) bool {
+	if client.options.DisableMaxQualityPLI {
+		return false
+	}
+
+	return next > previous && next > lastQuality
+}
@@ -5,8 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"sync"
+	"sync/atomic"
 
-	"github.com/golang/glog"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -17,6 +17,7 @@ var (
 )
 
 type clientTrackRed struct {
+	packetTime
 	id           string
 	context      context.Context
 	cancel       context.CancelFunc
@@ -27,6 +28,9 @@ type clientTrackRed struct {
 	localTrack   *webrtc.TrackLocalStaticRTP
 	remoteTrack  *remoteTrack
 	isReceiveRed bool
+	// forward only the primary encoding in the RED packet while the receiver doesn't lose packets
+	noRedundancy atomic.Bool
+	paused       atomic.Bool
 }
 
 func newClientTrackRed(c *Client, t *Track) *clientTrackRed {
@@ -72,19 +76,49 @@ func (t *clientTrackRed) Kind() webrtc.RTPCodecType {
 }
 
 func (t *clientTrackRed) push(rtp rtp.Packet, _ QualityLevel) {
+	t.markPacket(t.client.bitrateController.now())
+
 	if t.client.peerConnection.PC().ConnectionState() != webrtc.PeerConnectionStateConnected {
 		return
 	}
 
+	if !t.client.bitrateController.exists(t.ID()) {
+		// do nothing if the track is unsubscribed
+		return
+	}
+
+	if t.paused.Load() {
+		return
+	}
+
 	if !t.isReceiveRed {
 		rtp = t.getPrimaryEncoding(rtp)
+	} else if t.noRedundancy.Load() {
+		rtp = t.getPrimaryEncodingOnlyRED(rtp)
 	}
 
 	if err := t.localTrack.WriteRTP(&rtp); err != nil {
-		glog.Error("clienttrack: error on write rtp", err)
+		logger().Error("clienttrack: error on write rtp", err)
 	}
 }
 
+func (t *clientTrackRed) Pause() {
+	t.paused.Store(true)
+	t.client.bitrateController.setPaused(t.ID(), true)
+}
+
+func (t *clientTrackRed) Resume() {
+	if !t.paused.Swap(false) {
+		return
+	}
+
+	t.client.bitrateController.setPaused(t.ID(), false)
+}
+
+func (t *clientTrackRed) IsPaused() bool {
+	return t.paused.Load()
+}
+
 func (t *clientTrackRed) LocalTrack() *webrtc.TrackLocalStaticRTP {
 	return t.localTrack
 }
@@ -109,6 +143,14 @@ func (t *clientTrackRed) RequestPLI() {
 	t.remoteTrack.sendPLI()
 }
 
+func (t *clientTrackRed) AvailableQualities() []QualityLevel {
+	if t.isReceiveRed {
+		return []QualityLevel{QualityAudioRed}
+	}
+
+	return []QualityLevel{QualityAudio}
+}
+
 func (t *clientTrackRed) SetMaxQuality(_ QualityLevel) {
 	// do nothing
 }
@@ -117,10 +159,50 @@ func (t *clientTrackRed) MaxQuality() QualityLevel {
 	return QualityHigh
 }
 
+func (t *clientTrackRed) SetMinQuality(_ QualityLevel) {
+	// do nothing
+}
+
+func (t *clientTrackRed) SetPriority(_ int) {
+	// do nothing, the track bitrate is not adjustable
+}
+
+func (t *clientTrackRed) Priority() int {
+	return DefaultPriority
+}
+
+func (t *clientTrackRed) OnQualityChange(_ func(old, new QualityLevel)) {
+	// do nothing, the track only has one quality
+}
+
+func (t *clientTrackRed) SetLastQuality(_ QualityLevel) {
+	// do nothing, the track only has one quality
+}
+
+func (t *clientTrackRed) MinQuality() QualityLevel {
+	return QualityNone
+}
+
+// setRedundancy switch between forwarding the redundant encodings and only the primary encoding in the RED packets
+func (t *clientTrackRed) setRedundancy(enabled bool) {
+	t.noRedundancy.Store(!enabled)
+}
+
+func (t *clientTrackRed) getPrimaryEncodingOnlyRED(rtp rtp.Packet) rtp.Packet {
+	payload, err := removeRedundantEncodingsForRED(rtp.Payload)
+	if err != nil {
+		logger().Error("clienttrack: error on remove redundant encodings for red", err)
+		return rtp
+	}
+
+	rtp.Payload = payload
+	return rtp
+}
+
 func (t *clientTrackRed) getPrimaryEncoding(rtp rtp.Packet) rtp.Packet {
 	payload, err := extractPrimaryEncodingForRED(rtp.Payload)
 	if err != nil {
-		glog.Error("clienttrack: error on extract primary encoding for red", err)
+		logger().Error("clienttrack: error on extract primary encoding for red", err)
 		return rtp
 	}
 
@@ -173,3 +255,28 @@ func extractPrimaryEncodingForRED(payload []byte) ([]byte, error) {
 
 	return payload[blockLength:], nil
 }
+
+// removeRedundantEncodingsForRED return a RED payload that only has the primary encoding block,
+// so the receiver that negotiated RED can still decode the packet without the redundancy overhead
+func removeRedundantEncodingsForRED(payload []byte) ([]byte, error) {
+	primary, err := extractPrimaryEncodingForRED(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// the primary block header is the last header, one byte right before the redundant blocks data
+	headerLength := len(payload) - len(primary)
+	for i := 0; i < headerLength; {
+		if payload[i]&0x80 == 0 {
+			redPayload := make([]byte, 0, len(primary)+1)
+			redPayload = append(redPayload, payload[i])
+			redPayload = append(redPayload, primary...)
+
+			return redPayload, nil
+		}
+
+		i += 4
+	}
+
+	return nil, ErrIncompleteRedHeader
+}
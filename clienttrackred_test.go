@@ -0,0 +1,30 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveRedundantEncodingsForRED(t *testing.T) {
+	t.Parallel()
+
+	// one redundant block of 3 bytes with payload type 111, then the primary block
+	payload := []byte{
+		0x80 | 111, 0x03, 0xC0, 0x03,
+		111,
+		0x01, 0x02, 0x03,
+		0x04, 0x05,
+	}
+
+	redPayload, err := removeRedundantEncodingsForRED(payload)
+	require.NoError(t, err)
+	require.Equal(t, []byte{111, 0x04, 0x05}, redPayload)
+
+	primary, err := extractPrimaryEncodingForRED(redPayload)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x04, 0x05}, primary)
+
+	_, err = removeRedundantEncodingsForRED([]byte{0x80 | 111, 0x03})
+	require.ErrorIs(t, err, ErrIncompleteRedHeader)
+}
@@ -6,7 +6,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -17,24 +16,37 @@ type simulcastPacket struct {
 }
 
 type simulcastClientTrack struct {
-	id                      string
-	mu                      sync.RWMutex
-	client                  *Client
-	context                 context.Context
-	cancel                  context.CancelFunc
-	kind                    webrtc.RTPCodecType
-	mimeType                string
-	localTrack              *webrtc.TrackLocalStaticRTP
-	remoteTrack             *SimulcastTrack
-	lastBlankSequenceNumber *atomic.Uint32
-	sequenceNumber          *atomic.Uint32
-	lastQuality             *atomic.Uint32
-	paddingTS               *atomic.Uint32
-	maxQuality              *atomic.Uint32
-	lastTimestamp           *atomic.Uint32
-	isScreen                *atomic.Bool
-	isEnded                 *atomic.Bool
-	onTrackEndedCallbacks   []func()
+	packetTime
+	id                       string
+	mu                       sync.RWMutex
+	client                   *Client
+	context                  context.Context
+	cancel                   context.CancelFunc
+	kind                     webrtc.RTPCodecType
+	mimeType                 string
+	localTrack               *webrtc.TrackLocalStaticRTP
+	remoteTrack              *SimulcastTrack
+	lastBlankSequenceNumber  *atomic.Uint32
+	sequenceNumber           *atomic.Uint32
+	lastQuality              *atomic.Uint32
+	paddingTS                *atomic.Uint32
+	maxQuality               *atomic.Uint32
+	minQuality               *atomic.Uint32
+	priority                 *atomic.Int32
+	lastTimestamp            *atomic.Uint32
+	vp8Rewriter              *vp8Rewriter
+	isScreen                 *atomic.Bool
+	isEnded                  *atomic.Bool
+	onTrackEndedCallbacks    []func()
+	onQualityChangeCallbacks []func(old, new QualityLevel)
+	paused                   atomic.Bool
+	// each layer has its own timestamp base, the offset of a layer is set when the track switch to it
+	timestampOffsets      [QualityHigh + 1]uint32
+	timestampLayer        QualityLevel
+	lastSentTimestamp     uint32
+	lastSentTimestampTime time.Time
+	// the publisher header extension ids mapped to the subscriber ids, the layers share the publisher ids
+	headerExtensions atomic.Pointer[headerExtensionMap]
 }
 
 func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack {
@@ -54,27 +66,32 @@ func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack
 	lastTimestamp := &atomic.Uint32{}
 
 	ct := &simulcastClientTrack{
-		mu:                      sync.RWMutex{},
-		id:                      t.base.id,
-		context:                 ctx,
-		cancel:                  cancel,
-		kind:                    t.base.kind,
-		mimeType:                t.base.codec.MimeType,
-		client:                  c,
-		localTrack:              track,
-		remoteTrack:             t,
-		sequenceNumber:          sequenceNumber,
-		lastQuality:             lastQuality,
-		paddingTS:               &atomic.Uint32{},
-		maxQuality:              &atomic.Uint32{},
-		lastBlankSequenceNumber: &atomic.Uint32{},
-		lastTimestamp:           lastTimestamp,
-		isScreen:                isScreen,
-		isEnded:                 &atomic.Bool{},
-		onTrackEndedCallbacks:   make([]func(), 0),
+		mu:                       sync.RWMutex{},
+		id:                       t.base.id,
+		context:                  ctx,
+		cancel:                   cancel,
+		kind:                     t.base.kind,
+		mimeType:                 t.base.codec.MimeType,
+		client:                   c,
+		localTrack:               track,
+		remoteTrack:              t,
+		sequenceNumber:           sequenceNumber,
+		lastQuality:              lastQuality,
+		paddingTS:                &atomic.Uint32{},
+		maxQuality:               &atomic.Uint32{},
+		minQuality:               &atomic.Uint32{},
+		priority:                 &atomic.Int32{},
+		lastBlankSequenceNumber:  &atomic.Uint32{},
+		lastTimestamp:            lastTimestamp,
+		vp8Rewriter:              newVP8Rewriter(),
+		isScreen:                 isScreen,
+		isEnded:                  &atomic.Bool{},
+		onTrackEndedCallbacks:    make([]func(), 0),
+		onQualityChangeCallbacks: make([]func(old, new QualityLevel), 0),
 	}
 
 	ct.SetMaxQuality(QualityHigh)
+	ct.SetPriority(DefaultPriority)
 
 	ct.remoteTrack.sendPLI(QualityHigh)
 	ct.remoteTrack.sendPLI(QualityMid)
@@ -83,6 +100,22 @@ func newSimulcastClientTrack(c *Client, t *SimulcastTrack) *simulcastClientTrack
 	return ct
 }
 
+// simulcastRemoteTrack return the publisher simulcast track of a client track that report itself as simulcast.
+// A track that report simulcast but is not a simulcastClientTrack is logged and skipped instead of panicking.
+func simulcastRemoteTrack(track iClientTrack) (*SimulcastTrack, bool) {
+	if !track.IsSimulcast() {
+		return nil, false
+	}
+
+	simulcastTrack, ok := track.(*simulcastClientTrack)
+	if !ok {
+		logger().Warn("clienttrack: track ", track.ID(), " report simulcast but is not a simulcast client track, skipped")
+		return nil, false
+	}
+
+	return simulcastTrack.remoteTrack, true
+}
+
 func (t *simulcastClientTrack) Client() *Client {
 	return t.client
 }
@@ -101,22 +134,61 @@ func (t *simulcastClientTrack) send(p rtp.Packet, quality QualityLevel, lastQual
 	t.lastTimestamp.Store(p.Timestamp)
 
 	if lastQuality != quality {
-		t.lastQuality.Store(uint32(quality))
+		t.SetLastQuality(quality)
 	}
 
 	p = t.rewritePacket(p, quality)
+	p.Timestamp = t.rewriteTimestamp(p.Timestamp, quality, time.Now())
+
+	if headerExtensions := t.headerExtensions.Load(); headerExtensions != nil {
+		headerExtensions.rewrite(&p)
+	}
+
+	if t.mimeType == webrtc.MimeTypeVP8 {
+		// keep the picture id continuous across the layer switch
+		p.Payload = t.vp8Rewriter.rewrite(p.Payload, lastQuality != quality)
+	}
 
 	t.writeRTP(p)
 
 }
 
+// setSubscriberHeaderExtensions map the publisher header extensions to the header extensions negotiated with the subscriber
+func (t *simulcastClientTrack) setSubscriberHeaderExtensions(subscriber []webrtc.RTPHeaderExtensionParameter) {
+	headerExtensions := newHeaderExtensionMap(t.remoteTrack.base.headerExtensions, subscriber)
+
+	t.headerExtensions.Store(&headerExtensions)
+}
+
 func (t *simulcastClientTrack) writeRTP(p rtp.Packet) {
 	if err := t.localTrack.WriteRTP(&p); err != nil {
-		glog.Error("track: error on write rtp", err)
+		logger().Error("track: error on write rtp", err)
 	}
 }
 
+func (t *simulcastClientTrack) Pause() {
+	t.paused.Store(true)
+	t.client.bitrateController.setPaused(t.ID(), true)
+}
+
+func (t *simulcastClientTrack) Resume() {
+	if !t.paused.Swap(false) {
+		return
+	}
+
+	t.client.bitrateController.setPaused(t.ID(), false)
+
+	// the client need a keyframe to decode the track after the gap
+	t.RequestPLI()
+}
+
+func (t *simulcastClientTrack) IsPaused() bool {
+	return t.paused.Load()
+}
+
 func (t *simulcastClientTrack) push(p rtp.Packet, quality QualityLevel) {
+	t.markPacket(t.client.bitrateController.now())
+
 	var trackQuality QualityLevel
 
 	lastQuality := t.LastQuality()
@@ -126,6 +198,10 @@ func (t *simulcastClientTrack) push(p rtp.Packet, quality QualityLevel) {
 		return
 	}
 
+	if t.paused.Load() {
+		return
+	}
+
 	isFirstKeyframePacket := t.isFirstKeyframePacket(p)
 	if isFirstKeyframePacket {
 		t.remoteTrack.KeyFrameReceived()
@@ -136,17 +212,17 @@ func (t *simulcastClientTrack) push(p rtp.Packet, quality QualityLevel) {
 		// we try to send the low quality first	if the track is active and fallback to upper quality if not
 		if t.remoteTrack.getRemoteTrack(QualityLow) != nil && quality == QualityLow {
 			trackQuality = QualityLow
-			t.lastQuality.Store(uint32(QualityLow))
+			t.SetLastQuality(QualityLow)
 			// send PLI to make sure the client will receive the first frame
 			t.remoteTrack.sendPLI(QualityLow)
 		} else if t.remoteTrack.getRemoteTrack(QualityMid) != nil && quality == QualityMid {
 			trackQuality = QualityMid
-			t.lastQuality.Store(uint32(QualityMid))
+			t.SetLastQuality(QualityMid)
 			// send PLI to make sure the client will receive the first frame
 			t.remoteTrack.sendPLI(QualityMid)
 		} else if t.remoteTrack.getRemoteTrack(QualityHigh) != nil && quality == QualityHigh {
 			trackQuality = QualityHigh
-			t.lastQuality.Store(uint32(QualityHigh))
+			t.SetLastQuality(QualityHigh)
 			// send PLI to make sure the client will receive the first frame
 			t.remoteTrack.sendPLI(QualityHigh)
 		} else {
@@ -154,7 +230,7 @@ func (t *simulcastClientTrack) push(p rtp.Packet, quality QualityLevel) {
 		}
 
 		t.remoteTrack.onRemoteTrackAdded(func(remote *remoteTrack) {
-			quality := RIDToQuality(remote.track.RID())
+			quality := t.remoteTrack.ridQuality(remote.track.RID())
 			t.remoteTrack.sendPLI(quality)
 		})
 	} else {
@@ -175,7 +251,7 @@ func (t *simulcastClientTrack) push(p rtp.Packet, quality QualityLevel) {
 				t.remoteTrack.lastLowKeyframeTS.Store(time.Now().UnixNano())
 			}
 
-			t.lastQuality.Store(uint32(trackQuality))
+			t.SetLastQuality(trackQuality)
 		}
 	}
 
@@ -244,6 +320,31 @@ func (t *simulcastClientTrack) LastQuality() QualityLevel {
 	return Uint32ToQualityLevel(t.lastQuality.Load())
 }
 
+// SetLastQuality store the delivered quality and notify the quality change callbacks on transition
+func (t *simulcastClientTrack) SetLastQuality(quality QualityLevel) {
+	old := Uint32ToQualityLevel(t.lastQuality.Swap(uint32(quality)))
+	if old == quality {
+		return
+	}
+
+	t.mu.RLock()
+	callbacks := make([]func(old, new QualityLevel), len(t.onQualityChangeCallbacks))
+	copy(callbacks, t.onQualityChangeCallbacks)
+	t.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(old, quality)
+	}
+}
+
+// OnQualityChange register a callback that called when the quality delivered to the client is changed
+func (t *simulcastClientTrack) OnQualityChange(callback func(old, new QualityLevel)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onQualityChangeCallbacks = append(t.onQualityChangeCallbacks, callback)
+}
+
 func (t *simulcastClientTrack) OnTrackEnded(callback func()) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -266,13 +367,51 @@ func (t *simulcastClientTrack) onTrackEnded() {
 	t.isEnded.Store(true)
 }
 
+// SetMaxQuality set the viewport quality of the track, a keyframe is only requested when the forwarded quality can increase
 func (t *simulcastClientTrack) SetMaxQuality(quality QualityLevel) {
-	t.maxQuality.Store(uint32(quality))
-	t.remoteTrack.sendPLI(quality)
+	maxDecodeQuality := t.client.MaxDecodeQuality()
+	previous := min(Uint32ToQualityLevel(t.maxQuality.Swap(uint32(quality))), maxDecodeQuality)
+
+	if maxQualityNeedKeyframe(t.client, previous, min(quality, maxDecodeQuality), t.LastQuality()) {
+		t.remoteTrack.sendPLI(quality)
+	}
 }
 
+// MaxQuality return the lower quality between the viewport quality set with SetMaxQuality and the client max decode quality
 func (t *simulcastClientTrack) MaxQuality() QualityLevel {
-	return Uint32ToQualityLevel(t.maxQuality.Load())
+	return min(Uint32ToQualityLevel(t.maxQuality.Load()), t.client.MaxDecodeQuality())
+}
+
+// SetMinQuality set the quality floor of the track. The bitrate controller will never reduce the track
+// below this quality, it will reduce the other tracks first when the bandwidth is not enough.
+// A keyframe is only requested when the floor raise the forwarded quality.
+func (t *simulcastClientTrack) SetMinQuality(quality QualityLevel) {
+	claim := t.client.bitrateController.GetClaim(t.ID())
+	if claim == nil {
+		t.minQuality.Store(uint32(quality))
+		return
+	}
+
+	previous := t.client.bitrateController.claimQuality(t, claim)
+	t.minQuality.Store(uint32(quality))
+
+	if current := t.client.bitrateController.claimQuality(t, claim); current > previous {
+		t.remoteTrack.sendPLI(current)
+	}
+}
+
+func (t *simulcastClientTrack) MinQuality() QualityLevel {
+	return Uint32ToQualityLevel(t.minQuality.Load())
+}
+
+// SetPriority set the weight of the track when the bandwidth is distributed between tracks,
+// the track with higher weight get more bandwidth and reduced last
+func (t *simulcastClientTrack) SetPriority(weight int) {
+	t.priority.Store(int32(validPriority(weight)))
+}
+
+func (t *simulcastClientTrack) Priority() int {
+	return int(t.priority.Load())
 }
 
 func (t *simulcastClientTrack) IsSimulcast() bool {
@@ -286,18 +425,15 @@ func (t *simulcastClientTrack) IsScaleable() bool {
 func (t *simulcastClientTrack) rewritePacket(p rtp.Packet, quality QualityLevel) rtp.Packet {
 	t.remoteTrack.mu.Lock()
 	defer t.remoteTrack.mu.Unlock()
-	// make sure the timestamp and sequence number is consistent from the previous packet even it is not the same track
+	// make sure the sequence number is consistent from the previous packet even it is not the same track
 	sequenceDelta := uint16(0)
 	// credit to https://github.com/k0nserv for helping me with this on Pion Slack channel
 	switch quality {
 	case QualityHigh:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackHighBaseTS) - t.remoteTrack.remoteTrackHighBaseTS)
 		sequenceDelta = t.remoteTrack.highSequence - t.remoteTrack.lastHighSequence
 	case QualityMid:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackMidBaseTS) - t.remoteTrack.remoteTrackMidBaseTS)
 		sequenceDelta = t.remoteTrack.midSequence - t.remoteTrack.lastMidSequence
 	case QualityLow:
-		p.Timestamp = t.remoteTrack.baseTS + ((p.Timestamp - t.remoteTrack.remoteTrackLowBaseTS) - t.remoteTrack.remoteTrackLowBaseTS)
 		sequenceDelta = t.remoteTrack.lowSequence - t.remoteTrack.lastLowSequence
 	}
 
@@ -307,6 +443,46 @@ func (t *simulcastClientTrack) rewritePacket(p rtp.Packet, quality QualityLevel)
 	return p
 }
 
+// rewriteTimestamp keep the output timestamps continuous across the layer switches. The layers have unrelated timestamp
+// bases, so when the track switch to a layer its offset is set to continue from the last sent timestamp plus the
+// elapsed time since it was sent.
+func (t *simulcastClientTrack) rewriteTimestamp(timestamp uint32, quality QualityLevel, now time.Time) uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastSentTimestampTime.IsZero() {
+		t.timestampLayer = quality
+		t.timestampOffsets[quality] = 0
+	} else if quality != t.timestampLayer {
+		elapsed := uint32(now.Sub(t.lastSentTimestampTime).Seconds() * float64(t.localTrack.Codec().ClockRate))
+		t.timestampOffsets[quality] = t.lastSentTimestamp + max(elapsed, 1) - timestamp
+		t.timestampLayer = quality
+	}
+
+	rewritten := timestamp + t.timestampOffsets[quality]
+
+	// the packets of the same frame share the timestamp, only a newer frame move the last sent timestamp
+	if diff := rewritten - t.lastSentTimestamp; t.lastSentTimestampTime.IsZero() || (diff != 0 && diff < 1<<31) {
+		t.lastSentTimestamp = rewritten
+		t.lastSentTimestampTime = now
+	}
+
+	return rewritten
+}
+
+// AvailableQualities return the qualities of the simulcast layers that the publisher is sending
+func (t *simulcastClientTrack) AvailableQualities() []QualityLevel {
+	qualities := make([]QualityLevel, 0, 3)
+
+	for _, quality := range []QualityLevel{QualityLow, QualityMid, QualityHigh} {
+		if t.remoteTrack.getRemoteTrack(quality) != nil {
+			qualities = append(qualities, quality)
+		}
+	}
+
+	return qualities
+}
+
 func (t *simulcastClientTrack) RequestPLI() {
 	t.remoteTrack.sendPLI(t.LastQuality())
 }
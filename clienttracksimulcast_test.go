@@ -0,0 +1,569 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulcastOnQualityChange(t *testing.T) {
+	t.Parallel()
+
+	track := &simulcastClientTrack{
+		lastQuality: &atomic.Uint32{},
+	}
+
+	transitions := make([][2]QualityLevel, 0)
+	track.OnQualityChange(func(old, new QualityLevel) {
+		// registering a callback inside the callback would deadlock if the lock is held
+		track.OnQualityChange(func(_, _ QualityLevel) {})
+		transitions = append(transitions, [2]QualityLevel{old, new})
+	})
+
+	track.SetLastQuality(QualityLow)
+	track.SetLastQuality(QualityLow)
+	track.SetLastQuality(QualityHigh)
+
+	require.Equal(t, [][2]QualityLevel{{QualityNone, QualityLow}, {QualityLow, QualityHigh}}, transitions)
+	require.Equal(t, QualityLevel(QualityHigh), track.LastQuality())
+}
+
+// vp8Payload build a VP8 payload descriptor with 15 bits picture id and tl0picidx followed by a dummy payload byte
+func vp8Payload(pictureID uint16, tl0PicIdx uint8) []byte {
+	return []byte{0x90, 0xc0, 0x80 | byte(pictureID>>8), byte(pictureID), tl0PicIdx, 0x00}
+}
+
+func parseVP8PictureID(t *testing.T, payload []byte) (uint16, uint8) {
+	vp8 := &codecs.VP8Packet{}
+	_, err := vp8.Unmarshal(payload)
+	require.NoError(t, err)
+
+	return vp8.PictureID, vp8.TL0PICIDX
+}
+
+func TestVP8PictureIDContinuityOnLayerSwitch(t *testing.T) {
+	t.Parallel()
+
+	rewriter := newVP8Rewriter()
+
+	// low layer frames, two packets per frame
+	for i := uint16(0); i < 3; i++ {
+		for j := 0; j < 2; j++ {
+			payload := rewriter.rewrite(vp8Payload(100+i, uint8(10+i)), false)
+			pictureID, tl0PicIdx := parseVP8PictureID(t, payload)
+			require.Equal(t, 100+i, pictureID)
+			require.Equal(t, uint8(10+i), tl0PicIdx)
+		}
+	}
+
+	// switch to the high layer mid-stream, the high layer has its own picture id sequence
+	original := vp8Payload(5000, 200)
+	payload := rewriter.rewrite(original, true)
+	pictureID, tl0PicIdx := parseVP8PictureID(t, payload)
+	require.Equal(t, uint16(103), pictureID)
+	require.Equal(t, uint8(13), tl0PicIdx)
+
+	// the shared payload is not modified
+	require.Equal(t, vp8Payload(5000, 200), original)
+
+	// the next packet of the same frame keep the same picture id
+	payload = rewriter.rewrite(vp8Payload(5000, 200), false)
+	pictureID, _ = parseVP8PictureID(t, payload)
+	require.Equal(t, uint16(103), pictureID)
+
+	for i := uint16(1); i < 3; i++ {
+		payload := rewriter.rewrite(vp8Payload(5000+i, uint8(200+i)), false)
+		pictureID, tl0PicIdx := parseVP8PictureID(t, payload)
+		require.Equal(t, 103+i, pictureID)
+		require.Equal(t, uint8(13+i), tl0PicIdx)
+	}
+
+	// picture id wrap around
+	payload = rewriter.rewrite(vp8Payload(vp8PictureIDMask, 0), true)
+	pictureID, _ = parseVP8PictureID(t, payload)
+	require.Equal(t, uint16(106), pictureID)
+}
+
+func TestSimulcastTimestampContinuityOnLayerSwitch(t *testing.T) {
+	t.Parallel()
+
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "video", "stream-video")
+	require.NoError(t, err)
+
+	track := &simulcastClientTrack{
+		localTrack: localTrack,
+	}
+
+	// each layer has its own random timestamp base, the frames are 33ms apart
+	bases := map[QualityLevel]uint32{
+		QualityLow:  1_000_000,
+		QualityMid:  4_000_000_000,
+		QualityHigh: 50_000_000,
+	}
+
+	now := time.Now()
+	frame := uint32(0)
+	sent := make([]uint32, 0)
+	sendFrames := func(quality QualityLevel, count int) {
+		for i := 0; i < count; i++ {
+			timestamp := bases[quality] + frame*2970
+			// two packets per frame share the timestamp
+			first := track.rewriteTimestamp(timestamp, quality, now)
+			require.Equal(t, first, track.rewriteTimestamp(timestamp, quality, now))
+
+			sent = append(sent, first)
+			frame++
+			now = now.Add(33 * time.Millisecond)
+		}
+	}
+
+	sendFrames(QualityLow, 3)
+	sendFrames(QualityHigh, 3)
+	sendFrames(QualityMid, 3)
+	sendFrames(QualityLow, 3)
+
+	require.Equal(t, bases[QualityLow], sent[0])
+
+	for i := 1; i < len(sent); i++ {
+		require.Equal(t, uint32(2970), sent[i]-sent[i-1], "frame %d", i)
+	}
+}
+
+func TestSimulcastClaimPromotedWhenLayerAppear(t *testing.T) {
+	t.Parallel()
+
+	// enough bandwidth to forward the high layer when it is the only layer
+	client := newTestClient(t, 2*DefaultBitrates().VideoHigh)
+	bc := client.bitrateController
+
+	// the publisher start with a single encoding
+	simulcastTrack := &SimulcastTrack{
+		remoteTrackHigh: &remoteTrack{},
+	}
+
+	track := &simulcastClientTrack{
+		id:          "video",
+		context:     client.context,
+		client:      client,
+		kind:        webrtc.RTPCodecTypeVideo,
+		remoteTrack: simulcastTrack,
+		lastQuality: &atomic.Uint32{},
+		maxQuality:  &atomic.Uint32{},
+		isScreen:    &atomic.Bool{},
+	}
+	track.maxQuality.Store(QualityHigh)
+
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+	require.True(t, claim.isSimulcast())
+
+	_, quality := bc.checkAllTrackActive(claim)
+	require.Equal(t, QualityLevel(QualityHigh), quality)
+	require.False(t, claim.isSimulcast())
+
+	// the publisher enable the simulcast mid-call
+	simulcastTrack.mu.Lock()
+	simulcastTrack.remoteTrackMid = &remoteTrack{}
+	simulcastTrack.mu.Unlock()
+
+	bc.checkAllTrackActive(claim)
+	require.True(t, claim.isSimulcast())
+
+	simulcastTrack.mu.Lock()
+	simulcastTrack.remoteTrackLow = &remoteTrack{}
+	simulcastTrack.mu.Unlock()
+
+	bc.checkAllTrackActive(claim)
+	require.True(t, claim.isSimulcast())
+}
+
+func TestSimulcastSingleLayerOverBudget(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	newSingleLayerTrack := func(client *Client) *simulcastClientTrack {
+		track := &simulcastClientTrack{
+			id:      "video",
+			context: client.context,
+			client:  client,
+			kind:    webrtc.RTPCodecTypeVideo,
+			remoteTrack: &SimulcastTrack{
+				remoteTrackHigh: &remoteTrack{},
+			},
+			lastQuality: &atomic.Uint32{},
+			maxQuality:  &atomic.Uint32{},
+			isScreen:    &atomic.Bool{},
+		}
+		track.maxQuality.Store(QualityHigh)
+
+		return track
+	}
+
+	// the subscriber can only afford the low layer, but the publisher only send the high layer
+	client := newTestClient(t, bitrates.VideoLow)
+	unaffordable := make(chan QualityLevel, 1)
+	client.OnTrackLayerUnaffordable(func(trackID string, quality QualityLevel) {
+		require.Equal(t, "video", trackID)
+		unaffordable <- quality
+	})
+
+	track := newSingleLayerTrack(client)
+	claim, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	allActive, quality := client.bitrateController.checkAllTrackActive(claim)
+	require.True(t, allActive)
+	require.Equal(t, QualityLevel(QualityNone), quality)
+	require.Equal(t, QualityLevel(QualityNone), claim.Quality())
+	require.False(t, claim.isSimulcast())
+	require.Equal(t, QualityLevel(QualityHigh), <-unaffordable)
+
+	// the track is not promoted by the bitrate adjustment
+	client.bitrateController.checkAndAdjustBitrates()
+	require.Equal(t, QualityLevel(QualityNone), claim.Quality())
+
+	// the bandwidth is enough, but the layer is above the track max quality
+	client = newTestClient(t, 2*bitrates.VideoHigh)
+	track = newSingleLayerTrack(client)
+	track.maxQuality.Store(QualityMid)
+	claim, err = client.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	_, quality = client.bitrateController.checkAllTrackActive(claim)
+	require.Equal(t, QualityLevel(QualityNone), quality)
+
+	// the layer is forwarded once the track max quality allow it
+	track.maxQuality.Store(QualityHigh)
+	_, quality = client.bitrateController.checkAllTrackActive(claim)
+	require.Equal(t, QualityLevel(QualityHigh), quality)
+	require.Equal(t, QualityLevel(QualityHigh), claim.Quality())
+}
+
+func TestSimulcastFallbackToNearestLayer(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 2*DefaultBitrates().VideoHigh)
+
+	// the low and high layers are active, the mid layer stopped
+	now := time.Now().UnixNano()
+	simulcastTrack := &SimulcastTrack{
+		base:            &baseTrack{id: "video"},
+		remoteTrackHigh: &remoteTrack{},
+		remoteTrackMid:  &remoteTrack{},
+		remoteTrackLow:  &remoteTrack{},
+		lastReadHighTS:  &atomic.Int64{},
+		lastReadMidTS:   &atomic.Int64{},
+		lastReadLowTS:   &atomic.Int64{},
+	}
+	simulcastTrack.lastReadHighTS.Store(now)
+	simulcastTrack.lastReadLowTS.Store(now)
+
+	track := &simulcastClientTrack{
+		id:          "video",
+		context:     client.context,
+		client:      client,
+		kind:        webrtc.RTPCodecTypeVideo,
+		remoteTrack: simulcastTrack,
+		lastQuality: &atomic.Uint32{},
+		maxQuality:  &atomic.Uint32{},
+		minQuality:  &atomic.Uint32{},
+		isScreen:    &atomic.Bool{},
+	}
+	track.maxQuality.Store(QualityHigh)
+
+	_, err := client.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	// the low layer bitrate is closer to the mid bitrate with the default bitrates
+	require.Equal(t, QualityLevel(QualityLow), client.bitrateController.getQuality(track))
+
+	// the high layer is closer once the mid bitrate is near to the high bitrate
+	require.NoError(t, client.SFU().SetQualityBitrates(150_000, 1_000_000, 1_200_000))
+	require.Equal(t, QualityLevel(QualityHigh), client.bitrateController.getQuality(track))
+
+	// the screen bitrates are not changed, the low screen layer is still closer to the mid screen bitrate
+	track.isScreen.Store(true)
+	require.Equal(t, QualityLevel(QualityLow), client.bitrateController.getQuality(track))
+}
+
+func TestSimulcastFallbackRespectMaxQuality(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, 2*DefaultBitrates().VideoHigh)
+
+	// the claimed mid layer stopped, only the high layer is active
+	simulcastTrack := &SimulcastTrack{
+		base:            &baseTrack{id: "video"},
+		remoteTrackHigh: &remoteTrack{},
+		remoteTrackMid:  &remoteTrack{},
+		remoteTrackLow:  &remoteTrack{},
+		lastReadHighTS:  &atomic.Int64{},
+		lastReadMidTS:   &atomic.Int64{},
+		lastReadLowTS:   &atomic.Int64{},
+	}
+	simulcastTrack.lastReadHighTS.Store(time.Now().UnixNano())
+
+	track := &simulcastClientTrack{
+		id:          "video",
+		context:     client.context,
+		client:      client,
+		kind:        webrtc.RTPCodecTypeVideo,
+		remoteTrack: simulcastTrack,
+		lastQuality: &atomic.Uint32{},
+		maxQuality:  &atomic.Uint32{},
+		minQuality:  &atomic.Uint32{},
+		isScreen:    &atomic.Bool{},
+	}
+	track.maxQuality.Store(QualityMid)
+
+	_, err := client.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	// the high layer is above the max quality, the claimed layer is kept
+	require.Equal(t, QualityLevel(QualityMid), client.bitrateController.getQuality(track))
+
+	// the active layer below the max quality is used even the high layer bitrate is closer
+	simulcastTrack.lastReadLowTS.Store(time.Now().UnixNano())
+	require.NoError(t, client.SFU().SetQualityBitrates(150_000, 1_000_000, 1_200_000))
+	require.Equal(t, QualityLevel(QualityLow), client.bitrateController.getQuality(track))
+}
+
+func TestTotalActiveBitrates(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, bitrates.InitialBandwidth)
+	bc := client.bitrateController
+
+	// the publisher never sent the high layer
+	simulcast := &simulcastClientTrack{
+		id:      "simulcast",
+		context: client.context,
+		client:  client,
+		kind:    webrtc.RTPCodecTypeVideo,
+		remoteTrack: &SimulcastTrack{
+			base:            &baseTrack{id: "simulcast"},
+			remoteTrackHigh: &remoteTrack{},
+			lastReadHighTS:  &atomic.Int64{},
+		},
+		lastQuality: &atomic.Uint32{},
+		isScreen:    &atomic.Bool{},
+	}
+
+	_, err := bc.addClaim(simulcast, QualityHigh, true)
+	require.NoError(t, err)
+
+	active := newTestClientTrack(t, client, "active", webrtc.RTPCodecTypeVideo, true)
+	_, err = bc.addClaim(active, QualityMid, true)
+	require.NoError(t, err)
+
+	require.Equal(t, bitrates.VideoHigh+bitrates.VideoMid, bc.TotalBitrates())
+	require.Equal(t, bitrates.VideoMid, bc.TotalActiveBitrates())
+
+	// the paused claim is not active either
+	active.Pause()
+	require.Equal(t, uint32(0), bc.TotalActiveBitrates())
+}
+
+func TestAvailableQualities(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	// the publisher only send the high and low layers
+	simulcast := &simulcastClientTrack{
+		id:     "simulcast",
+		client: client,
+		remoteTrack: &SimulcastTrack{
+			remoteTrackHigh: &remoteTrack{},
+			remoteTrackLow:  &remoteTrack{},
+		},
+	}
+
+	require.Equal(t, []QualityLevel{QualityLow, QualityHigh}, simulcast.AvailableQualities())
+
+	scaleable := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	require.Empty(t, scaleable.AvailableQualities())
+
+	scaleable.layerCounts.Store(2<<8 | 3)
+	require.Equal(t, []QualityLevel{QualityLow, QualityMid}, scaleable.AvailableQualities())
+
+	scaleable.layerCounts.Store(3<<8 | 3)
+	require.Equal(t, []QualityLevel{QualityLow, QualityMid, QualityHigh}, scaleable.AvailableQualities())
+}
+
+func TestSimulcastCustomRIDQualities(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{SimulcastRIDQualities: map[string]QualityLevel{
+		"q":       QualityLow,
+		"h":       QualityMid,
+		"f":       QualityHigh,
+		"invalid": QualityVeryHigh,
+	}})
+
+	require.Equal(t, QualityLevel(QualityHigh), s.RIDToQuality("f"))
+	require.Equal(t, QualityLevel(QualityMid), s.RIDToQuality("mid"), "the default RIDs are still mapped")
+	require.Equal(t, QualityLevel(QualityLow), s.RIDToQuality("invalid"), "the invalid quality is ignored")
+
+	newRelay := func(rid string, ssrc webrtc.SSRC) IRemoteTrack {
+		return NewTrackRelay("video", "stream", rid, webrtc.RTPCodecTypeVideo, ssrc, webrtc.MimeTypeVP8, make(chan *rtp.Packet))
+	}
+
+	track := newSimulcastTrack(ctx, "client", newRelay("f", 1), nil, 0, 0, func() {}, nackOptions{}, nil, nil, s.RIDToQuality).(*SimulcastTrack)
+	track.AddRemoteTrack(ctx, newRelay("q", 2), func() {}, nil, nil)
+	track.AddRemoteTrack(ctx, newRelay("h", 3), func() {}, nil, nil)
+
+	require.Equal(t, "f", track.getRemoteTrack(QualityHigh).track.RID())
+	require.Equal(t, "h", track.getRemoteTrack(QualityMid).track.RID())
+	require.Equal(t, "q", track.getRemoteTrack(QualityLow).track.RID())
+}
+
+func TestSimulcastPLIDeduplication(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	var mu sync.Mutex
+	plis := make(map[webrtc.SSRC]int)
+	newRelay := func(rid string, ssrc webrtc.SSRC) (IRemoteTrack, func()) {
+		relay := NewTrackRelay("video", "stream", rid, webrtc.RTPCodecTypeVideo, ssrc, webrtc.MimeTypeVP8, make(chan *rtp.Packet))
+		return relay, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			plis[ssrc]++
+		}
+	}
+
+	high, onHighPLI := newRelay("high", 1)
+	mid, onMidPLI := newRelay("mid", 2)
+	low, onLowPLI := newRelay("low", 3)
+
+	track := newSimulcastTrack(ctx, "client", high, nil, 0, time.Minute, onHighPLI, nackOptions{}, nil, nil, RIDToQuality).(*SimulcastTrack)
+	track.AddRemoteTrack(ctx, mid, onMidPLI, nil, nil)
+	track.AddRemoteTrack(ctx, low, onLowPLI, nil, nil)
+
+	subscribers := make([]*simulcastClientTrack, 0)
+	for i := 0; i < 5; i++ {
+		subscribers = append(subscribers, &simulcastClientTrack{
+			id:          "video",
+			context:     client.context,
+			client:      client,
+			kind:        webrtc.RTPCodecTypeVideo,
+			remoteTrack: track,
+			lastQuality: &atomic.Uint32{},
+			isScreen:    &atomic.Bool{},
+		})
+	}
+
+	// all subscribers switch to the high layer, the publisher only receive a single PLI on the high layer
+	for _, subscriber := range subscribers {
+		subscriber.lastQuality.Store(QualityHigh)
+		subscriber.RequestPLI()
+	}
+
+	mu.Lock()
+	require.Equal(t, map[webrtc.SSRC]int{1: 1}, plis)
+	mu.Unlock()
+
+	// the PLIs of the other layer are not coalesced with the high layer
+	for _, subscriber := range subscribers {
+		subscriber.lastQuality.Store(QualityMid)
+		subscriber.RequestPLI()
+	}
+
+	mu.Lock()
+	require.Equal(t, map[webrtc.SSRC]int{1: 1, 2: 1}, plis)
+	mu.Unlock()
+}
+
+func TestSimulcastProbeLayers(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.sfu.simulcastProbeTimeout = 200 * time.Millisecond
+
+	// the publisher only send the low and mid layers, the layers are received once the keyframes are requested
+	simulcastTrack := &SimulcastTrack{
+		base:           &baseTrack{id: "video"},
+		lastReadHighTS: &atomic.Int64{},
+		lastReadMidTS:  &atomic.Int64{},
+		lastReadLowTS:  &atomic.Int64{},
+	}
+
+	plis := &atomic.Int32{}
+	simulcastTrack.remoteTrackMid = &remoteTrack{onPLI: func() {
+		plis.Add(1)
+		simulcastTrack.lastReadMidTS.Store(time.Now().UnixNano())
+	}}
+	simulcastTrack.remoteTrackLow = &remoteTrack{onPLI: func() {
+		plis.Add(1)
+		simulcastTrack.lastReadLowTS.Store(time.Now().UnixNano())
+	}}
+
+	track := &simulcastClientTrack{
+		id:          "video",
+		context:     client.context,
+		client:      client,
+		kind:        webrtc.RTPCodecTypeVideo,
+		remoteTrack: simulcastTrack,
+		lastQuality: &atomic.Uint32{},
+		maxQuality:  &atomic.Uint32{},
+		minQuality:  &atomic.Uint32{},
+		isScreen:    &atomic.Bool{},
+	}
+	track.maxQuality.Store(QualityHigh)
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return track.MaxQuality() == QualityMid
+	}, time.Second, 10*time.Millisecond)
+
+	// the keyframe of each existing layer is requested once
+	require.Equal(t, int32(2), plis.Load())
+
+	claim := client.bitrateController.GetClaim(track.ID())
+	require.NotNil(t, claim)
+
+	// the cap is kept while the high layer is not received
+	client.bitrateController.checkAllTrackActive(claim)
+	require.Equal(t, QualityLevel(QualityMid), track.MaxQuality())
+
+	// the publisher start sending the high layer after the probe, the cap is lifted
+	simulcastTrack.mu.Lock()
+	simulcastTrack.remoteTrackHigh = &remoteTrack{onPLI: func() {}}
+	simulcastTrack.mu.Unlock()
+	simulcastTrack.lastReadHighTS.Store(time.Now().UnixNano())
+
+	client.bitrateController.checkAllTrackActive(claim)
+	require.Equal(t, QualityLevel(QualityHigh), track.MaxQuality())
+
+	// the probe timeout bound the probe when no layer is received
+	empty := &SimulcastTrack{
+		base:           &baseTrack{id: "empty"},
+		lastReadHighTS: &atomic.Int64{},
+		lastReadMidTS:  &atomic.Int64{},
+		lastReadLowTS:  &atomic.Int64{},
+	}
+
+	start := time.Now()
+	require.Equal(t, QualityLevel(QualityNone), empty.probeLayers(context.Background(), 100*time.Millisecond))
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -3,9 +3,9 @@ package sfu
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
@@ -16,6 +16,19 @@ type IQualityPreset interface {
 	GetTID() uint8
 }
 
+type QualityVeryHighPreset struct {
+	SID uint8
+	TID uint8
+}
+
+func (q QualityVeryHighPreset) GetSID() uint8 {
+	return q.SID
+}
+
+func (q QualityVeryHighPreset) GetTID() uint8 {
+	return q.TID
+}
+
 type QualityHighPreset struct {
 	SID uint8
 	TID uint8
@@ -56,13 +69,70 @@ func (q QualityLowPreset) GetTID() uint8 {
 }
 
 type QualityPreset struct {
-	High QualityHighPreset
-	Mid  QualityMidPreset
-	Low  QualityLowPreset
+	// the very high preset is only used when the very high bitrate is configured,
+	// set it to the fourth spatial layer if the publisher encode one
+	VeryHigh QualityVeryHighPreset
+	High     QualityHighPreset
+	Mid      QualityMidPreset
+	Low      QualityLowPreset
+}
+
+// LayerReductionPreference decide which layer of a VP9 scaleable track is dropped first when the quality is reduced
+// below the high quality, see Client.SetLayerReductionPreference
+type LayerReductionPreference uint32
+
+const (
+	// LayerReductionBalanced follow the quality preset, the spatial and temporal layers are reduced together
+	LayerReductionBalanced LayerReductionPreference = iota
+	// LayerReductionTemporalFirst keep the resolution and reduce the frame rate first, then the resolution
+	LayerReductionTemporalFirst
+	// LayerReductionSpatialFirst keep the frame rate and reduce the resolution first, then the frame rate
+	LayerReductionSpatialFirst
+)
+
+// layerPreset is a quality preset derived from the configured presets
+type layerPreset struct {
+	sid uint8
+	tid uint8
+}
+
+func (q layerPreset) GetSID() uint8 {
+	return q.sid
+}
+
+func (q layerPreset) GetTID() uint8 {
+	return q.tid
+}
+
+// reducePreset drop the same number of layers from the top preset as the target preset does,
+// but in the order of the preference
+func reducePreset(top, target IQualityPreset, preference LayerReductionPreference) IQualityPreset {
+	sid, tid := top.GetSID(), top.GetTID()
+	steps := max(int(sid)-int(target.GetSID()), 0) + max(int(tid)-int(target.GetTID()), 0)
+
+	reduce := func(layer *uint8) {
+		n := min(int(*layer), steps)
+		*layer -= uint8(n)
+		steps -= n
+	}
+
+	if preference == LayerReductionTemporalFirst {
+		reduce(&tid)
+		reduce(&sid)
+	} else {
+		reduce(&sid)
+		reduce(&tid)
+	}
+
+	return layerPreset{sid: sid, tid: tid}
 }
 
 func DefaultQualityPreset() QualityPreset {
 	return QualityPreset{
+		VeryHigh: QualityVeryHighPreset{
+			SID: 2,
+			TID: 2,
+		},
 		High: QualityHighPreset{
 			SID: 2,
 			TID: 2,
@@ -78,60 +148,147 @@ func DefaultQualityPreset() QualityPreset {
 	}
 }
 
+const (
+	// the stream is considered providing the spatial upswitch point if there is one within this duration
+	upswitchPointTimeout = time.Second
+	// a sequence number jump bigger than this in both direction means the publisher reset the sequence number
+	sequenceJumpThreshold = 1000
+	// DefaultForceKeyframeInterval is how long an upswitch wait for the upswitch point before a keyframe is requested
+	DefaultForceKeyframeInterval = 5 * time.Second
+	// a late packet with the timestamp further than this from the cached packet is not in the same timeline,
+	// 5 seconds of the 90kHz video clock
+	lateTimestampThreshold = 5 * 90000
+	// the queued packets are written within this duration when the track is ended, the rest are dropped
+	trackDrainTimeout = 200 * time.Millisecond
+)
+
+// DropStats is the number of packets dropped by a scaleable track grouped by the reason
+type DropStats struct {
+	// dropped because the claimed quality is none
+	QualityNone uint64 `json:"quality_none"`
+	// dropped because the spatial layer is above the target layer
+	Spatial uint64 `json:"spatial"`
+	// dropped because the temporal layer is above the target layer
+	Temporal uint64 `json:"temporal"`
+	// late packets that already sent
+	Duplicate uint64 `json:"duplicate"`
+	// late packets from the timeline before the publisher reset the sequence number
+	Stale uint64 `json:"stale"`
+	// dropped from the packet queue because the local track writer is too slow
+	Congestion uint64 `json:"congestion"`
+	// padding only packets sent by the publisher to probe the bandwidth
+	Padding uint64 `json:"padding"`
+}
+
+type dropCounters struct {
+	dropsQualityNone atomic.Uint64
+	dropsSpatial     atomic.Uint64
+	dropsTemporal    atomic.Uint64
+	dropsDuplicate   atomic.Uint64
+	dropsStale       atomic.Uint64
+	dropsCongestion  atomic.Uint64
+	dropsPadding     atomic.Uint64
+}
+
 type scaleableClientTrack struct {
-	id                    string
-	context               context.Context
-	cancel                context.CancelFunc
-	mu                    sync.RWMutex
-	client                *Client
-	kind                  webrtc.RTPCodecType
-	mimeType              string
-	localTrack            *webrtc.TrackLocalStaticRTP
-	remoteTrack           *Track
-	sequenceNumber        uint16
-	lastQuality           QualityLevel
-	maxQuality            QualityLevel
-	temporalCount         uint8
-	spatsialCount         uint8
-	tid                   uint8
-	sid                   uint8
-	lastTimestamp         uint32
-	isScreen              bool
-	isEnded               bool
-	onTrackEndedCallbacks []func()
-	dropCounter           uint16
-	qualityPreset         QualityPreset
-	packetCaches          *packetCaches
-	packetChan            chan rtp.Packet
-	lastProcessTime       time.Time
+	packetTime
+	id                       string
+	context                  context.Context
+	cancel                   context.CancelFunc
+	mu                       sync.RWMutex
+	client                   *Client
+	kind                     webrtc.RTPCodecType
+	mimeType                 string
+	localTrack               *webrtc.TrackLocalStaticRTP
+	remoteTrack              *Track
+	sequenceNumber           uint16
+	lastQuality              QualityLevel
+	maxQuality               QualityLevel
+	minQuality               QualityLevel
+	priority                 int
+	temporalCount            uint8
+	spatsialCount            uint8
+	tid                      uint8
+	sid                      uint8
+	isScreen                 bool
+	isEnded                  bool
+	onTrackEndedCallbacks    []func()
+	onQualityChangeCallbacks []func(old, new QualityLevel)
+	dropCounter              uint16
+	drops                    dropCounters
+	qualityPreset            QualityPreset
+	packetCaches             *packetCaches
+	packetQueue              *packetQueue
+	writeMu                  sync.Mutex
+	keyframeTimestamp        uint32
+	lastProcessTime          time.Time
+	h264FrameTimestamp       uint32
+	h264FrameTID             uint8
+	lastUpswitchPointTime    time.Time
+	pendingLayerEnd          *queuedPacket
+	headerExtensions         atomic.Pointer[headerExtensionMap]
+	paused                   atomic.Bool
+	hasSequenceNumber        bool
+	lastSentSequenceNumber   uint16
+	paddingBudget            atomic.Int64
+	forceKeyframeInterval    atomic.Int64
+	upswitchPendingSince     time.Time
+	forwardedBitrate         bitrateMeter
+	timestampOffset          uint32
+	lastSentTimestamp        uint32
+	lastSentTimestampTime    time.Time
+	// the timestamp offset before the last jump, and the publisher sequence number the current offset start from
+	previousTimestampOffset uint32
+	timestampOffsetSequence uint16
+	// the spatial and temporal layer counts for the other goroutines, the spatial count is in the second byte
+	layerCounts atomic.Uint32
+	// the subscriber id of the layer drop header extension, and the layer packets dropped since the last sent packet
+	layerDropExtensionID atomic.Uint32
+	layerDrops           uint16
+	// the keyframe interval of the thumbnail mode, and the RTP timestamp of the last keyframe forwarded in the mode
+	thumbnailInterval  atomic.Int64
+	thumbnailTimestamp uint32
+	hasThumbnail       bool
 }
 
 func newScaleableClientTrack(
 	c *Client,
 	t *Track,
-	qualityPreset QualityPreset,
+	packetCacheSize int,
+	packetCacheMaxAge time.Duration,
+	packetCacheWindow time.Duration,
+	packetQueueSize int,
 ) *scaleableClientTrack {
 	ctx, cancel := context.WithCancel(t.Context())
 
 	sct := &scaleableClientTrack{
-		context:               ctx,
-		cancel:                cancel,
-		mu:                    sync.RWMutex{},
-		id:                    t.base.id,
-		kind:                  t.base.kind,
-		mimeType:              t.base.codec.MimeType,
-		client:                c,
-		localTrack:            t.createLocalTrack(),
-		remoteTrack:           t,
-		isScreen:              t.IsScreen(),
-		onTrackEndedCallbacks: make([]func(), 0),
-		qualityPreset:         c.SFU().QualityPreset(),
-		maxQuality:            QualityHigh,
-		lastQuality:           QualityHigh,
-		packetCaches:          newPacketCaches(1024),
-		packetChan:            make(chan rtp.Packet, 1),
+		context:                  ctx,
+		cancel:                   cancel,
+		mu:                       sync.RWMutex{},
+		id:                       t.base.id,
+		kind:                     t.base.kind,
+		mimeType:                 t.base.codec.MimeType,
+		client:                   c,
+		localTrack:               t.createLocalTrack(),
+		remoteTrack:              t,
+		isScreen:                 t.IsScreen(),
+		onTrackEndedCallbacks:    make([]func(), 0),
+		onQualityChangeCallbacks: make([]func(old, new QualityLevel), 0),
+		qualityPreset:            c.SFU().CodecQualityPreset(t.MimeType()),
+		maxQuality:               QualityVeryHigh,
+		minQuality:               QualityNone,
+		priority:                 DefaultPriority,
+		lastQuality:              QualityHigh,
+		packetCaches:             newPacketCaches(packetCacheSize),
+		packetQueue:              newPacketQueue(packetQueueSize),
 	}
 
+	sct.forceKeyframeInterval.Store(int64(DefaultForceKeyframeInterval))
+	sct.packetCaches.setMaxAge(packetCacheMaxAge, sct.localTrack.Codec().ClockRate)
+	sct.packetCaches.setWindow(packetCacheWindow, sct.localTrack.Codec().ClockRate)
+
+	sct.startWriter()
+
 	return sct
 }
 
@@ -146,14 +303,79 @@ func (t *scaleableClientTrack) Context() context.Context {
 	return t.context
 }
 
-func (t *scaleableClientTrack) writeRTP(p rtp.Packet, isLate bool) {
-	t.lastTimestamp = p.Timestamp
+// startWriter write the queued packets to the local track, so a slow local track never block the track reader
+func (t *scaleableClientTrack) startWriter() {
+	go func() {
+		ctx, cancel := context.WithCancel(t.context)
+		defer cancel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				t.drain(trackDrainTimeout)
+				return
+			case <-t.packetQueue.signal:
+				for t.writeNext() {
+				}
+			}
+		}
+	}()
+}
+
+// writeNext write the oldest queued packet, it returns false if the queue is empty
+func (t *scaleableClientTrack) writeNext() bool {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	queued, ok := t.packetQueue.pop()
+	if !ok {
+		return false
+	}
+
+	t.writeRTP(queued.packet)
+
+	return true
+}
+
+// drain write the queued packets in order before the track is ended, so the decoder is not left with a partial frame
+// when the track is quickly re-subscribed. The packets still queued after the timeout are dropped.
+func (t *scaleableClientTrack) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	for t.writeNext() {
+		if time.Now().After(deadline) {
+			logger().Warn("scalabletrack: drain timeout, drop ", t.packetQueue.Len(), " queued packets of track ", t.id)
+			return
+		}
+	}
+}
 
+func (t *scaleableClientTrack) writeRTP(p rtp.Packet) {
 	if err := t.localTrack.WriteRTP(&p); err != nil {
-		glog.Error("track: error on write rtp", err)
+		logger().Error("track: error on write rtp", err)
 	}
 }
 
+func (t *scaleableClientTrack) Pause() {
+	t.paused.Store(true)
+	t.client.bitrateController.setPaused(t.ID(), true)
+}
+
+func (t *scaleableClientTrack) Resume() {
+	if !t.paused.Swap(false) {
+		return
+	}
+
+	t.client.bitrateController.setPaused(t.ID(), false)
+
+	// the client need a keyframe to decode the track after the gap
+	t.RequestPLI()
+}
+
+func (t *scaleableClientTrack) IsPaused() bool {
+	return t.paused.Load()
+}
+
 func (t *scaleableClientTrack) isKeyframe(vp9 *codecs.VP9Packet) bool {
 	if len(vp9.Payload) < 1 {
 		return false
@@ -176,8 +398,12 @@ func (t *scaleableClientTrack) isKeyframe(vp9 *codecs.VP9Packet) bool {
 // this where the temporal and spatial layers are will be decided to be sent to the client or not
 // compare it with the claimed quality to decide if the packet should be sent or not
 func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
-	// glog.Info("process interval: ", time.Since(t.lastProcessTime))
-	// t.lastProcessTime = time.Now()
+	t.markPacket(t.client.bitrateController.now())
+
+	if !t.client.bitrateController.exists(t.ID()) {
+		// do nothing if the track is unsubscribed
+		return
+	}
 
 	var isLate bool
 
@@ -186,47 +412,108 @@ func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
 	// packet sequence reset
 
 	// 65535,0,1,2,3
-	if t.sequenceNumber > p.SequenceNumber && t.sequenceNumber-p.SequenceNumber < 1000 {
-		// late packet or retransmission
-		glog.Info("scalabletrack: client ", t.client.id, " late packet ", p.SequenceNumber, " previously ", t.sequenceNumber)
+	// the gaps are calculated with uint16 arithmetic so the rollover is handled
+	forwardGap := p.SequenceNumber - t.sequenceNumber
+	backwardGap := t.sequenceNumber - p.SequenceNumber
+
+	if !t.hasSequenceNumber {
+		t.hasSequenceNumber = true
+		t.sequenceNumber = p.SequenceNumber
+	} else if backwardGap > 0 && backwardGap < sequenceJumpThreshold {
+		// late packet or retransmission, the per packet logs are only written in debug mode to keep the hot path cheap
+		isDebug := t.client.IsDebugEnabled()
+		if isDebug {
+			logger().Debug("scalabletrack: client ", t.client.id, " late packet ", p.SequenceNumber, " previously ", t.sequenceNumber)
+		}
+
 		isLate = true
 		_, hasSent := t.packetCaches.GetPacket(p.SequenceNumber)
 		if hasSent {
-			glog.Info("scalabletrack: packet ", p.SequenceNumber, " has been sent")
+			if isDebug {
+				logger().Debug("scalabletrack: packet ", p.SequenceNumber, " has been sent")
+			}
+
+			t.drops.dropsDuplicate.Add(1)
 			return
 		}
+
+		if !t.isSameTimeline(p) {
+			if isDebug {
+				logger().Debug("scalabletrack: late packet ", p.SequenceNumber, " is from before the sequence number reset")
+			}
+
+			t.drops.dropsStale.Add(1)
+			return
+		}
+	} else if forwardGap >= sequenceJumpThreshold && backwardGap >= sequenceJumpThreshold {
+		t.flushLayerEnd(true)
+		t.resetSequenceNumber(p.SequenceNumber)
 	} else {
 		t.sequenceNumber = p.SequenceNumber
 	}
 
-	var qualityPreset IQualityPreset
+	// the padding only packets are sent by the publisher to probe its uplink, they are useless for the subscriber.
+	// The late padding packet is behind the sent packets, only the next packets are shifted to close the gap.
+	if len(p.Payload) == 0 {
+		if !isLate {
+			t.dropCounter++
+		}
+
+		t.drops.dropsPadding.Add(1)
+
+		return
+	}
+
+	if t.mimeType == webrtc.MimeTypeH264 {
+		t.pushH264(p, isLate)
+		return
+	}
 
 	vp9Packet := &codecs.VP9Packet{}
 	if _, err := vp9Packet.Unmarshal(p.Payload); err != nil {
-		t.send(p, isLate)
+		t.flushLayerEnd(true)
+		t.send(p, isLate, false)
 		return
 	}
 
+	// a spatial layer frame that is not inter-picture predicted only depends on the lower layer,
+	// the track can switch up to the layer without requesting a keyframe
+	if vp9Packet.B && vp9Packet.SID > 0 && !vp9Packet.P {
+		t.lastUpswitchPointTime = time.Now()
+	}
+
 	if t.spatsialCount == 0 || t.temporalCount == 0 {
 		t.temporalCount = vp9Packet.NG + 1
 		t.spatsialCount = vp9Packet.NS + 1
+		t.layerCounts.Store(uint32(t.spatsialCount)<<8 | uint32(t.temporalCount))
 	}
 
 	quality := t.getQuality()
 
 	if quality == QualityNone {
+		t.flushLayerEnd(true)
+
+		if vp9Packet.SID == 0 && t.isThumbnailPacket(p.Timestamp, t.isKeyframe(vp9Packet), isLate) {
+			// only the base layer of the keyframe is forwarded, the layers are scaled up from it on resume
+			t.sid = 0
+			t.tid = 0
+
+			if vp9Packet.E {
+				p.Marker = true
+			}
+
+			t.send(p, isLate, true)
+
+			return
+		}
+
 		t.dropCounter++
+		t.layerDrops++
+		t.drops.dropsQualityNone.Add(1)
 		return
 	}
 
-	switch quality {
-	case QualityHigh:
-		qualityPreset = t.qualityPreset.High
-	case QualityMid:
-		qualityPreset = t.qualityPreset.Mid
-	case QualityLow:
-		qualityPreset = t.qualityPreset.Low
-	}
+	qualityPreset := t.getQualityPreset(quality)
 
 	isKeyframe := t.isKeyframe(vp9Packet)
 	if isKeyframe {
@@ -235,12 +522,29 @@ func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
 
 	// check if possible to scale up spatial layer
 	targetSID := qualityPreset.GetSID()
+
+	// the layer is scaled up one layer at a time on the frame that is not inter-picture predicted,
+	// so the frame only depends on the lower layers of the same picture that are already forwarded
+	isUpswitch := !isLate && vp9Packet.B && !vp9Packet.P && t.sid < targetSID && vp9Packet.SID == t.sid+1
+
+	if !isLate && t.pendingLayerEnd != nil {
+		t.flushLayerEnd(!isUpswitch || p.Timestamp != t.pendingLayerEnd.packet.Timestamp)
+	}
+
 	if vp9Packet.B && t.sid != targetSID {
-		if vp9Packet.SID == targetSID && !vp9Packet.P {
+		if isUpswitch {
+			t.sid++
+		} else if t.sid > targetSID && vp9Packet.SID == targetSID && !vp9Packet.P {
 			t.sid = targetSID
+		} else if t.sid < targetSID {
+			t.requestUpswitch()
 		}
 	}
 
+	if t.sid >= targetSID {
+		t.upswitchPendingSince = time.Time{}
+	}
+
 	// check if possible to scale up temporal layer
 	targetTID := qualityPreset.GetTID()
 	if vp9Packet.B && t.tid != targetTID {
@@ -253,6 +557,10 @@ func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
 		t.SetLastQuality(quality)
 	}
 
+	// while scaling up, the end of the current layer frame is held until the next packet tell
+	// whether the picture continue with the next layer, so the marker is only set at the end of the forwarded picture
+	isHeld := !isLate && vp9Packet.E && t.sid == vp9Packet.SID && t.sid < targetSID && !p.Marker
+
 	// mark packet as a last spatial layer packet
 	if vp9Packet.E && t.sid == vp9Packet.SID {
 		p.Marker = true
@@ -260,7 +568,7 @@ func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
 
 	// base layer
 	if vp9Packet.TID == 0 && vp9Packet.SID == 0 {
-		t.send(p, isLate)
+		t.sendOrHold(p, isLate, isKeyframe, isHeld)
 		return
 	}
 
@@ -270,17 +578,195 @@ func (t *scaleableClientTrack) push(p rtp.Packet, _ QualityLevel) {
 	// targeting a higher spatial layer to know that it can safely
 	// discard this packet's frame without processing it, without having
 	// to wait for the "D" bit in the higher-layer frame
-	if t.tid < vp9Packet.TID || t.sid < vp9Packet.SID || (t.sid > vp9Packet.SID && vp9Packet.Z) {
+	if t.tid < vp9Packet.TID {
+		t.dropCounter++
+		t.layerDrops++
+		t.drops.dropsTemporal.Add(1)
+
+		return
+	}
+
+	if t.sid < vp9Packet.SID || (t.sid > vp9Packet.SID && vp9Packet.Z) {
 		t.dropCounter++
+		t.layerDrops++
+		t.drops.dropsSpatial.Add(1)
 
 		return
 	}
 
 	// if p.Marker && t.client.isDebug {
-	// 	glog.Info("scalabletrack: marker is set, sid: ", vp9Packet.SID)
+	// 	logger().Info("scalabletrack: marker is set, sid: ", vp9Packet.SID)
 	// }
 
-	t.send(p, isLate)
+	t.sendOrHold(p, isLate, isKeyframe, isHeld)
+}
+
+func (t *scaleableClientTrack) sendOrHold(p rtp.Packet, isLate bool, isKeyframe bool, isHeld bool) {
+	if isHeld {
+		t.pendingLayerEnd = &queuedPacket{packet: p, isKeyframe: isKeyframe}
+		return
+	}
+
+	t.send(p, isLate, isKeyframe)
+}
+
+// flushLayerEnd send the held layer end packet, the marker is kept only if the picture is not continued with the next layer
+func (t *scaleableClientTrack) flushLayerEnd(isPictureEnd bool) {
+	if t.pendingLayerEnd == nil {
+		return
+	}
+
+	held := t.pendingLayerEnd
+	t.pendingLayerEnd = nil
+
+	held.packet.Marker = isPictureEnd
+	t.send(held.packet, false, held.isKeyframe)
+}
+
+// pushH264 is the H.264 version of push. H.264 only has temporal layers here, the temporal id is read
+// from the SVC prefix NAL and applied to all packets of the same frame. Frames without prefix NAL are
+// treated as the base layer.
+func (t *scaleableClientTrack) pushH264(p rtp.Packet, isLate bool) {
+	quality := t.getQuality()
+
+	if quality == QualityNone {
+		t.dropCounter++
+		t.layerDrops++
+		t.drops.dropsQualityNone.Add(1)
+		return
+	}
+
+	tid, hasTID, isKeyframe := parseH264Payload(p.Payload)
+	if isKeyframe {
+		go t.remoteTrack.KeyFrameReceived()
+	}
+
+	isNewFrame := p.Timestamp != t.h264FrameTimestamp
+	if isNewFrame {
+		t.h264FrameTimestamp = p.Timestamp
+		t.h264FrameTID = 0
+	}
+
+	if hasTID {
+		t.h264FrameTID = tid
+	}
+
+	// only switch the temporal layer on the frame boundary
+	targetTID := t.getQualityPreset(quality).GetTID()
+	if isNewFrame && t.tid != targetTID {
+		if isKeyframe || t.tid > targetTID || t.h264FrameTID == 0 {
+			t.tid = targetTID
+		}
+	}
+
+	if t.tid == targetTID {
+		t.SetLastQuality(quality)
+	}
+
+	if t.h264FrameTID > t.tid {
+		t.dropCounter++
+		t.layerDrops++
+		t.drops.dropsTemporal.Add(1)
+		return
+	}
+
+	t.send(p, isLate, isKeyframe)
+}
+
+// requestUpswitch request a keyframe if the stream doesn't provide the upswitch point. The stream could provide
+// the upswitch points of the lower layers only, so a keyframe is also requested every force keyframe interval
+// while the upswitch is pending. The PLI is debounced by the remote track like the other PLI requests.
+func (t *scaleableClientTrack) requestUpswitch() {
+	now := time.Now()
+	if t.upswitchPendingSince.IsZero() {
+		t.upswitchPendingSince = now
+	}
+
+	if interval := t.ForceKeyframeInterval(); interval > 0 && now.Sub(t.upswitchPendingSince) >= interval {
+		logger().Info("scalabletrack: upswitch is pending for ", now.Sub(t.upswitchPendingSince), ", force a keyframe")
+		t.upswitchPendingSince = now
+		t.RequestPLI()
+
+		return
+	}
+
+	if time.Since(t.lastUpswitchPointTime) < upswitchPointTimeout {
+		return
+	}
+
+	t.RequestPLI()
+}
+
+// SetForceKeyframeInterval set how long an upswitch wait for the upswitch point before a keyframe is requested,
+// set zero to only wait for the upswitch points provided by the stream
+func (t *scaleableClientTrack) SetForceKeyframeInterval(interval time.Duration) {
+	t.forceKeyframeInterval.Store(int64(interval))
+}
+
+func (t *scaleableClientTrack) ForceKeyframeInterval() time.Duration {
+	return time.Duration(t.forceKeyframeInterval.Load())
+}
+
+// SetThumbnailInterval enable the thumbnail mode, the base layer of a keyframe is still forwarded every interval
+// while the track quality is QualityNone, so the subscriber can show the track instantly when the quality is raised.
+// The mode is only supported for VP9. Set zero to disable it, the default.
+func (t *scaleableClientTrack) SetThumbnailInterval(interval time.Duration) {
+	t.thumbnailInterval.Store(int64(interval))
+}
+
+func (t *scaleableClientTrack) ThumbnailInterval() time.Duration {
+	return time.Duration(t.thumbnailInterval.Load())
+}
+
+// isThumbnailPacket return true if the packet belong to the keyframe forwarded in the thumbnail mode. A keyframe is
+// picked once the thumbnail interval has passed since the last picked keyframe, measured with the RTP timestamp.
+func (t *scaleableClientTrack) isThumbnailPacket(timestamp uint32, isKeyframe bool, isLate bool) bool {
+	interval := t.ThumbnailInterval()
+	if interval <= 0 || t.paused.Load() {
+		return false
+	}
+
+	if t.hasThumbnail && timestamp == t.thumbnailTimestamp {
+		return true
+	}
+
+	if !isKeyframe || isLate {
+		return false
+	}
+
+	// VP9 always use the 90kHz video clock
+	intervalTicks := uint32(interval.Seconds() * 90000)
+	if t.hasThumbnail && timestamp-t.thumbnailTimestamp < intervalTicks {
+		return false
+	}
+
+	t.hasThumbnail = true
+	t.thumbnailTimestamp = timestamp
+
+	return true
+}
+
+func (t *scaleableClientTrack) getQualityPreset(quality QualityLevel) IQualityPreset {
+	var preset IQualityPreset
+
+	switch quality {
+	case QualityVeryHigh:
+		return t.qualityPreset.VeryHigh
+	case QualityHigh:
+		return t.qualityPreset.High
+	case QualityMid:
+		preset = t.qualityPreset.Mid
+	default:
+		preset = t.qualityPreset.Low
+	}
+
+	// H.264 only has temporal layers, there is no other layer to choose
+	preference := t.client.LayerReductionPreference()
+	if preference == LayerReductionBalanced || t.mimeType == webrtc.MimeTypeH264 {
+		return preset
+	}
+
+	return reducePreset(t.qualityPreset.High, preset, preference)
 }
 
 func (t *scaleableClientTrack) getSequenceNumber(sequenceNumber uint16, isLate bool) uint16 {
@@ -295,33 +781,198 @@ func (t *scaleableClientTrack) getSequenceNumber(sequenceNumber uint16, isLate b
 	return normalizeSequenceNumber(sequenceNumber, t.dropCounter)
 }
 
+// isSameTimeline check the late packet timestamp against the cached packet that used to normalize its sequence number.
+// A late packet from before the sequence number reset would get the drop counter of the new timeline, so it is only
+// sent if the timestamps are close.
+func (t *scaleableClientTrack) isSameTimeline(p rtp.Packet) bool {
+	cached, ok := t.packetCaches.GetPacketOrBefore(p.SequenceNumber)
+	if !ok {
+		return true
+	}
+
+	// the timestamps are compared with uint32 arithmetic so the rollover is handled
+	return cached.timestamp-p.Timestamp <= lateTimestampThreshold || p.Timestamp-cached.timestamp <= lateTimestampThreshold
+}
+
 // functiont to normalize the sequence number in case the sequence is rollover
+// the uint16 arithmetic wrap around on rollover
 func normalizeSequenceNumber(sequence, drop uint16) uint16 {
-	if sequence > drop {
-		return sequence - drop
-	} else {
-		return 65535 - drop + sequence
-	}
+	return sequence - drop
+}
+
+// resetSequenceNumber is called when the publisher reset the sequence number, for example when the encoder is restarted
+// without changing the SSRC. The drop counter is re-based so the next packet continue from the last sent sequence number.
+func (t *scaleableClientTrack) resetSequenceNumber(sequenceNumber uint16) {
+	logger().Warn("scalabletrack: client ", t.client.id, " sequence number reset from ", t.sequenceNumber, " to ", sequenceNumber)
+
+	t.packetCaches.Reset()
+	t.dropCounter = sequenceNumber - t.lastSentSequenceNumber - 1
+	t.sequenceNumber = sequenceNumber
+
+	t.RequestPLI()
 }
 
-func (t *scaleableClientTrack) send(p rtp.Packet, isLate bool) {
+func (t *scaleableClientTrack) send(p rtp.Packet, isLate bool, isKeyframe bool) {
+	sequenceNumber := p.SequenceNumber
 	p.SequenceNumber = t.getSequenceNumber(p.SequenceNumber, isLate)
+	if !isLate {
+		t.lastSentSequenceNumber = p.SequenceNumber
+	}
+
+	if headerExtensions := t.headerExtensions.Load(); headerExtensions != nil {
+		headerExtensions.rewrite(&p)
+
+		// the hint is only added to the rewritten header, the original header is shared with the other subscribers
+		if id := uint8(t.layerDropExtensionID.Load()); id > 0 && !isLate && t.layerDrops > 0 {
+			setLayerDropHint(&p, id, t.layerDrops)
+		}
+	}
+
+	if !isLate {
+		t.layerDrops = 0
+	}
+
+	// only the first packet is detected as keyframe, the rest of the keyframe packets share the timestamp
+	if isKeyframe {
+		t.keyframeTimestamp = p.Timestamp
+	} else if !isLate && t.keyframeTimestamp != 0 && p.Timestamp == t.keyframeTimestamp {
+		isKeyframe = true
+	}
+
+	// cache the publisher sequence number, so the retransmitted and the late packets can be found in the cache
+	t.packetCaches.Push(sequenceNumber, p.Timestamp, sequenceNumber-p.SequenceNumber)
+
+	p.Timestamp = t.rewriteTimestamp(p.Timestamp, sequenceNumber, isLate, time.Now())
+
+	t.forwardedBitrate.add(p.MarshalSize(), time.Now())
+
+	if dropped := t.packetQueue.push(queuedPacket{packet: p, isKeyframe: isKeyframe}); dropped > 0 {
+		// the writer is too slow and the queued packets are dropped, the gap is left in the sequence
+		// so the cached sequence numbers of the queued packets stay valid for the late packets
+		t.drops.dropsCongestion.Add(uint64(dropped))
+	}
+
+	if !isLate && p.Marker {
+		t.sendPadding(p)
+	}
+}
+
+// rewriteTimestamp keep the forwarded timestamps continuous when the publisher timeline jump, for example when the
+// encoder is restarted. The jump is replaced with the wall clock time since the last forwarded timestamp, so the track
+// stay in sync with the audio. The spatial layers of a picture share the timestamp, a layer switch never move the offset.
+// The late packets from before the jump keep the previous offset.
+func (t *scaleableClientTrack) rewriteTimestamp(timestamp uint32, sequenceNumber uint16, isLate bool, now time.Time) uint32 {
+	if isLate {
+		if int16(sequenceNumber-t.timestampOffsetSequence) < 0 {
+			return timestamp + t.previousTimestampOffset
+		}
+
+		return timestamp + t.timestampOffset
+	}
+
+	rewritten := timestamp + t.timestampOffset
+
+	if t.lastSentTimestampTime.IsZero() {
+		t.lastSentTimestamp = rewritten
+		t.lastSentTimestampTime = now
+
+		return rewritten
+	}
+
+	diff := rewritten - t.lastSentTimestamp
+	if diff > lateTimestampThreshold && -diff > lateTimestampThreshold {
+		elapsed := uint32(now.Sub(t.lastSentTimestampTime).Seconds() * float64(t.localTrack.Codec().ClockRate))
+		target := t.lastSentTimestamp + max(elapsed, 1)
+
+		logger().Warn("scalabletrack: timestamp jump ", int32(diff), " on track ", t.id, ", continue from ", target)
+
+		t.previousTimestampOffset = t.timestampOffset
+		t.timestampOffsetSequence = sequenceNumber
+		t.timestampOffset += target - rewritten
+		rewritten = target
+		diff = rewritten - t.lastSentTimestamp
+	}
+
+	// the reordered packet of the older picture doesn't move the last timestamp back
+	if diff != 0 && diff < 1<<31 {
+		t.lastSentTimestamp = rewritten
+		t.lastSentTimestampTime = now
+	}
 
-	t.packetCaches.Push(p.SequenceNumber, p.Timestamp, t.dropCounter)
-	t.writeRTP(p, isLate)
+	return rewritten
+}
+
+// setPaddingBudget set the padding bytes to send until the next budget is set
+func (t *scaleableClientTrack) setPaddingBudget(bytes int) {
+	t.paddingBudget.Store(int64(bytes))
+}
+
+// sendPadding send the padding only packets after the end of the forwarded picture. The padding take the next
+// sequence numbers and the drop counter is moved back, so the next media packets continue after the padding.
+// Nothing is sent while the writer is busy, the padding is only useful when the link is idle.
+func (t *scaleableClientTrack) sendPadding(last rtp.Packet) {
+	budget := t.paddingBudget.Load()
+	sent := int64(0)
+
+	for i := 0; i < maxPaddingPacketsPerPicture && budget-sent >= paddingPacketSize; i++ {
+		if t.packetQueue.Len() >= t.packetQueue.size/2 {
+			break
+		}
+
+		t.dropCounter--
+		t.lastSentSequenceNumber++
+
+		t.packetQueue.push(queuedPacket{packet: rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Padding:        true,
+				PayloadType:    last.PayloadType,
+				SequenceNumber: t.lastSentSequenceNumber,
+				Timestamp:      last.Timestamp,
+				SSRC:           last.SSRC,
+			},
+			PaddingSize: paddingPacketSize,
+		}})
+
+		sent += paddingPacketSize
+	}
+
+	if sent > 0 {
+		t.paddingBudget.Add(-sent)
+	}
+}
+
+func (t *scaleableClientTrack) resetPacketCaches() {
+	t.packetCaches.Reset()
+}
+
+// setSubscriberHeaderExtensions map the publisher header extensions to the header extensions negotiated with the subscriber
+func (t *scaleableClientTrack) setSubscriberHeaderExtensions(subscriber []webrtc.RTPHeaderExtensionParameter) {
+	headerExtensions := newHeaderExtensionMap(t.remoteTrack.base.headerExtensions, subscriber)
+	t.layerDropExtensionID.Store(uint32(headerExtensionID(subscriber, LayerDropURI)))
+	t.headerExtensions.Store(&headerExtensions)
+}
+
+// DropStats return the number of packets dropped by the track grouped by the reason
+func (t *scaleableClientTrack) DropStats() DropStats {
+	return DropStats{
+		QualityNone: t.drops.dropsQualityNone.Load(),
+		Spatial:     t.drops.dropsSpatial.Load(),
+		Temporal:    t.drops.dropsTemporal.Load(),
+		Duplicate:   t.drops.dropsDuplicate.Load(),
+		Stale:       t.drops.dropsStale.Load(),
+		Congestion:  t.drops.dropsCongestion.Load(),
+		Padding:     t.drops.dropsPadding.Load(),
+	}
 }
 
 func (t *scaleableClientTrack) RemoteTrack() *remoteTrack {
 	return t.remoteTrack.remoteTrack
 }
 
+// getCurrentBitrate return the bitrate of the forwarded layers, the dropped layers and the padding are not counted
 func (t *scaleableClientTrack) getCurrentBitrate() uint32 {
-	currentTrack := t.RemoteTrack()
-	if currentTrack == nil {
-		return 0
-	}
-
-	return currentTrack.GetCurrentBitrate()
+	return t.forwardedBitrate.bitrate(time.Now())
 }
 
 func (t *scaleableClientTrack) ID() string {
@@ -344,11 +995,31 @@ func (t *scaleableClientTrack) SetSourceType(sourceType TrackType) {
 	t.isScreen = (sourceType == TrackTypeScreen)
 }
 
+// SetLastQuality store the delivered quality and notify the quality change callbacks on transition
 func (t *scaleableClientTrack) SetLastQuality(quality QualityLevel) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	old := t.lastQuality
+	if old == quality {
+		t.mu.Unlock()
+		return
+	}
 
 	t.lastQuality = quality
+	callbacks := make([]func(old, new QualityLevel), len(t.onQualityChangeCallbacks))
+	copy(callbacks, t.onQualityChangeCallbacks)
+	t.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(old, quality)
+	}
+}
+
+// OnQualityChange register a callback that called when the quality delivered to the client is changed
+func (t *scaleableClientTrack) OnQualityChange(callback func(old, new QualityLevel)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onQualityChangeCallbacks = append(t.onQualityChangeCallbacks, callback)
 }
 
 func (t *scaleableClientTrack) LastQuality() QualityLevel {
@@ -364,11 +1035,16 @@ func (t *scaleableClientTrack) OnTrackEnded(callback func()) {
 	t.onTrackEndedCallbacks = append(t.onTrackEndedCallbacks, callback)
 }
 
+// onTrackEnded flush the queued packets before the track context is cancelled and the callbacks are called
 func (t *scaleableClientTrack) onTrackEnded() {
 	if t.isEnded {
 		return
 	}
 
+	t.flushLayerEnd(true)
+	t.drain(trackDrainTimeout)
+	t.cancel()
+
 	for _, callback := range t.onTrackEndedCallbacks {
 		callback()
 	}
@@ -376,19 +1052,74 @@ func (t *scaleableClientTrack) onTrackEnded() {
 	t.isEnded = true
 }
 
+// SetMaxQuality set the viewport quality of the track, a keyframe is only requested when the forwarded quality can increase
 func (t *scaleableClientTrack) SetMaxQuality(quality QualityLevel) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	maxDecodeQuality := t.client.MaxDecodeQuality()
 
+	t.mu.Lock()
+	previous := min(t.maxQuality, maxDecodeQuality)
 	t.maxQuality = quality
-	t.RemoteTrack().sendPLI()
+	lastQuality := QualityLevel(t.lastQuality)
+	t.mu.Unlock()
+
+	if maxQualityNeedKeyframe(t.client, previous, min(quality, maxDecodeQuality), lastQuality) {
+		t.RemoteTrack().sendPLI()
+	}
 }
 
+// MaxQuality return the lower quality between the viewport quality set with SetMaxQuality and the client max decode quality
 func (t *scaleableClientTrack) MaxQuality() QualityLevel {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	return t.maxQuality
+	return min(t.maxQuality, t.client.MaxDecodeQuality())
+}
+
+// SetMinQuality set the quality floor of the track. The bitrate controller will never reduce the track
+// below this quality, it will reduce the other tracks first when the bandwidth is not enough.
+// A keyframe is only requested when the floor raise the forwarded quality.
+func (t *scaleableClientTrack) SetMinQuality(quality QualityLevel) {
+	claim := t.client.bitrateController.GetClaim(t.ID())
+	if claim == nil || t.paused.Load() {
+		t.mu.Lock()
+		t.minQuality = quality
+		t.mu.Unlock()
+
+		return
+	}
+
+	previous := t.claimQuality(claim)
+
+	t.mu.Lock()
+	t.minQuality = quality
+	t.mu.Unlock()
+
+	if t.claimQuality(claim) > previous {
+		t.RemoteTrack().sendPLI()
+	}
+}
+
+func (t *scaleableClientTrack) MinQuality() QualityLevel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.minQuality
+}
+
+// SetPriority set the weight of the track when the bandwidth is distributed between tracks,
+// the track with higher weight get more bandwidth and reduced last
+func (t *scaleableClientTrack) SetPriority(weight int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.priority = validPriority(weight)
+}
+
+func (t *scaleableClientTrack) Priority() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.priority
 }
 
 func (t *scaleableClientTrack) IsSimulcast() bool {
@@ -399,17 +1130,57 @@ func (t *scaleableClientTrack) IsScaleable() bool {
 	return true
 }
 
+// AvailableQualities return the qualities which the preset layers are encoded by the publisher,
+// the quality with the same layers as the lower quality is skipped
+func (t *scaleableClientTrack) AvailableQualities() []QualityLevel {
+	layerCounts := t.layerCounts.Load()
+	spatialCount := uint8(layerCounts >> 8)
+	temporalCount := uint8(layerCounts)
+
+	qualities := make([]QualityLevel, 0, 4)
+
+	var lastPreset IQualityPreset
+
+	for quality := QualityLevel(QualityLow); quality <= t.client.SFU().HighestQuality(); quality = higherQuality(quality) {
+		preset := t.getQualityPreset(quality)
+		if preset.GetSID() >= spatialCount || preset.GetTID() >= temporalCount {
+			continue
+		}
+
+		if lastPreset != nil && preset.GetSID() == lastPreset.GetSID() && preset.GetTID() == lastPreset.GetTID() {
+			continue
+		}
+
+		qualities = append(qualities, quality)
+		lastPreset = preset
+	}
+
+	return qualities
+}
+
 func (t *scaleableClientTrack) RequestPLI() {
 	t.remoteTrack.remoteTrack.sendPLI()
 }
 
 func (t *scaleableClientTrack) getQuality() QualityLevel {
+	// the paused track is dropped like the QualityNone to keep the sequence number continuous on resume
+	if t.paused.Load() {
+		return QualityNone
+	}
+
 	claim := t.client.bitrateController.GetClaim(t.ID())
 
 	if claim == nil {
-		glog.Warning("scalabletrack: claim is nil")
+		logger().Warn("scalabletrack: claim is nil")
 		return QualityNone
 	}
 
-	return min(t.MaxQuality(), claim.Quality(), Uint32ToQualityLevel(t.client.quality.Load()))
+	return t.claimQuality(claim)
+}
+
+// claimQuality return the quality of the track that is forwarded for the claim, the quality floor never override the ceiling
+func (t *scaleableClientTrack) claimQuality(claim *bitrateClaim) QualityLevel {
+	ceiling := min(t.MaxQuality(), t.client.QualityCeiling())
+
+	return max(min(claim.Quality(), ceiling), min(claim.minQuality(), ceiling))
 }
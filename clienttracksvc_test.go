@@ -6,12 +6,16 @@ import (
 	"io"
 	"path"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/inlivedev/sfu/pkg/interceptors/playoutdelay"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 )
@@ -28,8 +32,8 @@ func TestSVCPacketDropSequence(t *testing.T) {
 	require.NoError(t, err, "error creating room: %v", err)
 	ctx := testRoom.sfu.context
 
-	_, _, trackChanReceiver, connected := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, "sender", false)
-	createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, "receiver", false)
+	_, _, trackChanReceiver, connected := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "sender", false)
+	createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "receiver", false)
 
 	timeout, cancelTimeout := context.WithTimeout(ctx, 60*time.Second)
 	defer cancelTimeout()
@@ -73,11 +77,14 @@ func TestSVCPacketDropSequence(t *testing.T) {
 	}
 }
 
-func createPeerSVC(ctx context.Context, room *Room, iceServers []webrtc.ICEServer, peerName string, isLoop bool) (*webrtc.PeerConnection, *Client, chan *webrtc.TrackRemote, chan bool) {
-	var (
-		client      *Client
-		mediaEngine *webrtc.MediaEngine = GetMediaEngine()
-	)
+// createPeerSVC create the peer with the codecs registered in the media engine, the media engine must register VP9.
+// The SFU media engine is used if the media engine is nil.
+func createPeerSVC(ctx context.Context, room *Room, iceServers []webrtc.ICEServer, mediaEngine *webrtc.MediaEngine, peerName string, isLoop bool) (*webrtc.PeerConnection, *Client, chan *webrtc.TrackRemote, chan bool) {
+	var client *Client
+
+	if mediaEngine == nil {
+		mediaEngine = GetMediaEngine()
+	}
 
 	i := &interceptor.Registry{}
 
@@ -202,3 +209,1349 @@ func sendPackets(ctx context.Context, track *webrtc.TrackLocalStaticRTP) {
 
 	}
 }
+
+func TestH264TemporalLayerDrop(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeH264, &remoteTrack{})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// prefix NAL with the temporal id in the third extension byte, followed by the slice
+	stapA := func(tid uint8, sliceType byte) []byte {
+		prefix := []byte{0x6e, 0x80, 0x00, tid << 5}
+		slice := []byte{sliceType, 0x88, 0x84}
+		payload := []byte{0x18}
+		payload = append(payload, 0x00, byte(len(prefix)))
+		payload = append(payload, prefix...)
+		payload = append(payload, 0x00, byte(len(slice)))
+		payload = append(payload, slice...)
+		return payload
+	}
+
+	sequence := uint16(1)
+	pushFrame := func(tid uint8, sliceType byte) {
+		// each frame has two packets, the second one belongs to the frame without the prefix NAL
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: stapA(tid, sliceType)}, QualityLow)
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence + 1, Timestamp: uint32(sequence) * 3000, Marker: true}, Payload: []byte{0x41, 0x9a, 0x00}}, QualityLow)
+		sequence += 2
+	}
+
+	// keyframe on base layer, then alternate the enhancement and base layer
+	pushFrame(0, 0x65)
+	pushFrame(1, 0x41)
+	pushFrame(0, 0x41)
+	pushFrame(1, 0x41)
+
+	// the enhancement layer frames are dropped on low quality
+	require.Equal(t, uint16(4), track.dropCounter)
+
+	client.bitrateController.setQuality(track.ID(), QualityHigh)
+
+	pushFrame(0, 0x41)
+	pushFrame(1, 0x41)
+
+	require.Equal(t, uint16(4), track.dropCounter)
+	require.Equal(t, QualityLevel(QualityHigh), track.LastQuality())
+}
+
+func TestParseH264Payload(t *testing.T) {
+	t.Parallel()
+
+	// single IDR NAL
+	_, hasTID, isKeyframe := parseH264Payload([]byte{0x65, 0x88})
+	require.False(t, hasTID)
+	require.True(t, isKeyframe)
+
+	// FU-A start of IDR
+	_, hasTID, isKeyframe = parseH264Payload([]byte{0x7c, 0x85, 0x88})
+	require.False(t, hasTID)
+	require.True(t, isKeyframe)
+
+	// FU-A continuation is not a keyframe
+	_, _, isKeyframe = parseH264Payload([]byte{0x7c, 0x05, 0x88})
+	require.False(t, isKeyframe)
+
+	// prefix NAL with temporal id 2
+	tid, hasTID, isKeyframe := parseH264Payload([]byte{0x6e, 0x80, 0x00, 0x40})
+	require.True(t, hasTID)
+	require.Equal(t, uint8(2), tid)
+	require.False(t, isKeyframe)
+
+	// blank frame contain an IDR
+	_, _, isKeyframe = parseH264Payload(getH264BlankFrame())
+	require.True(t, isKeyframe)
+}
+
+// newTestScaleableClientTrack create a scaleable client track that is not connected to any peer connection
+func newTestScaleableClientTrack(t *testing.T, client *Client, mimeType string, rt *remoteTrack) *scaleableClientTrack {
+	track := newTestScaleableClientTrackWithoutWriter(t, client, mimeType, rt)
+	track.startWriter()
+
+	return track
+}
+
+// newTestScaleableClientTrackWithoutWriter return a track that keep the sent packets in the packet queue
+func newTestScaleableClientTrackWithoutWriter(t *testing.T, client *Client, mimeType string, rt *remoteTrack) *scaleableClientTrack {
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000}, "video", "stream-video")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(client.context)
+	t.Cleanup(cancel)
+
+	track := &scaleableClientTrack{
+		id:            "video",
+		context:       ctx,
+		cancel:        cancel,
+		client:        client,
+		kind:          webrtc.RTPCodecTypeVideo,
+		mimeType:      mimeType,
+		localTrack:    localTrack,
+		remoteTrack:   &Track{remoteTrack: rt},
+		qualityPreset: DefaultQualityPreset(),
+		maxQuality:    QualityHigh,
+		minQuality:    QualityNone,
+		priority:      DefaultPriority,
+		lastQuality:   QualityHigh,
+		packetCaches:  newPacketCaches(1024),
+		packetQueue:   newPacketQueue(DefaultPacketQueueSize),
+	}
+
+	return track
+}
+
+// vp9Payload build a VP9 payload descriptor with layer indices and a non keyframe payload
+func vp9Payload(sid, tid uint8, interPredicted, switchingUp bool) []byte {
+	descriptor := byte(0x20 | 0x08 | 0x04) // L, B, E
+	if interPredicted {
+		descriptor |= 0x40
+	}
+
+	layer := tid<<5 | sid<<1
+	if switchingUp {
+		layer |= 0x10
+	}
+
+	return []byte{descriptor, layer, 0x00, 0x00, 0x00}
+}
+
+func TestSVCUpswitchWithoutPLI(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		upswitchPoint bool
+		expectedPLI   bool
+		expectedSID   uint8
+	}{
+		{name: "stream provide upswitch point", upswitchPoint: true, expectedPLI: false, expectedSID: 1},
+		{name: "stream without upswitch point", upswitchPoint: false, expectedPLI: true, expectedSID: 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+			pliCount := &atomic.Int32{}
+			rt := &remoteTrack{
+				pliDebounceWindow: DefaultPLIDebounceWindow,
+				onPLI: func() {
+					pliCount.Add(1)
+				},
+			}
+
+			track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, rt)
+
+			_, err := client.bitrateController.addClaim(track, QualityLow, true)
+			require.NoError(t, err)
+
+			sequence := uint16(1)
+			pushSuperframe := func() {
+				track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+				track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence + 1, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(1, 0, !tc.upswitchPoint, true)}, QualityLow)
+				sequence += 2
+			}
+
+			pushSuperframe()
+			pushSuperframe()
+
+			client.bitrateController.setQuality(track.ID(), QualityMid)
+
+			pushSuperframe()
+
+			require.Equal(t, tc.expectedPLI, pliCount.Load() > 0)
+			require.Equal(t, tc.expectedSID, track.sid)
+		})
+	}
+}
+
+func TestSVCDrainOnTrackEnded(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// the writer is busy, the packets are still queued when the track is ended
+	for i := uint16(0); i < 5; i++ {
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 100 + i, Timestamp: 3000}, Payload: vp9Payload(0, 0, false, false)}, QualityLow)
+	}
+
+	require.Equal(t, 5, track.packetQueue.Len())
+
+	ended := false
+	track.OnTrackEnded(func() {
+		// the queued packets are written before the callbacks are called
+		require.Equal(t, 0, track.packetQueue.Len())
+		ended = true
+	})
+
+	track.onTrackEnded()
+
+	require.True(t, ended)
+	require.ErrorIs(t, track.Context().Err(), context.Canceled)
+}
+
+func TestSVCTimestampContinuity(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// the forwarded packets are taken from the queue after each picture, so the queue never drop them
+	timestamps := make([]uint32, 0)
+	collect := func() {
+		for {
+			queued, ok := track.packetQueue.pop()
+			if !ok {
+				return
+			}
+
+			if len(timestamps) == 0 || timestamps[len(timestamps)-1] != queued.packet.Timestamp {
+				timestamps = append(timestamps, queued.packet.Timestamp)
+			}
+		}
+	}
+
+	sequence := uint16(0)
+	pushPictures := func(from, to int, timestampBase uint32) {
+		for picture := from; picture < to; picture++ {
+			for sid := uint8(0); sid < 3; sid++ {
+				sequence++
+				track.push(rtp.Packet{
+					Header: rtp.Header{
+						SequenceNumber: sequence,
+						Timestamp:      timestampBase + uint32(picture)*3000,
+						Marker:         sid == 2,
+					},
+					// the upper layers provide the upswitch points
+					Payload: vp9Payload(sid, 0, sid == 0 && picture > 0, true),
+				}, QualityLow)
+			}
+
+			collect()
+		}
+	}
+
+	// switch the spatial layer up and down mid-stream
+	pushPictures(0, 10, 0)
+	client.bitrateController.setQuality(track.ID(), QualityHigh)
+	pushPictures(10, 20, 0)
+	require.Equal(t, uint8(2), track.sid)
+	client.bitrateController.setQuality(track.ID(), QualityLow)
+	pushPictures(20, 30, 0)
+
+	// the publisher restart the encoder with a new timeline
+	pushPictures(30, 40, 1<<30)
+
+	require.Len(t, timestamps, 40)
+
+	for i := 1; i < len(timestamps); i++ {
+		diff := timestamps[i] - timestamps[i-1]
+		if i == 30 {
+			// the jump is replaced with the wall clock time since the last picture
+			require.Greater(t, diff, uint32(0))
+			require.Less(t, diff, uint32(3000))
+
+			continue
+		}
+
+		require.Equal(t, uint32(3000), diff, "picture %d", i)
+	}
+}
+
+func TestSVCLatePacketTimestampBeforeJump(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	now := time.Now()
+
+	// the packet 5 is delayed
+	for sequence := uint16(1); sequence <= 10; sequence++ {
+		if sequence != 5 {
+			require.Equal(t, uint32(sequence)*3000, track.rewriteTimestamp(uint32(sequence)*3000, sequence, false, now))
+		}
+	}
+
+	// the publisher timeline jump, the offset is moved
+	jumped := track.rewriteTimestamp(1<<30, 11, false, now.Add(33*time.Millisecond))
+	require.NotEqual(t, uint32(1<<30), jumped)
+
+	// the late packet from before the jump keep the previous offset, the late packet after the jump use the new one
+	require.Equal(t, uint32(5*3000), track.rewriteTimestamp(5*3000, 5, true, now))
+	require.Equal(t, jumped+3000, track.rewriteTimestamp(1<<30+3000, 12, true, now))
+}
+
+func TestSVCForceKeyframeInterval(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	pliCount := &atomic.Int32{}
+	rt := &remoteTrack{
+		pliDebounceWindow: 10 * time.Millisecond,
+		onPLI: func() {
+			pliCount.Add(1)
+		},
+	}
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, rt)
+
+	_, err := client.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+	require.NoError(t, client.SetTrackForceKeyframeInterval(track.ID(), 100*time.Millisecond))
+	require.ErrorIs(t, client.SetTrackForceKeyframeInterval("unknown", time.Second), ErrTrackNotFound)
+
+	// the stream provide the upswitch point of the first spatial layer only, the third layer is always inter predicted
+	sequence := uint16(1)
+	pushSuperframe := func() {
+		for sid := uint8(0); sid < 3; sid++ {
+			track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(sid, 0, sid != 1, true)}, QualityMid)
+			sequence++
+		}
+	}
+
+	// the first superframe request a keyframe because there was no upswitch point seen yet
+	pushSuperframe()
+	require.Equal(t, uint8(1), track.sid)
+	pliCount.Store(0)
+
+	client.bitrateController.setQuality(track.ID(), QualityHigh)
+
+	// the upswitch point of the lower layer is recent, so no keyframe is requested yet
+	pushSuperframe()
+	require.Equal(t, int32(0), pliCount.Load())
+
+	// the upswitch is pending too long, a keyframe is forced once per interval
+	time.Sleep(150 * time.Millisecond)
+	pushSuperframe()
+	pushSuperframe()
+	require.Equal(t, int32(1), pliCount.Load())
+	require.Equal(t, uint8(1), track.sid)
+}
+
+func TestSVCOnQualityChange(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	type transition struct {
+		old, new QualityLevel
+	}
+
+	transitions := make([]transition, 0)
+	track.OnQualityChange(func(old, new QualityLevel) {
+		// make sure the callback is not called while holding the track lock
+		require.Equal(t, new, track.LastQuality())
+		transitions = append(transitions, transition{old, new})
+	})
+
+	// base layer packets switch the delivered quality from the initial high to low
+	track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 3000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+	track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 2, Timestamp: 6000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+
+	require.Equal(t, []transition{{QualityHigh, QualityLow}}, transitions)
+
+	track.SetLastQuality(QualityMid)
+	track.SetLastQuality(QualityMid)
+
+	require.Equal(t, []transition{{QualityHigh, QualityLow}, {QualityLow, QualityMid}}, transitions)
+}
+
+func TestSVCSequenceNumberReset(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	pliCount := &atomic.Int32{}
+	rt := &remoteTrack{
+		pliDebounceWindow: DefaultPLIDebounceWindow,
+		onPLI: func() {
+			pliCount.Add(1)
+		},
+	}
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, rt)
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	lastSent := uint16(0)
+	pushPackets := func(start uint16, count int) {
+		for i := 0; i < count; i++ {
+			sequence := start + uint16(i)
+			track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+
+			cached := track.packetCaches.caches.Back().Value.(cachedPacket)
+			sent := cached.sequence - cached.dropCounter
+			if lastSent != 0 {
+				require.Equal(t, lastSent+1, sent, "output sequence is not monotonic on input ", sequence)
+			}
+
+			lastSent = sent
+		}
+	}
+
+	// rollover is not a reset
+	pushPackets(65530, 10)
+	require.Equal(t, int32(0), pliCount.Load())
+
+	// the publisher restarted the encoder and the sequence jump backward
+	pushPackets(40000, 10)
+	require.Equal(t, int32(1), pliCount.Load())
+
+	pushPackets(5, 10)
+	require.Equal(t, int32(1), pliCount.Load(), "the PLI is debounced")
+}
+
+func TestSVCStaleLatePacket(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	push := func(sequence uint16, timestamp uint32) {
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: timestamp}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+	}
+
+	for i := uint16(0); i < 10; i++ {
+		push(10000+i, 1_000_000+uint32(i)*3000)
+	}
+
+	// the publisher restarted the encoder with a new sequence number and timestamp
+	for i := uint16(0); i < 10; i++ {
+		push(20000+i, 500_000_000+uint32(i)*3000)
+	}
+
+	sent := make([]uint16, 0)
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		sent = append(sent, queued.packet.SequenceNumber)
+	}
+
+	require.Len(t, sent, 20)
+
+	// a packet of the old timeline is delayed, its sequence number look like a late packet of the new timeline
+	push(19995, 1_000_000+10*3000)
+	_, ok := track.packetQueue.pop()
+	require.False(t, ok, "the stale packet must not be sent with the sequence number of the new timeline")
+	require.Equal(t, uint64(1), track.DropStats().Stale)
+
+	// a late packet of the new timeline is still sent
+	push(20011, 500_000_000+11*3000)
+	push(20010, 500_000_000+10*3000)
+
+	queued, ok := track.packetQueue.pop()
+	require.True(t, ok)
+	require.Equal(t, sent[len(sent)-1]+2, queued.packet.SequenceNumber)
+
+	queued, ok = track.packetQueue.pop()
+	require.True(t, ok)
+	require.Equal(t, sent[len(sent)-1]+1, queued.packet.SequenceNumber)
+}
+
+func TestSVCDropStats(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	sequence := uint16(0)
+	push := func(sid, tid uint8) {
+		sequence++
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(sid, tid, true, false)}, QualityLow)
+	}
+
+	// the low quality only forward the base layer
+	push(0, 0)
+	push(0, 1)
+	push(0, 2)
+	push(1, 0)
+	push(2, 0)
+	push(0, 0)
+
+	// retransmission of the sent packet
+	track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 3000}, Payload: vp9Payload(0, 0, true, false)}, QualityLow)
+
+	// the track is paused
+	client.bitrateController.setQuality(track.ID(), QualityNone)
+	push(0, 0)
+	push(0, 0)
+	push(0, 0)
+
+	require.Equal(t, DropStats{
+		QualityNone: 3,
+		Spatial:     2,
+		Temporal:    2,
+		Duplicate:   1,
+	}, track.DropStats())
+}
+
+func TestCodecQualityPreset(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	// VP9 publishers only encode two spatial layers, the H264 publishers only encode temporal layers
+	vp9Preset := DefaultQualityPreset()
+	vp9Preset.High.SID = 1
+	vp9Preset.Mid.SID = 0
+
+	h264Preset := DefaultQualityPreset()
+	h264Preset.High.SID = 0
+	h264Preset.Mid.SID = 0
+
+	client.sfu.codecQualityPresets = validCodecQualityPresets(map[string]QualityPreset{
+		webrtc.MimeTypeVP9: vp9Preset,
+		"video/h264":       h264Preset,
+	})
+
+	require.Equal(t, DefaultQualityPreset(), client.SFU().CodecQualityPreset(webrtc.MimeTypeVP8))
+
+	for mimeType, preset := range map[string]QualityPreset{
+		webrtc.MimeTypeVP9:  vp9Preset,
+		webrtc.MimeTypeH264: h264Preset,
+	} {
+		relay := NewTrackRelay("video-"+mimeType, "stream", "", webrtc.RTPCodecTypeVideo, 1, mimeType, make(chan *rtp.Packet))
+		track := newTrack(client.context, "publisher", relay, nil, 0, 0, func() {}, nackOptions{}, nil, nil).(*Track)
+
+		clientTrack := newScaleableClientTrack(client, track, DefaultPacketCacheSize, 0, 0, DefaultPacketQueueSize)
+		require.Equal(t, preset, clientTrack.qualityPreset, mimeType)
+	}
+}
+
+func TestSVCPaddingDropped(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	sequence := uint16(0)
+	pushMedia := func() {
+		sequence++
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, 0, true, false)}, QualityLow)
+	}
+
+	// padding only packets lose their payload after unmarshal
+	pushPadding := func() {
+		sequence++
+		raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, Padding: true, SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, PaddingSize: 200}).Marshal()
+		require.NoError(t, err)
+
+		p := rtp.Packet{}
+		require.NoError(t, p.Unmarshal(raw))
+		track.push(p, QualityLow)
+	}
+
+	pushMedia()
+	pushPadding()
+	pushPadding()
+	pushMedia()
+	pushPadding()
+	pushMedia()
+
+	var sent []rtp.Packet
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		sent = append(sent, queued.packet)
+	}
+
+	require.Len(t, sent, 3)
+	for i, p := range sent {
+		require.NotEmpty(t, p.Payload)
+		if i > 0 {
+			require.Equal(t, sent[i-1].SequenceNumber+1, p.SequenceNumber)
+		}
+	}
+
+	require.Equal(t, uint64(3), track.DropStats().Padding)
+}
+
+// vp9LayerPayload build a VP9 payload descriptor of a spatial layer frame packet
+func vp9LayerPayload(sid uint8, interPredicted, begin, end bool) []byte {
+	descriptor := byte(0x20) // L
+	if interPredicted {
+		descriptor |= 0x40
+	}
+
+	if begin {
+		descriptor |= 0x08
+	}
+
+	if end {
+		descriptor |= 0x04
+	}
+
+	return []byte{descriptor, sid << 1, 0x00, 0x00, 0x00}
+}
+
+func TestSVCLayerSwitchOnPictureBoundary(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	sequence := uint16(0)
+	pushPicture := func(timestamp uint32, upswitchPoint bool) {
+		for sid := uint8(0); sid < 2; sid++ {
+			interPredicted := sid == 0 || !upswitchPoint
+			for i := 0; i < 2; i++ {
+				sequence++
+				track.push(rtp.Packet{
+					Header: rtp.Header{
+						SequenceNumber: sequence,
+						Timestamp:      timestamp,
+						// the publisher mark the end of the picture
+						Marker: sid == 1 && i == 1,
+					},
+					Payload: vp9LayerPayload(sid, interPredicted, i == 0, i == 1),
+				}, QualityLow)
+			}
+		}
+	}
+
+	pushPicture(3000, false)
+
+	client.bitrateController.setQuality(track.ID(), QualityMid)
+
+	// the upper layer is inter-picture predicted, the track can't switch yet
+	pushPicture(6000, false)
+	pushPicture(9000, true)
+	pushPicture(12000, false)
+	// the next picture release the held packet of the previous picture
+	pushPicture(15000, false)
+
+	type sentPacket struct {
+		timestamp uint32
+		sid       uint8
+		marker    bool
+	}
+
+	sent := make([]sentPacket, 0)
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		vp9Packet := &codecs.VP9Packet{}
+		_, err := vp9Packet.Unmarshal(queued.packet.Payload)
+		require.NoError(t, err)
+
+		sent = append(sent, sentPacket{queued.packet.Timestamp, vp9Packet.SID, queued.packet.Marker})
+	}
+
+	require.Equal(t, []sentPacket{
+		{3000, 0, false}, {3000, 0, true},
+		{6000, 0, false}, {6000, 0, true},
+		// the switch happen in the middle of the picture, the lower layer end is not marked as the end of the picture
+		{9000, 0, false}, {9000, 0, false}, {9000, 1, false}, {9000, 1, true},
+		{12000, 0, false}, {12000, 0, false}, {12000, 1, false}, {12000, 1, true},
+		{15000, 0, false}, {15000, 0, false}, {15000, 1, false}, {15000, 1, true},
+	}, sent)
+
+	require.Equal(t, uint8(1), track.sid)
+	require.Nil(t, track.pendingLayerEnd)
+}
+
+func TestSVCHeaderExtensionForwarding(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	track.remoteTrack.base.headerExtensions = []webrtc.RTPHeaderExtensionParameter{
+		{URI: sdp.TransportCCURI, ID: 1},
+		{URI: AbsCaptureTimeURI, ID: 3},
+		{URI: playoutdelay.PlayoutDelayURI, ID: 5},
+	}
+
+	// the subscriber negotiated different ids
+	track.setSubscriberHeaderExtensions([]webrtc.RTPHeaderExtensionParameter{
+		{URI: playoutdelay.PlayoutDelayURI, ID: 2},
+		{URI: sdp.TransportCCURI, ID: 4},
+		{URI: AbsCaptureTimeURI, ID: 7},
+	})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	absCaptureTime, err := rtp.NewAbsCaptureTimeExtensionWithCaptureClockOffset(time.Unix(1700000000, 0), 25*time.Millisecond).Marshal()
+	require.NoError(t, err)
+
+	playoutDelay, err := playoutdelay.PlayoutDelayFromValue(100, 400).Marshal()
+	require.NoError(t, err)
+
+	transportCC, err := (&rtp.TransportCCExtension{TransportSequence: 10}).Marshal()
+	require.NoError(t, err)
+
+	packets := make([]rtp.Packet, 0)
+
+	for i := uint16(0); i < 3; i++ {
+		p := rtp.Packet{
+			Header: rtp.Header{
+				SequenceNumber: 100 + i,
+				Timestamp:      3000 * uint32(i+1),
+				Marker:         true,
+			},
+			Payload: vp9Payload(0, 0, i > 0, false),
+		}
+
+		require.NoError(t, p.Header.SetExtension(1, transportCC))
+		require.NoError(t, p.Header.SetExtension(3, absCaptureTime))
+		require.NoError(t, p.Header.SetExtension(5, playoutDelay))
+
+		packets = append(packets, p)
+		track.push(p, QualityLow)
+	}
+
+	for i := range packets {
+		queued, ok := track.packetQueue.pop()
+		require.True(t, ok)
+
+		require.ElementsMatch(t, []uint8{2, 7}, queued.packet.Header.GetExtensionIDs())
+
+		captureTime := &rtp.AbsCaptureTimeExtension{}
+		require.NoError(t, captureTime.Unmarshal(queued.packet.Header.GetExtension(7)))
+		require.Equal(t, time.Unix(1700000000, 0).UnixNano(), captureTime.CaptureTime().UnixNano())
+		require.NotNil(t, captureTime.EstimatedCaptureClockOffsetDuration())
+		require.Equal(t, 25*time.Millisecond, captureTime.EstimatedCaptureClockOffsetDuration().Round(time.Millisecond))
+
+		delay := &playoutdelay.PlayOutDelay{}
+		require.NoError(t, delay.Unmarshal(queued.packet.Header.GetExtension(2)))
+		require.Equal(t, playoutdelay.PlayOutDelay{Min: 100, Max: 400}, *delay)
+
+		// the packet keep the publisher sequence and the published packet is not modified
+		require.Equal(t, packets[i].SequenceNumber, queued.packet.SequenceNumber)
+		require.Equal(t, []uint8{1, 3, 5}, packets[i].Header.GetExtensionIDs())
+	}
+
+	// the marshalled packet carry the rewritten extensions
+	p := packets[0]
+	track.headerExtensions.Load().rewrite(&p)
+	buf, err := p.Marshal()
+	require.NoError(t, err)
+
+	unmarshalled := &rtp.Packet{}
+	require.NoError(t, unmarshalled.Unmarshal(buf))
+	require.Equal(t, playoutDelay, unmarshalled.Header.GetExtension(2))
+	require.Equal(t, absCaptureTime, unmarshalled.Header.GetExtension(7))
+}
+
+func TestVideoOrientationForwarding(t *testing.T) {
+	t.Parallel()
+
+	publisherExtensions := []webrtc.RTPHeaderExtensionParameter{
+		{URI: sdp.SDESMidURI, ID: 1},
+		{URI: VideoOrientationURI, ID: 3},
+	}
+
+	subscriberExtensions := []webrtc.RTPHeaderExtensionParameter{
+		{URI: VideoOrientationURI, ID: 9},
+	}
+
+	// the camera is rotated 90 degrees
+	rotation := []byte{0x01}
+
+	newPacket := func(sequenceNumber uint16, payload []byte) rtp.Packet {
+		p := rtp.Packet{
+			Header: rtp.Header{
+				SequenceNumber: sequenceNumber,
+				Timestamp:      3000 * uint32(sequenceNumber),
+				Marker:         true,
+			},
+			Payload: payload,
+		}
+
+		require.NoError(t, p.Header.SetExtension(1, []byte("0")))
+		require.NoError(t, p.Header.SetExtension(3, rotation))
+
+		return p
+	}
+
+	t.Run("scaleable", func(t *testing.T) {
+		client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+		track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+		track.remoteTrack.base.headerExtensions = publisherExtensions
+		track.setSubscriberHeaderExtensions(subscriberExtensions)
+
+		_, err := client.bitrateController.addClaim(track, QualityLow, true)
+		require.NoError(t, err)
+
+		for i := uint16(0); i < 3; i++ {
+			track.push(newPacket(100+i, vp9Payload(0, 0, i > 0, false)), QualityLow)
+
+			queued, ok := track.packetQueue.pop()
+			require.True(t, ok)
+			require.Equal(t, []uint8{9}, queued.packet.Header.GetExtensionIDs())
+			require.Equal(t, rotation, queued.packet.Header.GetExtension(9))
+		}
+	})
+
+	t.Run("simulcast", func(t *testing.T) {
+		track := &simulcastClientTrack{
+			remoteTrack: &SimulcastTrack{base: &baseTrack{headerExtensions: publisherExtensions}},
+		}
+		track.setSubscriberHeaderExtensions(subscriberExtensions)
+
+		// the layers are sent with the same publisher ids
+		for _, p := range []rtp.Packet{newPacket(100, []byte{0x00}), newPacket(5000, []byte{0x00})} {
+			track.headerExtensions.Load().rewrite(&p)
+
+			buf, err := p.Marshal()
+			require.NoError(t, err)
+
+			forwarded := &rtp.Packet{}
+			require.NoError(t, forwarded.Unmarshal(buf))
+			require.Equal(t, []uint8{9}, forwarded.Header.GetExtensionIDs())
+			require.Equal(t, rotation, forwarded.Header.GetExtension(9))
+		}
+	})
+}
+
+func TestSVCProbePadding(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+
+	client := newTestClient(t, 10_000_000)
+	bc := client.bitrateController
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	claim, err := bc.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	sequenceNumber := uint16(100)
+	pushPicture := func() {
+		track.push(rtp.Packet{
+			Header: rtp.Header{
+				SequenceNumber: sequenceNumber,
+				Timestamp:      3000 * uint32(sequenceNumber),
+				Marker:         true,
+			},
+			Payload: vp9Payload(0, 0, sequenceNumber > 100, false),
+		}, QualityLow)
+		sequenceNumber++
+	}
+
+	popAll := func() []rtp.Packet {
+		packets := make([]rtp.Packet, 0)
+		for {
+			queued, ok := track.packetQueue.pop()
+			if !ok {
+				return packets
+			}
+
+			packets = append(packets, queued.packet)
+		}
+	}
+
+	// no padding while the claim is not probing
+	bc.updateProbePadding(time.Now())
+	pushPicture()
+	require.Len(t, popAll(), 1)
+
+	bc.startProbe(claim, client.GetEstimatedBandwidth())
+	require.True(t, claim.isProbing())
+
+	// the gap to the probe bitrate is filled with padding after the picture
+	bc.updateProbePadding(time.Now())
+	expectedBytes := int(float64(bitrates.VideoMid-bitrates.VideoLow) * probeBitrateFraction * probePaddingInterval.Seconds() / 8)
+	require.Equal(t, expectedBytes, bc.probePaddingBytes(claim, time.Now()))
+
+	pushPicture()
+	packets := popAll()
+	require.Len(t, packets, 1+min(expectedBytes/paddingPacketSize, maxPaddingPacketsPerPicture))
+	require.False(t, packets[0].Padding)
+
+	for i, p := range packets[1:] {
+		require.True(t, p.Padding)
+		require.Empty(t, p.Payload)
+		require.Equal(t, byte(paddingPacketSize), p.PaddingSize)
+		require.Equal(t, packets[0].SequenceNumber+uint16(i+1), p.SequenceNumber)
+
+		buf, err := p.Marshal()
+		require.NoError(t, err)
+		require.Len(t, buf, 12+paddingPacketSize)
+	}
+
+	// the media continue after the padding
+	lastSequenceNumber := packets[len(packets)-1].SequenceNumber
+	bc.updateProbePadding(time.Now().Add(maxProbePaddingDuration + time.Second))
+	pushPicture()
+	packets = popAll()
+	require.Len(t, packets, 1)
+	require.Equal(t, lastSequenceNumber+1, packets[0].SequenceNumber)
+}
+
+func TestSVCForwardedBitrate(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	forwardedBytes, totalBytes := 0, 0
+	sequence := uint16(0)
+
+	// every picture has 3 spatial layers with an upswitch point, the temporal layer cycle 0, 2, 1, 2
+	// and the mid quality forward up to S1T1
+	for picture := 0; picture < 20; picture++ {
+		tid := []uint8{0, 2, 1, 2}[picture%4]
+		for sid := uint8(0); sid < 3; sid++ {
+			sequence++
+			p := rtp.Packet{
+				Header: rtp.Header{
+					SequenceNumber: sequence,
+					Timestamp:      uint32(picture) * 3000,
+					Marker:         sid == 2,
+				},
+				Payload: append(vp9Payload(sid, tid, picture > 0, true), make([]byte, 1000)...),
+			}
+
+			totalBytes += p.MarshalSize()
+			if sid <= 1 && tid <= 1 {
+				forwardedBytes += p.MarshalSize()
+			}
+
+			track.push(p, QualityMid)
+		}
+	}
+
+	forwardedBitrate := uint32(forwardedBytes * 8)
+	require.InDelta(t, forwardedBitrate, track.getCurrentBitrate(), float64(forwardedBitrate)*0.05)
+	require.Less(t, track.getCurrentBitrate(), uint32(totalBytes*8)/2)
+
+	// the measurement expire after the window
+	require.Zero(t, track.forwardedBitrate.bitrate(time.Now().Add(2*bitrateMeterWindow)))
+}
+
+func TestSVCLayerDropHint(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	track.setSubscriberHeaderExtensions([]webrtc.RTPHeaderExtensionParameter{{URI: LayerDropURI, ID: 5}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// the low quality only forward the base temporal layer
+	for i, tid := range []uint8{0, 2, 1, 2, 0, 0} {
+		sequence := uint16(i + 1)
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, tid, i > 0, false)}, QualityLow)
+	}
+
+	var sent []rtp.Packet
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		sent = append(sent, queued.packet)
+	}
+
+	require.Len(t, sent, 3)
+	require.Equal(t, uint64(3), track.DropStats().Temporal)
+
+	// the sequence numbers are continuous, only the packet after the dropped layer packets carry the hint
+	require.Nil(t, sent[0].GetExtension(5))
+	require.Equal(t, sent[0].SequenceNumber+1, sent[1].SequenceNumber)
+	require.Equal(t, []byte{3}, sent[1].GetExtension(5))
+	require.Equal(t, sent[1].SequenceNumber+1, sent[2].SequenceNumber)
+	require.Nil(t, sent[2].GetExtension(5))
+
+	// the hint is not added if the subscriber doesn't negotiate the extension
+	track.setSubscriberHeaderExtensions(nil)
+
+	for i, tid := range []uint8{1, 0} {
+		sequence := uint16(i + 7)
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, tid, true, false)}, QualityLow)
+	}
+
+	queued, ok := track.packetQueue.pop()
+	require.True(t, ok)
+	require.False(t, queued.packet.Extension)
+}
+
+func TestSVCThumbnailMode(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	client.bitrateController.setQuality(track.ID(), QualityNone)
+
+	var sequence uint16
+
+	push := func(timestamp uint32, payload []byte) {
+		sequence++
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: timestamp}, Payload: payload}, QualityNone)
+	}
+
+	// 3 seconds of 30 fps pictures with two spatial layers and a keyframe every 15 pictures, the base layer keyframe is
+	// split into two packets
+	pushPictures := func() {
+		for i := 0; i < 90; i++ {
+			timestamp := uint32(i+1) * 3000
+			if i%15 != 0 {
+				push(timestamp, vp9Payload(0, 0, true, false))
+				continue
+			}
+
+			first := vp9Payload(0, 0, false, false)
+			first[0] &^= 0x04 // not the end of the layer frame
+			first[3] = 0x80   // keyframe header
+
+			second := vp9Payload(0, 0, false, false)
+			second[0] &^= 0x08 // not the beginning of the layer frame
+
+			push(timestamp, first)
+			push(timestamp, second)
+			push(timestamp, vp9Payload(1, 0, true, false))
+		}
+	}
+
+	popAll := func() []rtp.Packet {
+		sent := make([]rtp.Packet, 0)
+		for {
+			queued, ok := track.packetQueue.pop()
+			if !ok {
+				return sent
+			}
+
+			sent = append(sent, queued.packet)
+		}
+	}
+
+	// the thumbnail mode is opt-in
+	pushPictures()
+	require.Empty(t, popAll())
+
+	track.SetThumbnailInterval(time.Second)
+	pushPictures()
+
+	// only the base layer of a keyframe every second is forwarded
+	sent := popAll()
+	require.Len(t, sent, 6)
+
+	for i := 0; i < len(sent); i += 2 {
+		require.Equal(t, sent[i].Timestamp, sent[i+1].Timestamp)
+		require.False(t, sent[i].Marker)
+		require.True(t, sent[i+1].Marker)
+		require.Equal(t, sent[i].SequenceNumber+1, sent[i+1].SequenceNumber)
+
+		if i > 0 {
+			require.Equal(t, uint32(90000), sent[i].Timestamp-sent[i-2].Timestamp)
+		}
+	}
+
+	// every packet of the two passes is dropped except the forwarded keyframe packets
+	require.Equal(t, uint64(2*(84+6*3)-6), track.DropStats().QualityNone)
+}
+
+func TestLayerReductionPreference(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		preference  LayerReductionPreference
+		expectedMid layerPreset
+		expectedLow layerPreset
+	}{
+		{preference: LayerReductionBalanced, expectedMid: layerPreset{sid: 1, tid: 1}, expectedLow: layerPreset{sid: 0, tid: 0}},
+		{preference: LayerReductionTemporalFirst, expectedMid: layerPreset{sid: 2, tid: 0}, expectedLow: layerPreset{sid: 0, tid: 0}},
+		{preference: LayerReductionSpatialFirst, expectedMid: layerPreset{sid: 0, tid: 2}, expectedLow: layerPreset{sid: 0, tid: 0}},
+	}
+
+	for _, tc := range testCases {
+		client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+		client.SetLayerReductionPreference(tc.preference)
+
+		track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+		// the high quality is never reduced
+		require.Equal(t, uint8(2), track.getQualityPreset(QualityHigh).GetSID())
+		require.Equal(t, uint8(2), track.getQualityPreset(QualityHigh).GetTID())
+
+		for quality, expected := range map[QualityLevel]layerPreset{QualityMid: tc.expectedMid, QualityLow: tc.expectedLow} {
+			preset := track.getQualityPreset(quality)
+			require.Equal(t, expected, layerPreset{sid: preset.GetSID(), tid: preset.GetTID()})
+		}
+	}
+
+	// the temporal first decrease keep the spatial layer and only forward the base temporal layer
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.SetLayerReductionPreference(LayerReductionTemporalFirst)
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+
+	_, err := client.bitrateController.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	sequence := uint16(0)
+	pushSuperframe := func(tid uint8, isKeyframe bool) {
+		for sid := uint8(0); sid < 3; sid++ {
+			sequence++
+
+			payload := vp9Payload(sid, tid, !isKeyframe && sid == 0, false)
+			if isKeyframe && sid == 0 {
+				payload[3] = 0x80 // keyframe header
+			}
+
+			track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: payload}, QualityHigh)
+		}
+	}
+
+	pushSuperframe(0, true)
+	require.Equal(t, uint8(2), track.sid)
+	require.Equal(t, uint8(2), track.tid)
+
+	for {
+		if _, ok := track.packetQueue.pop(); !ok {
+			break
+		}
+	}
+
+	client.bitrateController.setQuality(track.ID(), QualityMid)
+
+	for _, tid := range []uint8{0, 2, 1, 2, 0, 2, 1, 2} {
+		pushSuperframe(tid, false)
+	}
+
+	sids := make(map[uint8]int)
+
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		vp9Packet := &codecs.VP9Packet{}
+		_, err := vp9Packet.Unmarshal(queued.packet.Payload)
+		require.NoError(t, err)
+		require.Equal(t, uint8(0), vp9Packet.TID)
+
+		sids[vp9Packet.SID]++
+	}
+
+	require.Equal(t, map[uint8]int{0: 2, 1: 2, 2: 2}, sids)
+	require.Equal(t, uint8(2), track.sid)
+	require.Equal(t, uint8(0), track.tid)
+}
+
+func TestPayloadTypeRemapping(t *testing.T) {
+	t.Parallel()
+
+	roomID := roomManager.CreateRoomID()
+	testRoom, err := roomManager.NewRoom(roomID, "test-room", RoomTypeLocal, DefaultRoomOptions())
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	// the subscriber only support VP9 with a payload type that is different from the publisher
+	subscriberPT := webrtc.PayloadType(120)
+	subscriberEngine := &webrtc.MediaEngine{}
+	require.NoError(t, subscriberEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        subscriberPT,
+	}, webrtc.RTPCodecTypeVideo))
+	require.NoError(t, subscriberEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio))
+
+	publisherPC, _, _, publisherConnected := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, nil, "publisher", true)
+	_, _, trackChan, _ := createPeerSVC(ctx, testRoom, []webrtc.ICEServer{}, subscriberEngine, "subscriber", true)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 60*time.Second)
+	defer cancelTimeout()
+
+	select {
+	case state := <-publisherConnected:
+		require.True(t, state, "publisher not connected")
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for connection")
+		return
+	}
+
+	var track *webrtc.TrackRemote
+	select {
+	case track = <-trackChan:
+	case <-timeout.Done():
+		require.Fail(t, "timeout waiting for track")
+		return
+	}
+
+	publisherPT := publisherPC.GetSenders()[0].GetParameters().Codecs[0].PayloadType
+	require.NotEqual(t, subscriberPT, publisherPT)
+
+	// the forwarded packets carry the payload type negotiated with the subscriber
+	require.Equal(t, subscriberPT, track.PayloadType())
+
+	for i := 0; i < 10; i++ {
+		p, _, err := track.ReadRTP()
+		require.NoError(t, err)
+		require.Equal(t, uint8(subscriberPT), p.PayloadType)
+	}
+}
+
+func TestSVCMinQualityKeyframe(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	plis := &atomic.Int32{}
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {
+		plis.Add(1)
+	}})
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	// the floor doesn't change the forwarded quality
+	track.SetMinQuality(QualityLow)
+	require.Equal(t, int32(0), plis.Load())
+
+	// the floor raise the forwarded quality, a keyframe of the new quality is needed
+	track.SetMinQuality(QualityMid)
+	require.Equal(t, QualityLevel(QualityMid), track.getQuality())
+	require.Equal(t, int32(1), plis.Load())
+
+	track.SetMinQuality(QualityLow)
+	require.Equal(t, QualityLevel(QualityLow), track.getQuality())
+	require.Equal(t, int32(1), plis.Load())
+
+	// the floor never override the client quality ceiling
+	client.SetQualityCeiling(QualityLow)
+	sent := plis.Load()
+
+	track.SetMinQuality(QualityHigh)
+	require.Equal(t, QualityLevel(QualityLow), track.getQuality())
+	require.Equal(t, sent, plis.Load())
+}
+
+func TestSVCVeryHighQualityForwarded(t *testing.T) {
+	t.Parallel()
+
+	bitrates := DefaultBitrates()
+	veryHigh := 2 * bitrates.VideoHigh
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.bitrateController.useBandwidthEstimation = false
+	require.NoError(t, client.SFU().SetVeryHighQualityBitrate(veryHigh))
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	// the publisher send a fourth spatial layer for the very high quality
+	track.qualityPreset.VeryHigh = QualityVeryHighPreset{SID: 3, TID: 2}
+	track.SetMaxQuality(QualityVeryHigh)
+
+	claim, err := client.bitrateController.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the default client ceiling follow the SFU highest quality, the claim is increased and forwarded at the very high quality
+	client.bitrateController.fitBitratesToBandwidth(veryHigh + bitrates.AudioHeadroom + 10_000)
+	require.Equal(t, QualityLevel(QualityVeryHigh), claim.Quality())
+	require.Equal(t, QualityLevel(QualityVeryHigh), track.getQuality())
+
+	forwarded := make(map[uint16]bool)
+	veryHighSequences := make([]uint16, 0)
+	sequence := uint16(0)
+
+	for picture := 0; picture < 5; picture++ {
+		for sid := uint8(0); sid < 4; sid++ {
+			sequence++
+			if sid == 3 {
+				veryHighSequences = append(veryHighSequences, sequence)
+			}
+
+			track.push(rtp.Packet{
+				Header: rtp.Header{
+					SequenceNumber: sequence,
+					Timestamp:      uint32(picture) * 3000,
+					Marker:         sid == 3,
+				},
+				Payload: vp9Payload(sid, 0, picture > 0, true),
+			}, QualityVeryHigh)
+		}
+
+		for {
+			queued, ok := track.packetQueue.pop()
+			if !ok {
+				break
+			}
+
+			forwarded[queued.packet.SequenceNumber] = true
+		}
+	}
+
+	// the packets are not renumbered when no layer is dropped, the fourth spatial layer is forwarded
+	for _, sequence := range veryHighSequences {
+		require.True(t, forwarded[sequence], "very high layer packet %d is not forwarded", sequence)
+	}
+}
@@ -200,6 +200,71 @@ func getH264BlankFrame() []byte {
 	return buf[:offset]
 }
 
+const (
+	h264NALUTypeIDR         = 5
+	h264NALUTypePrefix      = 14
+	h264NALUTypeSliceExt    = 20
+	h264NALUTypeSTAPA       = 24
+	h264NALUTypeFUA         = 28
+	h264NALUTypeBitmask     = 0x1F
+	h264FUAStartBitmask     = 0x80
+	h264SVCExtensionLength  = 3
+	h264TemporalIDBitOffset = 5
+)
+
+// parseH264Payload inspect the NAL units inside the H.264 RTP payload. It return the temporal id
+// from the SVC prefix NAL (type 14) or the extension slice (type 20) if exists, and whether the payload contains an IDR slice.
+func parseH264Payload(payload []byte) (tid uint8, hasTID bool, isKeyframe bool) {
+	if len(payload) < 1 {
+		return 0, false, false
+	}
+
+	parseNAL := func(nal []byte) {
+		if len(nal) < 1 {
+			return
+		}
+
+		switch nal[0] & h264NALUTypeBitmask {
+		case h264NALUTypeIDR:
+			isKeyframe = true
+		case h264NALUTypePrefix, h264NALUTypeSliceExt:
+			if len(nal) > h264SVCExtensionLength {
+				tid = nal[h264SVCExtensionLength] >> h264TemporalIDBitOffset
+				hasTID = true
+			}
+		}
+	}
+
+	switch payload[0] & h264NALUTypeBitmask {
+	case h264NALUTypeSTAPA:
+		offset := 1
+		for offset+2 <= len(payload) {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+			if offset+size > len(payload) {
+				break
+			}
+
+			parseNAL(payload[offset : offset+size])
+			offset += size
+		}
+	case h264NALUTypeFUA:
+		if len(payload) < 2 || payload[1]&h264FUAStartBitmask == 0 {
+			return
+		}
+
+		// rebuild the NAL header from the FU indicator and the FU header
+		nal := make([]byte, len(payload)-1)
+		copy(nal, payload[1:])
+		nal[0] = (payload[0] &^ h264NALUTypeBitmask) | (payload[1] & h264NALUTypeBitmask)
+		parseNAL(nal)
+	default:
+		parseNAL(payload)
+	}
+
+	return
+}
+
 // reuse from pion media engine and media sample
 func payloaderForCodec(codec webrtc.RTPCodecCapability) (rtp.Payloader, error) {
 	switch strings.ToLower(codec.MimeType) {
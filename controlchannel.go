@@ -0,0 +1,69 @@
+package sfu
+
+import (
+	"encoding/json"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// InternalDataChannelLabel is the data channel created by the SFU to exchange the stats and the voice activity
+	InternalDataChannelLabel = "internal"
+	// ControlDataChannelLabel is the data channel created by the SFU to receive the commands from the subscriber
+	ControlDataChannelLabel = "sfu-ctrl"
+)
+
+// controlCommand is a message received on the control data channel, only one of the commands is set on each message.
+// Example: {"setQuality":{"trackID":"track-1","quality":"mid"}}
+type controlCommand struct {
+	SetQuality *controlSetQuality `json:"setQuality,omitempty"`
+}
+
+type controlSetQuality struct {
+	TrackID string `json:"trackID"`
+	Quality string `json:"quality"`
+}
+
+func isReservedDataChannelLabel(label string) bool {
+	return label == InternalDataChannelLabel || label == ControlDataChannelLabel
+}
+
+// qualityFromName return the quality level of the quality name used in the control commands
+func qualityFromName(name string) (QualityLevel, bool) {
+	switch name {
+	case "none":
+		return QualityNone, true
+	case "low":
+		return QualityLow, true
+	case "mid":
+		return QualityMid, true
+	case "high":
+		return QualityHigh, true
+	case "veryhigh":
+		return QualityVeryHigh, true
+	default:
+		return QualityNone, false
+	}
+}
+
+// onControlMessage handle the command sent by the subscriber, the malformed commands are logged and ignored
+func (c *Client) onControlMessage(msg webrtc.DataChannelMessage) {
+	var command controlCommand
+
+	if err := json.Unmarshal(msg.Data, &command); err != nil {
+		logger().Error("client: error unmarshal control message ", err)
+		return
+	}
+
+	if command.SetQuality != nil {
+		quality, ok := qualityFromName(command.SetQuality.Quality)
+		if !ok {
+			logger().Error("client: invalid quality in control message ", command.SetQuality.Quality)
+			return
+		}
+
+		if err := c.SetTrackMaxQuality(command.SetQuality.TrackID, quality); err != nil {
+			logger().Error("client: error set track quality from control message ", command.SetQuality.TrackID, " ", err)
+		}
+	}
+}
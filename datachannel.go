@@ -3,19 +3,31 @@ package sfu
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v3"
 )
 
 var (
-	ErrDataChannelExists = errors.New("error: data channel already exists")
+	ErrDataChannelExists   = errors.New("error: data channel already exists")
+	ErrDataChannelReserved = errors.New("error: data channel label is reserved by the SFU")
 )
 
+// DefaultDataChannelMessageTTL is how long a relayed message wait for the target data channel to open before dropped
+const DefaultDataChannelMessageTTL = 5 * time.Second
+
 type SFUDataChannel struct {
-	label     string
-	clientIDs []string
-	isOrdered bool
+	label       string
+	clientIDs   []string
+	isOrdered   bool
+	messageTTL  time.Duration
+	history     *dataChannelHistory
+	idleTimeout time.Duration
+	// the unix nano time of the last message relayed on the data channel
+	lastActivity atomic.Int64
+	// the relayed messages that are queued or being sent to the clients
+	inFlight atomic.Int32
 }
 
 type SFUDataChannelList struct {
@@ -26,6 +38,12 @@ type SFUDataChannelList struct {
 type DataChannelOptions struct {
 	Ordered   bool
 	ClientIDs []string // empty means all clients
+	// MessageTTL is how long a message is buffered while the target data channel is not open yet.
+	// The message is dropped if the data channel doesn't open within the TTL, default is DefaultDataChannelMessageTTL
+	MessageTTL time.Duration
+	// IdleTimeout close the private data channel and remove it from the clients when no message is relayed on it
+	// for this long, 0 never close it. It is ignored on the public data channels.
+	IdleTimeout time.Duration
 }
 
 type Data struct {
@@ -36,16 +54,175 @@ type Data struct {
 }
 
 type DataChannelList struct {
-	dataChannels map[string]*webrtc.DataChannel
-	mu           sync.Mutex
+	dataChannels    map[string]*webrtc.DataChannel
+	pendingMessages map[string]*pendingMessages
+	onOpenCallbacks map[string][]func()
+	mu              sync.Mutex
+}
+
+type pendingMessage struct {
+	data       []byte
+	enqueuedAt time.Time
+	persistent bool
+}
+
+// pendingMessages buffer the messages for a data channel that is not open yet.
+// The messages older than the TTL are dropped, so a stale message is never delivered when the channel is opened later.
+type pendingMessages struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	messages []pendingMessage
+}
+
+func newPendingMessages(ttl time.Duration) *pendingMessages {
+	if ttl == 0 {
+		ttl = DefaultDataChannelMessageTTL
+	}
+
+	return &pendingMessages{
+		mu:       sync.Mutex{},
+		ttl:      ttl,
+		messages: make([]pendingMessage, 0),
+	}
+}
+
+// push buffer the message and schedule the expired messages removal
+func (p *pendingMessages) push(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, pendingMessage{
+		data:       data,
+		enqueuedAt: time.Now(),
+	})
+
+	time.AfterFunc(p.ttl, p.dropExpired)
+}
+
+func (p *pendingMessages) dropExpired() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeExpired(time.Now())
+}
+
+// pushPersistent buffer the message that is never expired, used to replay the history to a new client
+func (p *pendingMessages) pushPersistent(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, pendingMessage{
+		data:       data,
+		enqueuedAt: time.Now(),
+		persistent: true,
+	})
+}
+
+func (p *pendingMessages) removeExpired(now time.Time) {
+	messages := p.messages[:0]
+	for _, message := range p.messages {
+		if message.persistent || now.Sub(message.enqueuedAt) < p.ttl {
+			messages = append(messages, message)
+		}
+	}
+
+	if dropped := len(p.messages) - len(messages); dropped > 0 {
+		logger().Warn("datachannel: drop ", dropped, " expired messages")
+	}
+
+	p.messages = messages
+}
+
+// flush return the messages that are not expired yet and empty the buffer
+func (p *pendingMessages) flush() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeExpired(time.Now())
+
+	data := make([][]byte, 0, len(p.messages))
+	for _, message := range p.messages {
+		data = append(data, message.data)
+	}
+
+	p.messages = p.messages[:0]
+
+	return data
+}
+
+func (p *pendingMessages) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.messages)
+}
+
+// dataChannelHistory keep the last messages of a public data channel to replay them to the clients that join later
+type dataChannelHistory struct {
+	mu       sync.Mutex
+	size     int
+	messages []Data
+}
+
+func newDataChannelHistory(size int) *dataChannelHistory {
+	return &dataChannelHistory{
+		mu:       sync.Mutex{},
+		size:     size,
+		messages: make([]Data, 0, size),
+	}
+}
+
+func (h *dataChannelHistory) add(data Data) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.messages) == h.size {
+		h.messages = append(h.messages[:0], h.messages[1:]...)
+	}
+
+	h.messages = append(h.messages, data)
+}
+
+// Messages return the buffered messages from the oldest to the latest
+func (h *dataChannelHistory) Messages() []Data {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messages := make([]Data, len(h.messages))
+	copy(messages, h.messages)
+
+	return messages
 }
 
 func NewSFUDataChannel(label string, opts DataChannelOptions) *SFUDataChannel {
-	return &SFUDataChannel{
-		label:     label,
-		clientIDs: opts.ClientIDs,
-		isOrdered: opts.Ordered,
+	dc := &SFUDataChannel{
+		label:      label,
+		clientIDs:  opts.ClientIDs,
+		isOrdered:  opts.Ordered,
+		messageTTL: opts.MessageTTL,
+	}
+
+	if len(opts.ClientIDs) > 0 {
+		dc.idleTimeout = opts.IdleTimeout
+	}
+
+	dc.touch(time.Now())
+
+	return dc
+}
+
+// touch mark the data channel as used at the time
+func (s *SFUDataChannel) touch(now time.Time) {
+	s.lastActivity.Store(now.UnixNano())
+}
+
+// idleDuration return how long the data channel has no relayed message, zero while a message is still in flight
+func (s *SFUDataChannel) idleDuration(now time.Time) time.Duration {
+	if s.inFlight.Load() > 0 {
+		return 0
 	}
+
+	return now.Sub(time.Unix(0, s.lastActivity.Load()))
 }
 
 func (s *SFUDataChannel) ClientIDs() []string {
@@ -56,6 +233,14 @@ func (s *SFUDataChannel) IsOrdered() bool {
 	return s.isOrdered
 }
 
+func (s *SFUDataChannel) MessageTTL() time.Duration {
+	return s.messageTTL
+}
+
+func (s *SFUDataChannel) IdleTimeout() time.Duration {
+	return s.idleTimeout
+}
+
 func NewSFUDataChannelList() *SFUDataChannelList {
 	return &SFUDataChannelList{
 		dataChannels: make(map[string]*SFUDataChannel),
@@ -93,23 +278,33 @@ func (s *SFUDataChannelList) Get(label string) *SFUDataChannel {
 
 func DefaultDataChannelOptions() DataChannelOptions {
 	return DataChannelOptions{
-		Ordered:   true,
-		ClientIDs: []string{},
+		Ordered:    true,
+		ClientIDs:  []string{},
+		MessageTTL: DefaultDataChannelMessageTTL,
 	}
 }
 
 func NewDataChannelList() *DataChannelList {
 	return &DataChannelList{
-		dataChannels: make(map[string]*webrtc.DataChannel),
-		mu:           sync.Mutex{},
+		dataChannels:    make(map[string]*webrtc.DataChannel),
+		pendingMessages: make(map[string]*pendingMessages),
+		onOpenCallbacks: make(map[string][]func()),
+		mu:              sync.Mutex{},
 	}
 }
 
+// Add the data channel to the list, it must be called before the data channel is opened.
+// The open handler of the data channel is registered once here to send the pending messages,
+// use DataChannelList.OnOpen instead of DataChannel.OnOpen to not replace it.
 func (d *DataChannelList) Add(dc *webrtc.DataChannel) {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-
 	d.dataChannels[dc.Label()] = dc
+	d.mu.Unlock()
+
+	dc.OnOpen(func() {
+		d.flushPendingMessages(dc)
+		d.onOpen(dc.Label())
+	})
 }
 
 func (d *DataChannelList) Remove(dc *webrtc.DataChannel) {
@@ -117,6 +312,26 @@ func (d *DataChannelList) Remove(dc *webrtc.DataChannel) {
 	defer d.mu.Unlock()
 
 	delete(d.dataChannels, dc.Label())
+	delete(d.pendingMessages, dc.Label())
+	delete(d.onOpenCallbacks, dc.Label())
+}
+
+// OnOpen register the callback that is called after the pending messages are sent when the data channel is opened
+func (d *DataChannelList) OnOpen(label string, callback func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.onOpenCallbacks[label] = append(d.onOpenCallbacks[label], callback)
+}
+
+func (d *DataChannelList) onOpen(label string) {
+	d.mu.Lock()
+	callbacks := d.onOpenCallbacks[label]
+	d.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
 }
 
 func (d *DataChannelList) Get(label string) *webrtc.DataChannel {
@@ -130,3 +345,86 @@ func (d *DataChannelList) Get(label string) *webrtc.DataChannel {
 
 	return dc
 }
+
+// OpenLabels return the labels of the open data channels
+func (d *DataChannelList) OpenLabels() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	labels := make([]string, 0, len(d.dataChannels))
+	for label, dc := range d.dataChannels {
+		if dc.ReadyState() == webrtc.DataChannelStateOpen {
+			labels = append(labels, label)
+		}
+	}
+
+	return labels
+}
+
+// sendWhenOpen buffer the message until the data channel is opened, the buffered messages are sent in order on open.
+// The message is dropped if the data channel is not opened within the TTL.
+func (d *DataChannelList) sendWhenOpen(dc *webrtc.DataChannel, data []byte, ttl time.Duration) {
+	d.mu.Lock()
+	d.getPendingMessages(dc, ttl).push(data)
+	d.mu.Unlock()
+
+	d.flushIfOpen(dc)
+}
+
+// replayWhenOpen buffer the history messages until the data channel is opened, the messages are never expired
+func (d *DataChannelList) replayWhenOpen(dc *webrtc.DataChannel, messages [][]byte, ttl time.Duration) {
+	if len(messages) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	pending := d.getPendingMessages(dc, ttl)
+	for _, data := range messages {
+		pending.pushPersistent(data)
+	}
+	d.mu.Unlock()
+
+	d.flushIfOpen(dc)
+}
+
+// getPendingMessages return the pending messages of the data channel, the buffer is created if not exists yet
+// and flushed by the open handler registered in Add. It must be called with the mutex held.
+func (d *DataChannelList) getPendingMessages(dc *webrtc.DataChannel, ttl time.Duration) *pendingMessages {
+	pending, ok := d.pendingMessages[dc.Label()]
+	if ok {
+		return pending
+	}
+
+	pending = newPendingMessages(ttl)
+	d.pendingMessages[dc.Label()] = pending
+
+	return pending
+}
+
+// flushIfOpen send the pending messages if the data channel is opened before the messages are buffered,
+// the open event is already fired and will not flush them
+func (d *DataChannelList) flushIfOpen(dc *webrtc.DataChannel) {
+	if dc.ReadyState() == webrtc.DataChannelStateOpen {
+		d.flushPendingMessages(dc)
+	}
+}
+
+// flushPendingMessages send the pending messages and remove the buffer under the same lock,
+// so a message buffered after the flush create a new buffer instead of waiting for an open event that never come
+func (d *DataChannelList) flushPendingMessages(dc *webrtc.DataChannel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, ok := d.pendingMessages[dc.Label()]
+	if !ok {
+		return
+	}
+
+	delete(d.pendingMessages, dc.Label())
+
+	for _, data := range pending.flush() {
+		if err := dc.Send(data); err != nil {
+			logger().Error("datachannel: error on send pending message ", err)
+		}
+	}
+}
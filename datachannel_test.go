@@ -2,12 +2,15 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
 )
 
 func TestRoomDataChannel(t *testing.T) {
@@ -231,3 +234,448 @@ Loop:
 func TestStillUsableAfterReconnect(t *testing.T) {
 
 }
+
+func TestDataChannelPendingMessageTTL(t *testing.T) {
+	t.Parallel()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	// the peer connection is never connected, so the data channel never open
+	dc, err := pc.CreateDataChannel("typing", nil)
+	require.NoError(t, err)
+	require.NotEqual(t, webrtc.DataChannelStateOpen, dc.ReadyState())
+
+	list := NewDataChannelList()
+	list.Add(dc)
+
+	ttl := 100 * time.Millisecond
+	list.sendWhenOpen(dc, []byte("typing"), ttl)
+
+	list.mu.Lock()
+	pending := list.pendingMessages[dc.Label()]
+	list.mu.Unlock()
+
+	require.NotNil(t, pending)
+	require.Equal(t, 1, pending.Len())
+
+	require.Eventually(t, func() bool {
+		return pending.Len() == 0
+	}, 5*ttl, 10*time.Millisecond)
+
+	require.Empty(t, pending.flush())
+}
+
+func TestDataChannelPendingMessageAfterOpen(t *testing.T) {
+	t.Parallel()
+
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer offerer.Close()
+
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer answerer.Close()
+
+	received := make(chan string, 2)
+	answerer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			received <- string(msg.Data)
+		})
+	})
+
+	dc, err := offerer.CreateDataChannel("chat", nil)
+	require.NoError(t, err)
+
+	list := NewDataChannelList()
+	list.Add(dc)
+
+	opened := make(chan struct{})
+	list.OnOpen(dc.Label(), func() {
+		close(opened)
+	})
+
+	// the message is buffered before the data channel is opened
+	list.sendWhenOpen(dc, []byte("first"), time.Minute)
+
+	offer, err := offerer.CreateOffer(nil)
+	require.NoError(t, err)
+
+	offerGathered := webrtc.GatheringCompletePromise(offerer)
+	require.NoError(t, offerer.SetLocalDescription(offer))
+	<-offerGathered
+
+	require.NoError(t, answerer.SetRemoteDescription(*offerer.LocalDescription()))
+
+	answer, err := answerer.CreateAnswer(nil)
+	require.NoError(t, err)
+
+	answerGathered := webrtc.GatheringCompletePromise(answerer)
+	require.NoError(t, answerer.SetLocalDescription(answer))
+	<-answerGathered
+
+	require.NoError(t, offerer.SetRemoteDescription(*answerer.LocalDescription()))
+
+	select {
+	case msg := <-received:
+		require.Equal(t, "first", msg)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the pending message")
+	}
+
+	// the open callback is chained after the pending messages are sent
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the open callback")
+	}
+
+	// the buffer is removed when it is flushed
+	list.mu.Lock()
+	require.Empty(t, list.pendingMessages)
+	list.mu.Unlock()
+
+	// the sender checked the state before the data channel is opened, the message is still sent after the open event
+	list.sendWhenOpen(dc, []byte("second"), time.Minute)
+
+	select {
+	case msg := <-received:
+		require.Equal(t, "second", msg)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the message buffered after open")
+	}
+
+	list.mu.Lock()
+	require.Empty(t, list.pendingMessages)
+	list.mu.Unlock()
+}
+
+func TestPrivateDataChannelIdleExpiry(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.dataChannels = NewDataChannelList()
+	s := client.sfu
+
+	idleTimeout := 100 * time.Millisecond
+	require.NoError(t, s.CreateDataChannel("pm-a-b", DataChannelOptions{
+		Ordered:     true,
+		ClientIDs:   []string{client.ID()},
+		IdleTimeout: idleTimeout,
+	}))
+
+	// the public data channel is never expired
+	require.NoError(t, s.CreateDataChannel("chat", DataChannelOptions{Ordered: true, IdleTimeout: idleTimeout}))
+
+	// the client has no peer connection, its data channel is added after the SFU data channels are created
+	require.NoError(t, s.clients.Add(client))
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	dc, err := pc.CreateDataChannel("pm-a-b", nil)
+	require.NoError(t, err)
+	client.dataChannels.Add(dc)
+
+	sfuDC := s.dataChannels.Get("pm-a-b")
+	require.NotNil(t, sfuDC)
+
+	// a message is still being sent, the data channel is kept after the timeout
+	sfuDC.inFlight.Add(1)
+	time.Sleep(3 * idleTimeout)
+	require.Equal(t, sfuDC, s.dataChannels.Get("pm-a-b"))
+	require.Equal(t, dc, client.dataChannels.Get("pm-a-b"))
+
+	sfuDC.touch(time.Now())
+	sfuDC.inFlight.Add(-1)
+
+	require.Eventually(t, func() bool {
+		return s.dataChannels.Get("pm-a-b") == nil
+	}, 10*idleTimeout, 10*time.Millisecond)
+
+	require.Nil(t, client.dataChannels.Get("pm-a-b"))
+	// the peer connection is never connected, so the data channel is closing without the SCTP transport
+	require.Contains(t, []webrtc.DataChannelState{webrtc.DataChannelStateClosing, webrtc.DataChannelStateClosed}, dc.ReadyState())
+	require.NotNil(t, s.dataChannels.Get("chat"))
+}
+
+func TestDataChannelHistoryReplay(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{
+		Bitrates:               DefaultBitrates(),
+		QualityPreset:          DefaultQualityPreset(),
+		Codecs:                 []string{webrtc.MimeTypeVP9, webrtc.MimeTypeOpus},
+		DataChannelHistorySize: 3,
+	})
+
+	require.NoError(t, s.CreateDataChannel("chat", DefaultDataChannelOptions()))
+
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		s.addDataChannelHistory("sender", "chat", webrtc.DataChannelMessage{IsString: true, Data: []byte(text)})
+	}
+
+	// the late joiner data channel is not opened yet
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	dc, err := pc.CreateDataChannel("chat", nil)
+	require.NoError(t, err)
+
+	list := NewDataChannelList()
+	list.Add(dc)
+	s.replayDataChannelHistory(list, dc)
+
+	list.mu.Lock()
+	pending := list.pendingMessages[dc.Label()]
+	list.mu.Unlock()
+
+	require.NotNil(t, pending)
+
+	replayed := pending.flush()
+	require.Len(t, replayed, 3)
+
+	for i, text := range []string{"three", "four", "five"} {
+		data := Data{}
+		require.NoError(t, json.Unmarshal(replayed[i], &data))
+		require.Equal(t, "sender", data.FromID)
+		require.Equal(t, text, data.Data)
+		require.False(t, data.SentAt.IsZero())
+	}
+}
+
+func TestDataChannelRateLimit(t *testing.T) {
+	t.Parallel()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = []string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	roomOpts.DataChannelRateLimit = DataChannelRateLimit{MessagesPerSecond: 2}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	require.NoError(t, testRoom.CreateDataChannel("chat", DefaultDataChannelOptions()))
+
+	pc1, client1, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer1")
+	pc2, client2, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer2")
+
+	defer func() {
+		_ = testRoom.StopClient(client1.id)
+		_ = testRoom.StopClient(client2.id)
+	}()
+
+	limited := make(chan droppedMessages, 20)
+	client1.OnDataChannelRateLimited(func(label string, messages int, size int) {
+		require.Equal(t, "chat", label)
+		limited <- droppedMessages{messages: messages, bytes: size}
+	})
+
+	// the first peer flood the channel as soon as it is opened
+	pc1.OnDataChannel(func(d *webrtc.DataChannel) {
+		if d.Label() != "chat" {
+			return
+		}
+
+		d.OnOpen(func() {
+			for i := 0; i < 20; i++ {
+				_ = d.SendText("flood")
+			}
+		})
+	})
+
+	received := make(chan string, 20)
+	pc2.OnDataChannel(func(d *webrtc.DataChannel) {
+		if d.Label() != "chat" {
+			return
+		}
+
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			received <- string(msg.Data)
+		})
+	})
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelTimeout()
+
+	// only the burst of the rate is relayed, the rest are dropped before they reach the other client.
+	// The drops are aggregated, so the event is not called for every dropped message
+	dropped := droppedMessages{}
+	events := 0
+	for dropped.messages < 18 {
+		select {
+		case <-timeout.Done():
+			t.Fatal("timeout waiting for rate limited messages")
+		case drops := <-limited:
+			dropped.messages += drops.messages
+			dropped.bytes += drops.bytes
+			events++
+		}
+	}
+
+	require.Equal(t, 18, dropped.messages)
+	require.Equal(t, 18*len("flood"), dropped.bytes)
+	require.Less(t, events, 18)
+
+	require.Eventually(t, func() bool {
+		return len(received) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, received, 2)
+}
+
+func TestDataChannelLabels(t *testing.T) {
+	t.Parallel()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = []string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err, "error creating room: %v", err)
+	ctx := testRoom.sfu.context
+
+	require.NoError(t, testRoom.CreateDataChannel("chat", DefaultDataChannelOptions()))
+
+	pc1, client1, _ := CreateDataPair(ctx, testRoom, roomManager.options.IceServers, "peer1")
+
+	defer func() {
+		_ = testRoom.StopClient(client1.id)
+	}()
+
+	direct := make(chan *webrtc.DataChannel, 1)
+	pc1.OnDataChannel(func(d *webrtc.DataChannel) {
+		if d.Label() == "direct" {
+			direct <- d
+		}
+	})
+
+	require.NoError(t, testRoom.CreateDataChannel("direct", DataChannelOptions{
+		Ordered:   true,
+		ClientIDs: []string{client1.ID()},
+	}))
+
+	require.Eventually(t, func() bool {
+		public, private := testRoom.sfu.DataChannelLabels(client1.ID())
+		return slices.Equal(public, []string{"chat"}) && slices.Equal(private, []string{"direct"})
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// the closed channel is removed from the client
+	require.NoError(t, (<-direct).Close())
+
+	require.Eventually(t, func() bool {
+		public, private := testRoom.sfu.DataChannelLabels(client1.ID())
+		return slices.Equal(public, []string{"chat"}) && len(private) == 0
+	}, 10*time.Second, 50*time.Millisecond)
+
+	public, private := testRoom.sfu.DataChannelLabels("unknown")
+	require.Empty(t, public)
+	require.Empty(t, private)
+}
+
+func TestDataChannelRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	limiter := newDataChannelRateLimiter(DataChannelRateLimit{MessagesPerSecond: 10, BytesPerSecond: 100})
+
+	// the burst is limited by the bytes
+	require.True(t, limiter.allow(60, now))
+	require.False(t, limiter.allow(60, now))
+	require.True(t, limiter.allow(40, now))
+	require.False(t, limiter.allow(1, now))
+
+	// the tokens are refilled at the rate
+	now = now.Add(110 * time.Millisecond)
+	require.True(t, limiter.allow(10, now))
+	require.False(t, limiter.allow(10, now))
+
+	// the burst is limited by the messages
+	now = now.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		require.True(t, limiter.allow(1, now))
+	}
+	require.False(t, limiter.allow(1, now))
+
+	// unlimited without the limits
+	unlimited := newDataChannelRateLimiter(DataChannelRateLimit{})
+	for i := 0; i < 1000; i++ {
+		require.True(t, unlimited.allow(1000, now))
+	}
+}
+
+func TestFanoutPoolSlowRecipient(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := newFanoutPool(ctx, 2, 100)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+
+	receive := func(recipient string, i int) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			received[recipient] = append(received[recipient], i)
+		}
+	}
+
+	// the slow recipient block the worker until released
+	for i := 0; i < 10; i++ {
+		i := i
+		require.True(t, pool.submit("slow", func() {
+			<-release
+			receive("slow", i)()
+		}))
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, recipient := range []string{"fast-1", "fast-2", "fast-3"} {
+			require.True(t, pool.submit(recipient, receive(recipient, i)))
+		}
+	}
+
+	// the fast recipients receive all messages in order while the slow recipient still blocked
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, recipient := range []string{"fast-1", "fast-2", "fast-3"} {
+			if len(received[recipient]) != 10 {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	require.Empty(t, received["slow"])
+	for _, recipient := range []string{"fast-1", "fast-2", "fast-3"} {
+		require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, received[recipient])
+	}
+	mu.Unlock()
+
+	// the slow recipient queue is bounded, the message in progress is not queued anymore
+	for i := 10; i <= 100; i++ {
+		require.True(t, pool.submit("slow", receive("slow", i)))
+	}
+
+	require.False(t, pool.submit("slow", receive("slow", 101)))
+}
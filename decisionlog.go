@@ -0,0 +1,106 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// Decision is a bitrate adjustment decision made for a claim, with the inputs that the decision was based on
+type Decision struct {
+	Time    time.Time
+	TrackID string
+	// the quality and the bitrate of the claim when the decision was made
+	Quality QualityLevel
+	Bitrate uint32
+	StrategyContext
+	// the fraction lost reported by the receiver of the track
+	FractionLost float64
+	Adjustment   BitrateAdjustment
+}
+
+// decisionLog is a ring buffer that keep the latest decisions
+type decisionLog struct {
+	mu        sync.Mutex
+	decisions []Decision
+	next      int
+	full      bool
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{
+		mu:        sync.Mutex{},
+		decisions: make([]Decision, size),
+	}
+}
+
+func (l *decisionLog) push(decision Decision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.decisions[l.next] = decision
+	l.next++
+
+	if l.next == len(l.decisions) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// list return the decisions from the oldest to the latest
+func (l *decisionLog) list() []Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]Decision{}, l.decisions[:l.next]...)
+	}
+
+	decisions := make([]Decision, 0, len(l.decisions))
+	decisions = append(decisions, l.decisions[l.next:]...)
+
+	return append(decisions, l.decisions[:l.next]...)
+}
+
+// EnableDecisionLog keep the latest size bitrate decisions in memory, set 0 to disable the log.
+// The decisions logged before are dropped when the log is enabled again.
+func (bc *bitrateController) EnableDecisionLog(size int) {
+	if size <= 0 {
+		bc.decisionLog.Store(nil)
+		return
+	}
+
+	bc.decisionLog.Store(newDecisionLog(size))
+}
+
+// DecisionLog return the logged decisions from the oldest to the latest, nil if the log is disabled
+func (bc *bitrateController) DecisionLog() []Decision {
+	log := bc.decisionLog.Load()
+	if log == nil {
+		return nil
+	}
+
+	return log.list()
+}
+
+// recordDecision log the decision if the log is enabled, the inputs are only collected when the log is enabled
+func (bc *bitrateController) recordDecision(claim *bitrateClaim, adjustment bitrateAdjustment) {
+	log := bc.decisionLog.Load()
+	if log == nil {
+		return
+	}
+
+	decision := Decision{
+		Time:            time.Now(),
+		TrackID:         claim.track.ID(),
+		Quality:         claim.Quality(),
+		Bitrate:         claim.Bitrate(),
+		StrategyContext: bc.strategyContext(claim),
+		Adjustment:      adjustment,
+	}
+
+	if sender, err := bc.client.stats.GetSender(claim.track.ID()); err == nil {
+		decision.FractionLost = sender.RemoteInboundRTPStreamStats.FractionLost
+	}
+
+	log.push(decision)
+}
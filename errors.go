@@ -3,12 +3,21 @@ package sfu
 import "errors"
 
 var (
-	ErrClientNotFound = errors.New("client not found")
-	ErrClientExists   = errors.New("client already exists")
+	ErrClientNotFound      = errors.New("client not found")
+	ErrClientExists        = errors.New("client already exists")
+	ErrServerFull          = errors.New("server is full")
+	ErrTrackNotFound       = errors.New("track not found")
+	ErrTrackIsNotScaleable = errors.New("track is not scaleable")
+	ErrTrackNotAdjustable  = errors.New("track only has a single layer")
 
 	ErrRoomIsClosed   = errors.New("room is closed")
 	ErrRoomIsNotEmpty = errors.New("room is not empty")
 	ErrDecodingData   = errors.New("error decoding data")
 	ErrEncodingData   = errors.New("error encoding data")
 	ErrNotFound       = errors.New("not found")
+
+	ErrInvalidQualityBitrates     = errors.New("quality bitrates must be low < mid < high < very high")
+	ErrInvalidStartupQuality      = errors.New("startup quality must be low, mid or high")
+	ErrInvalidLossThresholds      = errors.New("loss thresholds must be 0 <= increase < decrease <= 1")
+	ErrInvalidLossDecreaseReports = errors.New("loss decrease reports must be at least 1")
 )
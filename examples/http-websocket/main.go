@@ -404,16 +404,16 @@ func clientHandler(isDebug bool, conn *websocket.Conn, messageChan chan Request,
 				switch quality {
 				case "low":
 					log.Println("switch to low quality")
-					client.SetQuality(sfu.QualityLow)
+					client.SetQualityCeiling(sfu.QualityLow)
 				case "mid":
 					log.Println("switch to mid quality")
-					client.SetQuality(sfu.QualityMid)
+					client.SetQualityCeiling(sfu.QualityMid)
 				case "high":
 					log.Println("switch to high quality")
-					client.SetQuality(sfu.QualityHigh)
+					client.SetQualityCeiling(sfu.QualityHigh)
 				case "none":
 					log.Println("switch to high quality")
-					client.SetQuality(sfu.QualityNone)
+					client.SetQualityCeiling(sfu.QualityNone)
 				}
 			} else if req.Type == TypeUpdateBandwidth {
 				bandwidth := uint32(req.Data.(float64))
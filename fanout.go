@@ -0,0 +1,121 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// the number of workers that send the data channel messages relayed to the other clients
+	dataChannelFanoutWorkers = 4
+	// the data channel messages waiting to be sent to a client, the new messages are dropped when it's full
+	dataChannelFanoutQueueSize = 1024
+)
+
+// fanoutRecipient is the queued tasks of a recipient, it is only taken by one worker at a time
+// so the tasks of the recipient are run in order
+type fanoutRecipient struct {
+	id        string
+	tasks     []func()
+	scheduled bool
+}
+
+// fanoutPool run the tasks of many recipients with a bounded number of workers. The workers take one task
+// of a recipient at a time in round robin, so a slow recipient only occupy one worker and never stall the others.
+type fanoutPool struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queueSize  int
+	recipients map[string]*fanoutRecipient
+	ready      []*fanoutRecipient
+	closed     bool
+}
+
+func newFanoutPool(ctx context.Context, workers, queueSize int) *fanoutPool {
+	p := &fanoutPool{
+		queueSize:  queueSize,
+		recipients: make(map[string]*fanoutRecipient),
+		ready:      make([]*fanoutRecipient, 0),
+	}
+
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.closed = true
+		p.cond.Broadcast()
+	})
+
+	return p
+}
+
+// submit queue the task of the recipient without blocking, return false if the recipient queue is full
+// or the pool is closed
+func (p *fanoutPool) submit(recipientID string, task func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	recipient, ok := p.recipients[recipientID]
+	if !ok {
+		recipient = &fanoutRecipient{id: recipientID}
+		p.recipients[recipientID] = recipient
+	}
+
+	if len(recipient.tasks) >= p.queueSize {
+		return false
+	}
+
+	recipient.tasks = append(recipient.tasks, task)
+
+	if !recipient.scheduled {
+		recipient.scheduled = true
+		p.ready = append(p.ready, recipient)
+		p.cond.Signal()
+	}
+
+	return true
+}
+
+func (p *fanoutPool) work() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+
+		if p.closed {
+			return
+		}
+
+		recipient := p.ready[0]
+		p.ready = p.ready[1:]
+
+		task := recipient.tasks[0]
+		recipient.tasks = recipient.tasks[1:]
+
+		p.mu.Unlock()
+		task()
+		p.mu.Lock()
+
+		if len(recipient.tasks) > 0 {
+			// back to the end of the line to give the other recipients a turn
+			p.ready = append(p.ready, recipient)
+			p.cond.Signal()
+		} else {
+			recipient.scheduled = false
+			delete(p.recipients, recipient.id)
+		}
+	}
+}
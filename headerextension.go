@@ -0,0 +1,134 @@
+package sfu
+
+import (
+	"github.com/inlivedev/sfu/pkg/interceptors/playoutdelay"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// AbsCaptureTimeURI is the header extension used by the clients to synchronize the playback of the tracks
+const AbsCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+
+// LayerDropURI is the header extension that tell the subscriber the SFU intentionally dropped the packets of the
+// spatial or temporal layers that are not forwarded before this packet. The one byte value is the number of dropped
+// packets, saturated at 255. The sequence numbers are continuous over the dropped packets, so a decoder that
+// understand the extension doesn't need to conceal anything, while a real gap in the sequence numbers is a network loss.
+const LayerDropURI = "urn:inlive:rtp-hdrext:layer-drop"
+
+// VideoOrientationURI is the coordination of video orientation (CVO) header extension, the mobile publishers send
+// the rotation of the camera in it instead of rotating the frames, so the subscribers need it to show the video upright
+const VideoOrientationURI = "urn:3gpp:video-orientation"
+
+const extensionProfileTwoByte = 0x1000
+
+// forwardedHeaderExtensions are the publisher header extensions that are forwarded to the subscribers,
+// other extensions are only meaningful on the publisher connection and dropped
+var forwardedHeaderExtensions = []string{
+	AbsCaptureTimeURI,
+	playoutdelay.PlayoutDelayURI,
+	VideoOrientationURI,
+}
+
+func RegisterAbsCaptureTimeHeaderExtension(m *webrtc.MediaEngine) {
+	for _, codecType := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: AbsCaptureTimeURI}, codecType); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func RegisterLayerDropHeaderExtension(m *webrtc.MediaEngine) {
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: LayerDropURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		panic(err)
+	}
+}
+
+func RegisterVideoOrientationHeaderExtension(m *webrtc.MediaEngine) {
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: VideoOrientationURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		panic(err)
+	}
+}
+
+// headerExtensionID return the id of the header extension negotiated with the peer, 0 if it is not negotiated
+func headerExtensionID(extensions []webrtc.RTPHeaderExtensionParameter, uri string) uint8 {
+	for _, extension := range extensions {
+		if extension.URI == uri {
+			return uint8(extension.ID)
+		}
+	}
+
+	return 0
+}
+
+// setLayerDropHint add the layer drop header extension with the number of packets dropped before the packet
+func setLayerDropHint(p *rtp.Packet, id uint8, dropped uint16) {
+	if err := p.Header.SetExtension(id, []byte{uint8(min(dropped, 255))}); err != nil {
+		logger().Warn("headerextension: error set layer drop extension ", id, " ", err)
+	}
+}
+
+// headerExtensionMap map the header extension id negotiated with the publisher to the id negotiated with the subscriber
+type headerExtensionMap map[uint8]uint8
+
+func newHeaderExtensionMap(publisher, subscriber []webrtc.RTPHeaderExtensionParameter) headerExtensionMap {
+	ids := make(headerExtensionMap)
+
+	for _, uri := range forwardedHeaderExtensions {
+		var publisherID, subscriberID int
+
+		for _, extension := range publisher {
+			if extension.URI == uri {
+				publisherID = extension.ID
+			}
+		}
+
+		for _, extension := range subscriber {
+			if extension.URI == uri {
+				subscriberID = extension.ID
+			}
+		}
+
+		if publisherID > 0 && subscriberID > 0 {
+			ids[uint8(publisherID)] = uint8(subscriberID)
+		}
+	}
+
+	return ids
+}
+
+// rewrite replace the packet header extensions with the forwarded extensions using the subscriber ids.
+// The packet get its own extensions slice, because the packet header is shared by all subscribers of the track
+// and the interceptors of each subscriber connection set their own extensions on it.
+func (m headerExtensionMap) rewrite(p *rtp.Packet) {
+	header := p.Header
+	header.Extension = false
+	header.ExtensionProfile = 0
+	header.Extensions = nil
+
+	// the one byte header only support id up to 14
+	for _, subscriberID := range m {
+		if subscriberID > 14 {
+			header.Extension = true
+			header.ExtensionProfile = extensionProfileTwoByte
+		}
+	}
+
+	for _, id := range p.Header.GetExtensionIDs() {
+		subscriberID, ok := m[id]
+		if !ok {
+			continue
+		}
+
+		if err := header.SetExtension(subscriberID, p.Header.GetExtension(id)); err != nil {
+			logger().Warn("headerextension: error set extension ", subscriberID, " ", err)
+		}
+	}
+
+	// no forwarded extension in the packet
+	if len(header.Extensions) == 0 {
+		header.Extension = false
+		header.ExtensionProfile = 0
+	}
+
+	p.Header = header
+}
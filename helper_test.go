@@ -1,9 +1,28 @@
 package sfu
 
 import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
 )
 
+// receiverReports number the receiver reports of the tests, so every report set on the sender stats is a new report
+var receiverReports atomic.Uint64
+
+// newReceiverReport return the sender stats of a new receiver report with the fraction lost
+func newReceiverReport(fractionLost float64) stats.Stats {
+	s := stats.Stats{}
+	s.RemoteInboundRTPStreamStats.PacketsReceived = receiverReports.Add(1)
+	s.RemoteInboundRTPStreamStats.FractionLost = fractionLost
+
+	return s
+}
+
 type PeerClient struct {
 	PeerConnection  *webrtc.PeerConnection
 	PendingTracks   []*webrtc.TrackLocalStaticSample
@@ -21,3 +40,145 @@ type RemoteTrackTest struct {
 func DefaultTestIceServers() []webrtc.ICEServer {
 	return []webrtc.ICEServer{}
 }
+
+// testClock is a Clock that only move when it is set or advanced by the test
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now()}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// networkStep is the network condition of the subscriber from the time offset of the scenario
+type networkStep struct {
+	at                 time.Duration
+	estimatedBandwidth uint32
+	fractionLost       float64
+}
+
+// qualityChange is a claim quality change observed after a network step
+type qualityChange struct {
+	at      time.Duration
+	trackID string
+	from    QualityLevel
+	to      QualityLevel
+}
+
+// networkHarness drive the bitrate controller of a test client with a scripted network. The clock of the controller
+// is only advanced by the steps, so the adjustment delays are deterministic.
+type networkHarness struct {
+	t      *testing.T
+	client *Client
+	bc     *bitrateController
+	clock  *testClock
+	start  time.Time
+}
+
+// newNetworkHarness create a harness with the bandwidth based adjustment if useBandwidthEstimation is true,
+// otherwise the loss based adjustment is checked on every step
+func newNetworkHarness(t *testing.T, useBandwidthEstimation bool, opts ...BCOption) *networkHarness {
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	client.bitrateController.Close()
+
+	clock := newTestClock()
+
+	opts = append([]BCOption{
+		WithBandwidthEstimation(useBandwidthEstimation),
+		WithClock(clock),
+		// the steps drive the adjustments, the interval adjustment must never run in between
+		WithInterval(24 * time.Hour),
+	}, opts...)
+
+	bc := newBitrateController(client, opts...)
+	t.Cleanup(bc.Close)
+
+	client.bitrateController = bc
+
+	return &networkHarness{
+		t:      t,
+		client: client,
+		bc:     bc,
+		clock:  clock,
+		start:  clock.Now(),
+	}
+}
+
+// addTrack claim a scaleable video track with the quality
+func (h *networkHarness) addTrack(id string, quality QualityLevel) *testClientTrack {
+	track := newTestClientTrack(h.t, h.client, id, webrtc.RTPCodecTypeVideo, true)
+
+	_, err := h.bc.addClaim(track, quality, true)
+	require.NoError(h.t, err)
+
+	return track
+}
+
+func (h *networkHarness) quality(id string) QualityLevel {
+	claim := h.bc.GetClaim(id)
+	require.NotNil(h.t, claim)
+
+	return claim.Quality()
+}
+
+func (h *networkHarness) qualities() map[string]QualityLevel {
+	qualities := make(map[string]QualityLevel)
+	for id, claim := range h.bc.Claims() {
+		qualities[id] = claim.Quality()
+	}
+
+	return qualities
+}
+
+// run apply the steps in order and return the quality changes after each step
+func (h *networkHarness) run(steps []networkStep) []qualityChange {
+	changes := make([]qualityChange, 0)
+
+	for _, step := range steps {
+		before := h.qualities()
+
+		h.clock.Set(h.start.Add(step.at))
+		h.client.rembBandwidth.Store(step.estimatedBandwidth)
+
+		for id := range before {
+			s := newReceiverReport(step.fractionLost)
+			h.client.stats.SetSender(id, s)
+		}
+
+		if h.bc.useBandwidthEstimation {
+			h.bc.onBandwidthChanged(step.estimatedBandwidth)
+		} else {
+			h.bc.checkAndAdjustBitrates()
+		}
+
+		for id, quality := range h.qualities() {
+			if quality != before[id] {
+				changes = append(changes, qualityChange{at: step.at, trackID: id, from: before[id], to: quality})
+			}
+		}
+	}
+
+	return changes
+}
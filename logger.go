@@ -0,0 +1,61 @@
+package sfu
+
+import (
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// Logger is the logger used by the package, use SetLogger to route the logs to the application logger.
+// The arguments are handled in the manner of fmt.Print.
+type Logger interface {
+	// Debug is only called when the client debug is enabled, see Client.EnableDebug
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// loggerHolder keep the same concrete type stored in the atomic.Value for every logger implementation
+type loggerHolder struct {
+	logger Logger
+}
+
+var currentLogger atomic.Value
+
+func init() {
+	currentLogger.Store(loggerHolder{logger: glogLogger{}})
+}
+
+// SetLogger replace the logger used by the package, the default logger is backed by glog.
+// Passing nil restore the default logger.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = glogLogger{}
+	}
+
+	currentLogger.Store(loggerHolder{logger: l})
+}
+
+func logger() Logger {
+	return currentLogger.Load().(loggerHolder).logger
+}
+
+// glogLogger is the default logger, debug logs are written as info logs
+type glogLogger struct{}
+
+func (glogLogger) Debug(args ...interface{}) {
+	glog.InfoDepth(1, args...)
+}
+
+func (glogLogger) Info(args ...interface{}) {
+	glog.InfoDepth(1, args...)
+}
+
+func (glogLogger) Warn(args ...interface{}) {
+	glog.WarningDepth(1, args...)
+}
+
+func (glogLogger) Error(args ...interface{}) {
+	glog.ErrorDepth(1, args...)
+}
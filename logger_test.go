@@ -0,0 +1,109 @@
+package sfu
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+type capturingLogger struct {
+	mu   sync.Mutex
+	logs map[string][]string
+}
+
+func newCapturingLogger() *capturingLogger {
+	return &capturingLogger{logs: make(map[string][]string)}
+}
+
+func (l *capturingLogger) log(level string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.logs[level] = append(l.logs[level], fmt.Sprint(args...))
+}
+
+func (l *capturingLogger) Debug(args ...interface{}) { l.log("debug", args...) }
+func (l *capturingLogger) Info(args ...interface{})  { l.log("info", args...) }
+func (l *capturingLogger) Warn(args ...interface{})  { l.log("warn", args...) }
+func (l *capturingLogger) Error(args ...interface{}) { l.log("error", args...) }
+
+func (l *capturingLogger) contains(level, substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, log := range l.logs[level] {
+		if strings.Contains(log, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// not parallel because the logger is shared by the package
+func TestSetLogger(t *testing.T) {
+	captured := newCapturingLogger()
+	SetLogger(captured)
+	t.Cleanup(func() { SetLogger(nil) })
+
+	client := newTestClient(t, 100_000)
+	bc := client.bitrateController
+
+	track := newTestScaleableClientTrack(t, client, webrtc.MimeTypeVP9, &remoteTrack{})
+	track.id = "debug-video"
+
+	claim, err := bc.addClaim(track, QualityHigh, true)
+	require.NoError(t, err)
+
+	// the debug log is only written when the client debug is enabled
+	require.Equal(t, bitrateAdjustment(decreaseBitrate), bc.getBitrateBasedAdjustment(100_000, claim))
+	require.False(t, captured.contains("debug", "debug-video decrease bitrate"))
+
+	client.EnableDebug()
+
+	require.Equal(t, bitrateAdjustment(decreaseBitrate), bc.getBitrateBasedAdjustment(100_000, claim))
+	require.True(t, captured.contains("debug", "debug-video decrease bitrate"))
+
+	SetLogger(nil)
+	require.IsType(t, glogLogger{}, logger())
+}
+
+// not parallel because the logger is shared by the package
+func TestNoPacketLogWithoutDebug(t *testing.T) {
+	captured := newCapturingLogger()
+	SetLogger(captured)
+	t.Cleanup(func() { SetLogger(nil) })
+
+	client := newTestClient(t, DefaultBitrates().InitialBandwidth)
+
+	track := newTestScaleableClientTrackWithoutWriter(t, client, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	track.id = "quiet-video"
+
+	_, err := client.bitrateController.addClaim(track, QualityLow, true)
+	require.NoError(t, err)
+
+	pushPackets := func(sequences ...uint16) {
+		for _, sequence := range sequences {
+			track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: sequence, Timestamp: uint32(sequence) * 3000}, Payload: vp9Payload(0, 0, sequence > 1, false)}, QualityLow)
+		}
+	}
+
+	// forwarded, duplicate and late packets
+	pushPackets(1, 2, 3, 2, 5, 4)
+	require.Equal(t, uint64(1), track.DropStats().Duplicate)
+
+	captured.mu.Lock()
+	require.Empty(t, captured.logs)
+	captured.mu.Unlock()
+
+	client.EnableDebug()
+
+	pushPackets(3)
+	require.True(t, captured.contains("debug", "late packet 3"))
+	require.True(t, captured.contains("debug", "packet 3 has been sent"))
+}
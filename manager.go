@@ -86,7 +86,23 @@ func (m *Manager) NewRoom(id, name, roomType string, opts RoomOptions) (*Room, e
 		PortEnd:                  m.options.PortEnd,
 		Codecs:                   opts.Codecs,
 		PLIInterval:              opts.PLIInterval,
+		PLIDebounceWindow:        opts.PLIDebounceWindow,
+		TrackStallTimeout:        opts.TrackStallTimeout,
+		SimulcastProbeTimeout:    opts.SimulcastProbeTimeout,
+		NACKRetransmitLimit:      opts.NACKRetransmitLimit,
+		NACKBackoff:              opts.NACKBackoff,
+		DataChannelHistorySize:   opts.DataChannelHistorySize,
+		DataChannelRateLimit:     opts.DataChannelRateLimit,
+		PacketCacheSize:          opts.PacketCacheSize,
+		PacketCacheMaxAge:        opts.PacketCacheMaxAge,
+		PacketCacheWindow:        opts.PacketCacheWindow,
+		PacketQueueSize:          opts.PacketQueueSize,
+		TWCCFeedbackInterval:     opts.TWCCFeedbackInterval,
+		EnableProbePadding:       opts.EnableProbePadding,
+		DegradationPolicy:        opts.DegradationPolicy,
+		SimulcastRIDQualities:    opts.SimulcastRIDQualities,
 		QualityPreset:            opts.QualityPreset,
+		CodecQualityPresets:      opts.CodecQualityPresets,
 		EnableBandwidthEstimator: m.options.EnableBandwidthEstimator,
 		PublicIP:                 m.options.PublicIP,
 		NAT1To1IPsCandidateType:  m.options.NAT1To1IPsCandidateType,
@@ -0,0 +1,164 @@
+package sfu
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultNACKRetransmitLimit is the default number of NACKs sent to the publisher for each missing packet
+	DefaultNACKRetransmitLimit = 3
+	// DefaultNACKBackoff is the default wait before the first NACK is repeated, the wait is doubled on each retry
+	DefaultNACKBackoff = 40 * time.Millisecond
+
+	// a bigger gap than this is considered as a stream reset and not recovered with NACK
+	nackMaxGap = 128
+)
+
+type nackOptions struct {
+	retransmitLimit int
+	backoff         time.Duration
+	// onNACK is called with the NACK packet that need to be sent to the publisher, NACK is disabled if nil
+	onNACK func(*rtcp.TransportLayerNack)
+}
+
+// configureNACKResponder is the same with webrtc.ConfigureNack but without the pion NACK generator, the NACKs to the
+// publisher are sent by the nackGenerator of the remote track and the publisher would receive every NACK twice otherwise
+func configureNACKResponder(m *webrtc.MediaEngine, i *interceptor.Registry) error {
+	responder, err := nack.NewResponderInterceptor()
+	if err != nil {
+		return err
+	}
+
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	i.Add(responder)
+
+	return nil
+}
+
+type missingPacket struct {
+	retries   int
+	nextRetry time.Time
+}
+
+// nackGenerator detect the gap on the sequence number received from the publisher and
+// generate the NACKs to request the missing packets. The retransmitted packet is received as a late packet,
+// and forwarded like other late packets, for scaleable tracks the packet caches is used to map the late packet sequence.
+type nackGenerator struct {
+	mu              sync.Mutex
+	started         bool
+	lastSequence    uint16
+	missing         map[uint16]*missingPacket
+	retransmitLimit int
+	backoff         time.Duration
+}
+
+func newNACKGenerator(retransmitLimit int, backoff time.Duration) *nackGenerator {
+	if retransmitLimit <= 0 {
+		retransmitLimit = DefaultNACKRetransmitLimit
+	}
+
+	if backoff <= 0 {
+		backoff = DefaultNACKBackoff
+	}
+
+	return &nackGenerator{
+		mu:              sync.Mutex{},
+		missing:         make(map[uint16]*missingPacket),
+		retransmitLimit: retransmitLimit,
+		backoff:         backoff,
+	}
+}
+
+// onPacket track the received sequence number, register the gap as missing packets and
+// remove the missing packet when it is recovered
+func (n *nackGenerator) onPacket(sequence uint16, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.started {
+		n.started = true
+		n.lastSequence = sequence
+		return
+	}
+
+	diff := sequence - n.lastSequence
+
+	if diff == 0 {
+		return
+	}
+
+	// late packet or the retransmitted packet
+	if diff >= 0x8000 {
+		delete(n.missing, sequence)
+		return
+	}
+
+	if diff > nackMaxGap {
+		n.missing = make(map[uint16]*missingPacket)
+	} else {
+		for seq := n.lastSequence + 1; seq != sequence; seq++ {
+			n.missing[seq] = &missingPacket{nextRetry: now}
+		}
+	}
+
+	n.lastSequence = sequence
+}
+
+// nacks return the missing sequence numbers that are due to be requested.
+// The retry wait is doubled each time, and the packet is given up after the retransmit limit is reached.
+func (n *nackGenerator) nacks(now time.Time) []uint16 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sequences := make([]uint16, 0)
+
+	for seq, missing := range n.missing {
+		if now.Before(missing.nextRetry) {
+			continue
+		}
+
+		if missing.retries >= n.retransmitLimit {
+			delete(n.missing, seq)
+			continue
+		}
+
+		sequences = append(sequences, seq)
+		missing.nextRetry = now.Add(n.backoff << missing.retries)
+		missing.retries++
+	}
+
+	// keep the wrapped sequence numbers in order after the higher ones
+	sort.Slice(sequences, func(i, j int) bool {
+		return sequences[i]-n.lastSequence < sequences[j]-n.lastSequence
+	})
+
+	return sequences
+}
+
+// nackPacket return the NACK packet for the missing packets that are due, nil if there is nothing to request
+func (n *nackGenerator) nackPacket(mediaSSRC uint32, now time.Time) *rtcp.TransportLayerNack {
+	sequences := n.nacks(now)
+	if len(sequences) == 0 {
+		return nil
+	}
+
+	return &rtcp.TransportLayerNack{
+		MediaSSRC: mediaSSRC,
+		Nacks:     rtcp.NackPairsFromSequenceNumbers(sequences),
+	}
+}
+
+func (n *nackGenerator) missingCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.missing)
+}
@@ -0,0 +1,133 @@
+package sfu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func nackedSequences(t *testing.T, n *nackGenerator, now time.Time) []uint16 {
+	nack := n.nackPacket(1234, now)
+	if nack == nil {
+		return nil
+	}
+
+	require.Equal(t, uint32(1234), nack.MediaSSRC)
+
+	sequences := make([]uint16, 0)
+	for _, pair := range nack.Nacks {
+		sequences = append(sequences, pair.PacketList()...)
+	}
+
+	return sequences
+}
+
+func TestNACKOnSequenceGap(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	n := newNACKGenerator(2, 10*time.Millisecond)
+
+	// the gap is wrapped around the sequence number
+	for _, seq := range []uint16{65533, 65534, 1, 2} {
+		n.onPacket(seq, now)
+	}
+
+	require.Equal(t, []uint16{65535, 0}, nackedSequences(t, n, now))
+
+	// the NACK is not repeated before the backoff
+	require.Empty(t, nackedSequences(t, n, now.Add(5*time.Millisecond)))
+
+	// the retransmitted packet is recovered
+	n.onPacket(0, now)
+	require.Equal(t, []uint16{65535}, nackedSequences(t, n, now.Add(10*time.Millisecond)))
+
+	// the retransmit limit is reached, the packet is given up
+	require.Empty(t, nackedSequences(t, n, now.Add(time.Second)))
+	require.Equal(t, 0, n.missingCount())
+}
+
+func TestNACKIgnoreStreamReset(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	n := newNACKGenerator(DefaultNACKRetransmitLimit, DefaultNACKBackoff)
+
+	n.onPacket(100, now)
+	n.onPacket(102, now)
+	require.Equal(t, 1, n.missingCount())
+
+	n.onPacket(102+nackMaxGap+1, now)
+	require.Equal(t, 0, n.missingCount())
+	require.Nil(t, n.nackPacket(1234, now))
+}
+
+func TestNACKSentOnceUpstream(t *testing.T) {
+	t.Parallel()
+
+	m := &webrtc.MediaEngine{}
+	registry := &interceptor.Registry{}
+	require.NoError(t, configureNACKResponder(m, registry))
+
+	chain, err := registry.Build("")
+	require.NoError(t, err)
+
+	defer chain.Close()
+
+	var sentNACKs atomic.Int32
+
+	countNACKs := func(pkts []rtcp.Packet) {
+		for _, pkt := range pkts {
+			if nack, ok := pkt.(*rtcp.TransportLayerNack); ok {
+				for _, pair := range nack.Nacks {
+					sentNACKs.Add(int32(len(pair.PacketList())))
+				}
+			}
+		}
+	}
+
+	chain.BindRTCPWriter(interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+		countNACKs(pkts)
+		return 0, nil
+	}))
+
+	packets := make(chan []byte, 4)
+	reader := chain.BindRemoteStream(&interceptor.StreamInfo{
+		SSRC:         1234,
+		RTCPFeedback: []interceptor.RTCPFeedback{{Type: "nack"}},
+	}, interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		return copy(b, <-packets), a, nil
+	}))
+
+	now := time.Now()
+	generator := newNACKGenerator(1, DefaultNACKBackoff)
+
+	// the packet 101 is lost
+	for _, seq := range []uint16{100, 102, 103} {
+		raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1234, SequenceNumber: seq}}).Marshal()
+		require.NoError(t, err)
+
+		packets <- raw
+
+		buf := make([]byte, 1500)
+		_, _, err = reader.Read(buf, interceptor.Attributes{})
+		require.NoError(t, err)
+
+		generator.onPacket(seq, now)
+	}
+
+	if nack := generator.nackPacket(1234, now); nack != nil {
+		countNACKs([]rtcp.Packet{nack})
+	}
+
+	// wait longer than the pion NACK generator interval
+	time.Sleep(300 * time.Millisecond)
+
+	require.Equal(t, int32(1), sentNACKs.Load())
+}
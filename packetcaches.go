@@ -3,13 +3,43 @@ package sfu
 import (
 	"container/list"
 	"sync"
+	"time"
 )
 
+const (
+	// DefaultPacketCacheSize is the number of packets cached by each track to handle the late packets
+	DefaultPacketCacheSize = 1024
+	// MinPacketCacheSize is the smallest packet cache size allowed
+	MinPacketCacheSize = 64
+)
+
+// validPacketCacheSize return the default size if the size is not set, and the minimum size if the size is too small
+func validPacketCacheSize(size int) int {
+	if size == 0 {
+		return DefaultPacketCacheSize
+	}
+
+	if size < MinPacketCacheSize {
+		logger().Warn("packetcaches: cache size ", size, " is too small, use the minimum size ", MinPacketCacheSize)
+		return MinPacketCacheSize
+	}
+
+	return size
+}
+
 // buffer ring for cached packets
 type packetCaches struct {
-	size   int
-	mu     sync.RWMutex
-	caches *list.List
+	size int
+	// the max age in the RTP clock, the packets older than this from the latest packet are evicted. Disabled if zero
+	maxAge uint32
+	// the duration in the RTP clock that the adaptive size should hold, the size is fixed if zero
+	window    uint32
+	minSize   int
+	maxSize   int
+	rateStart uint32
+	rateCount int
+	mu        sync.RWMutex
+	caches    *list.List
 }
 
 type cachedPacket struct {
@@ -26,6 +56,54 @@ func newPacketCaches(size int) *packetCaches {
 	}
 }
 
+// setMaxAge evict the packets older than the max age from the latest pushed packet, the age is compared with the
+// packet timestamps so the clock rate of the track is required
+func (p *packetCaches) setMaxAge(maxAge time.Duration, clockRate uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxAge = uint32(maxAge.Seconds() * float64(clockRate))
+}
+
+// setWindow adapt the cache size to the packet rate so the cache hold about the window duration of packets,
+// the current size become the maximum size and the size never go below MinPacketCacheSize
+func (p *packetCaches) setWindow(window time.Duration, clockRate uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.window = uint32(window.Seconds() * float64(clockRate))
+	p.maxSize = p.size
+	p.minSize = min(MinPacketCacheSize, p.size)
+}
+
+// adaptSize count the packets pushed within a window, then resize the cache to the count scaled to the window
+func (p *packetCaches) adaptSize(timestamp uint32) {
+	if p.caches.Len() == 1 {
+		p.rateStart = timestamp
+		p.rateCount = 0
+
+		return
+	}
+
+	elapsed := timestamp - p.rateStart
+	if elapsed > 1<<31 {
+		// a late packet with an older timestamp
+		return
+	}
+
+	p.rateCount++
+
+	if elapsed < p.window {
+		return
+	}
+
+	target := int(uint64(p.rateCount) * uint64(p.window) / uint64(elapsed))
+	p.size = max(p.minSize, min(target, p.maxSize))
+
+	p.rateStart = timestamp
+	p.rateCount = 0
+}
+
 func (p *packetCaches) Push(sequence uint16, timestamp uint32, dropCounter uint16) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -36,9 +114,43 @@ func (p *packetCaches) Push(sequence uint16, timestamp uint32, dropCounter uint1
 		dropCounter: dropCounter,
 	})
 
-	if p.caches.Len() > p.size {
+	if p.window > 0 {
+		p.adaptSize(timestamp)
+	}
+
+	for p.caches.Len() > p.size {
 		p.caches.Remove(p.caches.Front())
 	}
+
+	if p.maxAge == 0 {
+		return
+	}
+
+	for e := p.caches.Front(); e != nil && e != p.caches.Back(); e = p.caches.Front() {
+		// the uint32 arithmetic handle the rollover, an age over the half range is a packet newer than the timestamp
+		age := timestamp - e.Value.(cachedPacket).timestamp
+		if age <= p.maxAge || age > 1<<31 {
+			break
+		}
+
+		p.caches.Remove(e)
+	}
+}
+
+// Reset remove all cached packets
+func (p *packetCaches) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.caches.Init()
+}
+
+// Len return the number of cached packets
+func (p *packetCaches) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.caches.Len()
 }
 
 func (p *packetCaches) GetPacket(sequence uint16) (cachedPacket, bool) {
@@ -50,7 +162,9 @@ Loop:
 		packet := e.Value.(cachedPacket)
 		if packet.sequence == sequence {
 			return packet, true
-		} else if packet.sequence > sequence {
+		} else if int16(packet.sequence-sequence) < 0 {
+			// the caches are ordered, the older packets can't match. The sequences are compared with the
+			// wraparound, so a packet before the wraparound is still found after the sequence is wrapped
 			break Loop
 		}
 	}
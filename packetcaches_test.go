@@ -1,5 +1,14 @@
 package sfu
 
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
 // import (
 // 	"slices"
 // 	"testing"
@@ -105,3 +114,137 @@ package sfu
 // 	}
 
 // }
+
+func TestPacketCacheSize(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, DefaultPacketCacheSize, validPacketCacheSize(0))
+	require.Equal(t, MinPacketCacheSize, validPacketCacheSize(10))
+	require.Equal(t, 2048, validPacketCacheSize(2048))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{PacketCacheSize: 128})
+	require.Equal(t, 128, s.PacketCacheSize())
+
+	p := newPacketCaches(s.PacketCacheSize())
+
+	for i := 0; i < s.PacketCacheSize(); i++ {
+		p.Push(uint16(i), uint32(i), 0)
+	}
+
+	require.Equal(t, s.PacketCacheSize(), p.caches.Len())
+	require.Equal(t, uint16(0), p.caches.Front().Value.(cachedPacket).sequence)
+
+	// the oldest packet is evicted when the cache is full
+	p.Push(uint16(s.PacketCacheSize()), uint32(s.PacketCacheSize()), 0)
+
+	require.Equal(t, s.PacketCacheSize(), p.caches.Len())
+	require.Equal(t, uint16(1), p.caches.Front().Value.(cachedPacket).sequence)
+}
+
+func TestPacketCacheGetPacketWraparound(t *testing.T) {
+	t.Parallel()
+
+	p := newPacketCaches(DefaultPacketCacheSize)
+
+	for _, seq := range []uint16{65533, 65534, 65535, 0, 1, 2} {
+		p.Push(seq, uint32(seq), 0)
+	}
+
+	// the packets before the wraparound are still found
+	for _, seq := range []uint16{65533, 65535, 0, 2} {
+		pkt, ok := p.GetPacket(seq)
+		require.True(t, ok, "sequence %d is not found", seq)
+		require.Equal(t, seq, pkt.sequence)
+	}
+
+	// the packets older than the cache and newer than the latest packet are not found
+	for _, seq := range []uint16{65500, 3} {
+		_, ok := p.GetPacket(seq)
+		require.False(t, ok)
+	}
+}
+
+func TestPacketCacheMaxAge(t *testing.T) {
+	t.Parallel()
+
+	p := newPacketCaches(DefaultPacketCacheSize)
+	p.setMaxAge(500*time.Millisecond, 90000)
+
+	// 100 packets in a second of the 90kHz clock
+	for i := 0; i < 100; i++ {
+		p.Push(uint16(i), uint32(i)*900, 0)
+	}
+
+	// only the packets within the last 500ms are kept even the cache is not full
+	require.Equal(t, 51, p.caches.Len())
+
+	_, ok := p.GetPacket(10)
+	require.False(t, ok)
+
+	_, ok = p.GetPacket(48)
+	require.False(t, ok)
+
+	_, ok = p.GetPacket(60)
+	require.True(t, ok)
+
+	// a late packet with an older timestamp doesn't evict the newer packets
+	p.Push(100, 95*900, 0)
+	_, ok = p.GetPacket(99)
+	require.True(t, ok)
+
+	// the age is compared across the timestamp rollover
+	rollover := newPacketCaches(DefaultPacketCacheSize)
+	rollover.setMaxAge(time.Second, 90000)
+	rollover.Push(1, math.MaxUint32-45000, 0)
+	rollover.Push(2, 40000, 0)
+
+	_, ok = rollover.GetPacket(1)
+	require.True(t, ok)
+
+	rollover.Push(3, 50000, 0)
+
+	_, ok = rollover.GetPacket(1)
+	require.False(t, ok)
+}
+
+func TestPacketCacheWindow(t *testing.T) {
+	t.Parallel()
+
+	p := newPacketCaches(4096)
+	p.setWindow(500*time.Millisecond, 90000)
+
+	fixed := newPacketCaches(4096)
+
+	// 2000 packets per second of the 90kHz clock for 2 seconds
+	sequence := uint16(0)
+	timestamp := uint32(0)
+	push := func(count int, interval uint32) {
+		for i := 0; i < count; i++ {
+			p.Push(sequence, timestamp, 0)
+			fixed.Push(sequence, timestamp, 0)
+			sequence++
+			timestamp += interval
+		}
+	}
+
+	push(4000, 45)
+
+	// the adaptive cache hold 500ms of packets while the fixed cache hold all of them
+	require.Equal(t, 1000, p.Len())
+	require.Equal(t, 4000, fixed.Len())
+
+	front := p.caches.Front().Value.(cachedPacket)
+	back := p.caches.Back().Value.(cachedPacket)
+	require.InDelta(t, 45000, back.timestamp-front.timestamp, 45)
+
+	// the rate drop to 100 packets per second, the cache shrink to the minimum size
+	push(200, 900)
+	require.Equal(t, MinPacketCacheSize, p.Len())
+
+	// the rate increase again, the cache grow back but never above the configured size
+	push(20000, 9)
+	require.Equal(t, 4096, p.Len())
+}
@@ -0,0 +1,131 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// DefaultPacketQueueSize is the number of packets queued by each scaleable track while the local track writer is busy
+	DefaultPacketQueueSize = 64
+	// MinPacketQueueSize is the smallest packet queue size allowed
+	MinPacketQueueSize = 8
+	// packetQueueKeyframeHeadroom is how many times the size the queue can hold to keep the keyframe packets
+	packetQueueKeyframeHeadroom = 2
+)
+
+// validPacketQueueSize return the default size if the size is not set, and the minimum size if the size is too small
+func validPacketQueueSize(size int) int {
+	if size == 0 {
+		return DefaultPacketQueueSize
+	}
+
+	if size < MinPacketQueueSize {
+		logger().Warn("packetqueue: queue size ", size, " is too small, use the minimum size ", MinPacketQueueSize)
+		return MinPacketQueueSize
+	}
+
+	return size
+}
+
+type queuedPacket struct {
+	packet     rtp.Packet
+	isKeyframe bool
+}
+
+// packetQueue is a bounded queue between the track reader and the local track writer.
+// The reader never block on a slow writer, when the queue is full the oldest non-keyframe packet is dropped instead.
+// The keyframe packets can hold the queue over the size, up to packetQueueKeyframeHeadroom times the size.
+type packetQueue struct {
+	mu      sync.Mutex
+	size    int
+	packets []queuedPacket
+	signal  chan struct{}
+}
+
+func newPacketQueue(size int) *packetQueue {
+	return &packetQueue{
+		mu:      sync.Mutex{},
+		size:    size,
+		packets: make([]queuedPacket, 0, size),
+		signal:  make(chan struct{}, 1),
+	}
+}
+
+// push add the packet to the queue. When the queue is full, the oldest non-keyframe packet is dropped.
+// The sequence numbers are not shifted, so the subscriber see the gap as a loss and can recover with NACK or PLI.
+// It returns the number of dropped packets.
+func (q *packetQueue) push(p queuedPacket) (dropped int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.packets = append(q.packets, p)
+
+	if len(q.packets) > q.size {
+		dropped = q.dropOldest()
+	}
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	return dropped
+}
+
+// dropOldest drop the oldest non-keyframe packet, the keyframe packets are kept even the queue is over the size
+// because the client can't decode anything until the next keyframe without them. When the queue only has the keyframe
+// packets and reach the headroom, the oldest whole frame is dropped because a partial keyframe can't be decoded either.
+func (q *packetQueue) dropOldest() int {
+	for i, queued := range q.packets {
+		if queued.isKeyframe {
+			continue
+		}
+
+		q.packets = append(q.packets[:i], q.packets[i+1:]...)
+
+		return 1
+	}
+
+	if len(q.packets) <= q.size*packetQueueKeyframeHeadroom {
+		return 0
+	}
+
+	timestamp := q.packets[0].packet.Timestamp
+	packets := q.packets[:0]
+
+	for _, queued := range q.packets {
+		if queued.packet.Timestamp != timestamp {
+			packets = append(packets, queued)
+		}
+	}
+
+	dropped := len(q.packets) - len(packets)
+	q.packets = packets
+
+	return dropped
+}
+
+// pop return the oldest packet in the queue
+func (q *packetQueue) pop() (queuedPacket, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.packets) == 0 {
+		return queuedPacket{}, false
+	}
+
+	p := q.packets[0]
+	q.packets = q.packets[1:]
+
+	return p, true
+}
+
+// Len return the number of queued packets
+func (q *packetQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.packets)
+}
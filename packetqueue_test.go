@@ -0,0 +1,144 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketQueueDropOldestNonKeyframe(t *testing.T) {
+	t.Parallel()
+
+	q := newPacketQueue(MinPacketQueueSize)
+
+	// nothing pop the queue, like a stalled writer
+	dropped := 0
+	for i := uint16(1); i <= 20; i++ {
+		dropped += q.push(queuedPacket{packet: rtp.Packet{Header: rtp.Header{SequenceNumber: i}}, isKeyframe: i <= 3})
+	}
+
+	require.Equal(t, MinPacketQueueSize, q.Len())
+	require.Equal(t, 20-MinPacketQueueSize, dropped)
+
+	// the keyframe packets survive
+	for i := uint16(1); i <= 3; i++ {
+		queued, ok := q.pop()
+		require.True(t, ok)
+		require.True(t, queued.isKeyframe)
+		require.Equal(t, i, queued.packet.SequenceNumber)
+	}
+
+	// the writer continue from the latest packets, the dropped packets are left as a gap
+	for i := uint16(20 - MinPacketQueueSize + 4); i <= 20; i++ {
+		queued, ok := q.pop()
+		require.True(t, ok)
+		require.False(t, queued.isKeyframe)
+		require.Equal(t, i, queued.packet.SequenceNumber)
+	}
+}
+
+func TestPacketQueueKeyframeHardCap(t *testing.T) {
+	t.Parallel()
+
+	q := newPacketQueue(MinPacketQueueSize)
+
+	// nothing pop the queue while the publisher send a keyframe on every frame
+	dropped := 0
+	for i := uint16(0); i < 100; i++ {
+		dropped += q.push(queuedPacket{
+			packet:     rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: uint32(i/4) * 3000}},
+			isKeyframe: true,
+		})
+
+		require.LessOrEqual(t, q.Len(), MinPacketQueueSize*packetQueueKeyframeHeadroom)
+	}
+
+	require.Equal(t, 100-q.Len(), dropped)
+
+	// the oldest frames are dropped whole, the queue start from the first packet of a frame
+	queued, ok := q.pop()
+	require.True(t, ok)
+	require.Equal(t, uint16(0), queued.packet.SequenceNumber%4)
+}
+
+func TestScaleableTrackSlowWriter(t *testing.T) {
+	t.Parallel()
+
+	// the writer is not started to simulate a local track that is stalled
+	track := &scaleableClientTrack{
+		packetCaches: newPacketCaches(DefaultPacketCacheSize),
+		packetQueue:  newPacketQueue(MinPacketQueueSize),
+	}
+
+	// the first packet of the keyframe is detected as keyframe, the rest share the timestamp
+	for i := uint16(1); i <= 3; i++ {
+		track.send(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: 3000}}, false, i == 1)
+	}
+
+	for i := uint16(4); i <= 15; i++ {
+		track.send(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: uint32(i) * 3000}}, false, false)
+	}
+
+	require.Equal(t, MinPacketQueueSize, track.packetQueue.Len())
+	require.Equal(t, uint64(15-MinPacketQueueSize), track.drops.dropsCongestion.Load())
+	require.Equal(t, uint16(0), track.dropCounter)
+	require.Equal(t, uint16(15), track.lastSentSequenceNumber)
+
+	for i := uint16(1); i <= 3; i++ {
+		queued, ok := track.packetQueue.pop()
+		require.True(t, ok)
+		require.True(t, queued.isKeyframe, "keyframe packet ", i, " is dropped")
+		require.Equal(t, i, queued.packet.SequenceNumber)
+	}
+}
+
+func TestScaleableTrackLatePacketAfterQueueDrop(t *testing.T) {
+	t.Parallel()
+
+	track := &scaleableClientTrack{
+		packetCaches: newPacketCaches(DefaultPacketCacheSize),
+		packetQueue:  newPacketQueue(MinPacketQueueSize),
+	}
+
+	for i := uint16(1); i <= 3; i++ {
+		track.send(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: 3000}}, false, i == 1)
+	}
+
+	// the packet 10 is lost on the way from the publisher, the stalled writer make the queue drop the older packets
+	for i := uint16(4); i <= 15; i++ {
+		if i == 10 {
+			continue
+		}
+
+		track.send(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: uint32(i) * 3000}}, false, false)
+	}
+
+	require.Positive(t, track.drops.dropsCongestion.Load())
+
+	// the queued packets keep the sequence number that is cached for them
+	track.packetQueue.mu.Lock()
+	for _, queued := range track.packetQueue.packets {
+		cached, ok := track.packetCaches.GetPacket(queued.packet.SequenceNumber)
+		require.True(t, ok)
+		require.Equal(t, cached.sequence-cached.dropCounter, queued.packet.SequenceNumber)
+	}
+	track.packetQueue.mu.Unlock()
+
+	// the retransmitted packet arrive late and take its place in the sequence
+	track.send(rtp.Packet{Header: rtp.Header{SequenceNumber: 10, Timestamp: 30000}}, true, false)
+
+	sent := make([]uint16, 0)
+	for {
+		queued, ok := track.packetQueue.pop()
+		if !ok {
+			break
+		}
+
+		sent = append(sent, queued.packet.SequenceNumber)
+	}
+
+	// the late packet is forwarded with the sequence number of its gap, the full queue drop the oldest packet 11
+	// and it is left as a gap too
+	require.Equal(t, []uint16{1, 2, 3, 12, 13, 14, 15, 10}, sent)
+}
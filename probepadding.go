@@ -0,0 +1,87 @@
+package sfu
+
+import "time"
+
+const (
+	// the interval to give the probing claims the padding budget to send the probe bitrate
+	probePaddingInterval = 100 * time.Millisecond
+	// the padding is never sent faster than this bitrate
+	maxProbePaddingBitrate = 1_000_000
+	// the padding is stopped if the probe is not committed or cancelled within this duration
+	maxProbePaddingDuration = 3 * time.Second
+	// the padding size of a padding only packet, the largest padding allowed by RTP
+	paddingPacketSize = 255
+	// the padding is sent in bursts after each forwarded picture, the burst is bounded to keep the pacing smooth
+	maxPaddingPacketsPerPicture = 8
+)
+
+// paddingSender is implemented by the client tracks that own the sequence numbers sent to the client,
+// so the padding packets can be inserted between the media packets without breaking the sequence
+type paddingSender interface {
+	setPaddingBudget(bytes int)
+}
+
+// monitorProbePadding update the padding budget of the probing claims. The bandwidth estimator only report a higher
+// bandwidth after the SFU send more, so the padding fill the probe bitrate until the next quality is committed.
+func (bc *bitrateController) monitorProbePadding() {
+	if !bc.useBandwidthEstimation || !bc.client.SFU().ProbePaddingEnabled() {
+		return
+	}
+
+	bc.wg.Add(1)
+
+	go func() {
+		defer bc.wg.Done()
+
+		ticker := time.NewTicker(probePaddingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bc.context.Done():
+				return
+			case now := <-ticker.C:
+				bc.updateProbePadding(now)
+			}
+		}
+	}()
+}
+
+// updateProbePadding give every claim the padding budget for the next interval, zero if the claim is not probing
+func (bc *bitrateController) updateProbePadding(now time.Time) {
+	for _, claim := range bc.Claims() {
+		sender, ok := claim.track.(paddingSender)
+		if !ok {
+			continue
+		}
+
+		sender.setPaddingBudget(bc.probePaddingBytes(claim, now))
+	}
+}
+
+// probePaddingBytes return the bytes to send in an interval to fill the gap between the probe bitrate and the committed bitrate
+func (bc *bitrateController) probePaddingBytes(claim *bitrateClaim, now time.Time) int {
+	if claim.isSuspended() {
+		return 0
+	}
+
+	claim.mu.RLock()
+	quality := claim.quality
+	probeQuality := claim.probeQuality
+	probeStartTime := claim.probeStartTime
+	probeBitrate := claim.bitrate
+	claim.mu.RUnlock()
+
+	if probeQuality == QualityNone || now.Sub(probeStartTime) > maxProbePaddingDuration {
+		return 0
+	}
+
+	committedBitrate := bc.qualityBitrate(claim.track, quality)
+	if probeBitrate <= committedBitrate {
+		return 0
+	}
+
+	paddingBitrate := min(probeBitrate-committedBitrate, maxProbePaddingBitrate)
+
+	return int(uint64(paddingBitrate) * uint64(probePaddingInterval) / uint64(time.Second) / 8)
+}
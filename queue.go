@@ -5,7 +5,6 @@ import (
 	"errors"
 	"sync/atomic"
 
-	"github.com/golang/glog"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -50,7 +49,7 @@ func NewQueue(ctx context.Context) *queue {
 func (q *queue) Push(item interface{}) {
 	go func() {
 		if !q.IsOpen.Load() {
-			glog.Warning("sfu: queue is closed when push renegotiation")
+			logger().Warn("sfu: queue is closed when push renegotiation")
 			return
 		}
 
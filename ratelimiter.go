@@ -0,0 +1,146 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// DataChannelRateLimit limit the data channel messages relayed from each client to the other clients,
+// the client can send a burst up to one second of the rate. Zero means unlimited.
+type DataChannelRateLimit struct {
+	MessagesPerSecond int
+	BytesPerSecond    int
+}
+
+// tokenBucket allow the burst up to the rate, then refill the tokens at the rate per second
+type tokenBucket struct {
+	rate       float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:       float64(rate),
+		tokens:     float64(rate),
+		lastRefill: now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.lastRefill).Seconds(); elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed*b.rate)
+		b.lastRefill = now
+	}
+}
+
+// dataChannelRateLimiter limit the messages and the bytes sent by a client on all of its data channels
+type dataChannelRateLimiter struct {
+	mu       sync.Mutex
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newDataChannelRateLimiter(limit DataChannelRateLimit) *dataChannelRateLimiter {
+	now := time.Now()
+	limiter := &dataChannelRateLimiter{
+		mu: sync.Mutex{},
+	}
+
+	if limit.MessagesPerSecond > 0 {
+		limiter.messages = newTokenBucket(limit.MessagesPerSecond, now)
+	}
+
+	if limit.BytesPerSecond > 0 {
+		limiter.bytes = newTokenBucket(limit.BytesPerSecond, now)
+	}
+
+	return limiter
+}
+
+// allow return true and take the tokens if the message is within both limits, the tokens are not taken if the
+// message is over any of the limits
+func (l *dataChannelRateLimiter) allow(size int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.messages != nil {
+		l.messages.refill(now)
+		if l.messages.tokens < 1 {
+			return false
+		}
+	}
+
+	if l.bytes != nil {
+		l.bytes.refill(now)
+		if l.bytes.tokens < float64(size) {
+			return false
+		}
+	}
+
+	if l.messages != nil {
+		l.messages.tokens--
+	}
+
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(size)
+	}
+
+	return true
+}
+
+// dataChannelDropReportInterval is how often the dropped data channel messages are reported
+const dataChannelDropReportInterval = time.Second
+
+// droppedMessages is the number of the messages and the bytes dropped on a data channel within a report interval
+type droppedMessages struct {
+	messages int
+	bytes    int
+}
+
+// dropReporter aggregate the dropped messages per data channel label and report them once per interval,
+// so a flood doesn't log and fire an event for every dropped message. The reports are called in order on one goroutine.
+type dropReporter struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	drops     map[string]droppedMessages
+	scheduled bool
+	report    func(label string, drops droppedMessages)
+}
+
+func newDropReporter(interval time.Duration, report func(label string, drops droppedMessages)) *dropReporter {
+	return &dropReporter{
+		mu:       sync.Mutex{},
+		interval: interval,
+		drops:    make(map[string]droppedMessages),
+		report:   report,
+	}
+}
+
+// add count the dropped message, the report is scheduled at the end of the interval if not scheduled yet
+func (r *dropReporter) add(label string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	drops := r.drops[label]
+	drops.messages++
+	drops.bytes += size
+	r.drops[label] = drops
+
+	if !r.scheduled {
+		r.scheduled = true
+		time.AfterFunc(r.interval, r.flush)
+	}
+}
+
+func (r *dropReporter) flush() {
+	r.mu.Lock()
+	drops := r.drops
+	r.drops = make(map[string]droppedMessages)
+	r.scheduled = false
+	r.mu.Unlock()
+
+	for label, dropped := range drops {
+		r.report(label, dropped)
+	}
+}
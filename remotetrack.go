@@ -8,11 +8,13 @@ import (
 
 	"sync/atomic"
 
-	"github.com/golang/glog"
 	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/rtp"
 )
 
+// DefaultPLIDebounceWindow is the default window to coalesce the PLIs sent to the publisher
+const DefaultPLIDebounceWindow = 500 * time.Millisecond
+
 type remoteTrack struct {
 	context               context.Context
 	cancel                context.CancelFunc
@@ -27,12 +29,14 @@ type remoteTrack struct {
 	currentBytesReceived  *atomic.Uint64
 	latestUpdatedTS       *atomic.Uint64
 	lastPLIRequestTime    time.Time
+	pliDebounceWindow     time.Duration
 	onEndedCallbacks      []func()
 	statsGetter           stats.Getter
 	onStatsUpdated        func(*stats.Stats)
+	nackGenerator         *nackGenerator
 }
 
-func newRemoteTrack(ctx context.Context, track IRemoteTrack, pliInterval time.Duration, onPLI func(), statsGetter stats.Getter, onStatsUpdated func(*stats.Stats), onRead func(rtp.Packet)) *remoteTrack {
+func newRemoteTrack(ctx context.Context, track IRemoteTrack, pliInterval, pliDebounceWindow time.Duration, onPLI func(), nack nackOptions, statsGetter stats.Getter, onStatsUpdated func(*stats.Stats), onRead func(rtp.Packet)) *remoteTrack {
 	localctx, cancel := context.WithCancel(ctx)
 	rt := &remoteTrack{
 		context:               localctx,
@@ -48,12 +52,17 @@ func newRemoteTrack(ctx context.Context, track IRemoteTrack, pliInterval time.Du
 		onStatsUpdated:        onStatsUpdated,
 		onPLI:                 onPLI,
 		onRead:                onRead,
+		pliDebounceWindow:     pliDebounceWindow,
 	}
 
 	if pliInterval > 0 {
 		rt.enableIntervalPLI(pliInterval)
 	}
 
+	if nack.onNACK != nil && !rt.IsRelay() {
+		rt.enableNACK(nack)
+	}
+
 	rt.readRTP()
 
 	return rt
@@ -72,7 +81,7 @@ func (t *remoteTrack) readRTP() {
 				return
 			default:
 				if err := t.track.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
-					glog.Error("error setting read deadline: ", err.Error())
+					logger().Error("error setting read deadline: ", err.Error())
 					continue
 				}
 
@@ -82,6 +91,10 @@ func (t *remoteTrack) readRTP() {
 				}
 
 				if rtp != nil {
+					if t.nackGenerator != nil {
+						t.nackGenerator.onPacket(rtp.SequenceNumber, time.Now())
+					}
+
 					t.onRead(*rtp)
 
 					if !t.IsRelay() {
@@ -98,7 +111,7 @@ func (t *remoteTrack) KeyFrameReceived() {
 	defer t.mu.Unlock()
 
 	if t.pliCancel != nil {
-		glog.Info("remotetrack: keyframe received ", t.track.ID())
+		logger().Info("remotetrack: keyframe received ", t.track.ID())
 		t.pliCancel()
 	}
 }
@@ -106,7 +119,7 @@ func (t *remoteTrack) KeyFrameReceived() {
 func (t *remoteTrack) updateStats() {
 	s := t.statsGetter.Get(uint32(t.track.SSRC()))
 	if s == nil {
-		glog.Warning("remotetrack: stats not found for track: ", t.track.SSRC())
+		logger().Warn("remotetrack: stats not found for track: ", t.track.SSRC())
 		return
 	}
 
@@ -143,16 +156,16 @@ func (t *remoteTrack) GetCurrentBitrate() uint32 {
 	return t.bitrate.Load()
 }
 
+// sendPLI send the PLI immediately if there is no PLI sent within the debounce window,
+// otherwise the request is coalesced into the PLI that already sent in the window
 func (t *remoteTrack) sendPLI() {
-	// return if there is a pending PLI request
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	maxGapSeconds := 250 * time.Millisecond
 	requestGap := time.Since(t.lastPLIRequestTime)
 
-	if requestGap < maxGapSeconds {
-		return // ignore PLI request
+	if requestGap < t.pliDebounceWindow {
+		return // coalesce PLI request
 	}
 
 	t.lastPLIRequestTime = time.Now()
@@ -178,6 +191,29 @@ func (t *remoteTrack) enableIntervalPLI(interval time.Duration) {
 	}()
 }
 
+// enableNACK request the missing packets to the publisher, the retransmitted packets are forwarded as late packets
+func (t *remoteTrack) enableNACK(opts nackOptions) {
+	t.nackGenerator = newNACKGenerator(opts.retransmitLimit, opts.backoff)
+
+	go func() {
+		ctx, cancel := context.WithCancel(t.context)
+		defer cancel()
+		ticker := time.NewTicker(t.nackGenerator.backoff)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if nack := t.nackGenerator.nackPacket(uint32(t.track.SSRC()), time.Now()); nack != nil {
+					opts.onNACK(nack)
+				}
+			}
+		}
+	}()
+}
+
 func (t *remoteTrack) IsRelay() bool {
 	_, ok := t.track.(*RelayTrack)
 	return ok
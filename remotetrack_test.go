@@ -0,0 +1,40 @@
+package sfu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPLIDebounce(t *testing.T) {
+	t.Parallel()
+
+	pliCount := &atomic.Int32{}
+
+	rt := &remoteTrack{
+		pliDebounceWindow: DefaultPLIDebounceWindow,
+		onPLI: func() {
+			pliCount.Add(1)
+		},
+	}
+
+	track := &scaleableClientTrack{
+		remoteTrack: &Track{remoteTrack: rt},
+	}
+
+	// 10 requests within 100ms are coalesced into one PLI
+	for i := 0; i < 10; i++ {
+		track.RequestPLI()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Equal(t, int32(1), pliCount.Load())
+
+	// the first request after the window is sent immediately
+	time.Sleep(DefaultPLIDebounceWindow)
+	track.RequestPLI()
+
+	require.Equal(t, int32(2), pliCount.Load())
+}
@@ -5,7 +5,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -108,18 +107,73 @@ type RoomOptions struct {
 	// Configures the interval between sending PLIs to clients that will generate keyframe
 	// More often means more bandwidth usage but more stability on video quality
 	PLIInterval time.Duration
+	// Configures the window to coalesce the PLIs that sent to the publisher, at most one PLI per track layer
+	// is sent within the window to avoid flooding the publisher with keyframe requests
+	PLIDebounceWindow time.Duration
+	// Configures the time without any packet from the publisher before a subscribed track is considered stalled,
+	// the stalled track is excluded from the subscriber bandwidth allocation until the packets are received again
+	TrackStallTimeout time.Duration
+	// Configures how long the SFU wait for the simulcast layers of a publisher when a track is subscribed, the keyframe
+	// of every layer is requested and the subscriber max quality is capped to the highest layer received until a higher
+	// layer is sent. Disabled if zero, DefaultSimulcastProbeTimeout is a good value to enable it
+	SimulcastProbeTimeout time.Duration
+	// Configures the number of NACKs sent to the publisher to request each missing video packet
+	NACKRetransmitLimit int
+	// Configures the wait before a NACK is repeated, the wait is doubled on every retry
+	NACKBackoff time.Duration
+	// Configures the number of the latest messages kept for each data channel created by the room,
+	// the messages are replayed to the clients that join later. Disabled if zero
+	DataChannelHistorySize int
+	// Configures the number of messages and bytes per second that each client can send on the data channels created
+	// by the room, the messages over the limit are not relayed to the other clients. Unlimited if zero
+	DataChannelRateLimit DataChannelRateLimit
+	// Configures the number of packets cached by each scaleable track to handle the late packets
+	// Use a bigger cache for high bitrate tracks like screen share, the minimum is 64 packets
+	PacketCacheSize int
+	// Configures how long a packet is kept in the packet cache, the packets older than this are evicted even the cache
+	// is not full because they are too late to be forwarded anyway. Disabled if zero
+	PacketCacheMaxAge time.Duration
+	// Configures the packet cache to adapt its size to the packet rate of the track, so it holds about this duration
+	// of packets whatever the bitrate is. The size stay between 64 packets and the PacketCacheSize. Disabled if zero
+	PacketCacheWindow time.Duration
+	// Configures the number of packets queued by each scaleable track while the client connection is congested
+	// When the queue is full the oldest non-keyframe packet is dropped, the minimum is 8 packets
+	PacketQueueSize int
+	// Configures the interval between the transport-cc feedbacks sent to the publisher, used by the publisher to estimate the bandwidth
+	// Shorter interval makes the bandwidth estimation more reactive but with more RTCP overhead, the range is 10ms to 1s
+	TWCCFeedbackInterval time.Duration
+	// Configures the SFU to send padding packets while a quality increase is probed, so the bandwidth estimator
+	// can observe the higher bitrate before the quality is committed. Only used with the bandwidth estimator enabled
+	EnableProbePadding bool
+	// Configures which video source keep its quality longer when the subscriber bandwidth is not enough,
+	// the default DegradationScreenPriority reduce the camera tracks before the screen share tracks
+	DegradationPolicy DegradationPolicy
+	// Configures the quality of the simulcast layers for the publishers that use custom RID labels,
+	// for example {"q": QualityLow, "h": QualityMid, "f": QualityHigh}. The RIDs not in the map use the default
+	// "high", "mid" and "low" labels, and unknown RIDs are bound to the low quality
+	SimulcastRIDQualities map[string]QualityLevel
 	// Configure the mapping of spatsial and temporal layers to quality level
 	// Use this to use scalable video coding (SVC) to control the bitrate level of the video
 	QualityPreset QualityPreset
+	// Configure the quality preset of a codec mime type, for example webrtc.MimeTypeVP9, when the codecs use
+	// different layer structures. The codecs not in the map use the QualityPreset.
+	CodecQualityPresets map[string]QualityPreset
 }
 
 func DefaultRoomOptions() RoomOptions {
 	return RoomOptions{
-		Bitrates:      DefaultBitrates(),
-		QualityPreset: DefaultQualityPreset(),
-		Codecs:        []string{webrtc.MimeTypeVP9, webrtc.MimeTypeH264, "audio/red", webrtc.MimeTypeOpus},
-		ClientTimeout: 10 * time.Minute,
-		PLIInterval:   0,
+		Bitrates:             DefaultBitrates(),
+		QualityPreset:        DefaultQualityPreset(),
+		Codecs:               []string{webrtc.MimeTypeVP9, webrtc.MimeTypeH264, "audio/red", webrtc.MimeTypeOpus},
+		ClientTimeout:        10 * time.Minute,
+		PLIInterval:          0,
+		PLIDebounceWindow:    DefaultPLIDebounceWindow,
+		TrackStallTimeout:    DefaultTrackStallTimeout,
+		NACKRetransmitLimit:  DefaultNACKRetransmitLimit,
+		NACKBackoff:          DefaultNACKBackoff,
+		PacketCacheSize:      DefaultPacketCacheSize,
+		PacketQueueSize:      DefaultPacketQueueSize,
+		TWCCFeedbackInterval: DefaultTWCCFeedbackInterval,
 	}
 }
 
@@ -224,7 +278,10 @@ func (r *Room) AddClient(id, name string, opts ClientOptions) (*Client, error) {
 		return nil, ErrClientExists
 	}
 
-	client = r.sfu.NewClient(id, name, opts)
+	client, err := r.sfu.NewClient(id, name, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// stop client if not connecting for a specific time
 	initConnection := true
@@ -247,7 +304,7 @@ func (r *Room) AddClient(id, name string, opts ClientOptions) (*Client, error) {
 
 		select {
 		case <-timeout.Done():
-			glog.Warning("room: client is not connected after added, stopping client...")
+			logger().Warn("room: client is not connected after added, stopping client...")
 			_ = r.StopClient(client.ID())
 			timeoutReached = true
 
@@ -395,7 +452,7 @@ func (r *Room) CreateDataChannel(label string, opts DataChannelOptions) error {
 // Inconsistent bitrate configuration between client and server will result missed bitrate calculation and
 // could affecting packet loss and media quality
 func (r *Room) BitrateConfigs() BitrateConfigs {
-	return r.sfu.bitrateConfigs
+	return r.sfu.BitrateConfigs()
 }
 
 // CodecPreferences return the current codec preferences that used in SFU
@@ -57,3 +57,38 @@ type RoomStats struct {
 	Timestamp          time.Time                    `json:"timestamp"`
 	ClientStats        map[string]*ClientTrackStats `json:"client_stats"`
 }
+
+// TrackDumpStats is the state of a track that forwarded to a client
+type TrackDumpStats struct {
+	ID                  string       `json:"id"`
+	Kind                string       `json:"kind"`
+	Simulcast           bool         `json:"simulcast"`
+	Scaleable           bool         `json:"scaleable"`
+	Quality             QualityLevel `json:"quality"`
+	ClaimedBitrate      uint32       `json:"claimed_bitrate"`
+	PacketCacheOccupied int          `json:"packet_cache_occupied"`
+	FractionLost        float64      `json:"fraction_lost"`
+}
+
+// SubscriberLossStats summarize the fraction lost reported by the subscribers of a published track
+// in their receiver reports, use it to decide the keyframe and encoding of the publisher
+type SubscriberLossStats struct {
+	TrackID            string  `json:"track_id"`
+	Subscribers        int     `json:"subscribers"`
+	WorstFractionLost  float64 `json:"worst_fraction_lost"`
+	MedianFractionLost float64 `json:"median_fraction_lost"`
+}
+
+// ClientDumpStats is the bandwidth and the forwarded tracks state of a client
+type ClientDumpStats struct {
+	ID                 string           `json:"id"`
+	Name               string           `json:"name"`
+	EstimatedBandwidth uint32           `json:"estimated_bandwidth"`
+	Tracks             []TrackDumpStats `json:"tracks"`
+}
+
+// SFUDumpStats is a snapshot of all clients in the SFU for debugging purpose
+type SFUDumpStats struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Clients   []ClientDumpStats `json:"clients"`
+}
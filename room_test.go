@@ -313,3 +313,48 @@ func TestRoomAddClientTimeout(t *testing.T) {
 		require.Equal(t, c.ID(), client.ID())
 	}
 }
+
+func TestRoomMaxClients(t *testing.T) {
+	t.Parallel()
+
+	roomOpts := DefaultRoomOptions()
+	roomOpts.Codecs = []string{webrtc.MimeTypeH264, webrtc.MimeTypeOpus}
+	testRoom, err := roomManager.NewRoom(roomManager.CreateRoomID(), "test-room", RoomTypeLocal, roomOpts)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = testRoom.Close()
+	}()
+
+	maxClients := 3
+	testRoom.SFU().SetMaxClients(maxClients)
+
+	errs := make(chan error, maxClients+1)
+
+	// the clients join concurrently, only the limit is admitted
+	for i := 0; i <= maxClients; i++ {
+		go func() {
+			id := testRoom.CreateClientID()
+			_, err := testRoom.SFU().NewClient(id, id, DefaultClientOptions())
+			errs <- err
+		}()
+	}
+
+	rejected := 0
+
+	for i := 0; i <= maxClients; i++ {
+		if err := <-errs; err != nil {
+			require.ErrorIs(t, err, ErrServerFull)
+			rejected++
+		}
+	}
+
+	require.Equal(t, 1, rejected)
+	require.Equal(t, maxClients, testRoom.SFU().clients.Length())
+
+	id := testRoom.CreateClientID()
+	client, err := testRoom.AddClient(id, id, DefaultClientOptions())
+	require.ErrorIs(t, err, ErrServerFull)
+	require.Nil(t, client)
+	require.Equal(t, maxClients, testRoom.SFU().clients.Length())
+}
@@ -2,10 +2,12 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/exp/slices"
@@ -14,38 +16,82 @@ import (
 // BitrateConfigs is the configuration for the bitrate that will be used for adaptive bitrates controller
 // The paramenter is in bps (bit per second) for non pixels parameters.
 // For pixels parameters, it is total pixels (width * height) of the video.
-// High, Mid, and Low are the references for bitrate controller to decide the max bitrate to send to the client.
+// VeryHigh, High, Mid, and Low are the references for bitrate controller to decide the max bitrate to send to the client.
 type BitrateConfigs struct {
-	AudioRed         uint32 `json:"audio_red,omitempty" yaml:"audio_red,omitempty" mapstructure:"audio_red,omitempty"`
-	Audio            uint32 `json:"audio,omitempty" yaml:"audio,omitempty" mapstructure:"audio,omitempty"`
-	Video            uint32 `json:"video,omitempty" yaml:"video,omitempty" mapstructure:"video,omitempty"`
-	VideoHigh        uint32 `json:"video_high,omitempty" yaml:"video_high,omitempty" mapstructure:"video_high,omitempty"`
-	VideoHighPixels  uint32 `json:"video_high_pixels,omitempty" yaml:"video_high_pixels,omitempty" mapstructure:"video_high_pixels,omitempty"`
-	VideoMid         uint32 `json:"video_mid,omitempty" yaml:"video_mid,omitempty" mapstructure:"video_mid,omitempty"`
-	VideoMidPixels   uint32 `json:"video_mid_pixels,omitempty" yaml:"video_mid_pixels,omitempty" mapstructure:"video_mid_pixels,omitempty"`
-	VideoLow         uint32 `json:"video_low,omitempty" yaml:"video_low,omitempty" mapstructure:"video_low,omitempty"`
-	VideoLowPixels   uint32 `json:"video_low_pixels,omitempty" yaml:"video_low_pixels,omitempty" mapstructure:"video_low_pixels,omitempty"`
+	AudioRed uint32 `json:"audio_red,omitempty" yaml:"audio_red,omitempty" mapstructure:"audio_red,omitempty"`
+	Audio    uint32 `json:"audio,omitempty" yaml:"audio,omitempty" mapstructure:"audio,omitempty"`
+	Video    uint32 `json:"video,omitempty" yaml:"video,omitempty" mapstructure:"video,omitempty"`
+	// the very high tier is disabled when it is 0, so the high quality stay the highest quality to allocate
+	VideoVeryHigh   uint32 `json:"video_very_high,omitempty" yaml:"video_very_high,omitempty" mapstructure:"video_very_high,omitempty"`
+	VideoHigh       uint32 `json:"video_high,omitempty" yaml:"video_high,omitempty" mapstructure:"video_high,omitempty"`
+	VideoHighPixels uint32 `json:"video_high_pixels,omitempty" yaml:"video_high_pixels,omitempty" mapstructure:"video_high_pixels,omitempty"`
+	VideoMid        uint32 `json:"video_mid,omitempty" yaml:"video_mid,omitempty" mapstructure:"video_mid,omitempty"`
+	VideoMidPixels  uint32 `json:"video_mid_pixels,omitempty" yaml:"video_mid_pixels,omitempty" mapstructure:"video_mid_pixels,omitempty"`
+	VideoLow        uint32 `json:"video_low,omitempty" yaml:"video_low,omitempty" mapstructure:"video_low,omitempty"`
+	VideoLowPixels  uint32 `json:"video_low_pixels,omitempty" yaml:"video_low_pixels,omitempty" mapstructure:"video_low_pixels,omitempty"`
+	// screen share is encoded with higher resolution and lower framerate than camera, so it need its own bitrate for each quality
+	ScreenVeryHigh   uint32 `json:"screen_very_high,omitempty" yaml:"screen_very_high,omitempty" mapstructure:"screen_very_high,omitempty"`
+	ScreenHigh       uint32 `json:"screen_high,omitempty" yaml:"screen_high,omitempty" mapstructure:"screen_high,omitempty"`
+	ScreenMid        uint32 `json:"screen_mid,omitempty" yaml:"screen_mid,omitempty" mapstructure:"screen_mid,omitempty"`
+	ScreenLow        uint32 `json:"screen_low,omitempty" yaml:"screen_low,omitempty" mapstructure:"screen_low,omitempty"`
 	InitialBandwidth uint32 `json:"initial_bandwidth,omitempty" yaml:"initial_bandwidth,omitempty" mapstructure:"initial_bandwidth,omitempty"`
+	// the delay between bitrate adjustments is scaled by the measured RTT and clamped to these bounds
+	AdjustmentDelayMin time.Duration `json:"adjustment_delay_min,omitempty" yaml:"adjustment_delay_min,omitempty" mapstructure:"adjustment_delay_min,omitempty"`
+	AdjustmentDelayMax time.Duration `json:"adjustment_delay_max,omitempty" yaml:"adjustment_delay_max,omitempty" mapstructure:"adjustment_delay_max,omitempty"`
+	// the bandwidth reserved on top of the audio claims bitrate before the video is allocated
+	AudioHeadroom uint32 `json:"audio_headroom,omitempty" yaml:"audio_headroom,omitempty" mapstructure:"audio_headroom,omitempty"`
+	// the highest quality that a new video claim start with, the bitrate adjuster ramp it up once the bandwidth estimation is warmed up
+	StartupQuality QualityLevel `json:"startup_quality,omitempty" yaml:"startup_quality,omitempty" mapstructure:"startup_quality,omitempty"`
+	// the fraction of the estimated bandwidth used by the RTP, UDP and IP headers, the quality bitrates are payload bitrates
+	// so the claims are allocated from the bandwidth without the overhead
+	PacketOverhead float64 `json:"packet_overhead,omitempty" yaml:"packet_overhead,omitempty" mapstructure:"packet_overhead,omitempty"`
+	// when the sent video bitrate is more than this ratio of the video bandwidth, the claims drop to the highest
+	// sustainable quality in one adjustment instead of one quality level at a time, 0 disable it
+	SevereCongestionRatio float64 `json:"severe_congestion_ratio,omitempty" yaml:"severe_congestion_ratio,omitempty" mapstructure:"severe_congestion_ratio,omitempty"`
+	// the bandwidth must have the headroom for the next quality this long before the bitrate is increased,
+	// so a short spike of the estimation doesn't increase the quality just to drop it again, 0 disable it
+	SustainedHeadroom time.Duration `json:"sustained_headroom,omitempty" yaml:"sustained_headroom,omitempty" mapstructure:"sustained_headroom,omitempty"`
 }
 
+// DefaultPacketOverhead is the packet headers overhead of the default bitrates, about the overhead of 1200 bytes packets
+const DefaultPacketOverhead = 0.05
+
+// DefaultSevereCongestionRatio is the overshoot of the sent bitrate over the bandwidth that is handled as a bandwidth collapse
+const DefaultSevereCongestionRatio = 2
+
+// DefaultSustainedHeadroom is how long the bandwidth must have the headroom for the next quality before the bitrate is increased
+const DefaultSustainedHeadroom = 3 * time.Second
+
 func DefaultBitrates() BitrateConfigs {
 	return BitrateConfigs{
-		AudioRed:         65_000,
-		Audio:            48_000,
-		Video:            1_200_000,
-		VideoHigh:        1_200_000,
-		VideoHighPixels:  720 * 360,
-		VideoMid:         500_000,
-		VideoMidPixels:   360 * 180,
-		VideoLow:         150_000,
-		VideoLowPixels:   180 * 90,
-		InitialBandwidth: 1_000_000,
+		AudioRed:              65_000,
+		Audio:                 48_000,
+		Video:                 1_200_000,
+		VideoHigh:             1_200_000,
+		VideoHighPixels:       720 * 360,
+		VideoMid:              500_000,
+		VideoMidPixels:        360 * 180,
+		VideoLow:              150_000,
+		VideoLowPixels:        180 * 90,
+		ScreenHigh:            1_500_000,
+		ScreenMid:             800_000,
+		ScreenLow:             300_000,
+		InitialBandwidth:      1_000_000,
+		AdjustmentDelayMin:    1 * time.Second,
+		AdjustmentDelayMax:    5 * time.Second,
+		AudioHeadroom:         20_000,
+		StartupQuality:        QualityLow,
+		PacketOverhead:        DefaultPacketOverhead,
+		SevereCongestionRatio: DefaultSevereCongestionRatio,
+		SustainedHeadroom:     DefaultSustainedHeadroom,
 	}
 }
 
 type SFUClients struct {
-	clients map[string]*Client
-	mu      sync.Mutex
+	clients    map[string]*Client
+	reserved   map[string]struct{}
+	maxClients int
+	mu         sync.Mutex
 }
 
 func (s *SFUClients) GetClients() map[string]*Client {
@@ -82,8 +128,8 @@ func (s *SFUClients) Add(client *Client) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.clients[client.ID()]; ok {
-		return ErrClientExists
+	if err := s.checkAdmission(client.ID()); err != nil {
+		return err
 	}
 
 	s.clients[client.ID()] = client
@@ -91,6 +137,58 @@ func (s *SFUClients) Add(client *Client) error {
 	return nil
 }
 
+// checkAdmission must be called with the mutex held, the reserved clients are counted to the limit
+func (s *SFUClients) checkAdmission(id string) error {
+	if _, ok := s.clients[id]; ok {
+		return ErrClientExists
+	}
+
+	if _, ok := s.reserved[id]; ok {
+		return ErrClientExists
+	}
+
+	if s.maxClients > 0 && len(s.clients)+len(s.reserved) >= s.maxClients {
+		return ErrServerFull
+	}
+
+	return nil
+}
+
+// reserve take a slot for the client before the client is created, so the concurrent joins can't go over the limit
+// and the rejected client doesn't leave any state behind
+func (s *SFUClients) reserve(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkAdmission(id); err != nil {
+		return err
+	}
+
+	if s.reserved == nil {
+		s.reserved = make(map[string]struct{})
+	}
+
+	s.reserved[id] = struct{}{}
+
+	return nil
+}
+
+// addReserved replace the reservation with the created client
+func (s *SFUClients) addReserved(client *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reserved, client.ID())
+	s.clients[client.ID()] = client
+}
+
+func (s *SFUClients) setMaxClients(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxClients = n
+}
+
 func (s *SFUClients) Remove(client *Client) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -104,8 +202,27 @@ func (s *SFUClients) Remove(client *Client) error {
 	return nil
 }
 
+// sfuConfig is the configuration of the SFU that is only set by New. The fields are never changed after, so the getters
+// read them without the mutex, they are called while the SFU mutex is held, for example from subscribe by onTracksAvailable.
+type sfuConfig struct {
+	trackStallTimeout     time.Duration
+	simulcastProbeTimeout time.Duration
+	packetCacheSize       int
+	packetCacheMaxAge     time.Duration
+	packetCacheWindow     time.Duration
+	packetQueueSize       int
+	twccFeedbackInterval  time.Duration
+	enableProbePadding    bool
+	degradationPolicy     DegradationPolicy
+	ridQualities          map[string]QualityLevel
+	codecQualityPresets   map[string]QualityPreset
+	qualityRef            QualityPreset
+}
+
 type SFU struct {
+	sfuConfig
 	bitrateConfigs            BitrateConfigs
+	bitrateMu                 sync.RWMutex
 	clients                   *SFUClients
 	context                   context.Context
 	cancel                    context.CancelFunc
@@ -117,8 +234,12 @@ type SFU struct {
 	mux                       *UDPMux
 	onStop                    func()
 	pliInterval               time.Duration
+	pliDebounceWindow         time.Duration
+	nackRetransmitLimit       int
+	nackBackoff               time.Duration
+	dataChannelHistorySize    int
+	dataChannelRateLimit      DataChannelRateLimit
 	enableBandwidthEstimator  bool
-	qualityRef                QualityPreset
 	portStart                 uint16
 	portEnd                   uint16
 	publicIP                  string
@@ -126,6 +247,11 @@ type SFU struct {
 	onClientRemovedCallbacks  []func(*Client)
 	onClientAddedCallbacks    []func(*Client)
 	relayTracks               map[string]ITrack
+	dataChannelFanout         *fanoutPool
+	// the track claim callbacks have their own mutex because the claims can be added while the SFU mutex is locked
+	trackCallbacksMu            sync.RWMutex
+	onTrackPublishedCallbacks   []func(clientID, trackID string, kind webrtc.RTPCodecType)
+	onTrackUnpublishedCallbacks []func(clientID, trackID string, kind webrtc.RTPCodecType)
 }
 
 type PublishedTrack struct {
@@ -140,9 +266,25 @@ type sfuOptions struct {
 	PortEnd                  uint16
 	Bitrates                 BitrateConfigs
 	QualityPreset            QualityPreset
+	CodecQualityPresets      map[string]QualityPreset
 	Codecs                   []string
 	PLIInterval              time.Duration
+	PLIDebounceWindow        time.Duration
+	TrackStallTimeout        time.Duration
+	SimulcastProbeTimeout    time.Duration
+	NACKRetransmitLimit      int
+	NACKBackoff              time.Duration
+	DataChannelHistorySize   int
+	DataChannelRateLimit     DataChannelRateLimit
+	PacketCacheSize          int
+	PacketCacheMaxAge        time.Duration
+	PacketCacheWindow        time.Duration
+	PacketQueueSize          int
+	TWCCFeedbackInterval     time.Duration
 	EnableBandwidthEstimator bool
+	EnableProbePadding       bool
+	DegradationPolicy        DegradationPolicy
+	SimulcastRIDQualities    map[string]QualityLevel
 	PublicIP                 string
 	NAT1To1IPsCandidateType  webrtc.ICECandidateType
 }
@@ -151,7 +293,29 @@ type sfuOptions struct {
 func New(ctx context.Context, opts sfuOptions) *SFU {
 	localCtx, cancel := context.WithCancel(ctx)
 
+	if opts.PLIDebounceWindow == 0 {
+		opts.PLIDebounceWindow = DefaultPLIDebounceWindow
+	}
+
+	if opts.TrackStallTimeout == 0 {
+		opts.TrackStallTimeout = DefaultTrackStallTimeout
+	}
+
 	sfu := &SFU{
+		sfuConfig: sfuConfig{
+			trackStallTimeout:     opts.TrackStallTimeout,
+			simulcastProbeTimeout: opts.SimulcastProbeTimeout,
+			packetCacheSize:       validPacketCacheSize(opts.PacketCacheSize),
+			packetCacheMaxAge:     opts.PacketCacheMaxAge,
+			packetCacheWindow:     opts.PacketCacheWindow,
+			packetQueueSize:       validPacketQueueSize(opts.PacketQueueSize),
+			twccFeedbackInterval:  validTWCCFeedbackInterval(opts.TWCCFeedbackInterval),
+			enableProbePadding:    opts.EnableProbePadding,
+			degradationPolicy:     opts.DegradationPolicy,
+			ridQualities:          validRIDQualities(opts.SimulcastRIDQualities),
+			codecQualityPresets:   validCodecQualityPresets(opts.CodecQualityPresets),
+			qualityRef:            opts.QualityPreset,
+		},
 		clients:                   &SFUClients{clients: make(map[string]*Client), mu: sync.Mutex{}},
 		context:                   localCtx,
 		cancel:                    cancel,
@@ -163,7 +327,11 @@ func New(ctx context.Context, opts sfuOptions) *SFU {
 		bitrateConfigs:            opts.Bitrates,
 		enableBandwidthEstimator:  opts.EnableBandwidthEstimator,
 		pliInterval:               opts.PLIInterval,
-		qualityRef:                opts.QualityPreset,
+		pliDebounceWindow:         opts.PLIDebounceWindow,
+		nackRetransmitLimit:       opts.NACKRetransmitLimit,
+		nackBackoff:               opts.NACKBackoff,
+		dataChannelHistorySize:    opts.DataChannelHistorySize,
+		dataChannelRateLimit:      opts.DataChannelRateLimit,
 		publicIP:                  opts.PublicIP,
 		relayTracks:               make(map[string]ITrack),
 		portStart:                 opts.PortStart,
@@ -172,20 +340,23 @@ func New(ctx context.Context, opts sfuOptions) *SFU {
 		onClientRemovedCallbacks:  make([]func(*Client), 0),
 		onClientAddedCallbacks:    make([]func(*Client), 0),
 		nat1To1IPsCandidateType:   opts.NAT1To1IPsCandidateType,
+		dataChannelFanout:         newFanoutPool(localCtx, dataChannelFanoutWorkers, dataChannelFanoutQueueSize),
 	}
 
 	return sfu
 }
 
 func (s *SFU) addClient(client *Client) {
-	if err := s.clients.Add(client); err != nil {
-		glog.Error("sfu: failed to add client ", err)
-		return
-	}
-
+	s.clients.addReserved(client)
 	s.onClientAdded(client)
 }
 
+// SetMaxClients limit the number of clients in the SFU, the new client is rejected with ErrServerFull when the limit
+// is reached. The existing clients are not removed if the limit is lowered. Zero means unlimited.
+func (s *SFU) SetMaxClients(n int) {
+	s.clients.setMaxClients(n)
+}
+
 func (s *SFU) createClient(id string, name string, peerConnectionConfig webrtc.Configuration, opts ClientOptions) *Client {
 
 	client := NewClient(s, id, name, peerConnectionConfig, opts)
@@ -194,7 +365,13 @@ func (s *SFU) createClient(id string, name string, peerConnectionConfig webrtc.C
 	return client
 }
 
-func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
+// NewClient create a client and add it to the SFU. It returns ErrServerFull if the SFU reach the max clients limit,
+// or ErrClientExists if there is a client with the same id.
+func (s *SFU) NewClient(id, name string, opts ClientOptions) (*Client, error) {
+	if err := s.clients.reserve(id); err != nil {
+		return nil, err
+	}
+
 	peerConnectionConfig := webrtc.Configuration{}
 
 	if len(s.iceServers) > 0 {
@@ -204,9 +381,11 @@ func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 	client := s.createClient(id, name, peerConnectionConfig, opts)
 
 	client.OnConnectionStateChanged(func(connectionState webrtc.PeerConnectionState) {
-		glog.Info("client: connection state changed ", client.ID(), connectionState)
+		logger().Info("client: connection state changed ", client.ID(), connectionState)
 		switch connectionState {
 		case webrtc.PeerConnectionStateConnected:
+			client.restoreResources()
+
 			if client.state.Load() == ClientStateNew {
 				client.state.Store(ClientStateActive)
 				client.onJoined()
@@ -237,11 +416,15 @@ func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 				var internalDataChannel *webrtc.DataChannel
 				var err error
 
-				if internalDataChannel, err = client.createInternalDataChannel("internal", client.onInternalMessage); err != nil {
-					glog.Error("client: error create internal data channel ", err)
+				if internalDataChannel, err = client.createInternalDataChannel(InternalDataChannelLabel, client.onInternalMessage); err != nil {
+					logger().Error("client: error create internal data channel ", err)
 				}
 
-				client.internalDataChannel = internalDataChannel
+				client.setInternalDataChannel(internalDataChannel)
+
+				if client.controlDataChannel, err = client.createInternalDataChannel(ControlDataChannelLabel, client.onControlMessage); err != nil {
+					logger().Error("client: error create control data channel ", err)
+				}
 
 				client.renegotiate()
 			}
@@ -257,6 +440,7 @@ func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 		case webrtc.PeerConnectionStateClosed:
 			client.afterClosed()
 		case webrtc.PeerConnectionStateFailed:
+			client.releaseResources()
 			client.startIdleTimeout()
 		case webrtc.PeerConnectionStateConnecting:
 			client.cancelIdleTimeout()
@@ -276,7 +460,7 @@ func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 			return
 		}
 
-		glog.Info("publish tracks")
+		logger().Info("publish tracks")
 
 		availableTracks := client.pendingPublishedTracks.GetTracks()
 
@@ -297,7 +481,7 @@ func (s *SFU) NewClient(id, name string, opts ClientOptions) *Client {
 
 	s.addClient(client)
 
-	return client
+	return client, nil
 }
 
 func (s *SFU) AvailableTracks() []ITrack {
@@ -336,7 +520,7 @@ func (s *SFU) syncTrack(client *Client) bool {
 	if len(subscribes) > 0 {
 		err := client.SubscribeTracks(subscribes)
 		if err != nil {
-			glog.Error("client: failed to subscribe tracks ", err)
+			logger().Error("client: failed to subscribe tracks ", err)
 		}
 
 		return true
@@ -382,9 +566,50 @@ func (s *SFU) OnClientRemoved(callback func(*Client)) {
 	s.onClientRemovedCallbacks = append(s.onClientRemovedCallbacks, callback)
 }
 
+// OnTrackPublished register a callback that called once when a client publish a track, the client ID is the publisher.
+// It follows the published track, not the claims of the subscribers, so a track is reported once however many clients
+// subscribe to it. The callback is not called while holding any SFU lock.
+func (s *SFU) OnTrackPublished(callback func(clientID, trackID string, kind webrtc.RTPCodecType)) {
+	s.trackCallbacksMu.Lock()
+	defer s.trackCallbacksMu.Unlock()
+
+	s.onTrackPublishedCallbacks = append(s.onTrackPublishedCallbacks, callback)
+}
+
+// OnTrackUnpublished register a callback that called once when the track published by a client is ended,
+// the client ID is the publisher. The callback is not called while holding any SFU lock.
+func (s *SFU) OnTrackUnpublished(callback func(clientID, trackID string, kind webrtc.RTPCodecType)) {
+	s.trackCallbacksMu.Lock()
+	defer s.trackCallbacksMu.Unlock()
+
+	s.onTrackUnpublishedCallbacks = append(s.onTrackUnpublishedCallbacks, callback)
+}
+
+func (s *SFU) onTrackPublished(clientID, trackID string, kind webrtc.RTPCodecType) {
+	s.trackCallbacksMu.RLock()
+	callbacks := make([]func(clientID, trackID string, kind webrtc.RTPCodecType), len(s.onTrackPublishedCallbacks))
+	copy(callbacks, s.onTrackPublishedCallbacks)
+	s.trackCallbacksMu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(clientID, trackID, kind)
+	}
+}
+
+func (s *SFU) onTrackUnpublished(clientID, trackID string, kind webrtc.RTPCodecType) {
+	s.trackCallbacksMu.RLock()
+	callbacks := make([]func(clientID, trackID string, kind webrtc.RTPCodecType), len(s.onTrackUnpublishedCallbacks))
+	copy(callbacks, s.onTrackUnpublishedCallbacks)
+	s.trackCallbacksMu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(clientID, trackID, kind)
+	}
+}
+
 func (s *SFU) onAfterClientStopped(client *Client) {
 	if err := s.removeClient(client); err != nil {
-		glog.Error("sfu: failed to remove client ", err)
+		logger().Error("sfu: failed to remove client ", err)
 	}
 }
 
@@ -439,7 +664,7 @@ func (s *SFU) broadcastTracksToAutoSubscribeClients(ownerID string, tracks []ITr
 	for _, client := range s.clients.GetClients() {
 		if ownerID != client.ID() && client.IsSubscribeAllTracks.Load() {
 			if err := client.SubscribeTracks(trackReq); err != nil {
-				glog.Error("client: failed to subscribe tracks ", err)
+				logger().Error("client: failed to subscribe tracks ", err)
 			}
 		}
 	}
@@ -454,7 +679,7 @@ func (s *SFU) GetClient(id string) (*Client, error) {
 
 func (s *SFU) removeClient(client *Client) error {
 	if err := s.clients.Remove(client); err != nil {
-		glog.Error("sfu: failed to remove client ", err)
+		logger().Error("sfu: failed to remove client ", err)
 		return err
 	}
 
@@ -464,6 +689,10 @@ func (s *SFU) removeClient(client *Client) error {
 }
 
 func (s *SFU) CreateDataChannel(label string, opts DataChannelOptions) error {
+	if isReservedDataChannelLabel(label) {
+		return ErrDataChannelReserved
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -472,7 +701,14 @@ func (s *SFU) CreateDataChannel(label string, opts DataChannelOptions) error {
 		return ErrDataChannelExists
 	}
 
-	s.dataChannels.Add(label, opts)
+	sfuDC := s.dataChannels.Add(label, opts)
+	if s.dataChannelHistorySize > 0 {
+		sfuDC.history = newDataChannelHistory(s.dataChannelHistorySize)
+	}
+
+	if sfuDC.IdleTimeout() > 0 {
+		time.AfterFunc(sfuDC.IdleTimeout(), func() { s.expireIdleDataChannel(sfuDC) })
+	}
 
 	errors := []error{}
 	initOpts := &webrtc.DataChannelInit{
@@ -495,12 +731,29 @@ func (s *SFU) CreateDataChannel(label string, opts DataChannelOptions) error {
 	return FlattenErrors(errors)
 }
 
-func (s *SFU) setupMessageForwarder(clientID string, d *webrtc.DataChannel) {
+func (s *SFU) setupMessageForwarder(client *Client, d *webrtc.DataChannel) {
+	clientID := client.ID()
+
 	d.OnMessage(func(msg webrtc.DataChannelMessage) {
-		// broadcast to all clients
+		// drop the message before it is amplified to all clients
+		if !client.dataChannelLimiter.allow(len(msg.Data), time.Now()) {
+			client.rateLimitedDrops.add(d.Label(), len(msg.Data))
+
+			return
+		}
+
+		s.addDataChannelHistory(clientID, d.Label(), msg)
+
+		// broadcast to all clients, the messages are sent by the fanout workers so a slow client doesn't block the others.
+		// The messages of each client are sent in order.
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
+		sfuDC := s.dataChannels.Get(d.Label())
+		if sfuDC != nil {
+			sfuDC.touch(time.Now())
+		}
+
 		for _, client := range s.clients.GetClients() {
 			// skip the sender
 			if client.id == clientID {
@@ -512,17 +765,188 @@ func (s *SFU) setupMessageForwarder(clientID string, d *webrtc.DataChannel) {
 				continue
 			}
 
-			if dc.ReadyState() != webrtc.DataChannelStateOpen {
-				dc.OnOpen(func() {
-					dc.Send(msg.Data)
-				})
-			} else {
-				dc.Send(msg.Data)
+			recipient := client
+			if sfuDC != nil {
+				// the idle data channel is not closed until the message is sent
+				sfuDC.inFlight.Add(1)
+			}
+
+			relay := func() {
+				s.relayDataChannelMessage(recipient, dc, msg.Data)
+
+				if sfuDC != nil {
+					sfuDC.touch(time.Now())
+					sfuDC.inFlight.Add(-1)
+				}
+			}
+
+			if !s.dataChannelFanout.submit(recipient.ID(), relay) {
+				recipient.sendQueueDrops.add(d.Label(), len(msg.Data))
+
+				if sfuDC != nil {
+					sfuDC.inFlight.Add(-1)
+				}
 			}
 		}
 	})
 }
 
+// relayDataChannelMessage send the message to the data channel of the client, or buffer it until the data channel is open
+func (s *SFU) relayDataChannelMessage(client *Client, dc *webrtc.DataChannel, data []byte) {
+	if dc.ReadyState() != webrtc.DataChannelStateOpen {
+		ttl := DefaultDataChannelMessageTTL
+		if sfuDC := s.dataChannels.Get(dc.Label()); sfuDC != nil {
+			ttl = sfuDC.MessageTTL()
+		}
+
+		client.dataChannels.sendWhenOpen(dc, data, ttl)
+
+		return
+	}
+
+	if err := dc.Send(data); err != nil {
+		logger().Error("sfu: error send data channel message to client ", client.ID(), " ", err)
+	}
+}
+
+// expireIdleDataChannel close the private data channel of all its clients and remove it when no message is relayed
+// within the idle timeout, otherwise the check is scheduled again when the data channel would be idle for the timeout
+func (s *SFU) expireIdleDataChannel(sfuDC *SFUDataChannel) {
+	if s.context.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dataChannels.Get(sfuDC.label) != sfuDC {
+		return
+	}
+
+	// the message forwarder hold the same lock, so no new message is queued while it is checked
+	idle := sfuDC.idleDuration(time.Now())
+	if idle < sfuDC.IdleTimeout() {
+		time.AfterFunc(sfuDC.IdleTimeout()-idle, func() { s.expireIdleDataChannel(sfuDC) })
+		return
+	}
+
+	logger().Info("sfu: close idle data channel ", sfuDC.label)
+
+	s.dataChannels.Remove(sfuDC)
+
+	for _, clientID := range sfuDC.ClientIDs() {
+		client, err := s.clients.GetClient(clientID)
+		if err != nil {
+			continue
+		}
+
+		dc := client.dataChannels.Get(sfuDC.label)
+		if dc == nil {
+			continue
+		}
+
+		client.dataChannels.Remove(dc)
+
+		if err := dc.Close(); err != nil {
+			logger().Error("sfu: error on close idle data channel ", sfuDC.label, " of client ", clientID, " ", err)
+		}
+	}
+}
+
+// sendData deliver the typed message to the target client, or to all clients except the sender if the target is empty
+func (s *SFU) sendData(data Data) error {
+	if data.ToID != "" {
+		client, err := s.GetClient(data.ToID)
+		if err != nil {
+			return err
+		}
+
+		return client.receiveData(data)
+	}
+
+	for _, client := range s.clients.GetClients() {
+		if client.ID() == data.FromID {
+			continue
+		}
+
+		if err := client.receiveData(data); err != nil {
+			logger().Error("sfu: error send data to client ", client.ID(), " ", err)
+		}
+	}
+
+	return nil
+}
+
+// addDataChannelHistory keep the message in the data channel history if the history is enabled
+func (s *SFU) addDataChannelHistory(clientID, label string, msg webrtc.DataChannelMessage) {
+	sfuDC := s.dataChannels.Get(label)
+	if sfuDC == nil || sfuDC.history == nil {
+		return
+	}
+
+	data := Data{
+		FromID: clientID,
+		SentAt: time.Now(),
+	}
+
+	if msg.IsString {
+		data.Data = string(msg.Data)
+	} else {
+		data.Data = msg.Data
+	}
+
+	sfuDC.history.add(data)
+}
+
+// replayDataChannelHistory send the buffered history messages to the new data channel once it is opened.
+// Each message is sent as a JSON encoded Data to keep the original sender and time.
+func (s *SFU) replayDataChannelHistory(list *DataChannelList, dc *webrtc.DataChannel) {
+	sfuDC := s.dataChannels.Get(dc.Label())
+	if sfuDC == nil || sfuDC.history == nil {
+		return
+	}
+
+	messages := make([][]byte, 0)
+
+	for _, data := range sfuDC.history.Messages() {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			logger().Error("datachannel: error on encode history message ", err)
+			continue
+		}
+
+		messages = append(messages, encoded)
+	}
+
+	list.replayWhenOpen(dc, messages, sfuDC.MessageTTL())
+}
+
+// DataChannelLabels return the labels of the open data channels of the client, sorted by label. The public channels
+// are created for all clients, the private channels are created only for the clients listed in the ClientIDs option.
+// Both are empty if the client is not found.
+func (s *SFU) DataChannelLabels(clientID string) (public []string, private []string) {
+	public = make([]string, 0)
+	private = make([]string, 0)
+
+	client, err := s.GetClient(clientID)
+	if err != nil {
+		return public, private
+	}
+
+	for _, label := range client.dataChannels.OpenLabels() {
+		if sfuDC := s.dataChannels.Get(label); sfuDC != nil && len(sfuDC.ClientIDs()) > 0 {
+			private = append(private, label)
+		} else {
+			public = append(public, label)
+		}
+	}
+
+	sort.Strings(public)
+	sort.Strings(private)
+
+	return public, private
+}
+
 func (s *SFU) createExistingDataChannels(c *Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -538,7 +962,7 @@ func (s *SFU) createExistingDataChannels(c *Client) {
 		}
 
 		if err := c.createDataChannel(dc.label, initOpts); err != nil {
-			glog.Error("datachanel: error on create existing data channels, ", err)
+			logger().Error("datachanel: error on create existing data channels, ", err)
 		}
 	}
 }
@@ -557,7 +981,97 @@ func (s *SFU) TotalActiveSessions() int {
 	return count
 }
 
+// DumpStats return a JSON snapshot of every client, the tracks forwarded to it, the bitrate claims and the sender stats.
+// Each lock is acquired and released one at a time, so the dump can be called anytime without risking a deadlock.
+func (s *SFU) DumpStats() ([]byte, error) {
+	dump := SFUDumpStats{
+		Timestamp: time.Now(),
+		Clients:   make([]ClientDumpStats, 0),
+	}
+
+	for _, client := range s.clients.GetClients() {
+		clientStats := ClientDumpStats{
+			ID:                 client.ID(),
+			Name:               client.Name(),
+			EstimatedBandwidth: client.GetEstimatedBandwidth(),
+			Tracks:             make([]TrackDumpStats, 0),
+		}
+
+		for id, track := range client.ClientTracks() {
+			trackStats := TrackDumpStats{
+				ID:        id,
+				Kind:      track.Kind().String(),
+				Simulcast: track.IsSimulcast(),
+				Scaleable: track.IsScaleable(),
+			}
+
+			if claim := client.bitrateController.GetClaim(id); claim != nil {
+				trackStats.Quality = claim.Quality()
+				trackStats.ClaimedBitrate = claim.Bitrate()
+			}
+
+			if scaleableTrack, ok := track.(*scaleableClientTrack); ok {
+				trackStats.PacketCacheOccupied = scaleableTrack.packetCaches.Len()
+			}
+
+			if sender, err := client.stats.GetSender(id); err == nil {
+				trackStats.FractionLost = sender.RemoteInboundRTPStreamStats.FractionLost
+			}
+
+			clientStats.Tracks = append(clientStats.Tracks, trackStats)
+		}
+
+		sort.Slice(clientStats.Tracks, func(i, j int) bool {
+			return clientStats.Tracks[i].ID < clientStats.Tracks[j].ID
+		})
+
+		dump.Clients = append(dump.Clients, clientStats)
+	}
+
+	sort.Slice(dump.Clients, func(i, j int) bool {
+		return dump.Clients[i].ID < dump.Clients[j].ID
+	})
+
+	return json.Marshal(dump)
+}
+
+// SubscriberLossStats aggregate the latest receiver reports of every client that subscribe to the published track.
+// Return ErrCLientStatsNotFound if no subscriber has reported the track yet.
+func (s *SFU) SubscriberLossStats(trackID string) (SubscriberLossStats, error) {
+	losses := make([]float64, 0)
+
+	for _, client := range s.clients.GetClients() {
+		sender, err := client.stats.GetSender(trackID)
+		if err != nil {
+			continue
+		}
+
+		losses = append(losses, sender.RemoteInboundRTPStreamStats.FractionLost)
+	}
+
+	if len(losses) == 0 {
+		return SubscriberLossStats{}, ErrCLientStatsNotFound
+	}
+
+	sort.Float64s(losses)
+
+	median := losses[len(losses)/2]
+	if len(losses)%2 == 0 {
+		median = (losses[len(losses)/2-1] + median) / 2
+	}
+
+	return SubscriberLossStats{
+		TrackID:            trackID,
+		Subscribers:        len(losses),
+		WorstFractionLost:  losses[len(losses)-1],
+		MedianFractionLost: median,
+	}, nil
+}
+
 func (s *SFU) QualityLevelToBitrate(level QualityLevel) uint32 {
+	s.bitrateMu.RLock()
+	defer s.bitrateMu.RUnlock()
+
 	switch level {
 	case QualityAudioRed:
 		return s.bitrateConfigs.AudioRed
@@ -569,11 +1083,123 @@ func (s *SFU) QualityLevelToBitrate(level QualityLevel) uint32 {
 		return s.bitrateConfigs.VideoMid
 	case QualityHigh:
 		return s.bitrateConfigs.VideoHigh
+	case QualityVeryHigh:
+		return s.bitrateConfigs.VideoVeryHigh
 	default:
 		return 0
 	}
 }
 
+// HighestQuality return the highest video quality that the bitrate controller can allocate.
+// It is QualityVeryHigh only when the very high bitrate is configured.
+func (s *SFU) HighestQuality() QualityLevel {
+	s.bitrateMu.RLock()
+	defer s.bitrateMu.RUnlock()
+
+	if s.bitrateConfigs.VideoVeryHigh > 0 {
+		return QualityVeryHigh
+	}
+
+	return QualityHigh
+}
+
+// TrackQualityLevelToBitrate return the bitrate of the quality level for a camera or a screen share track.
+// The camera bitrate is used if the screen bitrate of the quality level is not configured.
+func (s *SFU) TrackQualityLevelToBitrate(level QualityLevel, isScreen bool) uint32 {
+	if isScreen {
+		s.bitrateMu.RLock()
+		configs := s.bitrateConfigs
+		s.bitrateMu.RUnlock()
+
+		var bitrate uint32
+
+		switch level {
+		case QualityLow:
+			bitrate = configs.ScreenLow
+		case QualityMid:
+			bitrate = configs.ScreenMid
+		case QualityHigh:
+			bitrate = configs.ScreenHigh
+		case QualityVeryHigh:
+			bitrate = configs.ScreenVeryHigh
+		}
+
+		if bitrate != 0 {
+			return bitrate
+		}
+	}
+
+	return s.QualityLevelToBitrate(level)
+}
+
+// BitrateConfigs return the current bitrate configuration. It is guarded by its own mutex because
+// it is read on every allocation decision, including while the SFU mutex is held.
+func (s *SFU) BitrateConfigs() BitrateConfigs {
+	s.bitrateMu.RLock()
+	defer s.bitrateMu.RUnlock()
+
+	return s.bitrateConfigs
+}
+
+// SetQualityBitrates update the bitrate that assigned to each video quality level.
+// The existing claims of all clients are updated to the new bitrates and re-evaluated on the next bitrate adjustment.
+func (s *SFU) SetQualityBitrates(low, mid, high uint32) error {
+	if low == 0 || low >= mid || mid >= high {
+		return ErrInvalidQualityBitrates
+	}
+
+	s.bitrateMu.Lock()
+	if veryHigh := s.bitrateConfigs.VideoVeryHigh; veryHigh > 0 && high >= veryHigh {
+		s.bitrateMu.Unlock()
+		return ErrInvalidQualityBitrates
+	}
+
+	s.bitrateConfigs.VideoLow = low
+	s.bitrateConfigs.VideoMid = mid
+	s.bitrateConfigs.VideoHigh = high
+	s.bitrateMu.Unlock()
+
+	for _, client := range s.clients.GetClients() {
+		client.bitrateController.refreshClaimBitrates()
+	}
+
+	return nil
+}
+
+// SetVeryHighQualityBitrate update the bitrate of the very high video quality level, set it to 0 to disable the tier.
+// The claims above the high quality are moved back to the high quality on the next bitrate adjustment when the tier is disabled.
+func (s *SFU) SetVeryHighQualityBitrate(bitrate uint32) error {
+	s.bitrateMu.Lock()
+	if bitrate != 0 && bitrate <= s.bitrateConfigs.VideoHigh {
+		s.bitrateMu.Unlock()
+		return ErrInvalidQualityBitrates
+	}
+
+	s.bitrateConfigs.VideoVeryHigh = bitrate
+	s.bitrateMu.Unlock()
+
+	for _, client := range s.clients.GetClients() {
+		client.bitrateController.refreshClaimBitrates()
+	}
+
+	return nil
+}
+
+// SetStartupQuality update the highest quality that the new video claims start with.
+// The existing claims are not changed, they are adjusted by the bitrate controller as usual.
+func (s *SFU) SetStartupQuality(quality QualityLevel) error {
+	if quality < QualityLow || quality > QualityHigh {
+		return ErrInvalidStartupQuality
+	}
+
+	s.bitrateMu.Lock()
+	defer s.bitrateMu.Unlock()
+
+	s.bitrateConfigs.StartupQuality = quality
+
+	return nil
+}
+
 func (s *SFU) PLIInterval() time.Duration {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -581,13 +1207,79 @@ func (s *SFU) PLIInterval() time.Duration {
 	return s.pliInterval
 }
 
+// QualityPreset return the quality preset of the tracks, the codecs can override it with CodecQualityPreset
 func (s *SFU) QualityPreset() QualityPreset {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.qualityRef
+}
+
+// CodecQualityPreset return the quality preset of the codec mime type, the codecs that not configured in the
+// CodecQualityPresets option use the QualityPreset.
+func (s *SFU) CodecQualityPreset(mimeType string) QualityPreset {
+	if preset, ok := s.codecQualityPresets[strings.ToLower(mimeType)]; ok {
+		return preset
+	}
 
 	return s.qualityRef
 }
 
+// RIDToQuality return the quality of the simulcast layer with the RID, the RIDs that not configured in the
+// SimulcastRIDQualities option use the default high, mid and low RIDs.
+func (s *SFU) RIDToQuality(rid string) QualityLevel {
+	if quality, ok := s.ridQualities[rid]; ok {
+		return quality
+	}
+
+	return RIDToQuality(rid)
+}
+
+// validRIDQualities return a copy of the RID mapping without the RIDs mapped to a quality that is not a simulcast layer
+func validRIDQualities(ridQualities map[string]QualityLevel) map[string]QualityLevel {
+	valid := make(map[string]QualityLevel, len(ridQualities))
+
+	for rid, quality := range ridQualities {
+		if quality < QualityLow || quality > QualityHigh {
+			logger().Warn("sfu: ignore the RID ", rid, " mapped to invalid simulcast quality ", quality)
+			continue
+		}
+
+		valid[rid] = quality
+	}
+
+	return valid
+}
+
+// validCodecQualityPresets key the presets with the lower case mime type, the negotiated mime type case may
+// differ from the configured one
+func validCodecQualityPresets(presets map[string]QualityPreset) map[string]QualityPreset {
+	valid := make(map[string]QualityPreset, len(presets))
+
+	for mimeType, preset := range presets {
+		valid[strings.ToLower(mimeType)] = preset
+	}
+
+	return valid
+}
+
+// PacketCacheSize return the number of packets cached by each scaleable track
+func (s *SFU) PacketCacheSize() int {
+	return s.packetCacheSize
+}
+
+// PacketCacheMaxAge return how long a packet is cached, the age is not limited if zero
+func (s *SFU) PacketCacheMaxAge() time.Duration {
+	return s.packetCacheMaxAge
+}
+
+// PacketCacheWindow return the duration that the adaptive packet cache hold, the cache size is fixed if zero
+func (s *SFU) PacketCacheWindow() time.Duration {
+	return s.packetCacheWindow
+}
+
+// PacketQueueSize return the number of packets queued by each scaleable track while the writer is busy
+func (s *SFU) PacketQueueSize() int {
+	return s.packetQueueSize
+}
+
 func (s *SFU) OnTracksAvailable(callback func(tracks []ITrack)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -604,7 +1296,7 @@ func (s *SFU) AddRelayTrack(ctx context.Context, id, streamid, rid, clientid str
 
 	if rid == "" {
 		// not simulcast
-		track = newTrack(ctx, clientid, relayTrack, s.pliInterval, onPLI, nil, nil)
+		track = newTrack(ctx, clientid, relayTrack, nil, s.pliInterval, s.pliDebounceWindow, onPLI, nackOptions{}, nil, nil)
 		s.mu.Lock()
 		s.relayTracks[relayTrack.ID()] = track
 		s.mu.Unlock()
@@ -617,11 +1309,11 @@ func (s *SFU) AddRelayTrack(ctx context.Context, id, streamid, rid, clientid str
 		track, ok := s.relayTracks[relayTrack.ID()]
 		if !ok {
 			// if track not found, add it
-			track = newSimulcastTrack(ctx, clientid, relayTrack, s.pliInterval, onPLI, nil, nil)
+			track = newSimulcastTrack(ctx, clientid, relayTrack, nil, s.pliInterval, s.pliDebounceWindow, onPLI, nackOptions{}, nil, nil, s.RIDToQuality)
 			s.relayTracks[relayTrack.ID()] = track
 
 		} else if simulcast, ok = track.(*SimulcastTrack); ok {
-			simulcast.AddRemoteTrack(simulcast.context, relayTrack, nil, nil)
+			simulcast.AddRemoteTrack(simulcast.context, relayTrack, onPLI, nil, nil)
 		}
 		s.mu.Unlock()
 	}
@@ -633,3 +1325,28 @@ func (s *SFU) AddRelayTrack(ctx context.Context, id, streamid, rid, clientid str
 
 	return nil
 }
+
+// TWCCFeedbackInterval return the interval between the transport-cc feedbacks sent to the publisher
+func (s *SFU) TWCCFeedbackInterval() time.Duration {
+	return s.twccFeedbackInterval
+}
+
+// TrackStallTimeout return how long a subscribed track can miss the packets before it is considered stalled
+func (s *SFU) TrackStallTimeout() time.Duration {
+	return s.trackStallTimeout
+}
+
+// SimulcastProbeTimeout return how long the simulcast layers are probed on subscribe, disabled if zero
+func (s *SFU) SimulcastProbeTimeout() time.Duration {
+	return s.simulcastProbeTimeout
+}
+
+// ProbePaddingEnabled return true if the padding packets are sent to probe the bandwidth before an increase
+func (s *SFU) ProbePaddingEnabled() bool {
+	return s.enableProbePadding
+}
+
+// DegradationPolicy return the policy that decide which tracks are reduced first when the bandwidth is low
+func (s *SFU) DegradationPolicy() DegradationPolicy {
+	return s.degradationPolicy
+}
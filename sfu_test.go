@@ -2,11 +2,14 @@ package sfu
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 )
@@ -195,3 +198,180 @@ Loop:
 
 	require.Equal(t, expectedTracksAfterAdded, trackReceived)
 }
+
+func TestDumpStats(t *testing.T) {
+	t.Parallel()
+
+	publisher := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	s := publisher.SFU()
+
+	subscriber := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	subscriber.id = "subscriber"
+	subscriber.name = "subscriber"
+	subscriber.sfu = s
+
+	require.NoError(t, s.clients.Add(publisher))
+	require.NoError(t, s.clients.Add(subscriber))
+
+	track := newTestScaleableClientTrack(t, subscriber, webrtc.MimeTypeVP9, &remoteTrack{onPLI: func() {}})
+	subscriber.clientTracks = map[string]iClientTrack{track.ID(): track}
+
+	_, err := subscriber.bitrateController.addClaim(track, QualityMid, true)
+	require.NoError(t, err)
+
+	for i := uint16(1); i <= 3; i++ {
+		track.push(rtp.Packet{Header: rtp.Header{SequenceNumber: i, Timestamp: uint32(i) * 3000, Marker: true}, Payload: vp9Payload(0, 0, true, true)}, QualityLow)
+	}
+
+	senderStats := stats.Stats{}
+	senderStats.RemoteInboundRTPStreamStats.FractionLost = 0.05
+	subscriber.stats.SetSender(track.ID(), senderStats)
+
+	data, err := s.DumpStats()
+	require.NoError(t, err)
+
+	dump := SFUDumpStats{}
+	require.NoError(t, json.Unmarshal(data, &dump))
+	require.Len(t, dump.Clients, 2)
+
+	var subscriberDump ClientDumpStats
+	for _, client := range dump.Clients {
+		if client.ID == subscriber.ID() {
+			subscriberDump = client
+		} else {
+			require.Equal(t, publisher.ID(), client.ID)
+			require.Empty(t, client.Tracks)
+		}
+	}
+
+	require.Equal(t, "subscriber", subscriberDump.Name)
+	require.Equal(t, subscriber.GetEstimatedBandwidth(), subscriberDump.EstimatedBandwidth)
+	require.Len(t, subscriberDump.Tracks, 1)
+
+	trackDump := subscriberDump.Tracks[0]
+	require.Equal(t, track.ID(), trackDump.ID)
+	require.Equal(t, "video", trackDump.Kind)
+	require.True(t, trackDump.Scaleable)
+	require.Equal(t, QualityLevel(QualityMid), trackDump.Quality)
+	require.Equal(t, DefaultBitrates().VideoMid, trackDump.ClaimedBitrate)
+	require.Equal(t, 3, trackDump.PacketCacheOccupied)
+	require.Equal(t, 0.05, trackDump.FractionLost)
+
+	// the raw JSON use the snake case field names
+	raw := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	require.Contains(t, raw, "clients")
+	require.Contains(t, raw, "timestamp")
+	require.Contains(t, raw["clients"].([]interface{})[0], "estimated_bandwidth")
+}
+
+func TestSubscriberLossStats(t *testing.T) {
+	t.Parallel()
+
+	publisher := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	s := publisher.SFU()
+	require.NoError(t, s.clients.Add(publisher))
+
+	_, err := s.SubscriberLossStats("video")
+	require.ErrorIs(t, err, ErrCLientStatsNotFound)
+
+	for i, fractionLost := range []float64{0.02, 0.3, 0, 0.1} {
+		subscriber := newTestClient(t, DefaultBitrates().InitialBandwidth)
+		subscriber.id = fmt.Sprintf("subscriber-%d", i)
+		subscriber.sfu = s
+		require.NoError(t, s.clients.Add(subscriber))
+
+		senderStats := stats.Stats{}
+		senderStats.RemoteInboundRTPStreamStats.FractionLost = fractionLost
+		subscriber.stats.SetSender("video", senderStats)
+
+		// the reports of the other tracks are not counted
+		subscriber.stats.SetSender("audio", stats.Stats{})
+	}
+
+	lossStats, err := s.SubscriberLossStats("video")
+	require.NoError(t, err)
+	require.Equal(t, "video", lossStats.TrackID)
+	require.Equal(t, 4, lossStats.Subscribers)
+	require.Equal(t, 0.3, lossStats.WorstFractionLost)
+	require.InDelta(t, 0.06, lossStats.MedianFractionLost, 0.0001)
+
+	// the median of an odd number of subscribers is the middle report
+	subscriber := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	subscriber.id = "subscriber-4"
+	subscriber.sfu = s
+	require.NoError(t, s.clients.Add(subscriber))
+
+	senderStats := stats.Stats{}
+	senderStats.RemoteInboundRTPStreamStats.FractionLost = 0.5
+	subscriber.stats.SetSender("video", senderStats)
+
+	lossStats, err = s.SubscriberLossStats("video")
+	require.NoError(t, err)
+	require.Equal(t, 5, lossStats.Subscribers)
+	require.Equal(t, 0.5, lossStats.WorstFractionLost)
+	require.Equal(t, 0.1, lossStats.MedianFractionLost)
+}
+
+func TestTrackPublishedCallbacks(t *testing.T) {
+	t.Parallel()
+
+	publisher := newTestClient(t, DefaultBitrates().InitialBandwidth)
+	publisher.tracks = newTrackList()
+	s := publisher.SFU()
+
+	type trackEvent struct {
+		clientID string
+		trackID  string
+		kind     webrtc.RTPCodecType
+	}
+
+	published := make(chan trackEvent, 4)
+	unpublished := make(chan trackEvent, 4)
+
+	s.OnTrackPublished(func(clientID, trackID string, kind webrtc.RTPCodecType) {
+		// the callback is not called under the track list lock, reading the tracks doesn't deadlock
+		_, err := publisher.tracks.Get(trackID)
+		require.NoError(t, err)
+		published <- trackEvent{clientID, trackID, kind}
+	})
+
+	s.OnTrackUnpublished(func(clientID, trackID string, kind webrtc.RTPCodecType) {
+		unpublished <- trackEvent{clientID, trackID, kind}
+	})
+
+	relay := NewTrackRelay("video", "stream", "", webrtc.RTPCodecTypeVideo, 1, webrtc.MimeTypeVP9, make(chan *rtp.Packet))
+	track := newTrack(publisher.context, publisher.ID(), relay, nil, 0, 0, func() {}, nackOptions{}, nil, nil).(*Track)
+	publisher.addTrack(track)
+
+	require.Equal(t, trackEvent{publisher.ID(), "video", webrtc.RTPCodecTypeVideo}, <-published)
+
+	// the subscriptions of the track don't publish or unpublish it again
+	for i := 0; i < 2; i++ {
+		subscriber := newTestClient(t, DefaultBitrates().InitialBandwidth)
+		subscriber.sfu = s
+		require.NoError(t, s.clients.Add(subscriber))
+
+		clientTrack := newTestClientTrack(t, subscriber, "video", webrtc.RTPCodecTypeVideo, true)
+		_, err := subscriber.bitrateController.addClaim(clientTrack, QualityHigh, true)
+		require.NoError(t, err)
+
+		subscriber.bitrateController.removeClaim(clientTrack.ID())
+	}
+
+	require.Empty(t, published)
+	require.Empty(t, unpublished)
+
+	// the track is unpublished once when it is ended
+	track.cancel()
+
+	select {
+	case event := <-unpublished:
+		require.Equal(t, trackEvent{publisher.ID(), "video", webrtc.RTPCodecTypeVideo}, event)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the track unpublished callback")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, unpublished)
+}
@@ -10,7 +10,6 @@ import (
 	"runtime"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/google/uuid"
 	"github.com/inlivedev/sfu/pkg/interceptors/simulcast"
 	"github.com/pion/interceptor"
@@ -412,7 +411,7 @@ func CreatePeerPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
-			glog.Info("test: peer connection ", peerName, " stated changed ", state)
+			logger().Info("test: peer connection ", peerName, " stated changed ", state)
 			if client != nil {
 				_ = room.StopClient(client.ID())
 				cancelClient()
@@ -461,7 +460,7 @@ func CreatePeerPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 	client, _ = room.AddClient(id, id, DefaultClientOptions())
 
 	client.OnAllowedRemoteRenegotiation(func() {
-		glog.Info("allowed remote renegotiation")
+		logger().Info("allowed remote renegotiation")
 		negotiate(pc, client)
 	})
 
@@ -475,14 +474,14 @@ func CreatePeerPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 
 	client.OnRenegotiation(func(ctx context.Context, offer webrtc.SessionDescription) (answer webrtc.SessionDescription, e error) {
 		if client.state.Load() == ClientStateEnded {
-			glog.Info("test: renegotiation canceled because client has ended")
+			logger().Info("test: renegotiation canceled because client has ended")
 			return webrtc.SessionDescription{}, errors.New("client ended")
 		}
 
 		currentTranscv := len(pc.GetTransceivers())
 
-		glog.Info("test: got renegotiation ", peerName)
-		defer glog.Info("test: renegotiation done ", peerName)
+		logger().Info("test: got renegotiation ", peerName)
+		defer logger().Info("test: renegotiation done ", peerName)
 		if err = pc.SetRemoteDescription(offer); err != nil {
 			return webrtc.SessionDescription{}, err
 		}
@@ -494,7 +493,7 @@ func CreatePeerPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 		}
 
 		newTcv := len(pc.GetTransceivers()) - currentTranscv
-		glog.Info("test: new transceiver ", newTcv, " total tscv ", len(pc.GetTransceivers()))
+		logger().Info("test: new transceiver ", newTcv, " total tscv ", len(pc.GetTransceivers()))
 
 		return *pc.LocalDescription(), nil
 	})
@@ -513,7 +512,7 @@ func CreatePeerPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 
 func negotiate(pc *webrtc.PeerConnection, client *Client) {
 	if pc.SignalingState() != webrtc.SignalingStateStable {
-		glog.Info("test: signaling state is not stable, skip renegotiation")
+		logger().Info("test: signaling state is not stable, skip renegotiation")
 		return
 	}
 
@@ -566,7 +565,7 @@ func CreateDataPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
-			glog.Info("test: peer connection closed ", peerName)
+			logger().Info("test: peer connection closed ", peerName)
 			if client != nil {
 				_ = room.StopClient(client.ID())
 			}
@@ -580,7 +579,7 @@ func CreateDataPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 	client, _ = room.AddClient(id, id, DefaultClientOptions())
 
 	client.OnAllowedRemoteRenegotiation(func() {
-		glog.Info("allowed remote renegotiation")
+		logger().Info("allowed remote renegotiation")
 		go negotiate(pc, client)
 	})
 
@@ -594,12 +593,12 @@ func CreateDataPair(ctx context.Context, room *Room, iceServers []webrtc.ICEServ
 
 	client.OnRenegotiation(func(ctx context.Context, offer webrtc.SessionDescription) (answer webrtc.SessionDescription, e error) {
 		if client.state.Load() == ClientStateEnded {
-			glog.Info("test: renegotiation canceled because client has ended")
+			logger().Info("test: renegotiation canceled because client has ended")
 			return webrtc.SessionDescription{}, errors.New("client ended")
 		}
 
-		glog.Info("test: got renegotiation ", peerName)
-		defer glog.Info("test: renegotiation done ", peerName)
+		logger().Info("test: got renegotiation ", peerName)
+		defer logger().Info("test: renegotiation done ", peerName)
 		_ = pc.SetRemoteDescription(offer)
 		answer, _ = pc.CreateAnswer(nil)
 		_ = pc.SetLocalDescription(answer)
@@ -665,7 +664,7 @@ func LoadVp9Track(ctx context.Context, pc *webrtc.PeerConnection, videoFileName
 	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(ctx)
 
 	pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
-		glog.Info("Connection State has changed %s \n", connectionState.String())
+		logger().Info("Connection State has changed %s \n", connectionState.String())
 		if connectionState == webrtc.ICEConnectionStateConnected {
 			iceConnectedCtxCancel()
 		}
@@ -741,7 +740,7 @@ func LoadVp9Track(ctx context.Context, pc *webrtc.PeerConnection, videoFileName
 
 		// Wait for connection established
 		<-iceConnectedCtx.Done()
-		glog.Info("Connection established, start sending track: %s \n", header.FourCC)
+		logger().Info("Connection established, start sending track: %s \n", header.FourCC)
 
 		// Send our video file frame at a time. Pace our sending so we send it at the same speed it should be played back as.
 		// This isn't required since the video is timestamped, but we will such much higher loss if we send all at once.
@@ -758,7 +757,7 @@ func LoadVp9Track(ctx context.Context, pc *webrtc.PeerConnection, videoFileName
 			case <-ticker.C:
 				frame, _, ivfErr := ivf.ParseNextFrame()
 				if errors.Is(ivfErr, io.EOF) {
-					glog.Info("All video frames parsed and sent")
+					logger().Info("All video frames parsed and sent")
 					if !loop {
 						return
 					} else {
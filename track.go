@@ -7,7 +7,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/inlivedev/sfu/pkg/interceptors/voiceactivedetector"
 	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/rtp"
@@ -19,6 +18,12 @@ const (
 	TrackTypeScreen = "screen"
 )
 
+// simulcastProbeInterval is how often the probe check if the high layer is received before the probe timeout
+const simulcastProbeInterval = 50 * time.Millisecond
+
+// simulcastLayerActiveThreshold is how long after the latest read a simulcast layer is still considered active
+const simulcastLayerActiveThreshold = 500 * time.Millisecond
+
 var (
 	ErrTrackExists      = errors.New("client: error track already exists")
 	ErrTrackIsNotExists = errors.New("client: error track is not exists")
@@ -40,6 +45,8 @@ type baseTrack struct {
 	codec        webrtc.RTPCodecParameters
 	isScreen     *atomic.Bool // source of the track, can be media or screen
 	clientTracks *clientTrackList
+	// header extensions negotiated with the publisher
+	headerExtensions []webrtc.RTPHeaderExtensionParameter
 }
 
 type ITrack interface {
@@ -71,20 +78,23 @@ type Track struct {
 	remoteTrack      *remoteTrack
 	onEndedCallbacks []func()
 	onReadCallbacks  []func(rtp.Packet, QualityLevel)
+	// the packets are not forwarded to the subscribers while muted by the SFU
+	muted atomic.Bool
 }
 
-func newTrack(ctx context.Context, clientID string, trackRemote IRemoteTrack, pliInterval time.Duration, onPLI func(), stats stats.Getter, onStatsUpdated func(*stats.Stats)) ITrack {
+func newTrack(ctx context.Context, clientID string, trackRemote IRemoteTrack, headerExtensions []webrtc.RTPHeaderExtensionParameter, pliInterval, pliDebounceWindow time.Duration, onPLI func(), nack nackOptions, stats stats.Getter, onStatsUpdated func(*stats.Stats)) ITrack {
 	ctList := newClientTrackList()
 
 	baseTrack := baseTrack{
-		id:           trackRemote.ID(),
-		isScreen:     &atomic.Bool{},
-		msid:         trackRemote.Msid(),
-		streamid:     trackRemote.StreamID(),
-		clientid:     clientID,
-		kind:         trackRemote.Kind(),
-		codec:        trackRemote.Codec(),
-		clientTracks: ctList,
+		id:               trackRemote.ID(),
+		isScreen:         &atomic.Bool{},
+		msid:             trackRemote.Msid(),
+		streamid:         trackRemote.StreamID(),
+		clientid:         clientID,
+		kind:             trackRemote.Kind(),
+		codec:            trackRemote.Codec(),
+		clientTracks:     ctList,
+		headerExtensions: headerExtensions,
 	}
 
 	t := &Track{
@@ -95,17 +105,16 @@ func newTrack(ctx context.Context, clientID string, trackRemote IRemoteTrack, pl
 	}
 
 	onRead := func(p rtp.Packet) {
-		// do
-		tracks := t.base.clientTracks.GetTracks()
-
-		for _, track := range tracks {
-			track.push(p, QualityHigh)
+		if !t.muted.Load() {
+			for _, track := range t.base.clientTracks.GetTracks() {
+				track.push(p, QualityHigh)
+			}
 		}
 
 		go t.onRead(p, QualityHigh)
 	}
 
-	t.remoteTrack = newRemoteTrack(ctx, trackRemote, pliInterval, onPLI, stats, onStatsUpdated, onRead)
+	t.remoteTrack = newRemoteTrack(ctx, trackRemote, pliInterval, pliDebounceWindow, onPLI, nack, stats, onStatsUpdated, onRead)
 
 	t.context, t.cancel = context.WithCancel(t.remoteTrack.Context())
 
@@ -125,6 +134,9 @@ func (t *Track) Context() context.Context {
 	return t.context
 }
 
+// createLocalTrack create the track that forward the publisher packets to a subscriber. The payload type of the publisher
+// is not kept, the local track is bound with the codec negotiated with the subscriber and WriteRTP rewrite the payload type
+// to the payload type of the binding.
 func (t *Track) createLocalTrack() *webrtc.TrackLocalStaticRTP {
 	track, newTrackErr := webrtc.NewTrackLocalStaticRTP(t.remoteTrack.track.Codec().RTPCodecCapability, t.base.id, t.base.streamid)
 	if newTrackErr != nil {
@@ -174,7 +186,7 @@ func (t *Track) IsSimulcast() bool {
 }
 
 func (t *Track) IsScaleable() bool {
-	return t.MimeType() == webrtc.MimeTypeVP9
+	return t.MimeType() == webrtc.MimeTypeVP9 || t.MimeType() == webrtc.MimeTypeH264
 }
 
 func (t *Track) IsProcessed() bool {
@@ -211,10 +223,10 @@ func (t *Track) TotalTracks() int {
 func (t *Track) subscribe(c *Client) iClientTrack {
 	var ct iClientTrack
 
-	if t.MimeType() == webrtc.MimeTypeVP9 {
-		ct = newScaleableClientTrack(c, t, c.SFU().QualityPreset())
+	if t.IsScaleable() {
+		ct = newScaleableClientTrack(c, t, c.SFU().PacketCacheSize(), c.SFU().PacketCacheMaxAge(), c.SFU().PacketCacheWindow(), c.SFU().PacketQueueSize())
 	} else if t.Kind() == webrtc.RTPCodecTypeAudio && t.PayloadType() == 63 {
-		glog.Info("track: red enabled", c.receiveRED)
+		logger().Info("track: red enabled", c.receiveRED)
 
 		ct = newClientTrackRed(c, t)
 	} else {
@@ -224,7 +236,7 @@ func (t *Track) subscribe(c *Client) iClientTrack {
 
 	if t.Kind() == webrtc.RTPCodecTypeAudio {
 		if c.IsVADEnabled() {
-			glog.Info("track: voice activity detector enabled")
+			logger().Info("track: voice activity detector enabled")
 			vad := c.vad.AddAudioTrack(ct.LocalTrack())
 			vad.OnVoiceDetected(func(activity voiceactivedetector.VoiceActivity) {
 				// send through datachannel
@@ -290,18 +302,14 @@ type SimulcastTrack struct {
 	cancel                      context.CancelFunc
 	mu                          sync.Mutex
 	base                        *baseTrack
-	baseTS                      uint32
 	onTrackCompleteCallbacks    []func()
 	remoteTrackHigh             *remoteTrack
-	remoteTrackHighBaseTS       uint32
 	highSequence                uint16
 	lastHighSequence            uint16
 	remoteTrackMid              *remoteTrack
-	remoteTrackMidBaseTS        uint32
 	midSequence                 uint16
 	lastMidSequence             uint16
 	remoteTrackLow              *remoteTrack
-	remoteTrackLowBaseTS        uint32
 	lowSequence                 uint16
 	lastLowSequence             uint16
 	lastReadHighTS              *atomic.Int64
@@ -313,21 +321,24 @@ type SimulcastTrack struct {
 	onAddedRemoteTrackCallbacks []func(*remoteTrack)
 	onReadCallbacks             []func(rtp.Packet, QualityLevel)
 	pliInterval                 time.Duration
-	onPLI                       func()
+	pliDebounceWindow           time.Duration
+	nack                        nackOptions
+	ridToQuality                func(rid string) QualityLevel
 }
 
-func newSimulcastTrack(ctx context.Context, clientid string, track IRemoteTrack, pliInterval time.Duration, onPLI func(), stats stats.Getter, onStatsUpdated func(*stats.Stats)) ITrack {
+func newSimulcastTrack(ctx context.Context, clientid string, track IRemoteTrack, headerExtensions []webrtc.RTPHeaderExtensionParameter, pliInterval, pliDebounceWindow time.Duration, onPLI func(), nack nackOptions, stats stats.Getter, onStatsUpdated func(*stats.Stats), ridToQuality func(rid string) QualityLevel) ITrack {
 	t := &SimulcastTrack{
 		mu: sync.Mutex{},
 		base: &baseTrack{
-			id:           track.ID(),
-			isScreen:     &atomic.Bool{},
-			msid:         track.Msid(),
-			streamid:     track.StreamID(),
-			clientid:     clientid,
-			kind:         track.Kind(),
-			codec:        track.Codec(),
-			clientTracks: newClientTrackList(),
+			id:               track.ID(),
+			isScreen:         &atomic.Bool{},
+			msid:             track.Msid(),
+			streamid:         track.StreamID(),
+			clientid:         clientid,
+			kind:             track.Kind(),
+			codec:            track.Codec(),
+			clientTracks:     newClientTrackList(),
+			headerExtensions: headerExtensions,
 		},
 		lastReadHighTS:              &atomic.Int64{},
 		lastReadMidTS:               &atomic.Int64{},
@@ -339,10 +350,12 @@ func newSimulcastTrack(ctx context.Context, clientid string, track IRemoteTrack,
 		onAddedRemoteTrackCallbacks: make([]func(*remoteTrack), 0),
 		onReadCallbacks:             make([]func(rtp.Packet, QualityLevel), 0),
 		pliInterval:                 pliInterval,
-		onPLI:                       onPLI,
+		pliDebounceWindow:           pliDebounceWindow,
+		nack:                        nack,
+		ridToQuality:                ridToQuality,
 	}
 
-	rt := t.AddRemoteTrack(ctx, track, stats, onStatsUpdated)
+	rt := t.AddRemoteTrack(ctx, track, onPLI, stats, onStatsUpdated)
 	t.context, t.cancel = context.WithCancel(rt.Context())
 
 	go func() {
@@ -421,25 +434,14 @@ func (t *SimulcastTrack) Kind() webrtc.RTPCodecType {
 	return t.base.kind
 }
 
-func (t *SimulcastTrack) AddRemoteTrack(ctx context.Context, track IRemoteTrack, stats stats.Getter, onStatsUpdated func(*stats.Stats)) *remoteTrack {
+// AddRemoteTrack add the simulcast layer of the track. The onPLI must send the PLI to the SSRC of the layer, so the PLIs
+// of the subscribers switching to the same layer are coalesced into a single keyframe request of that layer.
+func (t *SimulcastTrack) AddRemoteTrack(ctx context.Context, track IRemoteTrack, onPLI func(), stats stats.Getter, onStatsUpdated func(*stats.Stats)) *remoteTrack {
 	var remoteTrack *remoteTrack
 
-	quality := RIDToQuality(track.RID())
+	quality := t.ridQuality(track.RID())
 
 	onRead := func(p rtp.Packet) {
-		// set the base timestamp for the track if it is not set yet
-		if t.baseTS == 0 {
-			t.baseTS = p.Timestamp
-		}
-
-		if quality == QualityHigh && t.remoteTrackHighBaseTS == 0 {
-			t.remoteTrackHighBaseTS = p.Timestamp
-		} else if quality == QualityMid && t.remoteTrackMidBaseTS == 0 {
-			t.remoteTrackMidBaseTS = p.Timestamp
-		} else if quality == QualityLow && t.remoteTrackLowBaseTS == 0 {
-			t.remoteTrackLowBaseTS = p.Timestamp
-		}
-
 		readTime := time.Now().UnixNano()
 
 		switch quality {
@@ -466,7 +468,7 @@ func (t *SimulcastTrack) AddRemoteTrack(ctx context.Context, track IRemoteTrack,
 
 	}
 
-	remoteTrack = newRemoteTrack(ctx, track, t.pliInterval, t.onPLI, stats, onStatsUpdated, onRead)
+	remoteTrack = newRemoteTrack(ctx, track, t.pliInterval, t.pliDebounceWindow, onPLI, t.nack, stats, onStatsUpdated, onRead)
 
 	switch quality {
 	case QualityHigh:
@@ -514,7 +516,7 @@ func (t *SimulcastTrack) AddRemoteTrack(ctx context.Context, track IRemoteTrack,
 			t.cancel()
 		}()
 	default:
-		glog.Warning("client: unknown track quality ", track.RID())
+		logger().Warn("client: unknown track quality ", track.RID())
 		return nil
 	}
 
@@ -598,46 +600,45 @@ func (t *SimulcastTrack) isTrackActive(quality QualityLevel) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	// set max active track threshold to 500ms
-	threshold := time.Duration(500) * time.Millisecond
+	threshold := simulcastLayerActiveThreshold
 
 	switch quality {
 	case QualityHigh:
 		if t.remoteTrackHigh == nil {
-			glog.Warning("track: remote track high is nil")
+			logger().Warn("track: remote track high is nil")
 			return false
 		}
 
 		delta := time.Since(time.Unix(0, t.lastReadHighTS.Load()))
 
 		if delta > threshold {
-			glog.Warningf("track: remote track %s high is not active, last read was %d ms ago", delta.Milliseconds())
+			logger().Warn("track: remote track ", t.ID(), " high is not active, last read was ", delta.Milliseconds(), " ms ago")
 			return false
 		}
 
 		return true
 	case QualityMid:
 		if t.remoteTrackMid == nil {
-			glog.Warning("track: remote track medium is nil")
+			logger().Warn("track: remote track medium is nil")
 			return false
 		}
 
 		delta := time.Since(time.Unix(0, t.lastReadMidTS.Load()))
 		if delta > threshold {
-			glog.Warningf("track: remote track %s mid is not active, last read was %d ms ago", delta.Milliseconds())
+			logger().Warn("track: remote track ", t.ID(), " mid is not active, last read was ", delta.Milliseconds(), " ms ago")
 			return false
 		}
 
 		return true
 	case QualityLow:
 		if t.remoteTrackLow == nil {
-			glog.Warning("track: remote track low is nil")
+			logger().Warn("track: remote track low is nil")
 			return false
 		}
 
 		delta := time.Since(time.Unix(0, t.lastReadLowTS.Load()))
 		if delta > threshold {
-			glog.Warningf("track: remote track %s low is not active, last read was %d ms ago", delta.Milliseconds())
+			logger().Warn("track: remote track ", t.ID(), " low is not active, last read was ", delta.Milliseconds(), " ms ago")
 			return false
 		}
 
@@ -647,23 +648,57 @@ func (t *SimulcastTrack) isTrackActive(quality QualityLevel) bool {
 	return false
 }
 
-func (t *SimulcastTrack) sendPLI(quality QualityLevel) {
-	switch quality {
-	case QualityHigh:
-		if t.remoteTrackHigh != nil {
-			t.remoteTrackHigh.sendPLI()
-		}
-	case QualityMid:
-		if t.remoteTrackMid != nil {
-			t.remoteTrackMid.sendPLI()
+// probeLayers request a keyframe of every layer and wait until the high layer is received or the timeout is reached.
+// It return the highest layer received since the probe started, QualityNone if no layer is received.
+func (t *SimulcastTrack) probeLayers(ctx context.Context, timeout time.Duration) QualityLevel {
+	since := time.Now().UnixNano()
+
+	for _, quality := range []QualityLevel{QualityHigh, QualityMid, QualityLow} {
+		t.sendPLI(quality)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(simulcastProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		if t.lastReadHighTS.Load() >= since {
+			return QualityHigh
 		}
-	case QualityLow:
-		if t.remoteTrackLow != nil {
-			t.remoteTrackLow.sendPLI()
+
+		select {
+		case <-ctx.Done():
+			return QualityNone
+		case <-timer.C:
+			return t.highestLayerSince(since)
+		case <-ticker.C:
 		}
 	}
 }
 
+// highestLayerSince return the highest layer that is read after the time in unix nano, QualityNone if there is none
+func (t *SimulcastTrack) highestLayerSince(since int64) QualityLevel {
+	switch {
+	case t.lastReadHighTS.Load() >= since:
+		return QualityHigh
+	case t.lastReadMidTS.Load() >= since:
+		return QualityMid
+	case t.lastReadLowTS.Load() >= since:
+		return QualityLow
+	}
+
+	return QualityNone
+}
+
+// sendPLI request a keyframe of the layer, the requests of all subscribers are debounced per layer
+func (t *SimulcastTrack) sendPLI(quality QualityLevel) {
+	if remoteTrack := t.getRemoteTrack(quality); remoteTrack != nil {
+		remoteTrack.sendPLI()
+	}
+}
+
 func (t *SimulcastTrack) MimeType() string {
 	return t.base.codec.MimeType
 }
@@ -796,7 +831,7 @@ func (t *trackList) Add(track ITrack) error {
 
 	id := track.ID()
 	if _, ok := t.tracks[id]; ok {
-		glog.Warning("client: track already added ", id)
+		logger().Warn("client: track already added ", id)
 		return ErrTrackExists
 	}
 
@@ -860,6 +895,15 @@ func (t *trackList) Length() int {
 	return len(t.tracks)
 }
 
+// ridQuality return the quality of the simulcast layer with the RID, using the SFU RID mapping if it is set
+func (t *SimulcastTrack) ridQuality(rid string) QualityLevel {
+	if t.ridToQuality == nil {
+		return RIDToQuality(rid)
+	}
+
+	return t.ridToQuality(rid)
+}
+
 func RIDToQuality(RID string) QualityLevel {
 	switch RID {
 	case "high":
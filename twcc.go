@@ -0,0 +1,64 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultTWCCFeedbackInterval is the default interval between the transport-cc feedbacks sent to the publisher
+	DefaultTWCCFeedbackInterval = 100 * time.Millisecond
+	// MinTWCCFeedbackInterval is the shortest transport-cc feedback interval allowed
+	MinTWCCFeedbackInterval = 10 * time.Millisecond
+	// MaxTWCCFeedbackInterval is the longest transport-cc feedback interval allowed
+	MaxTWCCFeedbackInterval = time.Second
+)
+
+// validTWCCFeedbackInterval return the default interval if the interval is not set, and clamp the interval to the allowed range
+func validTWCCFeedbackInterval(interval time.Duration) time.Duration {
+	if interval == 0 {
+		return DefaultTWCCFeedbackInterval
+	}
+
+	if interval < MinTWCCFeedbackInterval {
+		logger().Warn("twcc: feedback interval ", interval, " is too short, use the minimum interval ", MinTWCCFeedbackInterval)
+		return MinTWCCFeedbackInterval
+	}
+
+	if interval > MaxTWCCFeedbackInterval {
+		logger().Warn("twcc: feedback interval ", interval, " is too long, use the maximum interval ", MaxTWCCFeedbackInterval)
+		return MaxTWCCFeedbackInterval
+	}
+
+	return interval
+}
+
+func newTWCCSenderInterceptorFactory(interval time.Duration) (*twcc.SenderInterceptorFactory, error) {
+	return twcc.NewSenderInterceptor(twcc.SendInterval(interval))
+}
+
+// configureTWCCSender is the same with webrtc.ConfigureTWCCSender but with a configurable feedback interval
+func configureTWCCSender(m *webrtc.MediaEngine, i *interceptor.Registry, interval time.Duration) error {
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}, webrtc.RTPCodecTypeVideo)
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.TransportCCURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}, webrtc.RTPCodecTypeAudio)
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.TransportCCURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+
+	generator, err := newTWCCSenderInterceptorFactory(interval)
+	if err != nil {
+		return err
+	}
+
+	i.Add(generator)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidTWCCFeedbackInterval(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, DefaultTWCCFeedbackInterval, validTWCCFeedbackInterval(0))
+	require.Equal(t, MinTWCCFeedbackInterval, validTWCCFeedbackInterval(time.Millisecond))
+	require.Equal(t, MaxTWCCFeedbackInterval, validTWCCFeedbackInterval(5*time.Second))
+	require.Equal(t, 50*time.Millisecond, validTWCCFeedbackInterval(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(ctx, sfuOptions{TWCCFeedbackInterval: 50 * time.Millisecond})
+	require.Equal(t, 50*time.Millisecond, s.TWCCFeedbackInterval())
+}
+
+func TestTWCCFeedbackInterval(t *testing.T) {
+	t.Parallel()
+
+	interval := 50 * time.Millisecond
+
+	factory, err := newTWCCSenderInterceptorFactory(interval)
+	require.NoError(t, err)
+
+	twccInterceptor, err := factory.NewInterceptor("")
+	require.NoError(t, err)
+
+	defer twccInterceptor.Close()
+
+	mu := sync.Mutex{}
+	feedbacks := make([]time.Time, 0)
+
+	twccInterceptor.BindRTCPWriter(interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+		for _, pkt := range pkts {
+			if _, ok := pkt.(*rtcp.TransportLayerCC); ok {
+				mu.Lock()
+				feedbacks = append(feedbacks, time.Now())
+				mu.Unlock()
+			}
+		}
+
+		return 0, nil
+	}))
+
+	reader := twccInterceptor.BindRemoteStream(&interceptor.StreamInfo{
+		SSRC:                1,
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: sdp.TransportCCURI, ID: 1}},
+	}, interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		return len(b), a, nil
+	}))
+
+	for i := 0; i < 60; i++ {
+		pkt := &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1, SequenceNumber: uint16(i)}}
+
+		ext, err := (&rtp.TransportCCExtension{TransportSequence: uint16(i)}).Marshal()
+		require.NoError(t, err)
+		require.NoError(t, pkt.SetExtension(1, ext))
+
+		buf, err := pkt.Marshal()
+		require.NoError(t, err)
+
+		_, _, err = reader.Read(buf, interceptor.Attributes{})
+		require.NoError(t, err)
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// around 300ms of packets should produce about 6 feedbacks with 50ms interval,
+	// the default 100ms interval would only produce about 3 feedbacks
+	require.GreaterOrEqual(t, len(feedbacks), 4)
+	require.LessOrEqual(t, len(feedbacks), 10)
+}
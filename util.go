@@ -3,7 +3,6 @@ package sfu
 import (
 	"bufio"
 	"context"
-	"errors"
 	"flag"
 	"log"
 	"net"
@@ -94,6 +93,19 @@ func GetSenderStats(pc *webrtc.PeerConnection, statsGetter stats.Getter) map[web
 	return stats
 }
 
+// isTransportCCNegotiated check if the transport-cc feedback is negotiated on the sender codecs
+func isTransportCCNegotiated(params webrtc.RTPSendParameters) bool {
+	for _, codec := range params.Codecs {
+		for _, feedback := range codec.RTCPFeedback {
+			if feedback.Type == webrtc.TypeRTCPFBTransportCC {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func RegisterSimulcastHeaderExtensions(m *webrtc.MediaEngine, codecType webrtc.RTPCodecType) {
 	for _, extension := range []string{
 		sdp.SDESMidURI,
@@ -424,17 +436,68 @@ func GetLocalIp() (net.IP, error) {
 	return ip, nil
 }
 
+// MultiError is the error returned by FlattenErrors. Use errors.Is or errors.As on it to match any of
+// the aggregated errors, or Errors to inspect them one by one.
+type MultiError struct {
+	errs []error
+}
+
+func (e *MultiError) Error() string {
+	errString := ""
+	for _, err := range e.errs {
+		errString += err.Error() + "\n"
+	}
+
+	return errString
+}
+
+// Unwrap return the aggregated errors, it let errors.Is and errors.As match each of them
+func (e *MultiError) Unwrap() []error {
+	return e.errs
+}
+
+// Errors return a copy of the aggregated errors in the order they occurred
+func (e *MultiError) Errors() []error {
+	errs := make([]error, len(e.errs))
+	copy(errs, e.errs)
+
+	return errs
+}
+
+// FlattenErrors aggregate the errors into a *MultiError, it returns nil if there is no error
 func FlattenErrors(errs []error) error {
 	if len(errs) == 0 {
 		return nil
 	}
 
-	errString := ""
-	for _, err := range errs {
-		errString += err.Error() + "\n"
+	return &MultiError{errs: errs}
+}
+
+// higherQuality return the video quality level above the quality
+func higherQuality(quality QualityLevel) QualityLevel {
+	if quality == QualityHigh {
+		return QualityVeryHigh
+	}
+
+	return quality + 1
+}
+
+// lowerQuality return the video quality level below the quality
+func lowerQuality(quality QualityLevel) QualityLevel {
+	if quality == QualityVeryHigh {
+		return QualityHigh
+	}
+
+	return quality - 1
+}
+
+// capVideoQuality return QualityVeryHigh for the quality above QualityHigh, including the audio levels
+func capVideoQuality(quality QualityLevel) QualityLevel {
+	if quality > QualityHigh {
+		return QualityVeryHigh
 	}
 
-	return errors.New(errString)
+	return quality
 }
 
 func Uint32ToQualityLevel(quality uint32) QualityLevel {
@@ -451,6 +514,8 @@ func Uint32ToQualityLevel(quality uint32) QualityLevel {
 		return QualityAudio
 	case 5:
 		return QualityAudioRed
+	case 6:
+		return QualityVeryHigh
 	default:
 		return QualityLow
 	}
@@ -0,0 +1,116 @@
+package sfu
+
+import "sync"
+
+const (
+	vp8PictureIDMask  = 0x7fff
+	vp8PictureIDMask7 = 0x7f
+	vp8DescriptorX    = 0x80
+	vp8DescriptorI    = 0x80
+	vp8DescriptorL    = 0x40
+	vp8PictureIDMFlag = 0x80
+)
+
+// vp8Rewriter keep the VP8 picture id and tl0picidx continuous when the simulcast client track switch between layers.
+// Each simulcast layer is encoded with its own picture id sequence, without rewriting the decoder will see
+// a jump on every layer switch and may treat it as a frame loss.
+type vp8Rewriter struct {
+	mu              sync.Mutex
+	started         bool
+	pictureIDOffset uint16
+	tl0PicIdxOffset uint8
+	lastPictureID   uint16
+	lastTL0PicIdx   uint8
+}
+
+func newVP8Rewriter() *vp8Rewriter {
+	return &vp8Rewriter{}
+}
+
+// rewrite return the payload with the picture id and tl0picidx rewritten. isSwitch must be true on the first packet
+// after the layer is switched, the offsets are recalculated to continue from the last sent picture.
+// The payload is copied before modification because the packet payload is shared with the other client tracks.
+func (r *vp8Rewriter) rewrite(payload []byte, isSwitch bool) []byte {
+	if len(payload) < 2 || payload[0]&vp8DescriptorX == 0 {
+		return payload
+	}
+
+	hasPictureID := payload[1]&vp8DescriptorI != 0
+	hasTL0PicIdx := payload[1]&vp8DescriptorL != 0
+
+	if !hasPictureID && !hasTL0PicIdx {
+		return payload
+	}
+
+	idx := 2
+	pictureIDIdx := -1
+	isLongPictureID := false
+	pictureID := uint16(0)
+
+	if hasPictureID {
+		if len(payload) <= idx {
+			return payload
+		}
+
+		pictureIDIdx = idx
+
+		if payload[idx]&vp8PictureIDMFlag != 0 {
+			if len(payload) <= idx+1 {
+				return payload
+			}
+
+			isLongPictureID = true
+			pictureID = (uint16(payload[idx]&vp8PictureIDMask7) << 8) | uint16(payload[idx+1])
+			idx += 2
+		} else {
+			pictureID = uint16(payload[idx] & vp8PictureIDMask7)
+			idx++
+		}
+	}
+
+	tl0PicIdxIdx := -1
+	tl0PicIdx := uint8(0)
+
+	if hasTL0PicIdx {
+		if len(payload) <= idx {
+			return payload
+		}
+
+		tl0PicIdxIdx = idx
+		tl0PicIdx = payload[idx]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if isSwitch && r.started {
+		r.pictureIDOffset = (r.lastPictureID + 1 - pictureID) & vp8PictureIDMask
+		r.tl0PicIdxOffset = r.lastTL0PicIdx + 1 - tl0PicIdx
+	}
+
+	r.started = true
+
+	rewritten := make([]byte, len(payload))
+	copy(rewritten, payload)
+
+	if pictureIDIdx >= 0 {
+		newPictureID := (pictureID + r.pictureIDOffset) & vp8PictureIDMask
+		if isLongPictureID {
+			rewritten[pictureIDIdx] = vp8PictureIDMFlag | byte(newPictureID>>8)
+			rewritten[pictureIDIdx+1] = byte(newPictureID)
+		} else {
+			newPictureID &= vp8PictureIDMask7
+			rewritten[pictureIDIdx] = byte(newPictureID)
+		}
+
+		r.lastPictureID = newPictureID
+	}
+
+	if tl0PicIdxIdx >= 0 {
+		newTL0PicIdx := tl0PicIdx + r.tl0PicIdxOffset
+		rewritten[tl0PicIdxIdx] = newTL0PicIdx
+		r.lastTL0PicIdx = newTL0PicIdx
+	}
+
+	return rewritten
+}